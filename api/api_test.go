@@ -0,0 +1,587 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/faketal"
+	"github.com/itsHenry35/tal_downloader/models"
+)
+
+// testRetry 测试使用的重试策略，等待时间很短
+var testRetry = api.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+// newTestServer 启动模拟服务器：乐读账号有两个学员，第一个学员有23门课程；
+// 培优账号只能用学员编号登录
+func newTestServer(t *testing.T) *faketal.Server {
+	t.Helper()
+	srv := faketal.NewServer()
+	t.Cleanup(srv.Close)
+
+	var courses []*faketal.Course
+	for i := 1; i <= 23; i++ {
+		courses = append(courses, &faketal.Course{Course: models.Course{
+			CourseID:   fmt.Sprintf("c%d", i),
+			TutorID:    "tutor",
+			CourseName: fmt.Sprintf("课程%d", i),
+		}})
+	}
+	courses[0].Lectures = []*faketal.Lecture{
+		{
+			Lecture:   models.Lecture{LiveID: 101, LiveTypeString: "SMALL_CLASS_MODE"},
+			VideoURLs: []string{"https://cdn.example.com/101/index.m3u8", "https://cdn.example.com/101/video.mp4"},
+		},
+		{
+			Lecture:   models.Lecture{LiveID: 102, LiveTypeString: "GENERAL_V2_MODE"},
+			VideoURLs: []string{"https://cdn.example.com/102/index.m3u8"},
+		},
+		{
+			Lecture: models.Lecture{LiveID: 103, LiveTypeString: "RECORD_MODE"},
+			Definitions: map[string][]string{
+				"标清": {"https://cdn.example.com/103/sd.mp4"},
+				"超清": {"https://cdn.example.com/103/hd-old.mp4", "https://cdn.example.com/103/hd.mp4"},
+				"高清": {"https://cdn.example.com/103/md.mp4"},
+			},
+		},
+		{
+			Lecture: models.Lecture{LiveID: 104, LiveTypeString: "ONLINE_REAL_RECORD"},
+			Message: "回放生成中",
+		},
+		{
+			Lecture:   models.Lecture{LiveID: 105, LiveTypeString: "SMALL_GROUPS_V2_MODE"},
+			VideoURLs: []string{"https://cdn.example.com/105/video.flv"},
+			Message:   "暂无回放",
+		},
+		{
+			Lecture: models.Lecture{LiveID: 106, LiveTypeString: "BIG_LIVE"},
+		},
+	}
+	for i := 0; i < 12; i++ {
+		courses[1].Lectures = append(courses[1].Lectures, &faketal.Lecture{
+			Lecture: models.Lecture{LiveID: 200 + i, LiveTypeString: "SMALL_CLASS_MODE"},
+		})
+	}
+
+	start := time.Date(2024, 5, 12, 18, 30, 0, 0, time.FixedZone("CST", 8*3600))
+	courses[2].Lectures = []*faketal.Lecture{
+		{Lecture: models.Lecture{LiveID: 301, Title: "函数初步", Num: 1, StartTime: models.LectureTime{Time: start}, EndTime: models.LectureTime{Time: start.Add(90 * time.Minute)}, TeacherName: "张老师", Status: models.LectureStatusEnded}},
+		{Lecture: models.Lecture{LiveID: 302, Title: "调课", Status: models.LectureStatusCancelled}},
+		{Lecture: models.Lecture{LiveID: 303, Title: "二次函数", Num: 2, Status: models.LectureStatusEnded}},
+		{Lecture: models.Lecture{LiveID: 304, Title: "指数函数", Num: 3, Status: models.LectureStatusNotStarted}},
+	}
+
+	srv.AddAccount(&faketal.Account{
+		Platform: "ledu",
+		Username: "13800000000",
+		Password: "secret",
+		Phone:    "13800000000",
+		SMSCode:  "123456",
+		Students: []*faketal.Student{
+			{UID: 1001, Nickname: "小明", Courses: courses},
+			{UID: 1002, Nickname: "小红", Courses: []*faketal.Course{
+				{Course: models.Course{CourseID: "r1", CourseName: "小红的课程"}},
+			}},
+		},
+	})
+	srv.AddAccount(&faketal.Account{
+		Platform:      "xes",
+		Username:      "XES2001",
+		Password:      "xes-secret",
+		StudentIDOnly: true,
+		Students:      []*faketal.Student{{UID: 2001, Nickname: "小刚"}},
+	})
+	return srv
+}
+
+func newTestClient(srv *faketal.Server, platform string) *api.Client {
+	client := api.NewClient(srv.Platform(platform))
+	client.SetRetryPolicy(testRetry)
+	return client
+}
+
+// login 使用乐读账号登录第一个学员
+func login(t *testing.T, srv *faketal.Server) *api.Client {
+	t.Helper()
+	client := newTestClient(srv, "ledu")
+	auth, err := client.LoginWithPassword("13800000000", "secret")
+	if err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+	client.SetAuth(auth.Token, auth.UserID)
+	return client
+}
+
+func TestLoginWithPassword(t *testing.T) {
+	srv := newTestServer(t)
+	client := newTestClient(srv, "ledu")
+
+	auth, err := client.LoginWithPassword("13800000000", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Token == "" || auth.UserID != "1001" || auth.Nickname != "小明" {
+		t.Errorf("unexpected auth data: %+v", auth)
+	}
+	if n := srv.Requests("/passport/v1/login/student/code"); n != 1 {
+		t.Errorf("getFinalAuth called %d times, want 1", n)
+	}
+	if n := srv.Requests("/passport/v1/login/student/password"); n != 0 {
+		t.Errorf("student ID login should not be tried, called %d times", n)
+	}
+}
+
+func TestLoginWithPasswordFallsBackToStudentID(t *testing.T) {
+	srv := newTestServer(t)
+	client := newTestClient(srv, "xes")
+
+	auth, err := client.LoginWithPassword("XES2001", "xes-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.UserID != "2001" || auth.Nickname != "小刚" {
+		t.Errorf("unexpected auth data: %+v", auth)
+	}
+	if n := srv.Requests("/v1/web/login/pwd"); n != 1 {
+		t.Errorf("passport login called %d times, want 1", n)
+	}
+}
+
+func TestLoginWithPasswordWrongPlatform(t *testing.T) {
+	srv := newTestServer(t)
+	// 培优账号不能登录乐读
+	client := newTestClient(srv, "ledu")
+
+	_, err := client.LoginWithPassword("XES2001", "xes-secret")
+	if err == nil {
+		t.Fatal("expected login to fail")
+	}
+	if api.KindOf(err) != api.ErrBusiness {
+		t.Errorf("error kind = %v, want business", api.KindOf(err))
+	}
+	// 返回的是 passport 登录的错误，而不是学员编号登录的
+	if !strings.Contains(err.Error(), "账号或密码错误") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoginWithSMS(t *testing.T) {
+	srv := newTestServer(t)
+	client := newTestClient(srv, "ledu")
+
+	if err := client.SendSMSCode("13800000000", "86"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.LoginWithSMS("13800000000", "000000", "86"); err == nil || !strings.Contains(err.Error(), "验证码错误") {
+		t.Errorf("wrong code: got %v", err)
+	}
+
+	auth, err := client.LoginWithSMS("13800000000", "123456", "86")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.UserID != "1001" {
+		t.Errorf("UserID = %s, want 1001", auth.UserID)
+	}
+}
+
+func TestStudentAccountsAndSwitch(t *testing.T) {
+	srv := newTestServer(t)
+	client := login(t, srv)
+
+	accounts, err := client.GetStudentAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 || !accounts[0].IsCurrentLoginAccount || accounts[1].PuUID != 1002 {
+		t.Fatalf("unexpected accounts: %+v", accounts)
+	}
+
+	if err := client.SwitchStudentAccount("1001", "1002"); err != nil {
+		t.Fatal(err)
+	}
+	client.SetAuth("", "1002")
+	courses, err := client.GetCourseList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(courses) != 1 || courses[0].CourseID != "r1" {
+		t.Errorf("unexpected courses after switching: %+v", courses)
+	}
+}
+
+func TestCourseListPagination(t *testing.T) {
+	srv := newTestServer(t)
+	client := login(t, srv)
+
+	courses, err := client.GetCourseList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(courses) != 23 {
+		t.Fatalf("got %d courses, want 23", len(courses))
+	}
+	for i, c := range courses {
+		if want := fmt.Sprintf("c%d", i+1); c.CourseID != want {
+			t.Errorf("courses[%d] = %s, want %s", i, c.CourseID, want)
+		}
+	}
+	// 3页数据加上一次空页
+	if n := srv.Requests("/course/v1/student/course/list"); n != 4 {
+		t.Errorf("course list requested %d times, want 4", n)
+	}
+
+	lectures, err := client.GetLectures("c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lectures) != 12 || lectures[11].LiveID != 211 {
+		t.Errorf("unexpected lectures: %d", len(lectures))
+	}
+}
+
+func TestGetLecturesMetadata(t *testing.T) {
+	srv := newTestServer(t)
+	client := login(t, srv)
+
+	lectures, err := client.GetLectures("c3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lectures) != 4 {
+		t.Fatalf("got %d lectures, want 4", len(lectures))
+	}
+	if got, want := lectures[0].Label(0), "第1讲 函数初步 · 2024-05-12 18:30 · 张老师 · 90分钟"; got != want {
+		t.Errorf("label = %q, want %q", got, want)
+	}
+	// 取消的讲次不占用序号
+	if n := lectures[2].Number(2); n != 2 {
+		t.Errorf("number = %d, want 2", n)
+	}
+	// 中间有取消的讲时，已结束的讲按状态确定，不是列表中的前 EndLiveNum 个
+	course := &models.Course{EndLiveNum: 2}
+	for i, want := range []bool{true, false, true, false} {
+		if got := lectures[i].Ended(i, course); got != want {
+			t.Errorf("lecture %d ended = %v, want %v", i, got, want)
+		}
+	}
+	if got := models.EndedLectures(lectures, course); !reflect.DeepEqual(got, []int{0, 2}) {
+		t.Errorf("ended lectures = %v, want [0 2]", got)
+	}
+}
+
+func TestLectureEndedWithoutStatus(t *testing.T) {
+	course := &models.Course{EndLiveNum: 1}
+	past := models.LectureTime{Time: time.Now().Add(-time.Hour)}
+	future := models.LectureTime{Time: time.Now().Add(time.Hour)}
+	tests := []struct {
+		name    string
+		lecture models.Lecture
+		index   int
+		want    bool
+	}{
+		{"past end time", models.Lecture{EndTime: past}, 1, true},
+		{"future end time", models.Lecture{EndTime: future}, 0, false},
+		{"no end time, within EndLiveNum", models.Lecture{}, 0, true},
+		{"no end time, after EndLiveNum", models.Lecture{}, 1, false},
+		{"status wins over end time", models.Lecture{EndTime: past, Status: models.LectureStatusCancelled}, 0, false},
+	}
+	for _, tt := range tests {
+		if got := tt.lecture.Ended(tt.index, course); got != tt.want {
+			t.Errorf("%s: ended = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLectureTimeFormats(t *testing.T) {
+	want := time.Date(2024, 5, 12, 18, 30, 0, 0, time.FixedZone("CST", 8*3600))
+	tests := []struct {
+		data string
+		want time.Time
+		bad  string // 无法解析时 TimeFormatError 中的原始值
+	}{
+		{data: `1715509800`, want: want},
+		{data: `1715509800000`, want: want},
+		{data: `"1715509800"`, want: want},
+		{data: `"2024-05-12 18:30:00"`, want: want},
+		{data: `"2024-05-12 18:30"`, want: want},
+		{data: `"2024-05-12T18:30:00+08:00"`, want: want},
+		{data: `null`},
+		{data: `""`},
+		{data: `0`},
+		{data: `"明天晚上"`, bad: "明天晚上"},
+		{data: `{"t":1}`, bad: `{"t":1}`},
+	}
+	for _, tt := range tests {
+		var lt models.LectureTime
+		if err := lt.UnmarshalJSON([]byte(tt.data)); err != nil {
+			t.Errorf("%s: %v", tt.data, err)
+			continue
+		}
+		if !lt.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.data, lt.Time, tt.want)
+		}
+		var formatErr *models.TimeFormatError
+		if tt.bad == "" && lt.Err() != nil {
+			t.Errorf("%s: unexpected error %v", tt.data, lt.Err())
+		}
+		if tt.bad != "" && (!errors.As(lt.Err(), &formatErr) || formatErr.Value != tt.bad) {
+			t.Errorf("%s: error = %v, want the raw value %s", tt.data, lt.Err(), tt.bad)
+		}
+	}
+
+	// 无法解析的时间不影响整个列表的解析，调用方可以通过 TimeErr 得到原始值
+	var lecture models.Lecture
+	if err := json.Unmarshal([]byte(`{"liveId":1,"liveStartTime":"明天晚上","liveEndTime":{"t":1}}`), &lecture); err != nil {
+		t.Fatalf("unknown time format: %v", err)
+	}
+	if lecture.LiveID != 1 || !lecture.StartTime.IsZero() || !lecture.EndTime.IsZero() {
+		t.Errorf("unexpected lecture: %+v", lecture)
+	}
+	if err := lecture.TimeErr(); err == nil || !strings.Contains(err.Error(), "明天晚上") {
+		t.Errorf("TimeErr = %v, want the unparsed start time", err)
+	}
+}
+
+func TestGetVideoURL(t *testing.T) {
+	srv := newTestServer(t)
+	client := login(t, srv)
+
+	lectures, err := client.GetLectures("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[int]*models.Lecture)
+	for _, l := range lectures {
+		byID[l.LiveID] = l
+	}
+
+	tests := []struct {
+		liveID  int
+		want    string
+		wantErr string
+	}{
+		{liveID: 101, want: "https://cdn.example.com/101/video.mp4"},  // mp4 优先
+		{liveID: 102, want: "https://cdn.example.com/102/index.m3u8"}, // 没有 mp4 时使用 m3u8
+		{liveID: 103, want: "https://cdn.example.com/103/hd.mp4"},     // 录播选择最高清晰度的最后一个地址
+		{liveID: 104, wantErr: "回放生成中"},                               // 录播没有清晰度
+		{liveID: 105, wantErr: "暂无回放"},                                // 没有 mp4 和 m3u8
+		{liveID: 106, wantErr: "unsupported live type: BIG_LIVE"},     // 不支持的直播类型
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.liveID), func(t *testing.T) {
+			url, err := client.GetVideoURL(byID[tt.liveID], "c1", "tutor")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if url != tt.want {
+				t.Errorf("url = %s, want %s", url, tt.want)
+			}
+		})
+	}
+
+	source, err := client.GetVideoSource(byID[103], "c1", "tutor", "高清")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Definition != "高清" || source.URL != "https://cdn.example.com/103/md.mp4" {
+		t.Errorf("unexpected source: %+v", source)
+	}
+}
+
+func TestRetryOnServerError(t *testing.T) {
+	srv := newTestServer(t)
+	client := login(t, srv)
+
+	srv.InjectFault(&faketal.Fault{Path: "/course/v1/student/course/user-live-list", Times: 2, Status: 500})
+	lectures, err := client.GetLectures("c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lectures) != 12 {
+		t.Errorf("got %d lectures, want 12", len(lectures))
+	}
+
+	// 超过重试次数后返回服务器错误
+	srv.InjectFault(&faketal.Fault{Path: "/course/v1/student/course/list", Status: 503})
+	_, err = client.GetCourseList()
+	if api.KindOf(err) != api.ErrServer {
+		t.Errorf("err = %v, want server error", err)
+	}
+	if n := srv.Requests("/course/v1/student/course/list"); n != testRetry.MaxAttempts {
+		t.Errorf("course list requested %d times, want %d", n, testRetry.MaxAttempts)
+	}
+}
+
+func TestPostIsNotRetried(t *testing.T) {
+	srv := newTestServer(t)
+	client := newTestClient(srv, "ledu")
+
+	srv.InjectFault(&faketal.Fault{Path: "/v1/web/login/sms/send", Status: 502})
+	err := client.SendSMSCode("13800000000", "86")
+	if api.KindOf(err) != api.ErrServer {
+		t.Errorf("err = %v, want server error", err)
+	}
+	if n := srv.Requests("/v1/web/login/sms/send"); n != 1 {
+		t.Errorf("SMS requested %d times, want 1", n)
+	}
+}
+
+func TestTruncatedResponse(t *testing.T) {
+	srv := newTestServer(t)
+	client := login(t, srv)
+
+	// 截断的响应按网络错误重试
+	srv.InjectFault(&faketal.Fault{Path: "/course/v1/student/course/list", Times: 1, Truncate: 20})
+	courses, err := client.GetCourseList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(courses) != 23 {
+		t.Errorf("got %d courses, want 23", len(courses))
+	}
+
+	srv.InjectFault(&faketal.Fault{Path: "/course/v1/student/course/list", Truncate: 20})
+	if _, err := client.GetCourseList(); !api.IsTemporary(err) {
+		t.Errorf("err = %v, want a temporary error", err)
+	}
+}
+
+func TestSlowResponse(t *testing.T) {
+	srv := newTestServer(t)
+	client := login(t, srv)
+
+	const delay = 100 * time.Millisecond
+	srv.InjectFault(&faketal.Fault{Path: "/passport/v1/students/account-list", Delay: delay})
+	start := time.Now()
+	if _, err := client.GetStudentAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("request finished in %v, expected at least %v", elapsed, delay)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	srv := newTestServer(t)
+	client := login(t, srv)
+
+	if err := client.ValidateToken(); err != nil {
+		t.Fatalf("token should be valid: %v", err)
+	}
+
+	// 接口对过期 token 的错误码没有公开，业务错误原样返回，由会话重新登录后确认（见 session.Validate）
+	srv.ExpireTokens()
+	_, err := client.GetCourseList()
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) || apiErr.Kind != api.ErrBusiness || apiErr.Code != faketal.ExpiredTokenCode {
+		t.Errorf("GetCourseList err = %v, want the server's business error", err)
+	}
+	// 业务错误不重试
+	if n := srv.Requests("/course/v1/student/course/list"); n != 1 {
+		t.Errorf("course list requested %d times, want 1", n)
+	}
+	if err := client.ValidateToken(); api.KindOf(err) != api.ErrBusiness {
+		t.Errorf("ValidateToken err = %v, want business error", err)
+	}
+
+	// 重新登录后恢复
+	client = login(t, srv)
+	if err := client.ValidateToken(); err != nil {
+		t.Errorf("token should be valid after login: %v", err)
+	}
+
+	// HTTP 401 明确表示登录过期
+	srv.InjectFault(&faketal.Fault{Path: "/passport/v1/students/account-list", Times: 1, Status: 401})
+	if err := client.ValidateToken(); !api.IsAuthExpired(err) {
+		t.Errorf("ValidateToken err = %v, want auth expired", err)
+	}
+	srv.InjectFault(&faketal.Fault{Path: "/playback/v1/video/init", Times: 1, Status: 401})
+	lecture := &models.Lecture{LiveID: 101, LiveTypeString: "SMALL_CLASS_MODE"}
+	if _, err := client.GetVideoURL(lecture, "c1", "tutor"); !api.IsAuthExpired(err) {
+		t.Errorf("GetVideoURL err = %v, want auth expired", err)
+	}
+}
+
+func TestValidateTokenKeepsOtherErrors(t *testing.T) {
+	srv := newTestServer(t)
+	client := login(t, srv)
+
+	// 无法解析的响应和其他业务错误不能说明 token 已过期
+	srv.InjectFault(&faketal.Fault{Path: "/passport/v1/students/account-list", Times: 1, Truncate: 20})
+	if err := client.ValidateToken(); err == nil || api.IsAuthExpired(err) {
+		t.Errorf("truncated response: err = %v, want a non-expiry error", err)
+	}
+	srv.InjectFault(&faketal.Fault{Path: "/passport/v1/students/account-list", Times: 1, Status: 400})
+	if err := client.ValidateToken(); err == nil || api.IsAuthExpired(err) {
+		t.Errorf("business error: err = %v, want a non-expiry error", err)
+	}
+}
+
+func TestEndpointAndHeaderOverrides(t *testing.T) {
+	srv := newTestServer(t)
+
+	// 平台配置中的地址不可用，通过选项指向模拟服务器
+	platform := srv.Platform("ledu")
+	platform.PassportAPIBase = "http://passport.invalid"
+	platform.CourseAPIBase = "http://course.invalid"
+	platform.ClassroomAPIBase = "http://classroom.invalid"
+	platform.Headers = map[string]string{"X-Platform": "ledu", "Referer": "https://mirror.example.com/"}
+
+	client := api.NewClient(platform,
+		api.WithEndpoints(api.Endpoints{Passport: srv.URL + "/", Course: srv.URL, Classroom: srv.URL}),
+		api.WithRetryPolicy(testRetry),
+		api.WithHeader("User-Agent", "mirror-test"),
+		api.WithHeader("terminal", ""),
+		api.WithHeader("Host", "classroom.example.com"),
+	)
+	if got := client.Endpoints().Passport; got != srv.URL {
+		t.Errorf("passport endpoint = %s, want %s", got, srv.URL)
+	}
+
+	auth, err := client.LoginWithPassword("13800000000", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetAuth(auth.Token, auth.UserID)
+
+	lecture := &models.Lecture{LiveID: 101, LiveTypeString: "SMALL_CLASS_MODE"}
+	if _, err := client.GetVideoURL(lecture, "c1", "tutor"); err != nil {
+		t.Fatal(err)
+	}
+	req := srv.LastRequest("/playback/v1/video/init")
+	if req == nil {
+		t.Fatal("playback init was not requested")
+	}
+	if req.Host != "classroom.example.com" {
+		t.Errorf("Host = %s, want classroom.example.com", req.Host)
+	}
+	if ua := req.Header.Get("User-Agent"); ua != "mirror-test" {
+		t.Errorf("User-Agent = %s, want mirror-test", ua)
+	}
+	if _, ok := req.Header["Terminal"]; ok {
+		t.Error("terminal header should be removed")
+	}
+	if req.Header.Get("X-Platform") != "ledu" || req.Header.Get("Referer") != "https://mirror.example.com/" {
+		t.Errorf("platform headers not applied: %v", req.Header)
+	}
+
+	// 复制的客户端保留覆盖
+	if _, err := client.Clone().GetCourseList(); err != nil {
+		t.Fatal(err)
+	}
+	if req := srv.LastRequest("/course/v1/student/course/list"); req.Host != "classroom.example.com" {
+		t.Errorf("clone Host = %s", req.Host)
+	}
+}
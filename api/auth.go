@@ -1,7 +1,6 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/url"
 
@@ -12,7 +11,7 @@ import (
 // LoginWithPassword performs password login
 func (c *Client) LoginWithPassword(username, password string) (*models.AuthData, error) {
 	// First try 100tal login
-	loginURL := fmt.Sprintf("%s/v1/web/login/pwd", config.PassportAPIBase)
+	loginURL := fmt.Sprintf("%s/v1/web/login/pwd", c.passportBase())
 
 	formData := url.Values{}
 	formData.Set("symbol", username)
@@ -23,26 +22,29 @@ func (c *Client) LoginWithPassword(username, password string) (*models.AuthData,
 	headers := map[string]string{
 		"content-type": "application/x-www-form-urlencoded",
 		"ver-num":      "1.13.03",
-		"client-id":    config.ClientID,
+		"client-id":    c.platform.ClientID,
 		"device-id":    config.DeviceID,
 	}
 
 	resp, err := c.doRequest("POST", loginURL, formData, headers, false)
 	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+		// 业务错误（如密码错误）时仍然尝试学员账号登录
+		if KindOf(err) != ErrBusiness {
+			return nil, err
+		}
+	} else {
+		defer resp.Body.Close()
 
-	var result models.AccountLoginResponse
+		var result models.AccountLoginResponse
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
-	}
+		if err := decodeResponse(resp, &result); err != nil {
+			return nil, err
+		}
 
-	if result.ErrCode == 0 {
-		return c.getFinalAuth(result.Data.Code)
-	} else {
-		err = fmt.Errorf("%s", result.ErrMsg)
+		if result.ErrCode == 0 {
+			return c.getFinalAuth(result.Data.Code)
+		}
+		err = businessError(result.ErrCode, result.ErrMsg)
 	}
 
 	// Try direct course API login
@@ -56,7 +58,7 @@ func (c *Client) LoginWithPassword(username, password string) (*models.AuthData,
 
 // SendSMSCode sends SMS verification code
 func (c *Client) SendSMSCode(phone, zoneCode string) error {
-	sendURL := fmt.Sprintf("%s/v1/web/login/sms/send", config.PassportAPIBase)
+	sendURL := fmt.Sprintf("%s/v1/web/login/sms/send", c.passportBase())
 
 	formData := url.Values{}
 	formData.Set("verify_type", "1")
@@ -66,7 +68,7 @@ func (c *Client) SendSMSCode(phone, zoneCode string) error {
 	headers := map[string]string{
 		"content-type": "application/x-www-form-urlencoded; charset=UTF-8",
 		"ver-num":      "1.13.03",
-		"client-id":    config.ClientID,
+		"client-id":    c.platform.ClientID,
 		"device-id":    config.DeviceID,
 		"origin":       "owcr://classroom",
 	}
@@ -82,12 +84,12 @@ func (c *Client) SendSMSCode(phone, zoneCode string) error {
 		ErrMsg  string `json:"errmsg"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := decodeResponse(resp, &result); err != nil {
 		return err
 	}
 
 	if result.ErrCode != 0 {
-		return fmt.Errorf("%s", result.ErrMsg)
+		return businessError(result.ErrCode, result.ErrMsg)
 	}
 
 	return nil
@@ -95,7 +97,7 @@ func (c *Client) SendSMSCode(phone, zoneCode string) error {
 
 // LoginWithSMS performs SMS login
 func (c *Client) LoginWithSMS(phone, smsCode, zoneCode string) (*models.AuthData, error) {
-	loginURL := fmt.Sprintf("%s/v1/web/login/sms", config.PassportAPIBase)
+	loginURL := fmt.Sprintf("%s/v1/web/login/sms", c.passportBase())
 
 	formData := url.Values{}
 	formData.Set("phone", phone)
@@ -107,7 +109,7 @@ func (c *Client) LoginWithSMS(phone, smsCode, zoneCode string) (*models.AuthData
 	headers := map[string]string{
 		"content-type": "application/x-www-form-urlencoded",
 		"ver-num":      "1.13.03",
-		"client-id":    config.ClientID,
+		"client-id":    c.platform.ClientID,
 		"device-id":    config.DeviceID,
 	}
 
@@ -119,25 +121,25 @@ func (c *Client) LoginWithSMS(phone, smsCode, zoneCode string) (*models.AuthData
 
 	var result models.AccountLoginResponse
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := decodeResponse(resp, &result); err != nil {
 		return nil, err
 	}
 
 	if result.ErrCode != 0 {
-		return nil, fmt.Errorf("%s", result.ErrMsg)
+		return nil, businessError(result.ErrCode, result.ErrMsg)
 	}
 
 	return c.getFinalAuth(result.Data.Code)
 }
 
 func (c *Client) loginWithStudentId(username, password string) (*models.AuthData, error) {
-	loginURL := fmt.Sprintf("%s/passport/v1/login/student/password", config.CourseAPIBase)
+	loginURL := fmt.Sprintf("%s/passport/v1/login/student/password", c.courseBase())
 
 	body := map[string]string{
 		"account":  username,
 		"password": password,
 		"deviceId": config.DeviceID,
-		"clientId": config.ClientID,
+		"clientId": c.platform.ClientID,
 	}
 
 	resp, err := c.doRequest("POST", loginURL, body, nil, true)
@@ -146,12 +148,8 @@ func (c *Client) loginWithStudentId(username, password string) (*models.AuthData
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("login failed with status: %d", resp.StatusCode)
-	}
-
 	var authResponse models.AuthFinalResponse
-	if err := json.NewDecoder(resp.Body).Decode(&authResponse); err != nil {
+	if err := decodeResponse(resp, &authResponse); err != nil {
 		return nil, err
 	}
 
@@ -165,14 +163,14 @@ func (c *Client) loginWithStudentId(username, password string) (*models.AuthData
 }
 
 func (c *Client) getFinalAuth(code string) (*models.AuthData, error) {
-	finalAuthURL := fmt.Sprintf("%s/passport/v1/login/student/code", config.CourseAPIBase)
+	finalAuthURL := fmt.Sprintf("%s/passport/v1/login/student/code", c.courseBase())
 
 	body := map[string]string{
 		"code":     code,
 		"deviceId": config.DeviceID,
 		"terminal": config.Terminal,
 		"product":  "ss",
-		"clientId": config.ClientID,
+		"clientId": c.platform.ClientID,
 	}
 
 	headers := map[string]string{
@@ -186,7 +184,7 @@ func (c *Client) getFinalAuth(code string) (*models.AuthData, error) {
 	defer resp.Body.Close()
 
 	var authResponse models.AuthFinalResponse
-	if err := json.NewDecoder(resp.Body).Decode(&authResponse); err != nil {
+	if err := decodeResponse(resp, &authResponse); err != nil {
 		return nil, err
 	}
 
@@ -201,11 +199,12 @@ func (c *Client) getFinalAuth(code string) (*models.AuthData, error) {
 
 // GetStudentAccounts 获取当前账号下的所有学生账号列表
 func (c *Client) GetStudentAccounts() (models.StudentAccountListResponse, error) {
-	listURL := fmt.Sprintf("%s/passport/v1/students/account-list", config.CourseAPIBase)
+	listURL := fmt.Sprintf("%s/passport/v1/students/account-list", c.courseBase())
+	token, userID := c.GetAuth()
 
 	payload := map[string]string{
-		"stuPuId":   c.userID, // 从客户端已登录信息中取
-		"signToken": c.token,  // 同上
+		"stuPuId":   userID, // 从客户端已登录信息中取
+		"signToken": token,  // 同上
 	}
 
 	headers := map[string]string{
@@ -214,7 +213,7 @@ func (c *Client) GetStudentAccounts() (models.StudentAccountListResponse, error)
 		"Referer":      "https://speiyou.cn/",
 		"resVer":       "1.0.6",
 		"terminal":     "pc",
-		"token":        c.token,
+		"token":        token,
 		"User-Agent":   "Mozilla/5.0 (Windows NT 10.0; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/78.0.3904.108 Safari/537.36",
 		"version":      "3.22.0.99",
 	}
@@ -226,7 +225,7 @@ func (c *Client) GetStudentAccounts() (models.StudentAccountListResponse, error)
 	defer resp.Body.Close()
 
 	var result models.StudentAccountListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := decodeResponse(resp, &result); err != nil {
 		return nil, err
 	}
 	return result, nil
@@ -234,18 +233,19 @@ func (c *Client) GetStudentAccounts() (models.StudentAccountListResponse, error)
 
 // SwitchStudentAccount 切换学生账号
 func (c *Client) SwitchStudentAccount(currentUID, nextUID string) error {
-	changeURL := fmt.Sprintf("%s/passport/v2/login/student/change-stu", config.CourseAPIBase)
+	changeURL := fmt.Sprintf("%s/passport/v2/login/student/change-stu", c.courseBase())
+	token, _ := c.GetAuth()
 
 	payload := map[string]string{
 		"stuPuId":        nextUID,
 		"currentStuPuId": currentUID,
-		"signToken":      c.token,
+		"signToken":      token,
 	}
 
 	headers := map[string]string{
 		"Content-Type": "application/json",
 		"terminal":     "pc",
-		"token":        c.token,
+		"token":        token,
 		"User-Agent":   "Mozilla/5.0 (Windows NT 10.0; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/78.0.3904.108 Safari/537.36",
 		"version":      "3.22.0.99",
 	}
@@ -257,10 +257,25 @@ func (c *Client) SwitchStudentAccount(currentUID, nextUID string) error {
 	defer resp.Body.Close()
 
 	var result models.AuthFinalResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := decodeResponse(resp, &result); err != nil {
 		return err
 	}
 
 	c.SetAuth(result.Token, fmt.Sprint(result.UserID))
 	return nil
 }
+
+// ValidateToken 检查 token 是否仍然有效。没有 token、HTTP 401/403 或学员列表为空时返回 ErrAuthExpired 类别的错误。
+// 接口对过期 token 的业务错误码没有公开，业务错误原样返回（见 session.Session.Validate），
+// 无法解析的响应和网络或服务器错误同样原样返回，此时无法判断 token 是否有效
+func (c *Client) ValidateToken() error {
+	if token, _ := c.GetAuth(); token == "" {
+		return &Error{Kind: ErrAuthExpired}
+	}
+
+	accounts, err := c.GetStudentAccounts()
+	if err == nil && len(accounts) == 0 {
+		return &Error{Kind: ErrAuthExpired}
+	}
+	return err
+}
@@ -7,7 +7,8 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"strings"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/itsHenry35/tal_downloader/config"
@@ -16,19 +17,66 @@ import (
 
 type Client struct {
 	httpClient *http.Client
-	token      string
-	userID     string
+	platform   *config.Platform
+	retry      RetryPolicy
+	endpoints  Endpoints        // 覆盖平台的接口地址
+	headers    []headerOverride // 覆盖固定请求头
+
+	authMu sync.RWMutex // 保护登录态，重新登录时下载协程仍在发送请求
+	token  string
+	userID string
 }
 
-func NewClient() *Client {
-	return &Client{
+// NewClient 创建访问指定平台的客户端，platform 为 nil 时使用默认平台
+func NewClient(platform *config.Platform, opts ...Option) *Client {
+	if platform == nil {
+		platform = config.DefaultPlatform()
+	}
+	c := &Client{
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		platform: platform,
+		retry:    DefaultRetryPolicy(),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
+}
+
+// Clone 复制客户端（包括平台和登录态），复制出的客户端可以独立切换学员
+func (c *Client) Clone() *Client {
+	token, userID := c.GetAuth()
+	return &Client{
+		httpClient: c.httpClient,
+		platform:   c.platform,
+		retry:      c.retry,
+		endpoints:  c.endpoints,
+		headers:    append([]headerOverride(nil), c.headers...),
+		token:      token,
+		userID:     userID,
+	}
+}
+
+// Platform 客户端所属的平台
+func (c *Client) Platform() *config.Platform {
+	return c.platform
+}
+
+// SetPlatform 切换客户端所属的平台（登录前选择平台时使用）
+func (c *Client) SetPlatform(platform *config.Platform) {
+	c.platform = platform
+}
+
+// SetRetryPolicy 设置 GET 请求的重试策略
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
 }
 
 func (c *Client) SetAuth(token, userID string) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
 	if token != "" {
 		c.token = token
 	}
@@ -39,36 +87,75 @@ func (c *Client) SetAuth(token, userID string) {
 
 // returns token, userID
 func (c *Client) GetAuth() (string, string) {
+	c.authMu.RLock()
+	defer c.authMu.RUnlock()
 	return c.token, c.userID
 }
 
+// studentID 当前学员ID，用于请求参数
+func (c *Client) studentID() string {
+	_, userID := c.GetAuth()
+	return userID
+}
+
 func (c *Client) getDefaultHeaders() map[string]string {
 	return map[string]string{
 		"User-Agent": config.UserAgent,
 		"Referer":    "https://speiyou.cn/",
 		"terminal":   config.Terminal,
-		"version":    config.Version,
-		"resVer":     config.ResVer,
+		"version":    c.platform.VersionHeader(),
+		"resVer":     c.platform.ResVerHeader(),
 	}
 }
 
 // nodh -> no default headers
+// GET 请求遇到网络错误、限流或服务器错误时按重试策略自动重试；
+// 返回的错误为 *Error，非 2xx 的响应也会作为错误返回
 func (c *Client) doRequest(method, urlStr string, body interface{}, headers map[string]string, nodh bool) (*http.Response, error) {
-	var reqBody io.Reader
-
+	var bodyData []byte
 	if body != nil {
 		switch v := body.(type) {
 		case url.Values:
-			reqBody = strings.NewReader(v.Encode())
+			bodyData = []byte(v.Encode())
 		default:
 			jsonData, err := json.Marshal(body)
 			if err != nil {
 				return nil, err
 			}
-			reqBody = bytes.NewReader(jsonData)
+			bodyData = jsonData
 		}
 	}
 
+	attempts := 1
+	if method == http.MethodGet && c.retry.MaxAttempts > 1 {
+		attempts = c.retry.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.doRequestOnce(method, urlStr, bodyData, body != nil, headers, nodh)
+		if err == nil {
+			return resp, nil
+		}
+		apiErr, ok := err.(*Error)
+		if !ok || !apiErr.Temporary() || attempt >= attempts {
+			return nil, err
+		}
+
+		delay := c.retry.delay(attempt, apiErr.RetryAfter)
+		if constants.Version == "Debug" {
+			fmt.Fprintf(os.Stderr, "Request failed (%v), retrying in %v\n", err, delay)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// doRequestOnce 发送一次请求并读取完整的响应
+func (c *Client) doRequestOnce(method, urlStr string, bodyData []byte, hasBody bool, headers map[string]string, nodh bool) (*http.Response, error) {
+	var reqBody io.Reader
+	if hasBody {
+		reqBody = bytes.NewReader(bodyData)
+	}
+
 	req, err := http.NewRequest(method, urlStr, reqBody)
 	if err != nil {
 		return nil, err
@@ -85,37 +172,43 @@ func (c *Client) doRequest(method, urlStr string, body interface{}, headers map[
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
+	c.applyHeaderOverrides(req)
 
 	// Add auth headers if available
-	if c.token != "" {
-		req.Header.Set("token", c.token)
+	token, userID := c.GetAuth()
+	if token != "" {
+		req.Header.Set("token", token)
 	}
-	if c.userID != "" {
-		req.Header.Set("stuId", c.userID)
+	if userID != "" {
+		req.Header.Set("stuId", userID)
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &Error{Kind: ErrNetwork, Method: method, URL: urlStr, Err: err}
 	}
+	defer resp.Body.Close()
 
 	if constants.Version == "Debug" {
-		fmt.Println("Request URL:", urlStr)
-		fmt.Println("Request Method:", method)
+		fmt.Fprintln(os.Stderr, "Request URL:", urlStr)
+		fmt.Fprintln(os.Stderr, "Request Method:", method)
 		// print request headers
-		fmt.Println("Request Headers:", req.Header)
+		fmt.Fprintln(os.Stderr, "Request Headers:", req.Header)
 	}
 	// print response body
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, &Error{Kind: ErrNetwork, Method: method, URL: urlStr, StatusCode: resp.StatusCode, Err: err}
 	}
 	// Reset the response body so it can be read again later
 	resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 	if constants.Version == "Debug" {
-		fmt.Println("Response Status:", resp.Status)
-		fmt.Println("Response Body:", string(bodyBytes))
+		fmt.Fprintln(os.Stderr, "Response Status:", resp.Status)
+		fmt.Fprintln(os.Stderr, "Response Body:", string(bodyBytes))
 	}
 
+	if apiErr := classifyResponse(req, resp, bodyBytes, req.Header.Get("token") != ""); apiErr != nil {
+		return nil, apiErr
+	}
 	return resp, nil
 }
@@ -1,10 +1,8 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 
-	"github.com/itsHenry35/tal_downloader/config"
 	"github.com/itsHenry35/tal_downloader/models"
 	"github.com/itsHenry35/tal_downloader/utils"
 )
@@ -17,7 +15,7 @@ func (c *Client) GetCourseList() ([]*models.Course, error) {
 
 	for {
 		coursesURL := fmt.Sprintf("%s/course/v1/student/course/list?stuId=%s&courseStatus=0&stdSubject=&page=%d&perPage=%d&order=desc",
-			config.CourseAPIBase, c.userID, page, perPage)
+			c.courseBase(), c.studentID(), page, perPage)
 
 		resp, err := c.doRequest("GET", coursesURL, nil, nil, false)
 		if err != nil {
@@ -25,7 +23,7 @@ func (c *Client) GetCourseList() ([]*models.Course, error) {
 		}
 
 		var courses []*models.Course
-		if err := json.NewDecoder(resp.Body).Decode(&courses); err != nil {
+		if err := decodeResponse(resp, &courses); err != nil {
 			resp.Body.Close()
 			return nil, err
 		}
@@ -50,7 +48,7 @@ func (c *Client) GetLectures(courseID string) ([]*models.Lecture, error) {
 
 	for {
 		lecturesURL := fmt.Sprintf("%s/course/v1/student/course/user-live-list?stuId=%s&stdCourseId=%s&type=1&needPage=1&page=%d&perPage=%d&order=asc",
-			config.CourseAPIBase, c.userID, courseID, page, perPage)
+			c.courseBase(), c.studentID(), courseID, page, perPage)
 
 		resp, err := c.doRequest("GET", lecturesURL, nil, nil, false)
 		if err != nil {
@@ -58,7 +56,7 @@ func (c *Client) GetLectures(courseID string) ([]*models.Lecture, error) {
 		}
 
 		var lectures []*models.Lecture
-		if err := json.NewDecoder(resp.Body).Decode(&lectures); err != nil {
+		if err := decodeResponse(resp, &lectures); err != nil {
 			resp.Body.Close()
 			return nil, err
 		}
@@ -75,10 +73,9 @@ func (c *Client) GetLectures(courseID string) ([]*models.Lecture, error) {
 	return allLectures, nil
 }
 
-// GetVideoURL retrieves the download URL for a video
-func (c *Client) GetVideoURL(lecture *models.Lecture, courseID, tutorID string) (string, error) {
+// GetVideoSources retrieves the available definitions of a video, sorted from highest to lowest
+func (c *Client) GetVideoSources(lecture *models.Lecture, courseID, tutorID string) ([]models.VideoSource, error) {
 	headers := map[string]string{
-		"Host":          "classroom-api-online.saasp.vdyoo.com",
 		"lecturerId":    lecture.LecturerID,
 		"stdSubject":    lecture.SubjectID,
 		"tutorId":       tutorID,
@@ -92,51 +89,71 @@ func (c *Client) GetVideoURL(lecture *models.Lecture, courseID, tutorID string)
 
 	switch lecture.LiveTypeString {
 	case "SMALL_GROUPS_V2_MODE", "COMBINE_SMALL_CLASS_MODE", "SMALL_CLASS_MODE", "GENERAL_V2_MODE":
-		url := fmt.Sprintf("%s/playback/v1/video/init", config.ClassroomAPIBase)
+		url := fmt.Sprintf("%s/playback/v1/video/init", c.classroomBase())
 		resp, err := c.doRequest("GET", url, nil, headers, false)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		defer resp.Body.Close()
 
 		var result models.VideoUrlResponse
 
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			return "", err
+		if err := decodeResponse(resp, &result); err != nil {
+			return nil, err
 		}
 
-		return utils.ParseVideoUrl(result.VideoURLs, result.Message)
+		videoURL, err := utils.ParseVideoUrl(result.VideoURLs, result.Message)
+		if err != nil {
+			return nil, err
+		}
+		return []models.VideoSource{{URL: videoURL}}, nil
 
 	case "RECORD_MODE", "ONLINE_REAL_RECORD":
-		url := fmt.Sprintf("%s/classroom-ai/record/v1/resources", config.ClassroomAPIBase)
+		url := fmt.Sprintf("%s/classroom-ai/record/v1/resources", c.classroomBase())
 		resp, err := c.doRequest("GET", url, nil, headers, false)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		defer resp.Body.Close()
 
 		var result models.RecordModeVideoUrlResponse
 
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			return "", err
+		if err := decodeResponse(resp, &result); err != nil {
+			return nil, err
 		}
 
-		var videoURL string
-
-		for _, urls := range result.Definitions {
+		var sources []models.VideoSource
+		for definition, urls := range result.Definitions {
 			if len(urls) > 0 {
-				videoURL = urls[len(urls)-1]
-				break
+				sources = append(sources, models.VideoSource{Definition: definition, URL: urls[len(urls)-1]})
 			}
 		}
 
-		if videoURL == "" {
-			return "", fmt.Errorf("未找到回放：%s", result.Message)
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("未找到回放：%s", result.Message)
 		}
-		return videoURL, nil
+		utils.SortVideoSources(sources)
+		return sources, nil
 
 	default:
-		return "", fmt.Errorf("unsupported live type: %s", lecture.LiveTypeString)
+		return nil, fmt.Errorf("unsupported live type: %s", lecture.LiveTypeString)
 	}
+}
 
+// GetVideoSource retrieves the download URL of a video in the preferred definition
+func (c *Client) GetVideoSource(lecture *models.Lecture, courseID, tutorID, preference string) (models.VideoSource, error) {
+	sources, err := c.GetVideoSources(lecture, courseID, tutorID)
+	if err != nil {
+		return models.VideoSource{}, err
+	}
+	return utils.SelectVideoSource(sources, preference)
+}
+
+// GetVideoURL retrieves the download URL for a video in the highest definition
+func (c *Client) GetVideoURL(lecture *models.Lecture, courseID, tutorID string) (string, error) {
+	source, err := c.GetVideoSource(lecture, courseID, tutorID, models.DefinitionHighest)
+	if err != nil {
+		return "", err
+	}
+	return source.URL, nil
 }
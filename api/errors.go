@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorKind 接口错误的类别
+type ErrorKind int
+
+const (
+	ErrNetwork     ErrorKind = iota + 1 // 网络错误或超时
+	ErrAuthExpired                      // 登录已过期，需要重新登录
+	ErrRateLimited                      // 请求过于频繁（HTTP 429）
+	ErrServer                           // 服务器错误（HTTP 5xx）
+	ErrDecode                           // 响应无法解析
+	ErrBusiness                         // 接口返回的业务错误（errcode/errmsg）
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrNetwork:
+		return "network"
+	case ErrAuthExpired:
+		return "auth_expired"
+	case ErrRateLimited:
+		return "rate_limited"
+	case ErrServer:
+		return "server"
+	case ErrDecode:
+		return "decode"
+	case ErrBusiness:
+		return "business"
+	}
+	return "unknown"
+}
+
+// Error 接口请求失败的原因
+type Error struct {
+	Kind       ErrorKind
+	Method     string
+	URL        string
+	StatusCode int           // HTTP 状态码，网络错误时为0
+	Code       int           // 业务错误码
+	Message    string        // 接口返回的错误信息
+	RetryAfter time.Duration // 服务器要求的重试间隔（Retry-After）
+	Err        error         // 底层错误
+}
+
+func (e *Error) Error() string {
+	switch e.Kind {
+	case ErrNetwork:
+		return fmt.Sprintf("网络错误: %v", e.Err)
+	case ErrAuthExpired:
+		return "登录已过期，请重新登录"
+	case ErrRateLimited:
+		return "请求过于频繁，请稍后再试"
+	case ErrServer:
+		return fmt.Sprintf("服务器错误 (HTTP %d)", e.StatusCode)
+	case ErrDecode:
+		return fmt.Sprintf("解析响应失败: %v", e.Err)
+	}
+	if e.Message != "" {
+		return e.Message
+	}
+	if e.StatusCode != 0 && e.StatusCode != http.StatusOK {
+		return fmt.Sprintf("请求失败 (HTTP %d)", e.StatusCode)
+	}
+	return fmt.Sprintf("请求失败 (错误码 %d)", e.Code)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary 是否为可以重试的临时错误
+func (e *Error) Temporary() bool {
+	return e.Kind == ErrNetwork || e.Kind == ErrRateLimited || e.Kind == ErrServer
+}
+
+// KindOf 返回错误的类别，不是接口错误时返回0
+func KindOf(err error) ErrorKind {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	return 0
+}
+
+// IsAuthExpired 判断是否因登录过期而失败
+func IsAuthExpired(err error) bool {
+	return KindOf(err) == ErrAuthExpired
+}
+
+// IsTemporary 判断是否为网络、限流或服务器等临时错误
+func IsTemporary(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Temporary()
+}
+
+// businessError 接口返回的业务错误
+func businessError(code int, message string) *Error {
+	return &Error{Kind: ErrBusiness, Code: code, Message: message}
+}
+
+// errorBody 错误响应中常见的字段
+type errorBody struct {
+	ErrCode json.RawMessage `json:"errcode"`
+	Code    json.RawMessage `json:"code"`
+	ErrMsg  string          `json:"errmsg"`
+	Message string          `json:"message"`
+	Msg     string          `json:"msg"`
+}
+
+// parseErrorBody 从响应中读取错误码和错误信息
+func parseErrorBody(body []byte) (code int, message string) {
+	var eb errorBody
+	if json.Unmarshal(body, &eb) != nil {
+		return 0, ""
+	}
+	for _, raw := range []json.RawMessage{eb.ErrCode, eb.Code} {
+		if len(raw) == 0 {
+			continue
+		}
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			code, _ = strconv.Atoi(s)
+		} else {
+			json.Unmarshal(raw, &code)
+		}
+		if code != 0 {
+			break
+		}
+	}
+	for _, m := range []string{eb.ErrMsg, eb.Message, eb.Msg} {
+		if m != "" {
+			message = m
+			break
+		}
+	}
+	return code, message
+}
+
+// classifyResponse 根据状态码判断响应是否失败，成功时返回 nil
+func classifyResponse(req *http.Request, resp *http.Response, body []byte, authenticated bool) *Error {
+	status := resp.StatusCode
+	if status >= 200 && status < 300 {
+		return nil
+	}
+
+	e := &Error{Method: req.Method, URL: req.URL.String(), StatusCode: status}
+	e.Code, e.Message = parseErrorBody(body)
+	switch {
+	case (status == http.StatusUnauthorized || status == http.StatusForbidden) && authenticated:
+		e.Kind = ErrAuthExpired
+	case status == http.StatusTooManyRequests:
+		e.Kind = ErrRateLimited
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			e.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	case status >= 500:
+		e.Kind = ErrServer
+	default:
+		e.Kind = ErrBusiness
+	}
+	return e
+}
+
+// decodeResponse 解析 JSON 响应。无法解析时，若响应是带错误信息的业务错误则返回该错误
+func decodeResponse(resp *http.Response, v interface{}) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &Error{Kind: ErrNetwork, Method: resp.Request.Method, URL: resp.Request.URL.String(), StatusCode: resp.StatusCode, Err: err}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		if code, message := parseErrorBody(body); code != 0 || message != "" {
+			e := businessError(code, message)
+			e.Method, e.URL, e.StatusCode = resp.Request.Method, resp.Request.URL.String(), resp.StatusCode
+			return e
+		}
+		return &Error{Kind: ErrDecode, Method: resp.Request.Method, URL: resp.Request.URL.String(), StatusCode: resp.StatusCode, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Endpoints 客户端使用的接口地址，为空的字段使用平台配置中的地址
+type Endpoints struct {
+	Passport  string // 账号密码/短信登录接口
+	Course    string // 课程接口
+	Classroom string // 回放接口
+}
+
+// Option 创建客户端时的可选配置，用于让客户端访问镜像、录制代理或本地模拟服务器
+type Option func(*Client)
+
+// WithEndpoints 覆盖平台的接口地址
+func WithEndpoints(endpoints Endpoints) Option {
+	return func(c *Client) {
+		if endpoints.Passport != "" {
+			c.endpoints.Passport = strings.TrimSuffix(endpoints.Passport, "/")
+		}
+		if endpoints.Course != "" {
+			c.endpoints.Course = strings.TrimSuffix(endpoints.Course, "/")
+		}
+		if endpoints.Classroom != "" {
+			c.endpoints.Classroom = strings.TrimSuffix(endpoints.Classroom, "/")
+		}
+	}
+}
+
+// WithHeader 覆盖所有请求中的固定请求头（包括各接口自带的请求头）。
+// value 为空时不发送该请求头；名称为 Host 时设置请求的 Host
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.headers = append(c.headers, headerOverride{key: key, value: value})
+	}
+}
+
+// WithHTTPClient 使用指定的 http.Client（如设置了代理或自定义 Transport 的客户端）
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetryPolicy 设置 GET 请求的重试策略
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// headerOverride 按设置顺序应用的请求头覆盖
+type headerOverride struct {
+	key   string
+	value string
+}
+
+// passportBase 等返回实际使用的接口地址
+func (c *Client) passportBase() string {
+	if c.endpoints.Passport != "" {
+		return c.endpoints.Passport
+	}
+	return c.platform.PassportAPIBase
+}
+
+func (c *Client) courseBase() string {
+	if c.endpoints.Course != "" {
+		return c.endpoints.Course
+	}
+	return c.platform.CourseAPIBase
+}
+
+func (c *Client) classroomBase() string {
+	if c.endpoints.Classroom != "" {
+		return c.endpoints.Classroom
+	}
+	return c.platform.ClassroomAPIBase
+}
+
+// Endpoints 客户端实际使用的接口地址
+func (c *Client) Endpoints() Endpoints {
+	return Endpoints{Passport: c.passportBase(), Course: c.courseBase(), Classroom: c.classroomBase()}
+}
+
+// applyHeaderOverrides 先应用平台配置的请求头，再应用客户端的覆盖
+func (c *Client) applyHeaderOverrides(req *http.Request) {
+	apply := func(key, value string) {
+		if strings.EqualFold(key, "Host") {
+			req.Host = value
+			return
+		}
+		if value == "" {
+			req.Header.Del(key)
+		} else {
+			req.Header.Set(key, value)
+		}
+	}
+	for key, value := range c.platform.Headers {
+		apply(key, value)
+	}
+	for _, h := range c.headers {
+		apply(h.key, h.value)
+	}
+}
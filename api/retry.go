@@ -0,0 +1,51 @@
+package api
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/config"
+)
+
+// RetryPolicy 幂等请求（GET）遇到临时错误时的重试策略，等待时间按指数增长并加入随机抖动
+type RetryPolicy struct {
+	MaxAttempts int           // 最多尝试次数（包括第一次），小于等于1时不重试
+	BaseDelay   time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	MaxDelay    time.Duration // 单次等待时间的上限
+	Jitter      float64       // 随机抖动的比例（0~1）
+}
+
+// DefaultRetryPolicy 默认的重试策略
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: config.APIMaxAttempts,
+		BaseDelay:   config.APIRetryBaseDelay,
+		MaxDelay:    config.APIRetryMaxDelay,
+		Jitter:      0.2,
+	}
+}
+
+// NoRetry 不重试
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// delay 第 attempt 次重试（从1开始）前的等待时间
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	// 服务器指定了重试间隔时至少等待该时间
+	if retryAfter > d {
+		d = retryAfter
+		if p.MaxDelay > 0 && d > p.MaxDelay {
+			d = p.MaxDelay
+		}
+	}
+	return d
+}
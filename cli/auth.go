@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/config"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+func runLogin(args []string) int {
+	fs := newFlagSet("login")
+	platformID := fs.String("platform", "ledu", "平台: ledu、xes 或 platforms.json 中配置的平台")
+	username := fs.String("username", "", "手机号或学员编号（账号密码登录）")
+	password := fs.String("password", "", "密码（账号密码登录）")
+	phone := fs.String("phone", "", "手机号（短信验证码登录）")
+	smsCode := fs.String("sms-code", "", "短信验证码")
+	zone := fs.String("zone", "86", "手机号区号: 86、886、853、852")
+	sendSMS := fs.Bool("send-sms", false, "仅发送短信验证码到 -phone")
+	rememberPassword := fs.Bool("remember-password", false, "加密保存密码，登录过期后自动重新登录（仅账号密码登录）")
+	asJSON := fs.Bool("json", false, "以JSON输出")
+	var af apiFlags
+	af.register(fs)
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+
+	platform, err := config.GetPlatform(*platformID)
+	if err != nil {
+		return fail(err)
+	}
+
+	client := api.NewClient(platform, af.options()...)
+
+	if *sendSMS {
+		if *phone == "" {
+			return fail(fmt.Errorf("请使用 -phone 指定手机号"))
+		}
+		if err := client.SendSMSCode(*phone, *zone); err != nil {
+			return fail(err)
+		}
+		fmt.Fprintln(os.Stderr, "验证码已发送")
+		return exitOK
+	}
+
+	var (
+		authData *models.AuthData
+		user     models.SavedUser
+	)
+	switch {
+	case *username != "" && *password != "":
+		authData, err = client.LoginWithPassword(*username, *password)
+		user.Username = *username
+		if *rememberPassword {
+			user.Password = *password
+		}
+	case *phone != "" && *smsCode != "":
+		if *rememberPassword {
+			fmt.Fprintln(os.Stderr, "-remember-password 仅用于账号密码登录")
+			return exitUsage
+		}
+		authData, err = client.LoginWithSMS(*phone, *smsCode, *zone)
+		user.Username = *phone
+	default:
+		fmt.Fprintln(os.Stderr, "请填写 -username 和 -password，或 -phone 和 -sms-code")
+		return exitUsage
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	// 命令行模式依赖保存的账号在多次调用之间保持登录态
+	user.Nickname, user.Token, user.Platform, user.UserID = authData.Nickname, authData.Token, platform.Name, authData.UserID
+	if err := utils.SaveUser(user); err != nil {
+		return fail(fmt.Errorf("保存用户信息失败: %v", err))
+	}
+
+	if *asJSON {
+		printJSON(map[string]string{
+			"username": user.Username,
+			"nickname": authData.Nickname,
+			"userId":   authData.UserID,
+			"platform": platform.Name,
+		})
+	} else {
+		fmt.Printf("登录成功: %s (%s) - %s\n", authData.Nickname, authData.UserID, platform.Name)
+	}
+	return exitOK
+}
+
+func runListStudents(args []string) int {
+	fs := newFlagSet("list-students")
+	var sf sessionFlags
+	sf.register(fs)
+	asJSON := fs.Bool("json", false, "以JSON输出")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return fail(err)
+	}
+
+	accounts, err := s.Client.GetStudentAccounts()
+	if err != nil {
+		return fail(err)
+	}
+
+	_, currentUID := s.Client.GetAuth()
+	for _, acc := range accounts {
+		current := fmt.Sprint(acc.PuUID) == currentUID
+		if *asJSON {
+			printJSON(map[string]interface{}{
+				"uid":      acc.PuUID,
+				"nickname": acc.Nickname,
+				"current":  current,
+			})
+			continue
+		}
+		marker := " "
+		if current {
+			marker = "*"
+		}
+		fmt.Printf("%s %-12d %s\n", marker, acc.PuUID, acc.Nickname)
+	}
+	return exitOK
+}
+
+func runSwitchStudent(args []string) int {
+	fs := newFlagSet("switch-student")
+	var sf sessionFlags
+	sf.register(fs)
+	target := fs.String("to", "", "要切换到的学员（学员ID或昵称）")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+
+	if *target == "" {
+		fmt.Fprintln(os.Stderr, "请使用 -to 指定学员")
+		return exitUsage
+	}
+	if sf.token != "" {
+		fmt.Fprintln(os.Stderr, "switch-student 需要保存的账号，不能与 -token 一起使用")
+		return exitUsage
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return fail(err)
+	}
+
+	selected, err := switchStudent(s.Client, *target)
+	if err != nil {
+		return fail(err)
+	}
+
+	token, uid := s.Client.GetAuth()
+	if err := utils.AddUser(s.SavedUser.Username, selected.Nickname, token, s.SavedUser.Platform, uid); err != nil {
+		return fail(fmt.Errorf("保存用户信息失败: %v", err))
+	}
+
+	fmt.Printf("已切换到学员: %s (%s)\n", selected.Nickname, uid)
+	return exitOK
+}
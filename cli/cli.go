@@ -0,0 +1,397 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/config"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+const commandName = "tal_downloader cli"
+
+// 退出码
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+	exitAuth   = 3 // 登录已过期，需要重新登录
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"login", "登录并保存账号（账号密码或短信验证码）", runLogin},
+		{"list-students", "列出当前账号下的学员", runListStudents},
+		{"switch-student", "切换学员并保存到账号", runSwitchStudent},
+		{"list-courses", "列出课程", runListCourses},
+		{"list-lectures", "列出课程的讲次", runListLectures},
+		{"download", "下载课程回放", runDownload},
+		{"resume", "继续当前学员未完成的下载", runResume},
+		{"subscribe", "订阅课程，或列出、取消订阅", runSubscribe},
+		{"sync", "同步订阅的课程，只下载新结束的讲", runSync},
+		{"daemon", "后台运行，按计划同步订阅的课程", runDaemon},
+		{"serve", "启动网页界面，在浏览器中登录、选择课程和管理下载", runServe},
+		{"history", "查看下载记录", runHistory},
+		{"settings", "查看或修改下载设置（同时下载数、线程数、限速）", runSettings},
+	}
+}
+
+// Run 执行命令行模式，返回进程退出码
+func Run(args []string) int {
+	if err := utils.LoadPlatformConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "加载平台配置失败: %v\n", err)
+	}
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "未知命令: %s\n\n", args[0])
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "用法: %s <命令> [参数]\n\n命令:\n", commandName)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(w, "\n使用 \"%s <命令> -h\" 查看命令参数\n", commandName)
+}
+
+// newFlagSet 创建子命令的参数集
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(commandName+" "+name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// parseFlags 解析参数，返回非负值时表示应直接以该退出码结束
+func parseFlags(fs *flag.FlagSet, args []string) int {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "多余的参数: %s\n", strings.Join(fs.Args(), " "))
+		return exitUsage
+	}
+	return -1
+}
+
+// headerList 可重复指定的 -header 参数，格式为 "名称: 值"
+type headerList [][2]string
+
+func (h *headerList) String() string {
+	parts := make([]string, len(*h))
+	for i, kv := range *h {
+		parts[i] = kv[0] + ": " + kv[1]
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h *headerList) Set(value string) error {
+	key, val, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("请求头格式应为 \"名称: 值\"")
+	}
+	*h = append(*h, [2]string{strings.TrimSpace(key), strings.TrimSpace(val)})
+	return nil
+}
+
+// apiFlags 覆盖接口地址和请求头的参数，用于访问镜像、录制代理或本地模拟服务器
+type apiFlags struct {
+	passport  string
+	course    string
+	classroom string
+	headers   headerList
+}
+
+func (af *apiFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&af.passport, "passport-api", "", "覆盖登录接口地址")
+	fs.StringVar(&af.course, "course-api", "", "覆盖课程接口地址")
+	fs.StringVar(&af.classroom, "classroom-api", "", "覆盖回放接口地址")
+	fs.Var(&af.headers, "header", "覆盖请求头，格式为 \"名称: 值\"，值为空时不发送，可重复指定")
+}
+
+// options 转换为创建客户端的选项
+func (af *apiFlags) options() []api.Option {
+	opts := []api.Option{api.WithEndpoints(api.Endpoints{
+		Passport:  af.passport,
+		Course:    af.course,
+		Classroom: af.classroom,
+	})}
+	for _, kv := range af.headers {
+		opts = append(opts, api.WithHeader(kv[0], kv[1]))
+	}
+	return opts
+}
+
+// sessionFlags 需要登录态的命令共用的参数
+type sessionFlags struct {
+	platform string
+	user     string
+	token    string
+	uid      string
+	student  string
+	api      apiFlags
+}
+
+func (sf *sessionFlags) register(fs *flag.FlagSet) {
+	sf.api.register(fs)
+	fs.StringVar(&sf.platform, "platform", "", "平台: ledu、xes 或 platforms.json 中配置的平台（默认使用保存账号的平台）")
+	fs.StringVar(&sf.user, "user", "", "保存的账号用户名（仅保存了一个账号时可省略）")
+	fs.StringVar(&sf.token, "token", "", "直接使用 token 登录（需同时指定 -uid）")
+	fs.StringVar(&sf.uid, "uid", "", "与 -token 搭配使用的学员ID")
+	fs.StringVar(&sf.student, "student", "", "本次运行临时切换到的学员（学员ID或昵称）")
+}
+
+// open 根据参数恢复登录态
+func (sf *sessionFlags) open() (*session.Session, error) {
+	var platform *config.Platform
+	if sf.platform != "" {
+		var err error
+		if platform, err = config.GetPlatform(sf.platform); err != nil {
+			return nil, err
+		}
+	}
+
+	var s *session.Session
+	if sf.token != "" {
+		if sf.uid == "" {
+			return nil, fmt.Errorf("使用 -token 时必须指定 -uid")
+		}
+		s = &session.Session{Client: api.NewClient(platform, sf.api.options()...)}
+		s.Client.SetAuth(sf.token, sf.uid)
+	} else {
+		user, err := findSavedUser(sf.user, platform)
+		if err != nil {
+			return nil, err
+		}
+		if s, err = session.OpenSavedUser(*user, sf.api.options()...); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateSession(s); err != nil {
+		return nil, err
+	}
+
+	if sf.student != "" {
+		selected, err := switchStudent(s.Client, sf.student)
+		if err != nil {
+			return nil, err
+		}
+		s.StudentName = selected.Nickname
+	}
+	return s, nil
+}
+
+// validateSession 检查登录是否有效，过期时尝试使用保存的密码重新登录。
+// 网络等临时错误时无法判断，交给后续请求处理
+func validateSession(s *session.Session) error {
+	if err := s.Validate(); err != nil && !api.IsTemporary(err) {
+		return err
+	}
+	return nil
+}
+
+// openAll 打开一个或多个会话。allUsers 时使用所有保存的账号（可用 -platform 限定平台）；
+// students 为 "all" 或逗号分隔的学员ID/昵称时，为每个账号下的这些学员分别创建会话
+func (sf *sessionFlags) openAll(allUsers bool, students string) ([]*session.Session, error) {
+	var bases []*session.Session
+	if allUsers {
+		if sf.user != "" || sf.token != "" || sf.student != "" {
+			return nil, fmt.Errorf("-all-users 不能与 -user、-token、-student 同时使用")
+		}
+		var platform *config.Platform
+		if sf.platform != "" {
+			var err error
+			if platform, err = config.GetPlatform(sf.platform); err != nil {
+				return nil, err
+			}
+		}
+		data, err := utils.LoadSavedUsers()
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range data.Users {
+			if platform != nil && user.Platform != platform.Name {
+				continue
+			}
+			s, err := session.OpenSavedUser(user, sf.api.options()...)
+			if err != nil {
+				return nil, err
+			}
+			if err := validateSession(s); err != nil {
+				return nil, err
+			}
+			bases = append(bases, s)
+		}
+		if len(bases) == 0 {
+			return nil, fmt.Errorf("没有保存的账号，请先执行 login")
+		}
+	} else {
+		s, err := sf.open()
+		if err != nil {
+			return nil, err
+		}
+		bases = append(bases, s)
+	}
+
+	manager := session.NewManager()
+	if students == "" {
+		for _, s := range bases {
+			manager.Add(s)
+		}
+		return manager.Sessions(), nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(students, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			wanted[name] = true
+		}
+	}
+	found := make(map[string]bool)
+	for _, base := range bases {
+		accounts, err := base.Client.GetStudentAccounts()
+		if err != nil {
+			return nil, err
+		}
+		var selected []*models.StudentAccount
+		for _, acc := range accounts {
+			id := fmt.Sprint(acc.PuUID)
+			if wanted["all"] || wanted[id] || wanted[acc.Nickname] {
+				selected = append(selected, acc)
+				found[id], found[acc.Nickname] = true, true
+			}
+		}
+		sessions, err := session.ForStudents(base, selected)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sessions {
+			manager.Add(s)
+		}
+	}
+	for name := range wanted {
+		if name != "all" && !found[name] {
+			return nil, fmt.Errorf("未找到学员: %s", name)
+		}
+	}
+	return manager.Sessions(), nil
+}
+
+// findSavedUser 按用户名（和平台）查找保存的账号，platform 为 nil 时不限平台
+func findSavedUser(username string, platform *config.Platform) (*models.SavedUser, error) {
+	data, err := utils.LoadSavedUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []models.SavedUser
+	for _, user := range data.Users {
+		if username != "" && user.Username != username {
+			continue
+		}
+		if platform != nil && user.Platform != platform.Name {
+			continue
+		}
+		matched = append(matched, user)
+	}
+
+	switch len(matched) {
+	case 0:
+		if username == "" {
+			return nil, fmt.Errorf("没有保存的账号，请先执行 login")
+		}
+		return nil, fmt.Errorf("未找到保存的账号: %s", username)
+	case 1:
+		return &matched[0], nil
+	default:
+		names := make([]string, len(matched))
+		for i, user := range matched {
+			names[i] = fmt.Sprintf("%s(%s)", user.Username, user.Platform)
+		}
+		return nil, fmt.Errorf("存在多个保存的账号，请使用 -user 指定: %s", strings.Join(names, ", "))
+	}
+}
+
+// switchStudent 将客户端切换到指定学员（学员ID或昵称），返回该学员
+func switchStudent(client *api.Client, target string) (*models.StudentAccount, error) {
+	accounts, err := client.GetStudentAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected *models.StudentAccount
+	for _, acc := range accounts {
+		if fmt.Sprint(acc.PuUID) == target || acc.Nickname == target {
+			selected = acc
+			break
+		}
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("未找到学员: %s", target)
+	}
+
+	_, currentUID := client.GetAuth()
+	selectedUID := fmt.Sprint(selected.PuUID)
+	if selectedUID == currentUID {
+		return selected, nil
+	}
+
+	if err := client.SwitchStudentAccount(currentUID, selectedUID); err != nil {
+		return nil, err
+	}
+	client.SetAuth("", selectedUID)
+	return selected, nil
+}
+
+// printJSON 以单行JSON输出
+func printJSON(v interface{}) {
+	fprintJSON(os.Stdout, v)
+}
+
+// fprintJSON 以单行JSON输出到 w
+func fprintJSON(w io.Writer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Fprintln(w, string(data))
+}
+
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+	if api.IsAuthExpired(err) {
+		fmt.Fprintf(os.Stderr, "请使用 %s login 重新登录\n", commandName)
+		return exitAuth
+	}
+	return exitFailed
+}
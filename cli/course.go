@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/models"
+)
+
+func runListCourses(args []string) int {
+	fs := newFlagSet("list-courses")
+	var sf sessionFlags
+	sf.register(fs)
+	asJSON := fs.Bool("json", false, "以JSON输出")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return fail(err)
+	}
+
+	courses, err := s.Client.GetCourseList()
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, course := range courses {
+		if *asJSON {
+			printJSON(course)
+			continue
+		}
+		fmt.Printf("%-24s %s - %s (已结束%d讲)\n", course.CourseID, course.SubjectName, course.CourseName, course.EndLiveNum)
+	}
+	return exitOK
+}
+
+func runListLectures(args []string) int {
+	fs := newFlagSet("list-lectures")
+	var sf sessionFlags
+	sf.register(fs)
+	courseID := fs.String("course", "", "课程ID（见 list-courses）")
+	asJSON := fs.Bool("json", false, "以JSON输出")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+
+	if *courseID == "" {
+		fmt.Fprintln(os.Stderr, "请使用 -course 指定课程ID")
+		return exitUsage
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return fail(err)
+	}
+
+	course, err := findCourse(s.Client, *courseID)
+	if err != nil {
+		return fail(err)
+	}
+
+	lectures, err := s.Client.GetLectures(course.CourseID)
+	if err != nil {
+		return fail(err)
+	}
+
+	for j, lecture := range lectures {
+		if *asJSON {
+			printJSON(lectureInfo(lecture, j, course))
+			continue
+		}
+		if err := lecture.TimeErr(); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: %s: %v\n", lecture.Name(j), err)
+		}
+		fmt.Printf("%s\t%d\t%s\t%s\n", lecture.Label(j), lecture.LiveID, lecture.LiveTypeString, lectureStatus(lecture, j, course))
+	}
+	return exitOK
+}
+
+// lectureStatus 讲次状态的显示文本，接口没有返回状态时按是否已结束判断
+func lectureStatus(lecture *models.Lecture, j int, course *models.Course) string {
+	if status := lecture.StatusText(); status != "" {
+		return status
+	}
+	if lecture.Ended(j, course) {
+		return "已结束"
+	}
+	return "未开始"
+}
+
+// lectureInfo 讲次的JSON输出（list-lectures -json 和网页模式的接口）
+func lectureInfo(lecture *models.Lecture, j int, course *models.Course) map[string]interface{} {
+	out := map[string]interface{}{
+		"index":    j + 1,
+		"number":   lecture.Number(j),
+		"liveId":   lecture.LiveID,
+		"liveType": lecture.LiveTypeString,
+		"title":    lecture.Title,
+		"teacher":  lecture.TeacherName,
+		"status":   lectureStatus(lecture, j, course),
+		"ended":    lecture.Ended(j, course),
+	}
+	if err := lecture.TimeErr(); err != nil {
+		out["timeError"] = err.Error()
+	}
+	if !lecture.StartTime.IsZero() {
+		out["startTime"] = lecture.StartTime.Format(time.RFC3339)
+		out["durationMinutes"] = int(lecture.Duration().Minutes())
+	}
+	return out
+}
+
+// findCourse 在课程列表中按ID查找课程
+func findCourse(client *api.Client, courseID string) (*models.Course, error) {
+	courses, err := client.GetCourseList()
+	if err != nil {
+		return nil, err
+	}
+	for _, course := range courses {
+		if course.CourseID == courseID {
+			return course, nil
+		}
+	}
+	return nil, fmt.Errorf("未找到课程: %s", courseID)
+}
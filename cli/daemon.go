@@ -0,0 +1,359 @@
+package cli
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/schedule"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+const daemonStatusFileName = "daemon_status.json"
+
+// daemonTokenFileName 程序数据目录中保存状态接口令牌的文件，POST /sync 需要在 daemonTokenHeader 中带上令牌
+const daemonTokenFileName = "daemon_token"
+
+// daemonTokenHeader 携带令牌的请求头。浏览器跨站发送自定义请求头前需要预检，其他网站的页面无法触发同步
+const daemonTokenHeader = "X-Daemon-Token"
+
+// daemonPasswordEnv 设置状态接口密码的环境变量
+const daemonPasswordEnv = "TAL_DAEMON_PASSWORD"
+
+// daemonCheckInterval 等待下一次同步时检查时间的间隔，系统休眠后也能按墙上时间及时触发
+const daemonCheckInterval = time.Minute
+
+func runDaemon(args []string) int {
+	fs := newFlagSet("daemon")
+	var af apiFlags
+	af.register(fs)
+	var qf qualityFlags
+	qf.register(fs)
+	var tf transferFlags
+	tf.register(fs)
+	cron := fs.String("schedule", "0 2 * * *", "同步计划: cron 表达式（分 时 日 月 周，如 \"0 2 * * *\" 每天2点）、@daily 或 @every 6h")
+	users := fs.String("users", "", "只同步这些保存账号的订阅，逗号分隔（默认全部）")
+	listen := fs.String("listen", "127.0.0.1:8765", "本地状态接口的监听地址，为空时不启用")
+	password := fs.String("password", os.Getenv(daemonPasswordEnv), "状态接口的访问密码（HTTP Basic 认证，用户名任意），也可以使用环境变量 "+daemonPasswordEnv+"；监听非本机地址时必须设置")
+	logPath := fs.String("log", "", "日志文件（JSON Lines，追加写入），默认输出到标准输出")
+	statusPath := fs.String("status-file", "", "状态文件路径（默认为程序数据目录中的 "+daemonStatusFileName+"）")
+	runNow := fs.Bool("run-now", false, "启动后立即同步一次")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+	if err := qf.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := tf.apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if *listen != "" && *password == "" && !isLoopback(*listen) {
+		fmt.Fprintf(os.Stderr, "状态接口监听非本机地址时必须使用 -password 或环境变量 %s 设置访问密码\n", daemonPasswordEnv)
+		return exitUsage
+	}
+	sched, err := schedule.Parse(*cron)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	// 启动时检查一次订阅，之后每次同步时重新加载
+	if _, err := loadSubscriptions(*users); err != nil {
+		return fail(err)
+	}
+
+	var logOut io.Writer = os.Stdout
+	if *logPath != "" {
+		file, err := os.OpenFile(*logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fail(err)
+		}
+		defer file.Close()
+		logOut = file
+	}
+	if *statusPath == "" {
+		*statusPath = filepath.Join(utils.GetRootPath(), daemonStatusFileName)
+	}
+	var token string
+	if *listen != "" {
+		if token, err = loadDaemonToken(filepath.Join(utils.GetRootPath(), daemonTokenFileName)); err != nil {
+			return fail(err)
+		}
+	}
+
+	d := &daemon{
+		schedule:   sched,
+		users:      *users,
+		af:         af,
+		qf:         qf,
+		tf:         tf,
+		log:        logOut,
+		statusPath: *statusPath,
+		password:   *password,
+		token:      token,
+		trigger:    make(chan string, 1),
+		status: daemonStatus{
+			PID:       os.Getpid(),
+			Schedule:  sched.String(),
+			State:     "idle",
+			StartedAt: time.Now(),
+		},
+	}
+	if *runNow {
+		d.trigger <- "startup"
+	}
+	return d.run(*listen)
+}
+
+// daemon 后台模式：按计划同步订阅，记录状态并提供本地状态接口
+type daemon struct {
+	schedule   *schedule.Schedule
+	users      string
+	af         apiFlags
+	qf         qualityFlags
+	tf         transferFlags
+	log        io.Writer // JSON Lines 日志
+	statusPath string
+	password   string      // 状态接口的访问密码，为空时只接受本机的请求
+	token      string      // POST /sync 需要的令牌，见 daemonTokenFileName
+	trigger    chan string // 计划外的同步（启动时或状态接口触发），值为触发原因
+
+	mu      sync.Mutex
+	status  daemonStatus
+	current *reporter // 正在进行的同步的输出，用于统计进度
+}
+
+// daemonStatus 写入状态文件和状态接口返回的内容
+type daemonStatus struct {
+	PID       int       `json:"pid"`
+	Schedule  string    `json:"schedule"`
+	State     string    `json:"state"` // idle、syncing、stopped
+	StartedAt time.Time `json:"started_at"`
+	NextRun   time.Time `json:"next_run"`
+	Runs      int       `json:"runs"` // 已完成的同步次数
+	Current   *syncRun  `json:"current,omitempty"`
+	LastRun   *syncRun  `json:"last_run,omitempty"`
+}
+
+// syncRun 一次同步的结果
+type syncRun struct {
+	Trigger    string    `json:"trigger"` // schedule、startup、manual
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Completed  int       `json:"completed"`
+	Skipped    int       `json:"skipped"`
+	Failed     int       `json:"failed"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// logEvent 以与下载进度相同的格式记录后台模式自身的事件
+func (d *daemon) logEvent(ev progressEvent) {
+	rep := newReporter(true)
+	rep.out, rep.stamp = d.log, true
+	rep.report(ev)
+}
+
+func (d *daemon) run(listen string) int {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	if listen != "" {
+		ln, err := net.Listen("tcp", listen)
+		if err != nil {
+			return fail(err)
+		}
+		srv := &http.Server{Handler: d.handler()}
+		go srv.Serve(ln)
+		defer srv.Close()
+	}
+	d.logEvent(progressEvent{Event: "daemon_started", Message: fmt.Sprintf("计划 %s，状态接口 %s", d.schedule, listen)})
+
+	for {
+		next := d.schedule.Next(time.Now())
+		if next.IsZero() {
+			return fail(fmt.Errorf("同步计划不会再触发: %s", d.schedule))
+		}
+		d.update(func(s *daemonStatus) { s.NextRun = next })
+
+		trigger := ""
+		for trigger == "" {
+			wait := time.Until(next)
+			if wait > daemonCheckInterval {
+				wait = daemonCheckInterval
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				d.update(func(s *daemonStatus) { s.State = "stopped" })
+				d.logEvent(progressEvent{Event: "daemon_stopped"})
+				return exitOK
+			case trigger = <-d.trigger:
+				timer.Stop()
+			case <-timer.C:
+				if !time.Now().Before(next) {
+					trigger = "schedule"
+				}
+			}
+		}
+		d.sync(trigger)
+
+		// 同步过程中收到的停止信号已经用于取消下载，此时退出
+		select {
+		case <-stop:
+			d.update(func(s *daemonStatus) { s.State = "stopped" })
+			d.logEvent(progressEvent{Event: "daemon_stopped"})
+			return exitOK
+		default:
+		}
+	}
+}
+
+// sync 执行一次同步，结果写入状态
+func (d *daemon) sync(trigger string) {
+	run := newDownloadRun(true, d.qf, d.tf)
+	run.rep.out, run.rep.stamp = d.log, true
+	current := &syncRun{Trigger: trigger, StartedAt: time.Now()}
+	d.mu.Lock()
+	d.current = run.rep
+	d.status.State = "syncing"
+	d.status.Current = current
+	d.mu.Unlock()
+	d.update(func(*daemonStatus) {})
+	run.rep.report(progressEvent{Event: "sync_started", Message: trigger})
+
+	subs, err := loadSubscriptions(d.users)
+	if err == nil {
+		syncSubscriptions(run, subs, d.af, *d.qf.definition, false)
+	} else {
+		run.rep.report(progressEvent{}.failed(err))
+		current.Error = err.Error()
+	}
+
+	current.FinishedAt = time.Now()
+	current.Completed, current.Skipped, current.Failed = run.rep.counts()
+	d.mu.Lock()
+	d.current = nil
+	d.mu.Unlock()
+	d.update(func(s *daemonStatus) {
+		s.State = "idle"
+		s.Current = nil
+		s.LastRun = current
+		s.Runs++
+	})
+}
+
+// snapshot 当前状态的副本，同步进行中时填写目前的完成数
+func (d *daemon) snapshot() daemonStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := d.status
+	if status.Current != nil && d.current != nil {
+		current := *status.Current
+		current.Completed, current.Skipped, current.Failed = d.current.counts()
+		status.Current = &current
+	}
+	return status
+}
+
+// update 修改状态并写入状态文件
+func (d *daemon) update(change func(*daemonStatus)) {
+	d.mu.Lock()
+	change(&d.status)
+	d.mu.Unlock()
+
+	if err := d.writeStatus(d.snapshot()); err != nil {
+		fmt.Fprintf(os.Stderr, "写入状态文件失败: %v\n", err)
+	}
+}
+
+// writeStatus 先写入临时文件再重命名，读取状态文件的程序不会读到写了一半的内容
+func (d *daemon) writeStatus(status daemonStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := d.statusPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, d.statusPath)
+}
+
+// handler 本地状态接口：GET /status 返回状态，POST /sync 立即开始一次同步。
+// 没有密码时只接受 Host 为本机的请求（见 guardAccess）；/sync 还需要在 daemonTokenHeader 中带上
+// 程序数据目录中的令牌，普通的跨站表单无法触发同步
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeStatusJSON(w, http.StatusOK, d.snapshot())
+	})
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if got := r.Header.Get(daemonTokenHeader); d.token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(d.token)) != 1 {
+			writeStatusJSON(w, http.StatusForbidden, map[string]string{"error": "需要在 " + daemonTokenHeader + " 请求头中提供程序数据目录中 " + daemonTokenFileName + " 的内容"})
+			return
+		}
+		if d.snapshot().State == "syncing" {
+			writeStatusJSON(w, http.StatusConflict, map[string]string{"error": "正在同步"})
+			return
+		}
+		select {
+		case d.trigger <- "manual":
+			writeStatusJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+		default:
+			writeStatusJSON(w, http.StatusConflict, map[string]string{"error": "已有等待开始的同步"})
+		}
+	})
+	return guardAccess(d.password, mux)
+}
+
+// loadDaemonToken 读取状态接口的令牌，文件不存在时生成随机令牌并保存（只有当前用户可读）
+func loadDaemonToken(path string) (string, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("读取状态接口令牌失败: %w", err)
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("保存状态接口令牌失败: %w", err)
+	}
+	return token, nil
+}
+
+func writeStatusJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+func newTestDaemon(password string) *daemon {
+	return &daemon{password: password, token: "secret-token", trigger: make(chan string, 1), status: daemonStatus{State: "idle"}}
+}
+
+// daemonRequest 向状态接口发送请求，返回状态码
+func daemonRequest(h http.Handler, method, host, token string, setup func(*http.Request)) int {
+	r := httptest.NewRequest(method, "http://"+host+"/sync", nil)
+	if method == http.MethodGet {
+		r = httptest.NewRequest(method, "http://"+host+"/status", nil)
+	}
+	if token != "" {
+		r.Header.Set(daemonTokenHeader, token)
+	}
+	if setup != nil {
+		setup(r)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestDaemonHandlerWithoutPassword(t *testing.T) {
+	d := newTestDaemon("")
+	h := d.handler()
+
+	if code := daemonRequest(h, http.MethodGet, "127.0.0.1:8765", "", nil); code != http.StatusOK {
+		t.Errorf("status from loopback = %d, want 200", code)
+	}
+	// DNS 重绑定时 Host 是其他网站的域名
+	if code := daemonRequest(h, http.MethodGet, "attacker.example.com:8765", "", nil); code != http.StatusForbidden {
+		t.Errorf("status with foreign Host = %d, want 403", code)
+	}
+
+	// 跨站表单不能带自定义请求头，没有令牌时不触发同步
+	form := func(r *http.Request) { r.Header.Set("Content-Type", "application/x-www-form-urlencoded") }
+	if code := daemonRequest(h, http.MethodPost, "localhost:8765", "", form); code != http.StatusForbidden {
+		t.Errorf("sync without token = %d, want 403", code)
+	}
+	if code := daemonRequest(h, http.MethodPost, "localhost:8765", "wrong", nil); code != http.StatusForbidden {
+		t.Errorf("sync with wrong token = %d, want 403", code)
+	}
+	if len(d.trigger) != 0 {
+		t.Fatal("rejected request triggered a sync")
+	}
+
+	if code := daemonRequest(h, http.MethodPost, "localhost:8765", "secret-token", nil); code != http.StatusAccepted {
+		t.Errorf("sync with token = %d, want 202", code)
+	}
+	if code := daemonRequest(h, http.MethodPost, "localhost:8765", "secret-token", nil); code != http.StatusConflict {
+		t.Errorf("second sync = %d, want 409", code)
+	}
+	if trigger := <-d.trigger; trigger != "manual" {
+		t.Errorf("trigger = %q, want manual", trigger)
+	}
+}
+
+func TestDaemonHandlerWithPassword(t *testing.T) {
+	h := newTestDaemon("pw").handler()
+	auth := func(r *http.Request) { r.SetBasicAuth("any", "pw") }
+
+	if code := daemonRequest(h, http.MethodGet, "192.168.1.2:8765", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("status without password = %d, want 401", code)
+	}
+	if code := daemonRequest(h, http.MethodGet, "192.168.1.2:8765", "", auth); code != http.StatusOK {
+		t.Errorf("status with password = %d, want 200", code)
+	}
+	if code := daemonRequest(h, http.MethodPost, "192.168.1.2:8765", "", auth); code != http.StatusForbidden {
+		t.Errorf("sync with password but no token = %d, want 403", code)
+	}
+	if code := daemonRequest(h, http.MethodPost, "192.168.1.2:8765", "secret-token", auth); code != http.StatusAccepted {
+		t.Errorf("sync with password and token = %d, want 202", code)
+	}
+}
+
+func TestDaemonRefusesPublicListenWithoutPassword(t *testing.T) {
+	utils.SetRootPath(t.TempDir())
+	defer utils.SetRootPath("")
+	t.Setenv(daemonPasswordEnv, "")
+
+	if code := runDaemon([]string{"-listen", "0.0.0.0:0"}); code != exitUsage {
+		t.Errorf("exit code = %d, want %d", code, exitUsage)
+	}
+}
+
+func TestLoadDaemonToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", daemonTokenFileName)
+	token, err := loadDaemonToken(path)
+	if err != nil || len(token) != 64 {
+		t.Fatalf("loadDaemonToken = %q, %v", token, err)
+	}
+	if again, err := loadDaemonToken(path); err != nil || again != token {
+		t.Errorf("token changed on reload: %q -> %q (%v)", token, again, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm()&0077 != 0 {
+		t.Errorf("token file mode = %v, %v", info.Mode(), err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.TrimSpace(string(data)) != token {
+		t.Errorf("token file contains %q", data)
+	}
+}
@@ -0,0 +1,741 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/downloader"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+// courseFlag 可重复的 -course 参数，格式为 "课程ID" 或 "课程ID:讲次范围"
+type courseFlag []string
+
+func (c *courseFlag) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *courseFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*c = append(*c, v)
+		}
+	}
+	return nil
+}
+
+// courseSelection 选中的课程及其讲次范围
+type courseSelection struct {
+	course *models.Course
+	spec   string
+}
+
+// downloadJob 已加入下载器的讲
+type downloadJob struct {
+	id      int // 任务编号，从1开始
+	task    *downloader.DownloadTask
+	student string
+	course  string
+	lecture int
+	title   string
+	file    string
+	source  downloader.ManifestEntry // 下载完成后写入清单的来源信息
+	record  models.DownloadJob       // 下载记录，下载完成时据此更新订阅的同步状态
+}
+
+// markSynced 记录订阅课程中的一讲已同步（下载完成或本地已有）
+func markSynced(job models.DownloadJob) {
+	if err := utils.MarkSynced(job.Platform, job.StudentID, job.Course.CourseID, job.Lecture.LiveID, job.Extensive); err != nil {
+		fmt.Fprintf(os.Stderr, "更新同步状态失败: %v\n", err)
+	}
+}
+
+// event 任务对应的输出事件
+func (job *downloadJob) event() progressEvent {
+	return progressEvent{ID: job.id, Student: job.student, Course: job.course, Lecture: job.lecture, Title: job.title, File: job.file}
+}
+
+// warning 下载器的警告事件对应的输出事件
+func (job *downloadJob) warning(ev downloader.Event) progressEvent {
+	out := job.event()
+	out.Event, out.File, out.Message = "warning", ev.Path, ev.Err.Error()
+	return out
+}
+
+func runDownload(args []string) int {
+	fs := newFlagSet("download")
+	var sf sessionFlags
+	sf.register(fs)
+	var courseIDs courseFlag
+	fs.Var(&courseIDs, "course", "课程ID，可重复或用逗号分隔；\"课程ID:1-3,5\" 单独指定讲次")
+	all := fs.Bool("all", false, "下载全部课程")
+	lectures := fs.String("lectures", "", "讲次范围，如 \"1-3,5,8-\"（默认全部已结束的讲）")
+	extensive := fs.Bool("extensive", false, "下载延伸课程")
+	overwrite := fs.Bool("overwrite", false, "覆盖已下载文件")
+	path := fs.String("path", ".", "下载路径（会在其中创建平台下载目录）")
+	students := fs.String("students", "", "同时下载账号下的多个学员: all 或逗号分隔的学员ID/昵称")
+	allUsers := fs.Bool("all-users", false, "同时下载所有保存的账号")
+	var qf qualityFlags
+	qf.register(fs)
+	var tf transferFlags
+	tf.register(fs)
+	asJSON := fs.Bool("json", false, "以JSON Lines输出进度")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+	if err := qf.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := tf.apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	if !*all && len(courseIDs) == 0 {
+		fmt.Fprintln(os.Stderr, "请使用 -course 指定课程，或使用 -all 下载全部课程")
+		return exitUsage
+	}
+
+	sessions, err := sf.openAll(*allUsers, *students)
+	if err != nil {
+		return fail(err)
+	}
+
+	// 多个学员的课程加入同一个下载器，每个学员使用单独的目录
+	run := newDownloadRun(*asJSON, qf, tf)
+	run.multiStudent = len(sessions) > 1
+	selected := 0
+	for _, s := range sessions {
+		courses, err := s.Client.GetCourseList()
+		if err != nil {
+			if !run.multiStudent {
+				return fail(err)
+			}
+			run.rep.report(progressEvent{Student: s.Label()}.failed(err))
+			continue
+		}
+
+		selections, err := selectCourses(courses, courseIDs, *all, *lectures, run.multiStudent)
+		if err != nil {
+			return fail(err)
+		}
+
+		downloadPath := filepath.Join(*path, s.Platform().DownloadFolderName())
+		if run.multiStudent && !run.template.Uses("student") {
+			downloadPath = filepath.Join(downloadPath, s.DirName())
+		}
+		if err := utils.Mkdir(downloadPath); err != nil {
+			return fail(err)
+		}
+
+		for _, sel := range selections {
+			course := sel.course
+			selected++
+
+			courseLectures, err := s.Client.GetLectures(course.CourseID)
+			if err != nil {
+				run.rep.report(progressEvent{Student: run.studentLabel(s), Course: courseDirName(course)}.failed(err))
+				continue
+			}
+
+			indices, err := lectureIndices(courseLectures, sel.spec, course)
+			if err != nil {
+				return fail(err)
+			}
+
+			dest := lectureDest{baseDir: downloadPath, template: run.template, overwrite: *overwrite}
+			run.queueLectures(s, course, courseLectures, indices, dest, *extensive, *qf.definition)
+		}
+	}
+	if selected == 0 && len(courseIDs) > 0 {
+		return fail(fmt.Errorf("未找到课程: %s", courseIDs.String()))
+	}
+
+	return run.wait()
+}
+
+// lectureIndices 将讲次范围转换为讲次列表中的下标。范围按讲次序号计算，
+// 列表中有取消或调课的讲次时序号与下标不一致；未指定范围时选择所有已结束的讲
+func lectureIndices(lectures []*models.Lecture, spec string, course *models.Course) ([]int, error) {
+	if strings.TrimSpace(spec) == "" {
+		return models.EndedLectures(lectures, course), nil
+	}
+	maxNumber := 0
+	for j, lecture := range lectures {
+		if n := lecture.Number(j); n > maxNumber {
+			maxNumber = n
+		}
+	}
+	numbers, err := utils.ParseLectureRanges(spec, maxNumber)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[int]bool, len(numbers))
+	for _, n := range numbers {
+		wanted[n+1] = true
+	}
+	var indices []int
+	for j, lecture := range lectures {
+		// 取消的讲没有自己的序号，不按范围选择
+		if lecture.Status == models.LectureStatusCancelled {
+			continue
+		}
+		if wanted[lecture.Number(j)] {
+			indices = append(indices, j)
+		}
+	}
+	return indices, nil
+}
+
+// courseDirName 输出中的课程名称（与默认模板中的课程目录名相同）
+func courseDirName(course *models.Course) string {
+	return utils.SanitizeFileName(fmt.Sprintf("%s - %s", course.SubjectName, course.CourseName))
+}
+
+// qualityFlags 清晰度相关的参数
+type qualityFlags struct {
+	definition *string
+	variant    *string
+}
+
+func (qf *qualityFlags) register(fs *flag.FlagSet) {
+	qf.definition = fs.String("definition", models.DefinitionHighest, "回放清晰度: highest、lowest 或清晰度名称（如 超清）")
+	qf.variant = fs.String("variant", downloader.VariantHighest, "HLS 清晰度: highest、lowest 或最大高度（如 720）")
+}
+
+func (qf *qualityFlags) validate() error {
+	if strings.TrimSpace(*qf.definition) == "" {
+		return fmt.Errorf("清晰度不能为空")
+	}
+	return downloader.ValidateVariantPolicy(*qf.variant)
+}
+
+// transferFlags 限速和线程数相关的参数，默认值来自保存的设置（见 settings 命令）
+type transferFlags struct {
+	limit       *string
+	schedule    *string
+	concurrency *int
+	threads     *int
+	adaptive    *bool
+	template    *string
+}
+
+func (tf *transferFlags) register(fs *flag.FlagSet) {
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "加载设置失败: %v\n", err)
+	}
+	tf.limit = fs.String("limit", downloader.FormatRate(settings.RateLimit), "下载限速，如 2MB、512KB（0 表示不限速）")
+	tf.schedule = fs.String("limit-schedule", settings.RateSchedule, "按时段限速，如 \"09:00-18:00=2MB,22:00-07:00=0\"，不在时段内时使用 -limit")
+	tf.concurrency = fs.Int("concurrency", settings.MaxConcurrentDownloads, "同时下载的文件数")
+	tf.threads = fs.Int("threads", settings.ThreadCount, "每个文件的下载线程数（自动调节时为上限）")
+	tf.adaptive = fs.Bool("adaptive", settings.AdaptiveThreads, "根据速度和错误率自动调节每个文件的线程数")
+	tf.template = fs.String("template", settings.FileTemplate, "保存路径模板，如 \"{student}/{course}/{index:02} {title}.{ext}\"（默认 \""+utils.DefaultFileTemplate+"\"）")
+}
+
+// toSettings 检查参数并转换为设置
+func (tf *transferFlags) toSettings() (models.Settings, error) {
+	settings := models.Settings{
+		MaxConcurrentDownloads: *tf.concurrency,
+		ThreadCount:            *tf.threads,
+		AdaptiveThreads:        *tf.adaptive,
+		RateSchedule:           strings.TrimSpace(*tf.schedule),
+		FileTemplate:           strings.TrimSpace(*tf.template),
+	}
+	if settings.MaxConcurrentDownloads < 1 || settings.MaxConcurrentDownloads > models.MaxConcurrentDownloadsLimit {
+		return settings, fmt.Errorf("-concurrency 应在 1-%d 之间", models.MaxConcurrentDownloadsLimit)
+	}
+	if settings.ThreadCount < 1 || settings.ThreadCount > models.ThreadCountLimit {
+		return settings, fmt.Errorf("-threads 应在 1-%d 之间", models.ThreadCountLimit)
+	}
+	var err error
+	if settings.RateLimit, err = downloader.ParseRate(*tf.limit); err != nil {
+		return settings, err
+	}
+	if _, err := downloader.ParseSchedule(settings.RateSchedule); err != nil {
+		return settings, err
+	}
+	if _, err := utils.ParseFileTemplate(settings.FileTemplate); err != nil {
+		return settings, err
+	}
+	return settings, nil
+}
+
+// apply 检查参数并设置所有下载共享的限速器
+func (tf *transferFlags) apply() error {
+	settings, err := tf.toSettings()
+	if err != nil {
+		return err
+	}
+	return applyRateSettings(settings)
+}
+
+// applyRateSettings 将设置中的限速应用到所有下载共享的限速器
+func applyRateSettings(settings models.Settings) error {
+	schedule, err := downloader.ParseSchedule(settings.RateSchedule)
+	if err != nil {
+		return err
+	}
+	limiter := downloader.GlobalLimiter()
+	limiter.SetLimit(settings.RateLimit)
+	limiter.SetSchedule(schedule)
+	return nil
+}
+
+// newDownloader 按参数创建下载器
+func (tf *transferFlags) newDownloader() *downloader.Downloader {
+	dl := downloader.NewDownloader(*tf.concurrency, *tf.threads)
+	dl.SetAdaptive(*tf.adaptive)
+	return dl
+}
+
+// downloadRun 一次下载命令中的所有任务（可能来自多个学员）
+type downloadRun struct {
+	rep          *reporter
+	dl           *downloader.Downloader
+	multiStudent bool                // 是否同时下载多个学员，此时输出中标明学员
+	template     *utils.FileTemplate // 保存路径模板
+	jobs         []*downloadJob
+	recording    sync.WaitGroup // 正在写入清单的已完成任务
+}
+
+func newDownloadRun(asJSON bool, qf qualityFlags, tf transferFlags) *downloadRun {
+	dl := tf.newDownloader()
+	dl.SetVariantPolicy(*qf.variant)
+	// 参数已经在 toSettings 中检查过
+	template, _ := utils.ParseFileTemplate(*tf.template)
+	return &downloadRun{
+		rep:      newReporter(asJSON),
+		dl:       dl,
+		template: template,
+	}
+}
+
+// lectureDest 一组讲次的保存位置：按模板在 baseDir 中生成，或使用下载记录中的路径（继续下载时）
+type lectureDest struct {
+	baseDir   string
+	template  *utils.FileTemplate
+	paths     map[int]string // 讲次下标 -> 保存路径
+	overwrite bool           // 不检查已下载的副本，重新下载
+}
+
+// needsDefinition 保存路径是否需要获取回放地址后才能确定
+func (d lectureDest) needsDefinition() bool {
+	return d.paths == nil && d.template.Uses("definition")
+}
+
+func (d lectureDest) path(j int, vars utils.FileTemplateVars) string {
+	if d.paths != nil {
+		return d.paths[j]
+	}
+	return d.template.RenderPath(d.baseDir, vars)
+}
+
+// studentLabel 同时下载多个学员时输出中的学员名称
+func (r *downloadRun) studentLabel(s *session.Session) string {
+	if !r.multiStudent {
+		return ""
+	}
+	return s.Label()
+}
+
+// queueLectures 将学员课程中指定下标的讲按清晰度偏好加入下载器
+func (r *downloadRun) queueLectures(s *session.Session, course *models.Course, lectures []*models.Lecture, indices []int, dest lectureDest, extensive bool, definition string) {
+	courseName := courseDirName(course)
+	studentID := s.StudentID()
+	student := r.studentLabel(s)
+
+	for _, j := range indices {
+		if j >= len(lectures) {
+			continue
+		}
+		lecture := lectures[j]
+
+		if extensive {
+			lecture.LiveTypeString = "ONLINE_REAL_RECORD" // 强制设为延伸课程类型
+		}
+		vars := utils.LectureFileVars(s.Platform().Name, s.DirName(), course, lecture, j, extensive)
+		ev := progressEvent{Student: student, Course: courseName, Lecture: lecture.Number(j), Title: lecture.Title}
+
+		if lecture.Status == models.LectureStatusCancelled {
+			ev.Event, ev.Message = "skipped", "该讲已取消"
+			r.rep.report(ev)
+			continue
+		}
+		if !lecture.Ended(j, course) {
+			ev.Event, ev.Message = "skipped", "该讲尚未开始"
+			r.rep.report(ev)
+			continue
+		}
+
+		// 路径中使用清晰度时先获取回放地址
+		var source models.VideoSource
+		var sourceErr error
+		fetched := dest.needsDefinition()
+		if fetched {
+			source, sourceErr = s.Client.GetVideoSource(lecture, course.CourseID, course.TutorID, definition)
+			if sourceErr != nil {
+				r.rep.report(ev.failed(sourceErr))
+				continue
+			}
+			vars.Definition = source.Definition
+		}
+		filePath := dest.path(j, vars)
+		ev.File = filePath
+
+		job := models.DownloadJob{
+			FilePath:     filePath,
+			Platform:     s.Client.Platform().Name,
+			StudentID:    studentID,
+			StudentName:  s.StudentName,
+			Course:       *course,
+			Lecture:      *lecture,
+			LectureIndex: j,
+			Extensive:    extensive,
+		}
+
+		// 按清单查找已下载的副本，文件被改名或移动到之前的下载目录时同样跳过
+		repair := false
+		if !dest.overwrite && !downloader.HasResumeState(filePath) {
+			lookup := downloader.ManifestEntry{LiveID: lecture.LiveID, Extensive: extensive, Definition: source.Definition, URL: source.URL}
+			local := downloader.FindLocalCopy(filePath, lookup, utils.GetDownloadedDirs(lecture.LiveID, extensive)...)
+			switch local.Status {
+			case downloader.CopyComplete:
+				ev.Event, ev.Message = "skipped", "文件已存在"
+				if local.Path != filePath {
+					ev.Message = "文件已存在: " + local.Path
+				}
+				markSynced(job)
+				r.rep.report(ev)
+				continue
+			case downloader.CopyPartial:
+				repair = true
+			}
+		}
+
+		if !fetched {
+			source, sourceErr = s.Client.GetVideoSource(lecture, course.CourseID, course.TutorID, definition)
+			if sourceErr != nil {
+				job.Status, job.Error = models.JobStatusError, sourceErr.Error()
+				utils.QueueDownloadJob(job)
+				r.rep.report(ev.failed(sourceErr))
+				continue
+			}
+		}
+
+		job.Definition = source.Definition
+		ev.Definition = source.Definition
+
+		task := r.dl.AddTask(source.URL, filePath, nil)
+		if err := utils.QueueDownloadJob(job); err != nil {
+			fmt.Fprintf(os.Stderr, "保存下载记录失败: %v\n", err)
+		}
+		ev.ID = len(r.jobs) + 1
+		r.jobs = append(r.jobs, &downloadJob{
+			id: ev.ID, task: task, student: student, course: courseName, lecture: lecture.Number(j), title: lecture.Title, file: filePath,
+			source: downloader.ManifestEntry{LiveID: lecture.LiveID, Extensive: extensive, Definition: source.Definition, URL: source.URL},
+			record: job,
+		})
+
+		ev.Event = "queued"
+		if repair {
+			ev.Message = "文件不完整，重新下载"
+		}
+		r.rep.report(ev)
+	}
+}
+
+// wait 启动下载并等待全部完成，返回退出码。收到中断信号时停止下载，
+// 已下载的部分和下载记录会保留，之后可以用 resume 继续
+func (r *downloadRun) wait() int {
+	events, unsubscribe := r.dl.Subscribe(64)
+	defer unsubscribe()
+	r.dl.Start()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupt:
+			fmt.Fprintln(os.Stderr, "正在停止下载，使用 resume 命令可以继续")
+			r.dl.Cancel()
+		case <-done:
+		}
+	}()
+
+	jobs := make(map[*downloader.DownloadTask]*downloadJob, len(r.jobs))
+	for _, job := range r.jobs {
+		jobs[job.task] = job
+	}
+	for remaining := len(r.jobs); remaining > 0; {
+		ev := <-events
+		job := jobs[ev.Task]
+		if job == nil {
+			continue
+		}
+		switch ev.Type {
+		case downloader.EventProgress:
+			r.rep.progress(job.event(), ev)
+		case downloader.EventWarning:
+			r.rep.report(job.warning(ev))
+		case downloader.EventCompleted, downloader.EventFailed, downloader.EventCancelled:
+			r.finish(job, ev)
+			remaining--
+		}
+	}
+	r.recording.Wait()
+
+	if r.rep.summary() > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
+
+// finish 汇报结束的任务并更新下载记录
+func (r *downloadRun) finish(job *downloadJob, ev downloader.Event) {
+	out := job.event()
+	size := atomic.LoadInt64(&job.task.Downloaded)
+	if ev.Type == downloader.EventCompleted {
+		size = ev.Size
+		out.Event = "completed"
+		out.File = ev.Path
+		out.Duration = ev.Duration.Round(time.Second).String()
+		out.Total = size
+		markSynced(job.record)
+		// 计算校验和需要读取整个文件，不阻塞其他任务的事件
+		r.recording.Add(1)
+		go func(path string, entry downloader.ManifestEntry) {
+			defer r.recording.Done()
+			if err := downloader.RecordDownload(path, entry); err != nil {
+				fmt.Fprintf(os.Stderr, "更新下载清单失败: %v\n", err)
+			}
+		}(ev.Path, job.source)
+	} else {
+		out = out.failed(ev.Err)
+	}
+	if ev.Type != downloader.EventCancelled {
+		// 取消的任务保持未完成状态，resume 时继续下载
+		utils.FinishDownloadJob(job.file, job.task.StartTime, size, ev.Err)
+	}
+	r.rep.report(out)
+}
+
+// selectCourses 根据参数挑选要下载的课程。allowMissing 时忽略不存在的课程ID，
+// 用于同时下载多个学员，每个学员只下载自己报名的课程
+func selectCourses(courses []*models.Course, courseIDs []string, all bool, lectures string, allowMissing bool) ([]courseSelection, error) {
+	var selections []courseSelection
+
+	if all {
+		for _, course := range courses {
+			selections = append(selections, courseSelection{course: course, spec: lectures})
+		}
+		return selections, nil
+	}
+
+	for _, value := range courseIDs {
+		id, spec := value, lectures
+		if idx := strings.Index(value, ":"); idx >= 0 {
+			id, spec = value[:idx], value[idx+1:]
+		}
+
+		var found *models.Course
+		for _, course := range courses {
+			if course.CourseID == id {
+				found = course
+				break
+			}
+		}
+		if found == nil {
+			if allowMissing {
+				continue
+			}
+			return nil, fmt.Errorf("未找到课程: %s", id)
+		}
+		selections = append(selections, courseSelection{course: found, spec: spec})
+	}
+	return selections, nil
+}
+
+// progressEvent 下载过程中输出的一条事件
+type progressEvent struct {
+	Time          string  `json:"time,omitempty"` // 后台模式的日志中记录事件时间
+	Event         string  `json:"event"`          // queued, skipped, pending（sync -dry-run）, extensive（sync 发现新的延伸内容）, progress, completed, failed, summary；后台模式还有 daemon_started, sync_started, daemon_stopped；网页模式还有 paused, resumed
+	ID            int     `json:"id,omitempty"`   // 加入下载器的任务编号，网页模式中用于暂停、继续和取消
+	Student       string  `json:"student,omitempty"`
+	Course        string  `json:"course,omitempty"`
+	Lecture       int     `json:"lecture,omitempty"` // 讲次序号
+	Title         string  `json:"title,omitempty"`   // 讲次标题
+	File          string  `json:"file,omitempty"`
+	Definition    string  `json:"definition,omitempty"`
+	Percent       float64 `json:"percent,omitempty"`
+	Speed         string  `json:"speed,omitempty"`
+	BytesPerSec   float64 `json:"bytes_per_sec,omitempty"`
+	SegmentsDone  int     `json:"segments_done,omitempty"`  // M3U8 已完成的分段数
+	SegmentsTotal int     `json:"segments_total,omitempty"` // M3U8 的总分段数
+	Downloaded    int64   `json:"downloaded,omitempty"`
+	Total         int64   `json:"total,omitempty"`
+	Duration      string  `json:"duration,omitempty"`
+	Message       string  `json:"message,omitempty"`
+	ErrorKind     string  `json:"error_kind,omitempty"` // 接口错误的类别（见 api.ErrorKind），文件校验失败时为 corrupt
+
+	Completed int `json:"completed,omitempty"`
+	Skipped   int `json:"skipped,omitempty"`
+	Failed    int `json:"failed,omitempty"`
+}
+
+// failed 将事件标记为失败，接口错误时记录错误类别
+func (ev progressEvent) failed(err error) progressEvent {
+	ev.Event, ev.Message = "failed", err.Error()
+	if kind := api.KindOf(err); kind != 0 {
+		ev.ErrorKind = kind.String()
+	} else if downloader.IsCorrupt(err) {
+		ev.ErrorKind = "corrupt"
+	} else if downloader.IsCancelled(err) {
+		ev.ErrorKind = "cancelled"
+	}
+	return ev
+}
+
+// reporter 以纯文本或JSON Lines输出下载事件
+type reporter struct {
+	json      bool
+	out       io.Writer           // 输出位置，默认为标准输出
+	stamp     bool                // 在事件中记录时间（后台模式的日志）
+	onEvent   func(progressEvent) // 每个事件输出后调用（网页模式推送给浏览器），在输出的锁中调用，不能阻塞
+	mu        sync.Mutex
+	lastPrint map[string]time.Time
+	completed int
+	skipped   int
+	failed    int
+}
+
+// progressInterval 同一文件两次进度输出之间的最小间隔
+const progressInterval = time.Second
+
+func newReporter(asJSON bool) *reporter {
+	return &reporter{
+		json:      asJSON,
+		out:       os.Stdout,
+		lastPrint: make(map[string]time.Time),
+	}
+}
+
+func (r *reporter) progress(ev progressEvent, dl downloader.Event) {
+	r.mu.Lock()
+	now := time.Now()
+	if now.Sub(r.lastPrint[ev.File]) < progressInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastPrint[ev.File] = now
+	r.mu.Unlock()
+
+	ev.Event = "progress"
+	if percent := dl.Percent(); percent >= 0 {
+		ev.Percent = float64(int(percent*10)) / 10
+	}
+	ev.Speed = dl.Speed()
+	ev.BytesPerSec = float64(int64(dl.BytesPerSec))
+	ev.Downloaded = dl.Bytes
+	ev.Total = dl.Total
+	ev.SegmentsDone = dl.SegmentsDone
+	ev.SegmentsTotal = dl.SegmentsTotal
+	r.report(ev)
+}
+
+func (r *reporter) report(ev progressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch ev.Event {
+	case "completed":
+		r.completed++
+	case "skipped":
+		r.skipped++
+	case "failed":
+		r.failed++
+	}
+
+	if r.stamp {
+		ev.Time = time.Now().Format(time.RFC3339)
+	}
+	if r.onEvent != nil {
+		r.onEvent(ev)
+	}
+	if r.json {
+		fprintJSON(r.out, ev)
+		return
+	}
+
+	name := ev.Course
+	if ev.Lecture > 0 {
+		name = strings.TrimSpace(fmt.Sprintf("%s 第%d讲 %s", ev.Course, ev.Lecture, ev.Title))
+	}
+	if ev.Student != "" {
+		name = strings.TrimSpace("[" + ev.Student + "] " + name)
+	}
+
+	switch ev.Event {
+	case "queued":
+		if ev.Definition != "" {
+			name += " (" + ev.Definition + ")"
+		}
+		if ev.Message != "" {
+			name += ": " + ev.Message
+		}
+		fmt.Fprintf(r.out, "[排队] %s\n", name)
+	case "skipped":
+		fmt.Fprintf(r.out, "[跳过] %s: %s\n", name, ev.Message)
+	case "pending":
+		fmt.Fprintf(r.out, "[待下载] %s: %s\n", name, ev.Message)
+	case "extensive":
+		fmt.Fprintf(r.out, "[延伸] %s: %s\n", name, ev.Message)
+	case "progress":
+		fmt.Fprintf(r.out, "[下载] %s %5.1f%% %s %s\n", name, ev.Percent, ev.Speed, utils.FormatFileSize(ev.Downloaded))
+	case "completed":
+		fmt.Fprintf(r.out, "[完成] %s %s 用时%s\n", name, utils.FormatFileSize(ev.Total), ev.Duration)
+	case "paused":
+		fmt.Fprintf(r.out, "[暂停] %s\n", name)
+	case "resumed":
+		fmt.Fprintf(r.out, "[继续] %s\n", name)
+	case "warning":
+		fmt.Fprintf(r.out, "[警告] %s: %s\n", name, ev.Message)
+	case "failed":
+		fmt.Fprintf(r.out, "[失败] %s: %s\n", name, ev.Message)
+	case "summary":
+		fmt.Fprintf(r.out, "完成 %d，跳过 %d，失败 %d\n", ev.Completed, ev.Skipped, ev.Failed)
+	}
+}
+
+// counts 目前完成、跳过和失败的数量
+func (r *reporter) counts() (completed, skipped, failed int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.completed, r.skipped, r.failed
+}
+
+// summary 输出汇总并返回失败数
+func (r *reporter) summary() int {
+	r.mu.Lock()
+	ev := progressEvent{Event: "summary", Completed: r.completed, Skipped: r.skipped, Failed: r.failed}
+	r.mu.Unlock()
+
+	r.report(ev)
+	return ev.Failed
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/itsHenry35/tal_downloader/models"
+)
+
+func TestLectureIndices(t *testing.T) {
+	// 第2项已取消，不占用序号
+	course := &models.Course{EndLiveNum: 2}
+	lectures := []*models.Lecture{
+		{LiveID: 1, Num: 1, Status: models.LectureStatusEnded},
+		{LiveID: 2, Status: models.LectureStatusCancelled},
+		{LiveID: 3, Num: 2, Status: models.LectureStatusEnded},
+		{LiveID: 4, Num: 3, Status: models.LectureStatusNotStarted},
+	}
+	tests := []struct {
+		spec string
+		want []int
+	}{
+		{"", []int{0, 2}}, // 未指定范围时为所有已结束的讲
+		{"2", []int{2}},
+		{"1-3", []int{0, 2, 3}},
+	}
+	for _, tt := range tests {
+		got, err := lectureIndices(lectures, tt.spec, course)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: indices = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
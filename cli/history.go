@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+func runHistory(args []string) int {
+	fs := newFlagSet("history")
+	student := fs.String("student", "", "只显示指定学员（学员ID或昵称）的记录")
+	status := fs.String("status", "", "只显示指定状态: queued、completed、error、corrupt")
+	limit := fs.Int("limit", 0, "最多显示的条数（0 表示全部）")
+	asJSON := fs.Bool("json", false, "以JSON Lines输出")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+
+	jobs, err := utils.GetDownloadHistory()
+	if err != nil {
+		return fail(err)
+	}
+
+	shown := 0
+	for _, job := range jobs {
+		if *student != "" && job.StudentID != *student && job.StudentName != *student {
+			continue
+		}
+		if *status != "" && job.Status != *status {
+			continue
+		}
+		if *limit > 0 && shown >= *limit {
+			break
+		}
+		shown++
+
+		if *asJSON {
+			printJSON(job)
+			continue
+		}
+
+		when := job.CreatedAt
+		if !job.FinishedAt.IsZero() {
+			when = job.FinishedAt
+		}
+		title := fmt.Sprintf("%s - %s %s", job.Course.SubjectName, job.Course.CourseName, job.Lecture.Name(job.LectureIndex))
+		if job.Extensive {
+			title += "(延伸内容)"
+		}
+		if job.Definition != "" {
+			title += " [" + job.Definition + "]"
+		}
+		line := fmt.Sprintf("%s\t%s(%s)\t%s\t%s\t%s", when.Format("2006-01-02 15:04"), job.StudentName, job.Platform, title, job.Status, utils.FormatFileSize(job.Bytes))
+		if job.Error != "" {
+			line += "\t" + job.Error
+		}
+		fmt.Println(line)
+	}
+	return exitOK
+}
+
+func runResume(args []string) int {
+	fs := newFlagSet("resume")
+	var sf sessionFlags
+	sf.register(fs)
+	var qf qualityFlags
+	qf.register(fs)
+	var tf transferFlags
+	tf.register(fs)
+	asJSON := fs.Bool("json", false, "以JSON Lines输出进度")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+	if err := qf.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := tf.apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return fail(err)
+	}
+
+	jobs, err := utils.GetUnfinishedDownloadJobs(s.Platform().Name, s.StudentID())
+	if err != nil {
+		return fail(err)
+	}
+	if len(jobs) == 0 {
+		fmt.Println("没有未完成的下载")
+		return exitOK
+	}
+
+	courses, err := s.Client.GetCourseList()
+	if err != nil {
+		return fail(err)
+	}
+
+	// 按课程、下载目录、延伸内容选项和清晰度分组，每组只需获取一次讲次列表
+	type resumeGroup struct {
+		course     *models.Course
+		courseDir  string
+		extensive  bool
+		definition string
+		indices    []int
+		paths      map[int]string
+	}
+	var groups []*resumeGroup
+	for _, job := range jobs {
+		courseDir := filepath.Dir(job.FilePath)
+		// 沿用上次选择的清晰度，以便继续下载同一个文件
+		definition := job.Definition
+		if definition == "" {
+			definition = *qf.definition
+		}
+
+		var group *resumeGroup
+		for _, g := range groups {
+			if g.course.CourseID == job.Course.CourseID && g.courseDir == courseDir && g.extensive == job.Extensive && g.definition == definition {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			saved := job.Course
+			group = &resumeGroup{course: &saved, courseDir: courseDir, extensive: job.Extensive, definition: definition, paths: make(map[int]string)}
+			// 优先使用最新的课程信息（已结束讲数可能有变化）
+			for _, c := range courses {
+				if c.CourseID == job.Course.CourseID {
+					group.course = c
+					break
+				}
+			}
+			groups = append(groups, group)
+		}
+		group.indices = append(group.indices, job.LectureIndex)
+		group.paths[job.LectureIndex] = job.FilePath
+	}
+
+	run := newDownloadRun(*asJSON, qf, tf)
+	for _, group := range groups {
+		lectures, err := s.Client.GetLectures(group.course.CourseID)
+		if err != nil {
+			run.rep.report(progressEvent{Course: courseDirName(group.course)}.failed(err))
+			continue
+		}
+		// 沿用下载记录中的路径，不受之后修改的模板影响
+		run.queueLectures(s, group.course, lectures, group.indices, lectureDest{paths: group.paths}, group.extensive, group.definition)
+	}
+
+	return run.wait()
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// isLoopback 监听地址是否只允许本机访问
+func isLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLoopbackHost 请求的 Host（可以带端口）是否为本机名称或地址
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// guardAccess 设置了密码时使用 HTTP Basic 认证（用户名任意），没有密码时拒绝 Host 不是本机名称或地址的请求。
+// DNS 重绑定可以让其他网站的页面以同源身份访问本机端口，这时请求的 Host 是该网站的域名
+func guardAccess(password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if password == "" {
+			if !isLoopbackHost(r.Host) {
+				http.Error(w, "forbidden host", http.StatusForbidden)
+				return
+			}
+		} else if _, got, ok := r.BasicAuth(); !ok || subtle.ConstantTimeCompare([]byte(got), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tal_downloader", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,834 @@
+package cli
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/config"
+	"github.com/itsHenry35/tal_downloader/downloader"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+//go:embed web/index.html
+var webIndex []byte
+
+// webPasswordEnv 设置访问密码的环境变量，避免密码出现在进程列表中
+const webPasswordEnv = "TAL_WEB_PASSWORD"
+
+// sseKeepAlive 没有事件时向浏览器发送注释的间隔，防止代理断开空闲连接
+const sseKeepAlive = 30 * time.Second
+
+func runServe(args []string) int {
+	fs := newFlagSet("serve")
+	var af apiFlags
+	af.register(fs)
+	var qf qualityFlags
+	qf.register(fs)
+	var tf transferFlags
+	tf.register(fs)
+	listen := fs.String("listen", "127.0.0.1:8080", "监听地址，如 0.0.0.0:8080 允许局域网中的其他设备访问")
+	password := fs.String("password", os.Getenv(webPasswordEnv), "访问密码（浏览器中用户名任意），也可以使用环境变量 "+webPasswordEnv+"；监听非本机地址时必须设置")
+	path := fs.String("path", ".", "下载路径（会在其中创建平台下载目录）")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+	if err := qf.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := tf.apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if *password == "" && !isLoopback(*listen) {
+		fmt.Fprintf(os.Stderr, "监听非本机地址时必须使用 -password 或环境变量 %s 设置访问密码\n", webPasswordEnv)
+		return exitUsage
+	}
+	baseDir, err := filepath.Abs(*path)
+	if err != nil {
+		return fail(err)
+	}
+
+	run := newDownloadRun(false, qf, tf)
+	// 网页中可以先后登录多个学员，事件中总是标明学员
+	run.multiStudent = true
+	ws := &webServer{
+		af:         af,
+		password:   *password,
+		baseDir:    baseDir,
+		definition: *qf.definition,
+		sessions:   session.NewManager(),
+		run:        run,
+		tasks:      make(map[*downloader.DownloadTask]*downloadJob),
+		clients:    make(map[chan progressEvent]bool),
+	}
+	run.rep.onEvent = ws.onEvent
+	return ws.serve(*listen)
+}
+
+// webServer 网页模式：通过浏览器登录、选择课程和管理下载队列，下载在运行本程序的机器上进行
+type webServer struct {
+	af         apiFlags
+	password   string
+	baseDir    string
+	definition string // 请求没有指定清晰度时使用的清晰度
+	sessions   *session.Manager
+	run        *downloadRun
+
+	queueMu sync.Mutex // downloadRun 不能同时加入任务，加入任务的请求依次进行
+
+	mu      sync.Mutex
+	tasks   map[*downloader.DownloadTask]*downloadJob
+	jobs    []*webJob                   // 下载队列（按加入顺序），清除已结束的任务时移除
+	clients map[chan progressEvent]bool // 正在接收事件的浏览器
+}
+
+// webJob 下载队列中的一项，progressEvent 中保留最近的进度
+type webJob struct {
+	progressEvent
+	Status string `json:"status"` // queued、downloading、paused、completed、failed、cancelled
+
+	job *downloadJob
+}
+
+// finished 任务是否已结束
+func (j *webJob) finished() bool {
+	switch j.Status {
+	case "completed", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// update 根据任务的新事件更新状态和进度
+func (j *webJob) update(ev progressEvent) {
+	j.Event = ev.Event
+	switch ev.Event {
+	case "progress":
+		j.Percent, j.Speed, j.BytesPerSec = ev.Percent, ev.Speed, ev.BytesPerSec
+		j.Downloaded, j.Total = ev.Downloaded, ev.Total
+		j.SegmentsDone, j.SegmentsTotal = ev.SegmentsDone, ev.SegmentsTotal
+		if j.Status == "queued" {
+			j.Status = "downloading"
+		}
+	case "paused":
+		j.Status = "paused"
+	case "resumed":
+		j.Status = "downloading"
+	case "completed":
+		j.Status = "completed"
+		j.File, j.Total, j.Downloaded, j.Duration = ev.File, ev.Total, ev.Total, ev.Duration
+		j.Percent, j.Speed, j.BytesPerSec = 100, "", 0
+	case "failed":
+		j.Status = "failed"
+		if ev.ErrorKind == "cancelled" {
+			j.Status = "cancelled"
+		}
+		j.Message, j.ErrorKind = ev.Message, ev.ErrorKind
+		j.Speed, j.BytesPerSec = "", 0
+	}
+}
+
+// onEvent 记录任务的新状态并推送给所有浏览器
+func (ws *webServer) onEvent(ev progressEvent) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ev.ID > 0 {
+		if ev.Event == "queued" {
+			ws.jobs = append(ws.jobs, &webJob{progressEvent: ev, Status: "queued"})
+		} else if j := ws.findJob(ev.ID); j != nil {
+			j.update(ev)
+		}
+	}
+	for ch := range ws.clients {
+		// 浏览器读取过慢时丢弃事件，重新连接后可以通过 /api/downloads 获取完整状态
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// findJob 按任务编号查找队列中的任务，调用时需持有 ws.mu
+func (ws *webServer) findJob(id int) *webJob {
+	for _, j := range ws.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (ws *webServer) serve(listen string) int {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fail(err)
+	}
+	srv := &http.Server{Handler: ws.authorize(ws.handler())}
+
+	events, unsubscribe := ws.run.dl.Subscribe(256)
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		ws.dispatch(events)
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		<-stop
+		srv.Close()
+	}()
+
+	fmt.Printf("网页模式已启动: http://%s\n", ln.Addr())
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		return fail(err)
+	}
+
+	// 与 download 相同，停止时保留未完成的下载记录
+	fmt.Fprintln(os.Stderr, "正在停止下载，使用 resume 命令可以继续")
+	ws.queueMu.Lock()
+	ws.run.dl.Cancel()
+	for _, job := range ws.run.jobs {
+		job.task.Wait()
+	}
+	ws.queueMu.Unlock()
+	// 处理完通道中剩余的事件，已完成的任务写入清单后再退出
+	unsubscribe()
+	<-dispatched
+	ws.run.recording.Wait()
+	return exitOK
+}
+
+// dispatch 将下载器的事件转换为输出事件，直到取消订阅
+func (ws *webServer) dispatch(events <-chan downloader.Event) {
+	for ev := range events {
+		ws.mu.Lock()
+		job := ws.tasks[ev.Task]
+		ws.mu.Unlock()
+		if job == nil {
+			continue
+		}
+		switch ev.Type {
+		case downloader.EventProgress:
+			ws.run.rep.progress(job.event(), ev)
+		case downloader.EventWarning:
+			ws.run.rep.report(job.warning(ev))
+		case downloader.EventPaused, downloader.EventResumed:
+			out := job.event()
+			out.Event = ev.Type.String()
+			ws.run.rep.report(out)
+		case downloader.EventCompleted, downloader.EventFailed, downloader.EventCancelled:
+			ws.run.finish(job, ev)
+		}
+	}
+}
+
+// authorize 访问控制见 guardAccess。修改状态的请求必须是JSON，浏览器不会在没有预检的情况下跨站发送，
+// 其他网站无法借用已保存的密码
+func (ws *webServer) authorize(next http.Handler) http.Handler {
+	return guardAccess(ws.password, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if mediaType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";"); strings.TrimSpace(mediaType) != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("请求的 Content-Type 应为 application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+func (ws *webServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webIndex)
+	})
+	mux.HandleFunc("/api/platforms", ws.handlePlatforms)
+	mux.HandleFunc("/api/users", ws.handleUsers)
+	mux.HandleFunc("/api/users/open", ws.handleOpenUser)
+	mux.HandleFunc("/api/sms", ws.handleSendSMS)
+	mux.HandleFunc("/api/login", ws.handleLogin)
+	mux.HandleFunc("/api/sessions", ws.handleSessions)
+	mux.HandleFunc("/api/students", ws.handleStudents)
+	mux.HandleFunc("/api/courses", ws.handleCourses)
+	mux.HandleFunc("/api/lectures", ws.handleLectures)
+	mux.HandleFunc("/api/downloads", ws.handleDownloads)
+	mux.HandleFunc("/api/downloads/", ws.handleDownloadAction)
+	mux.HandleFunc("/api/events", ws.handleEvents)
+	return mux
+}
+
+// allowMethod 检查请求方法，不允许时返回405
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("不支持的请求方法: %s", r.Method))
+	return false
+}
+
+// decodeRequest 解析JSON请求体
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("请求格式错误: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeError 以JSON返回错误，接口错误时附带错误类别（见 api.ErrorKind）
+func writeError(w http.ResponseWriter, status int, err error) {
+	body := map[string]string{"error": err.Error()}
+	if kind := api.KindOf(err); kind != 0 {
+		body["error_kind"] = kind.String()
+	}
+	writeStatusJSON(w, status, body)
+}
+
+// writeAPIError 返回调用课程接口时的错误：接口错误为502，其他错误（如参数错误）为400
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if api.KindOf(err) != 0 {
+		status = http.StatusBadGateway
+	}
+	writeError(w, status, err)
+}
+
+// webSession 接口返回的会话信息
+type webSession struct {
+	Key         string `json:"key"` // 其他接口的 session 参数
+	Platform    string `json:"platform"`
+	StudentID   string `json:"student_id"`
+	StudentName string `json:"student_name"`
+	Username    string `json:"username,omitempty"` // 对应的保存账号
+}
+
+func sessionInfo(s *session.Session) webSession {
+	info := webSession{Key: s.Key(), Platform: s.Platform().Name, StudentID: s.StudentID(), StudentName: s.StudentName}
+	if s.SavedUser != nil {
+		info.Username = s.SavedUser.Username
+	}
+	return info
+}
+
+func (ws *webServer) sessionList() []webSession {
+	list := []webSession{}
+	for _, s := range ws.sessions.Sessions() {
+		list = append(list, sessionInfo(s))
+	}
+	return list
+}
+
+// session 按 key 查找已登录的会话，不存在时返回404
+func (ws *webServer) session(w http.ResponseWriter, key string) *session.Session {
+	s := ws.sessions.Get(key)
+	if s == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("未登录的学员: %s", key))
+	}
+	return s
+}
+
+func (ws *webServer) handlePlatforms(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	list := []map[string]string{}
+	for _, p := range config.Platforms() {
+		list = append(list, map[string]string{"id": p.ID, "name": p.Name})
+	}
+	writeStatusJSON(w, http.StatusOK, list)
+}
+
+// handleUsers 保存的账号（不包括 token 和密码）
+func (ws *webServer) handleUsers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	data, err := utils.LoadSavedUsers()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	list := []map[string]string{}
+	for _, user := range data.Users {
+		list = append(list, map[string]string{"username": user.Username, "nickname": user.Nickname, "platform": user.Platform})
+	}
+	writeStatusJSON(w, http.StatusOK, list)
+}
+
+// handleOpenUser 使用保存的账号登录，登录过期且保存了密码时自动重新登录
+func (ws *webServer) handleOpenUser(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+		Platform string `json:"platform"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	platform, err := config.GetPlatform(req.Platform)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	user, err := findSavedUser(req.Username, platform)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	s, err := session.OpenSavedUser(*user, ws.af.options()...)
+	if err == nil {
+		err = validateSession(s)
+	}
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	ws.sessions.Add(s)
+	writeStatusJSON(w, http.StatusOK, sessionInfo(s))
+}
+
+// handleSendSMS 发送短信验证码
+func (ws *webServer) handleSendSMS(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Platform string `json:"platform"`
+		Phone    string `json:"phone"`
+		Zone     string `json:"zone"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	platform, err := config.GetPlatform(req.Platform)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Phone == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("请填写手机号"))
+		return
+	}
+	if req.Zone == "" {
+		req.Zone = "86"
+	}
+	if err := api.NewClient(platform, ws.af.options()...).SendSMSCode(req.Phone, req.Zone); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeStatusJSON(w, http.StatusOK, map[string]string{"status": "sent"})
+}
+
+// handleLogin 账号密码或短信验证码登录，与图形界面相同可以选择保存账号和记住密码
+func (ws *webServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Platform         string `json:"platform"`
+		Username         string `json:"username"`
+		Password         string `json:"password"`
+		Phone            string `json:"phone"`
+		Code             string `json:"code"`
+		Zone             string `json:"zone"`
+		Save             bool   `json:"save"`
+		RememberPassword bool   `json:"remember_password"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	platform, err := config.GetPlatform(req.Platform)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Zone == "" {
+		req.Zone = "86"
+	}
+
+	client := api.NewClient(platform, ws.af.options()...)
+	var (
+		authData *models.AuthData
+		user     models.SavedUser
+	)
+	switch {
+	case req.Username != "" && req.Password != "":
+		authData, err = client.LoginWithPassword(req.Username, req.Password)
+		user.Username = req.Username
+		if req.RememberPassword {
+			user.Password = req.Password
+		}
+	case req.Phone != "" && req.Code != "":
+		authData, err = client.LoginWithSMS(req.Phone, req.Code, req.Zone)
+		user.Username = req.Phone
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("请填写用户名和密码，或手机号和验证码"))
+		return
+	}
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	client.SetAuth(authData.Token, authData.UserID)
+
+	s := &session.Session{Client: client, StudentName: authData.Nickname}
+	if req.Save {
+		user.Nickname, user.Token, user.Platform, user.UserID = authData.Nickname, authData.Token, platform.Name, authData.UserID
+		if err := utils.SaveUser(user); err != nil {
+			// 保存失败不影响登录
+			fmt.Fprintf(os.Stderr, "保存用户信息失败: %v\n", err)
+		} else {
+			s.SavedUser = &user
+		}
+	}
+	ws.sessions.Add(s)
+	writeStatusJSON(w, http.StatusOK, sessionInfo(s))
+}
+
+// handleSessions GET 列出已登录的学员，DELETE ?session= 退出学员
+func (ws *webServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if ws.session(w, r.URL.Query().Get("session")) == nil {
+			return
+		}
+		ws.sessions.Remove(r.URL.Query().Get("session"))
+	}
+	writeStatusJSON(w, http.StatusOK, ws.sessionList())
+}
+
+// handleStudents GET ?session= 列出账号下的学员；POST 切换到选中的学员，
+// 选中多个学员时与图形界面相同为每个学员创建会话
+func (ws *webServer) handleStudents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		s := ws.session(w, r.URL.Query().Get("session"))
+		if s == nil {
+			return
+		}
+		accounts, err := s.Client.GetStudentAccounts()
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		list := []map[string]interface{}{}
+		for _, acc := range accounts {
+			id := fmt.Sprint(acc.PuUID)
+			list = append(list, map[string]interface{}{"uid": id, "nickname": acc.Nickname, "current": id == s.StudentID()})
+		}
+		writeStatusJSON(w, http.StatusOK, list)
+		return
+	}
+
+	var req struct {
+		Session  string   `json:"session"`
+		Students []string `json:"students"` // 学员ID或昵称
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	base := ws.session(w, req.Session)
+	if base == nil {
+		return
+	}
+	if len(req.Students) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("请至少选择一个学员"))
+		return
+	}
+	accounts, err := base.Client.GetStudentAccounts()
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	var selected []*models.StudentAccount
+	for _, target := range req.Students {
+		var found *models.StudentAccount
+		for _, acc := range accounts {
+			if fmt.Sprint(acc.PuUID) == target || acc.Nickname == target {
+				found = acc
+				break
+			}
+		}
+		if found == nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("未找到学员: %s", target))
+			return
+		}
+		selected = append(selected, found)
+	}
+	sessions, err := session.ForStudents(base, selected)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	// 未选中原来的学员时移除原来的会话
+	ws.sessions.Remove(base.Key())
+	for _, s := range sessions {
+		ws.sessions.Add(s)
+	}
+	writeStatusJSON(w, http.StatusOK, ws.sessionList())
+}
+
+// handleCourses GET ?session= 列出学员的课程
+func (ws *webServer) handleCourses(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	s := ws.session(w, r.URL.Query().Get("session"))
+	if s == nil {
+		return
+	}
+	courses, err := s.Client.GetCourseList()
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if courses == nil {
+		courses = []*models.Course{}
+	}
+	writeStatusJSON(w, http.StatusOK, courses)
+}
+
+// handleLectures GET ?session=&course= 列出课程的讲次，格式与 list-lectures -json 相同
+func (ws *webServer) handleLectures(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	s := ws.session(w, r.URL.Query().Get("session"))
+	if s == nil {
+		return
+	}
+	course, err := findCourse(s.Client, r.URL.Query().Get("course"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	lectures, err := s.Client.GetLectures(course.CourseID)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	list := []map[string]interface{}{}
+	for j, lecture := range lectures {
+		list = append(list, lectureInfo(lecture, j, course))
+	}
+	writeStatusJSON(w, http.StatusOK, list)
+}
+
+// handleDownloads GET 返回下载队列；POST 将课程的讲次加入下载队列
+func (ws *webServer) handleDownloads(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		ws.mu.Lock()
+		list := make([]webJob, len(ws.jobs))
+		for i, j := range ws.jobs {
+			list[i] = *j
+		}
+		ws.mu.Unlock()
+		writeStatusJSON(w, http.StatusOK, list)
+		return
+	}
+
+	var req struct {
+		Session    string `json:"session"`
+		Course     string `json:"course"`
+		Lectures   string `json:"lectures"` // 讲次范围，如 "1-3,5"，为空时下载全部已结束的讲
+		Extensive  bool   `json:"extensive"`
+		Definition string `json:"definition"`
+		Overwrite  bool   `json:"overwrite"`
+		// 保存到下载目录中以学员命名的子目录（与同时下载多个学员时相同），模板中有 {student} 时不再创建
+		StudentFolder bool `json:"studentFolder"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	s := ws.session(w, req.Session)
+	if s == nil {
+		return
+	}
+	if req.Definition == "" {
+		req.Definition = ws.definition
+	}
+	course, err := findCourse(s.Client, req.Course)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	lectures, err := s.Client.GetLectures(course.CourseID)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	indices, err := lectureIndices(lectures, req.Lectures, course)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// 目录结构只由请求和模板决定，同一学员的文件不会因为登录的学员数量不同而保存到不同目录
+	downloadPath := filepath.Join(ws.baseDir, s.Platform().DownloadFolderName())
+	if req.StudentFolder && !ws.run.template.Uses("student") {
+		downloadPath = filepath.Join(downloadPath, s.DirName())
+	}
+	if err := utils.Mkdir(downloadPath); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ws.queueMu.Lock()
+	defer ws.queueMu.Unlock()
+	before := len(ws.run.jobs)
+	dest := lectureDest{baseDir: downloadPath, template: ws.run.template, overwrite: req.Overwrite}
+	ws.run.queueLectures(s, course, lectures, indices, dest, req.Extensive, req.Definition)
+
+	ids := []int{}
+	ws.mu.Lock()
+	for _, job := range ws.run.jobs[before:] {
+		ws.tasks[job.task] = job
+		if j := ws.findJob(job.id); j != nil {
+			j.job = job
+		}
+		ids = append(ids, job.id)
+	}
+	ws.mu.Unlock()
+	ws.run.dl.Start()
+	// 跳过和失败的讲次通过 /api/events 推送
+	writeStatusJSON(w, http.StatusAccepted, map[string]interface{}{"queued": ids})
+}
+
+// handleDownloadAction POST /api/downloads/<编号>/<pause|resume|cancel>，编号为 all 时操作所有任务；
+// POST /api/downloads/clear 从队列中移除已结束的任务
+func (ws *webServer) handleDownloadAction(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	target, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/downloads/"), "/")
+	if target == "clear" && action == "" {
+		ws.mu.Lock()
+		var kept []*webJob
+		for _, j := range ws.jobs {
+			if !j.finished() {
+				kept = append(kept, j)
+			}
+		}
+		ws.jobs = kept
+		ws.mu.Unlock()
+		writeStatusJSON(w, http.StatusOK, map[string]string{"status": "cleared"})
+		return
+	}
+
+	var apply func(task *downloader.DownloadTask)
+	switch action {
+	case "pause":
+		apply = (*downloader.DownloadTask).Pause
+	case "resume":
+		apply = (*downloader.DownloadTask).Resume
+	case "cancel":
+		apply = (*downloader.DownloadTask).Cancel
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("未知操作: %s", action))
+		return
+	}
+
+	// 操作任务时会发出事件，不能持有 ws.mu
+	var tasks []*downloader.DownloadTask
+	ws.mu.Lock()
+	if target == "all" {
+		for _, j := range ws.jobs {
+			if j.job != nil && !j.finished() {
+				tasks = append(tasks, j.job.task)
+			}
+		}
+	} else if id, err := strconv.Atoi(target); err == nil {
+		if j := ws.findJob(id); j != nil && j.job != nil {
+			tasks = append(tasks, j.job.task)
+		}
+	}
+	ws.mu.Unlock()
+	if len(tasks) == 0 && target != "all" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("未找到任务: %s", target))
+		return
+	}
+	for _, task := range tasks {
+		apply(task)
+	}
+	writeStatusJSON(w, http.StatusOK, map[string]int{"affected": len(tasks)})
+}
+
+// handleEvents 以 Server-Sent Events 推送下载事件，格式与 download -json 的输出相同
+func (ws *webServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("不支持推送事件"))
+		return
+	}
+
+	ch := make(chan progressEvent, 64)
+	ws.mu.Lock()
+	ws.clients[ch] = true
+	ws.mu.Unlock()
+	defer func() {
+		ws.mu.Lock()
+		delete(ws.clients, ch)
+		ws.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
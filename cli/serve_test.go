@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/downloader"
+	"github.com/itsHenry35/tal_downloader/faketal"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+// sampleMP4 结构完整的 MP4 文件
+func sampleMP4() []byte {
+	box := func(boxType string, content []byte) []byte {
+		b := make([]byte, 8, 8+len(content))
+		binary.BigEndian.PutUint32(b, uint32(8+len(content)))
+		copy(b[4:], boxType)
+		return append(b, content...)
+	}
+	var data []byte
+	data = append(data, box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))...)
+	data = append(data, box("moov", box("mvhd", make([]byte, 100)))...)
+	return append(data, box("mdat", bytes.Repeat([]byte{1}, 4096))...)
+}
+
+// newTestWebServer 创建网页模式的服务器，下载保存到临时目录
+func newTestWebServer(t *testing.T, password string) *webServer {
+	t.Helper()
+	utils.SetRootPath(t.TempDir())
+	t.Cleanup(func() { utils.SetRootPath("") })
+
+	fs := newFlagSet("serve")
+	var qf qualityFlags
+	qf.register(fs)
+	var tf transferFlags
+	tf.register(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	run := newDownloadRun(false, qf, tf)
+	run.multiStudent = true
+	ws := &webServer{
+		password:   password,
+		baseDir:    t.TempDir(),
+		definition: *qf.definition,
+		sessions:   session.NewManager(),
+		run:        run,
+		tasks:      make(map[*downloader.DownloadTask]*downloadJob),
+		clients:    make(map[chan progressEvent]bool),
+	}
+	run.rep.onEvent = ws.onEvent
+	t.Cleanup(func() {
+		run.dl.Cancel()
+		for _, job := range run.jobs {
+			job.task.Wait()
+		}
+	})
+	return ws
+}
+
+func serveRequest(h http.Handler, method, host, body string, setup func(*http.Request)) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "http://"+host+"/api/platforms", nil)
+	if body != "" {
+		r = httptest.NewRequest(method, "http://"+host+"/api/downloads", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if setup != nil {
+		setup(r)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestServeAccessControl(t *testing.T) {
+	ws := newTestWebServer(t, "")
+	h := ws.authorize(ws.handler())
+	if w := serveRequest(h, http.MethodGet, "127.0.0.1:8080", "", nil); w.Code != http.StatusOK {
+		t.Errorf("loopback without password = %d, want 200", w.Code)
+	}
+	if w := serveRequest(h, http.MethodGet, "localhost:8080", "", nil); w.Code != http.StatusOK {
+		t.Errorf("localhost without password = %d, want 200", w.Code)
+	}
+	// DNS 重绑定时 Host 是其他网站的域名
+	if w := serveRequest(h, http.MethodGet, "attacker.example.com:8080", "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("foreign Host without password = %d, want 403", w.Code)
+	}
+
+	ws = newTestWebServer(t, "pw")
+	h = ws.authorize(ws.handler())
+	if w := serveRequest(h, http.MethodGet, "192.168.1.2:8080", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("password set, no credentials = %d, want 401", w.Code)
+	}
+	auth := func(r *http.Request) { r.SetBasicAuth("any", "pw") }
+	if w := serveRequest(h, http.MethodGet, "192.168.1.2:8080", "", auth); w.Code != http.StatusOK {
+		t.Errorf("password set, with credentials = %d, want 200", w.Code)
+	}
+	// 表单提交不需要预检，修改状态的请求必须是JSON
+	form := func(r *http.Request) {
+		auth(r)
+		r.Header.Set("Content-Type", "text/plain")
+	}
+	if w := serveRequest(h, http.MethodPost, "192.168.1.2:8080", "{}", form); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("non-JSON POST = %d, want 415", w.Code)
+	}
+}
+
+func TestServeDownloadOptionsPerRequest(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+	media := srv.AddFile("lecture.mp4", sampleMP4())
+	course := &faketal.Course{Course: models.Course{CourseID: "c1", SubjectName: "数学", CourseName: "函数", EndLiveNum: 3}}
+	for i := 1; i <= 3; i++ {
+		course.Lectures = append(course.Lectures, &faketal.Lecture{
+			Lecture:   models.Lecture{LiveID: 100 + i, Num: i, LiveTypeString: "SMALL_CLASS_MODE", Status: models.LectureStatusEnded},
+			VideoURLs: []string{media},
+		})
+	}
+	srv.AddAccount(&faketal.Account{
+		Platform: "ledu",
+		Username: "13800000000",
+		Password: "secret",
+		Students: []*faketal.Student{{UID: 1001, Nickname: "小明", Courses: []*faketal.Course{course}}, {UID: 1002, Nickname: "小红"}},
+	})
+
+	ws := newTestWebServer(t, "")
+	client := api.NewClient(srv.Platform("ledu"))
+	auth, err := client.LoginWithPassword("13800000000", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetAuth(auth.Token, auth.UserID)
+	s := &session.Session{Client: client, StudentName: "小明"}
+	ws.sessions.Add(s)
+	other, err := session.ForStudent(s, &models.StudentAccount{PuUID: 1002, Nickname: "小红"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.sessions.Add(other)
+
+	// 所有讲次都已有完整的文件
+	courseDir := filepath.Join(ws.baseDir, srv.Platform("ledu").DownloadFolderName(), "数学 - 函数")
+	if err := os.MkdirAll(courseDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := os.WriteFile(filepath.Join(courseDir, "第"+string(rune('0'+i))+"讲.mp4"), sampleMP4(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := ws.authorize(ws.handler())
+	queue := func(lectures string, overwrite, studentFolder bool) []int {
+		t.Helper()
+		body, _ := json.Marshal(map[string]interface{}{"session": s.Key(), "course": "c1", "lectures": lectures, "overwrite": overwrite, "studentFolder": studentFolder})
+		w := serveRequest(h, http.MethodPost, "127.0.0.1:8080", string(body), nil)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("POST /api/downloads = %d: %s", w.Code, w.Body)
+		}
+		var resp struct {
+			Queued []int `json:"queued"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Queued
+	}
+
+	// 覆盖只对本次请求生效，登录了多个学员也不改变保存目录
+	if ids := queue("1", true, false); len(ids) != 1 {
+		t.Fatalf("overwrite request queued %v, want one job", ids)
+	}
+	if ids := queue("2", false, false); len(ids) != 0 {
+		t.Errorf("request without overwrite queued %v, want the existing file skipped", ids)
+	}
+	if got, want := ws.run.jobs[0].file, filepath.Join(courseDir, "第1讲.mp4"); got != want {
+		t.Errorf("file = %s, want %s", got, want)
+	}
+
+	// 请求学员子目录时保存到以学员命名的目录
+	if ids := queue("3", false, true); len(ids) != 1 {
+		t.Fatalf("student folder request queued %v, want one job", ids)
+	}
+	want := filepath.Join(ws.baseDir, srv.Platform("ledu").DownloadFolderName(), s.DirName(), "数学 - 函数", "第3讲.mp4")
+	if got := ws.run.jobs[1].file; got != want {
+		t.Errorf("file = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/itsHenry35/tal_downloader/downloader"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+func runSettings(args []string) int {
+	fs := newFlagSet("settings")
+	var tf transferFlags
+	tf.register(fs)
+	reset := fs.Bool("reset", false, "恢复默认设置")
+	asJSON := fs.Bool("json", false, "以JSON输出设置")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+
+	// 只在指定了参数时修改设置，否则显示当前设置
+	changed := *reset
+	fs.Visit(func(f *flag.Flag) {
+		changed = changed || f.Name != "json"
+	})
+
+	settings, err := tf.toSettings()
+	if err != nil {
+		return fail(err)
+	}
+	if *reset {
+		settings = utils.DefaultSettings()
+	}
+	if changed {
+		if err := utils.SaveSettings(settings); err != nil {
+			return fail(err)
+		}
+	}
+
+	if *asJSON {
+		printJSON(settings)
+		return exitOK
+	}
+	printSettings(settings)
+	if changed {
+		fmt.Println("设置已保存")
+	}
+	return exitOK
+}
+
+func printSettings(settings models.Settings) {
+	adaptive := "关闭"
+	if settings.AdaptiveThreads {
+		adaptive = "开启"
+	}
+	limit := "不限速"
+	if settings.RateLimit > 0 {
+		limit = downloader.FormatRate(settings.RateLimit) + "/s"
+	}
+	schedule := settings.RateSchedule
+	if schedule == "" {
+		schedule = "无"
+	}
+	fmt.Printf("同时下载文件数:\t%d\n", settings.MaxConcurrentDownloads)
+	fmt.Printf("每个文件线程数:\t%d\n", settings.ThreadCount)
+	fmt.Printf("自动调节线程数:\t%s\n", adaptive)
+	fmt.Printf("下载限速:\t%s\n", limit)
+	fmt.Printf("按时段限速:\t%s\n", schedule)
+	template := settings.FileTemplate
+	if template == "" {
+		template = utils.DefaultFileTemplate + "（默认）"
+	}
+	fmt.Printf("保存路径模板:\t%s\n", template)
+}
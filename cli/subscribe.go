@@ -0,0 +1,288 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/itsHenry35/tal_downloader/config"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
+	"github.com/itsHenry35/tal_downloader/subscription"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+func runSubscribe(args []string) int {
+	fs := newFlagSet("subscribe")
+	var sf sessionFlags
+	sf.register(fs)
+	var courseIDs courseFlag
+	fs.Var(&courseIDs, "course", "订阅的课程ID，可重复或用逗号分隔")
+	all := fs.Bool("all", false, "订阅学员当前的全部课程（包括之后新报名的）")
+	extensive := fs.Bool("extensive", false, "同步时同时下载延伸内容（否则只报告）")
+	definition := fs.String("definition", "", "同步时的回放清晰度（默认最高清晰度）")
+	path := fs.String("path", ".", "下载路径（会在其中创建平台下载目录）")
+	students := fs.String("students", "", "同时订阅账号下的多个学员: all 或逗号分隔的学员ID/昵称")
+	remove := fs.Bool("remove", false, "取消订阅 -course 指定的课程，未指定课程时取消学员的整个订阅")
+	list := fs.Bool("list", false, "列出所有订阅")
+	asJSON := fs.Bool("json", false, "以JSON Lines输出（-list）")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+
+	if *list {
+		return listSubscriptions(*asJSON)
+	}
+	if !*remove && !*all && len(courseIDs) == 0 {
+		fmt.Fprintln(os.Stderr, "请使用 -course 指定课程，或使用 -all 订阅全部课程")
+		return exitUsage
+	}
+
+	sessions, err := sf.openAll(false, *students)
+	if err != nil {
+		return fail(err)
+	}
+
+	if *remove {
+		for _, s := range sessions {
+			if err := utils.Unsubscribe(s.Platform().Name, s.StudentID(), courseIDs); err != nil {
+				return fail(err)
+			}
+			fmt.Printf("已取消 %s 的订阅\n", s.Label())
+		}
+		return exitOK
+	}
+
+	baseDir, err := filepath.Abs(*path)
+	if err != nil {
+		return fail(err)
+	}
+	settings, _ := utils.LoadSettings()
+	template, _ := utils.ParseFileTemplate(settings.FileTemplate)
+	for _, s := range sessions {
+		if s.SavedUser == nil {
+			return fail(fmt.Errorf("同步时需要使用保存的账号登录，请先执行 login"))
+		}
+		if !*all {
+			// 检查课程ID是否存在
+			courses, err := s.Client.GetCourseList()
+			if err != nil {
+				return fail(err)
+			}
+			if _, err := selectCourses(courses, courseIDs, false, "", len(sessions) > 1); err != nil {
+				return fail(err)
+			}
+		}
+
+		// 与 download 相同，多个学员时每个学员使用单独的目录
+		downloadPath := filepath.Join(baseDir, s.Platform().DownloadFolderName())
+		if len(sessions) > 1 && !template.Uses("student") {
+			downloadPath = filepath.Join(downloadPath, s.DirName())
+		}
+		sub, err := utils.Subscribe(models.Subscription{
+			Platform:    s.Platform().Name,
+			Username:    s.SavedUser.Username,
+			StudentID:   s.StudentID(),
+			StudentName: s.StudentName,
+			AllCourses:  *all,
+			CourseIDs:   courseIDs,
+			Extensive:   *extensive,
+			Definition:  *definition,
+			Path:        downloadPath,
+		})
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Printf("已订阅 %s: %s\n", s.Label(), subscriptionCourses(sub))
+	}
+	return exitOK
+}
+
+// subscriptionCourses 订阅课程的说明
+func subscriptionCourses(sub *models.Subscription) string {
+	if sub.AllCourses {
+		return "全部课程"
+	}
+	return strings.Join(sub.CourseIDs, ",")
+}
+
+func listSubscriptions(asJSON bool) int {
+	data, err := utils.LoadSubscriptions()
+	if err != nil {
+		return fail(err)
+	}
+	for _, sub := range data.Subscriptions {
+		if asJSON {
+			printJSON(sub)
+			continue
+		}
+		lastSync := "从未同步"
+		if !sub.LastSync.IsZero() {
+			lastSync = sub.LastSync.Format("2006-01-02 15:04")
+		}
+		line := fmt.Sprintf("%s(%s)\t%s\t%s\t%s", sub.StudentName, sub.Platform, subscriptionCourses(sub), sub.Path, lastSync)
+		if sub.Extensive {
+			line += "\t含延伸内容"
+		}
+		fmt.Println(line)
+	}
+	return exitOK
+}
+
+func runSync(args []string) int {
+	fs := newFlagSet("sync")
+	var af apiFlags
+	af.register(fs)
+	var qf qualityFlags
+	qf.register(fs)
+	var tf transferFlags
+	tf.register(fs)
+	users := fs.String("users", "", "只同步这些保存账号的订阅，逗号分隔（默认全部）")
+	dryRun := fs.Bool("dry-run", false, "只列出需要下载的讲次和新的延伸内容，不下载")
+	asJSON := fs.Bool("json", false, "以JSON Lines输出进度")
+	if code := parseFlags(fs, args); code >= 0 {
+		return code
+	}
+	if err := qf.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := tf.apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	subs, err := loadSubscriptions(*users)
+	if err != nil {
+		return fail(err)
+	}
+	run := newDownloadRun(*asJSON, qf, tf)
+	return syncSubscriptions(run, subs, af, *qf.definition, *dryRun)
+}
+
+// loadSubscriptions 加载订阅，users 为逗号分隔的保存账号时只保留这些账号的订阅
+func loadSubscriptions(users string) ([]*models.Subscription, error) {
+	data, err := utils.LoadSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(users, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			wanted[name] = true
+		}
+	}
+	var subs []*models.Subscription
+	for _, sub := range data.Subscriptions {
+		if len(wanted) == 0 || wanted[sub.Username] {
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("没有订阅的课程，请先使用 subscribe 添加")
+	}
+	return subs, nil
+}
+
+// syncSubscriptions 同步订阅并等待下载完成，返回退出码。definition 为订阅没有设置清晰度时使用的清晰度
+func syncSubscriptions(run *downloadRun, subs []*models.Subscription, af apiFlags, definition string, dryRun bool) int {
+	run.multiStudent = len(subs) > 1
+	for _, sub := range subs {
+		label := fmt.Sprintf("%s (%s)", sub.StudentName, sub.Platform)
+		s, err := openSubscription(sub, af)
+		if err != nil {
+			run.rep.report(progressEvent{Student: label}.failed(err))
+			continue
+		}
+
+		diffs, errs, err := subscription.Diff(s, sub)
+		if err != nil {
+			run.rep.report(progressEvent{Student: label}.failed(err))
+			continue
+		}
+		for _, err := range errs {
+			run.rep.report(progressEvent{Student: run.studentLabel(s)}.failed(err))
+		}
+		if !dryRun {
+			if err := utils.Mkdir(sub.Path); err != nil {
+				run.rep.report(progressEvent{Student: label}.failed(err))
+				continue
+			}
+		}
+
+		subDefinition := definition
+		if sub.Definition != "" {
+			subDefinition = sub.Definition
+		}
+		dest := lectureDest{baseDir: sub.Path, template: run.template}
+		for _, d := range diffs {
+			if !sub.Extensive {
+				for _, j := range d.FoundExtensive {
+					run.rep.report(progressEvent{
+						Event: "extensive", Student: run.studentLabel(s), Course: courseDirName(d.Course),
+						Lecture: d.Lectures[j].Number(j), Title: d.Lectures[j].Title, Message: "有新的延伸内容",
+					})
+				}
+			}
+			if dryRun {
+				reportPending(run, s, d)
+				continue
+			}
+			// 先加入回放再加入延伸内容：加入延伸内容时会修改讲次的类型
+			run.queueLectures(s, d.Course, d.Lectures, d.New, dest, false, subDefinition)
+			run.queueLectures(s, d.Course, d.Lectures, d.NewExtensive, dest, true, subDefinition)
+		}
+		if dryRun {
+			continue
+		}
+		if err := utils.UpdateSubscription(sub); err != nil {
+			fmt.Fprintf(os.Stderr, "保存同步状态失败: %v\n", err)
+		}
+	}
+	if dryRun {
+		return exitOK
+	}
+	return run.wait()
+}
+
+// reportPending 输出需要下载的讲次（-dry-run）
+func reportPending(run *downloadRun, s *session.Session, d *subscription.CourseDiff) {
+	pending := func(indices []int, message string) {
+		for _, j := range indices {
+			run.rep.report(progressEvent{
+				Event: "pending", Student: run.studentLabel(s), Course: courseDirName(d.Course),
+				Lecture: d.Lectures[j].Number(j), Title: d.Lectures[j].Title, Message: message,
+			})
+		}
+	}
+	pending(d.New, "新的回放")
+	pending(d.NewExtensive, "新的延伸内容")
+}
+
+// openSubscription 使用订阅的保存账号登录，并切换到订阅的学员
+func openSubscription(sub *models.Subscription, af apiFlags) (*session.Session, error) {
+	platform, err := config.GetPlatform(sub.Platform)
+	if err != nil {
+		return nil, err
+	}
+	user, err := findSavedUser(sub.Username, platform)
+	if err != nil {
+		return nil, err
+	}
+	s, err := session.OpenSavedUser(*user, af.options()...)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSession(s); err != nil {
+		return nil, err
+	}
+	if s.StudentID() != sub.StudentID {
+		selected, err := switchStudent(s.Client, sub.StudentID)
+		if err != nil {
+			return nil, err
+		}
+		s.StudentName = selected.Nickname
+	}
+	return s, nil
+}
@@ -0,0 +1,18 @@
+// tal_downloader_cli 只包含命令行模式的程序，不链接图形界面（GLFW/X11），
+// 可以在没有图形环境的服务器上编译和运行。参数与 "tal_downloader cli" 相同
+package main
+
+import (
+	"os"
+
+	"github.com/itsHenry35/tal_downloader/cli"
+)
+
+func main() {
+	args := os.Args[1:]
+	// 兼容 "tal_downloader cli <命令>" 的写法
+	if len(args) > 0 && args[0] == "cli" {
+		args = args[1:]
+	}
+	os.Exit(cli.Run(args))
+}
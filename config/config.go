@@ -1,6 +1,13 @@
 package config
 
-import "fyne.io/fyne/v2"
+import (
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+// AppID 应用ID，也决定程序数据目录的位置
+const AppID = "com.itshenry.tal_downloader"
 
 const (
 	// API Base URLs
@@ -26,29 +33,16 @@ const (
 	// Download Configuration
 	MaxConcurrentDownloads = 32
 	ThreadCount            = 16
+
+	// API Retry Configuration
+	APIMaxAttempts    = 4
+	APIRetryBaseDelay = 500 * time.Millisecond
+	APIRetryMaxDelay  = 8 * time.Second
+
+	// TokenCheckInterval 图形界面切换页面时检查登录状态的最小间隔
+	TokenCheckInterval = 5 * time.Minute
 )
 
 var (
 	DefaultWindowSize = fyne.NewSize(800, 600)
-	CourseAPIBase     = CourseAPIBase_Ledu    // Default to Ledu, can be changed to XES
-	ClassroomAPIBase  = ClassroomAPIBase_Ledu // Default to Ledu, can be changed to XES
-	ClientID          = ClientID_Ledu         // Default to Ledu, can be changed to XES
-	PlatformName      = PlatformName_Ledu     // Default to Ledu, can be changed to XES
 )
-
-func SetPlatform(platform string) {
-	switch platform {
-	case "ledu", "乐读":
-		CourseAPIBase = CourseAPIBase_Ledu
-		ClassroomAPIBase = ClassroomAPIBase_Ledu
-		ClientID = ClientID_Ledu
-		PlatformName = PlatformName_Ledu
-	case "xes", "学而思培优":
-		CourseAPIBase = CourseAPIBase_XES
-		ClassroomAPIBase = ClassroomAPIBase_XES
-		ClientID = ClientID_XES
-		PlatformName = PlatformName_XES
-	default:
-		panic("Unsupported platform: " + platform)
-	}
-}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Platform 一个平台（乐读、学而思培优等）的接口地址和客户端信息
+type Platform struct {
+	ID               string `json:"id"`                        // 命令行等场景使用的标识，如 "ledu"
+	Name             string `json:"name"`                      // 显示名称，保存的账号和下载记录使用该名称
+	PassportAPIBase  string `json:"passport_api_base"`         // 账号密码/短信登录接口地址
+	CourseAPIBase    string `json:"course_api_base"`           // 课程接口地址
+	ClassroomAPIBase string `json:"classroom_api_base"`        // 回放接口地址
+	ClientID         string `json:"client_id"`                 // 登录时使用的客户端ID
+	Version          string `json:"version,omitempty"`         // version 请求头，默认为 Version
+	ResVer           string `json:"res_ver,omitempty"`         // resVer 请求头，默认为 ResVer
+	DownloadFolder   string `json:"download_folder,omitempty"` // 下载目录名，默认为 "<名称>-下载"
+	// Headers 覆盖所有请求中的固定请求头，值为空时不发送该请求头，Host 设置请求的 Host
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// DownloadFolderName 平台的下载目录名
+func (p *Platform) DownloadFolderName() string {
+	if p.DownloadFolder != "" {
+		return p.DownloadFolder
+	}
+	return fmt.Sprintf("%s-下载", p.Name)
+}
+
+// VersionHeader version 请求头
+func (p *Platform) VersionHeader() string {
+	if p.Version != "" {
+		return p.Version
+	}
+	return Version
+}
+
+// ResVerHeader resVer 请求头
+func (p *Platform) ResVerHeader() string {
+	if p.ResVer != "" {
+		return p.ResVer
+	}
+	return ResVer
+}
+
+// merge 返回用 override 中非空字段覆盖后的副本，请求头逐项合并
+func (p *Platform) merge(override *Platform) *Platform {
+	merged := *p
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{
+		{&merged.Name, override.Name},
+		{&merged.PassportAPIBase, override.PassportAPIBase},
+		{&merged.CourseAPIBase, override.CourseAPIBase},
+		{&merged.ClassroomAPIBase, override.ClassroomAPIBase},
+		{&merged.ClientID, override.ClientID},
+		{&merged.Version, override.Version},
+		{&merged.ResVer, override.ResVer},
+		{&merged.DownloadFolder, override.DownloadFolder},
+	} {
+		if f.src != "" {
+			*f.dst = f.src
+		}
+	}
+	if len(override.Headers) > 0 {
+		merged.Headers = make(map[string]string, len(p.Headers)+len(override.Headers))
+		for k, v := range p.Headers {
+			merged.Headers[k] = v
+		}
+		for k, v := range override.Headers {
+			merged.Headers[k] = v
+		}
+	}
+	return &merged
+}
+
+func (p *Platform) validate() error {
+	if p.ID == "" || p.Name == "" {
+		return fmt.Errorf("平台缺少 id 或 name")
+	}
+	if p.PassportAPIBase == "" || p.CourseAPIBase == "" || p.ClassroomAPIBase == "" || p.ClientID == "" {
+		return fmt.Errorf("平台 %s 缺少接口地址或客户端ID", p.ID)
+	}
+	return nil
+}
+
+var (
+	platformsMutex sync.RWMutex
+	platforms      = []*Platform{
+		{
+			ID:               "ledu",
+			Name:             PlatformName_Ledu,
+			PassportAPIBase:  PassportAPIBase,
+			CourseAPIBase:    CourseAPIBase_Ledu,
+			ClassroomAPIBase: ClassroomAPIBase_Ledu,
+			ClientID:         ClientID_Ledu,
+		},
+		{
+			ID:               "xes",
+			Name:             PlatformName_XES,
+			PassportAPIBase:  PassportAPIBase,
+			CourseAPIBase:    CourseAPIBase_XES,
+			ClassroomAPIBase: ClassroomAPIBase_XES,
+			ClientID:         ClientID_XES,
+		},
+	}
+)
+
+// DefaultPlatform 默认平台（乐读）
+func DefaultPlatform() *Platform {
+	platformsMutex.RLock()
+	defer platformsMutex.RUnlock()
+	return platforms[0]
+}
+
+// Platforms 已注册的所有平台
+func Platforms() []*Platform {
+	platformsMutex.RLock()
+	defer platformsMutex.RUnlock()
+	result := make([]*Platform, len(platforms))
+	copy(result, platforms)
+	return result
+}
+
+// GetPlatform 按标识或显示名称查找平台
+func GetPlatform(idOrName string) (*Platform, error) {
+	platformsMutex.RLock()
+	defer platformsMutex.RUnlock()
+	for _, p := range platforms {
+		if strings.EqualFold(p.ID, idOrName) || p.Name == idOrName {
+			return p, nil
+		}
+	}
+
+	ids := make([]string, len(platforms))
+	for i, p := range platforms {
+		ids[i] = p.ID
+	}
+	return nil, fmt.Errorf("不支持的平台: %s（可选 %s）", idOrName, strings.Join(ids, "、"))
+}
+
+// RegisterPlatform 注册平台，标识已存在时替换原有配置
+func RegisterPlatform(p *Platform) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	platformsMutex.Lock()
+	defer platformsMutex.Unlock()
+	for i, existing := range platforms {
+		if existing.ID == p.ID {
+			platforms[i] = p
+			return nil
+		}
+	}
+	platforms = append(platforms, p)
+	return nil
+}
+
+// platformsFile 平台配置文件的格式
+type platformsFile struct {
+	Platforms []*Platform `json:"platforms"`
+}
+
+// LoadPlatforms 从配置文件注册平台，格式为 {"platforms": [{"id": ..., "name": ..., ...}]}。
+// id 与已有平台相同时只覆盖填写了的字段，可以只修改内置平台的接口地址或请求头
+func LoadPlatforms(r io.Reader) error {
+	var file platformsFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return fmt.Errorf("解析平台配置失败: %v", err)
+	}
+	for _, p := range file.Platforms {
+		if existing, err := GetPlatform(p.ID); err == nil && p.ID != "" {
+			p = existing.merge(p)
+		}
+		if err := RegisterPlatform(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadPlatformsOverridesBuiltin(t *testing.T) {
+	original, err := GetPlatform("xes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RegisterPlatform(original)
+
+	err = LoadPlatforms(strings.NewReader(`{"platforms": [
+		{"id": "xes", "course_api_base": "https://mirror.example.com", "headers": {"Host": "studentlive.speiyou.com"}},
+		{"id": "custom", "name": "自定义", "passport_api_base": "a", "course_api_base": "b", "classroom_api_base": "c", "client_id": "1"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, _ := GetPlatform("xes")
+	if p.CourseAPIBase != "https://mirror.example.com" {
+		t.Errorf("CourseAPIBase = %s", p.CourseAPIBase)
+	}
+	if p.Name != original.Name || p.ClientID != original.ClientID || p.ClassroomAPIBase != original.ClassroomAPIBase {
+		t.Errorf("unset fields should keep the builtin values: %+v", p)
+	}
+	if p.Headers["Host"] != "studentlive.speiyou.com" {
+		t.Errorf("Headers = %v", p.Headers)
+	}
+	if original.CourseAPIBase == p.CourseAPIBase {
+		t.Error("the builtin platform must not be modified in place")
+	}
+
+	if _, err := GetPlatform("custom"); err != nil {
+		t.Error(err)
+	}
+	if err := LoadPlatforms(strings.NewReader(`{"platforms": [{"id": "broken", "name": "x"}]}`)); err == nil {
+		t.Error("a new platform without endpoints should be rejected")
+	}
+}
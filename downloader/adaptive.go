@@ -0,0 +1,202 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// adaptiveStartThreads 自动调节时每个文件初始的线程数
+	adaptiveStartThreads = 4
+	// tuneWindow 自动调节时统计速度和错误率的时间窗口
+	tuneWindow = 2 * time.Second
+	// tuneErrorRate 窗口内失败的请求超过该比例时减少线程
+	tuneErrorRate = 0.2
+	// tuneGain 速度至少提升该比例才继续增加线程
+	tuneGain = 1.1
+	// adaptivePartSize 自动调节时普通文件的分片大小，分片多于线程数以便运行中调整
+	adaptivePartSize = 8 << 20
+	// tuneHoldWindows 减少线程后至少保持的窗口数，避免反复增减
+	tuneHoldWindows = 3
+	// partAttempts 自动调节时单个分片的最大尝试次数
+	partAttempts = 3
+)
+
+// statusError 服务器返回了非预期的状态码
+type statusError struct {
+	what string
+	code int
+	text string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.what, e.text)
+}
+
+func newStatusError(what string, resp *http.Response) error {
+	return &statusError{what: what, code: resp.StatusCode, text: resp.Status}
+}
+
+// isThrottled 判断错误是否为服务器限制连接数（403/429/503）
+func isThrottled(err error) bool {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.code == http.StatusForbidden || se.code == http.StatusTooManyRequests || se.code == http.StatusServiceUnavailable
+}
+
+// threadTuner 限制单个文件同时进行的请求数。自动调节时按窗口统计速度和错误：
+// 被限流或错误率过高时线程数减半；线程用满时逐个增加，增加后速度下降则退回，
+// 减少线程或增加无效后保持几个窗口再尝试
+type threadTuner struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	active   int
+	adaptive bool
+	now      func() time.Time
+	onChange func(limit int) // 线程数变化时调用，用于让后续文件从调节后的线程数开始
+
+	windowStart time.Time
+	bytes       int64
+	requests    int
+	failures    int
+	throttled   bool
+	saturated   bool    // 窗口内线程数是否用满，没有用满时增加线程没有意义
+	lastSpeed   float64 // 上一个窗口的速度
+	raised      bool    // 上一个窗口结束时是否增加了线程
+	hold        int     // 减少线程或增加无效后，保持线程数不变的剩余窗口数
+}
+
+// newThreadTuner 创建线程限制，adaptive 为 false 时线程数固定为 limit
+func newThreadTuner(limit, max int, adaptive bool) *threadTuner {
+	if max < 1 {
+		max = 1
+	}
+	if limit < 1 || limit > max {
+		limit = max
+	}
+	t := &threadTuner{limit: limit, max: max, adaptive: adaptive, now: time.Now}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// Limit 返回当前允许的线程数
+func (t *threadTuner) Limit() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+// acquire 等待空闲的线程
+func (t *threadTuner) acquire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.active >= t.limit {
+		t.saturated = true
+		t.cond.Wait()
+	}
+	t.active++
+	if t.active == t.limit {
+		t.saturated = true
+	}
+}
+
+func (t *threadTuner) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	t.cond.Broadcast()
+}
+
+// record 统计读取的字节数
+func (t *threadTuner) record(n int) {
+	if !t.adaptive || n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bytes += int64(n)
+	t.tune()
+}
+
+// done 统计一次请求的结果
+func (t *threadTuner) done(err error) {
+	if !t.adaptive {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+	if err != nil {
+		t.failures++
+		t.throttled = t.throttled || isThrottled(err)
+		// 被限流时立即减少线程，不等窗口结束
+		if t.throttled {
+			t.windowStart = time.Time{}
+		}
+	}
+	t.tune()
+}
+
+// tune 窗口结束时调整线程数，调用时需持有锁
+func (t *threadTuner) tune() {
+	now := t.now()
+	if t.windowStart.IsZero() && !t.throttled {
+		t.windowStart = now
+		return
+	}
+	elapsed := now.Sub(t.windowStart)
+	if !t.throttled && elapsed < tuneWindow {
+		return
+	}
+
+	limit := t.limit
+	speed := 0.0
+	if elapsed > 0 && !t.windowStart.IsZero() {
+		speed = float64(t.bytes) / elapsed.Seconds()
+	}
+	raised := false
+	switch {
+	case t.throttled || (t.requests > 0 && float64(t.failures)/float64(t.requests) > tuneErrorRate):
+		limit /= 2
+		t.hold = tuneHoldWindows
+	case t.raised && speed < t.lastSpeed:
+		// 增加线程后速度反而下降，退回
+		limit--
+		t.hold = tuneHoldWindows
+	case t.raised && speed < t.lastSpeed*tuneGain:
+		// 速度提升不明显，保持当前线程数
+		t.hold = tuneHoldWindows
+	case t.hold > 0:
+		t.hold--
+	case t.saturated:
+		limit++
+		raised = true
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > t.max {
+		limit, raised = t.max, false
+	}
+	t.raised = raised
+
+	t.lastSpeed = speed
+	t.windowStart = now
+	t.bytes, t.requests, t.failures = 0, 0, 0
+	t.throttled, t.saturated = false, t.active >= limit
+
+	if limit != t.limit {
+		t.limit = limit
+		t.cond.Broadcast()
+		if t.onChange != nil {
+			t.onChange(limit)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeTuner 创建使用模拟时钟的自动调节线程限制
+func fakeTuner(limit, max int) (*threadTuner, *time.Time) {
+	now := time.Unix(0, 0)
+	t := newThreadTuner(limit, max, true)
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+// runWindow 占满线程并在一个窗口内下载 bytes 字节
+func runWindow(t *threadTuner, now *time.Time, bytes int) {
+	limit := t.Limit()
+	for i := 0; i < limit; i++ {
+		t.acquire()
+	}
+	*now = now.Add(tuneWindow)
+	t.record(bytes)
+	for i := 0; i < limit; i++ {
+		t.release()
+	}
+}
+
+func TestTunerHalvesWhenThrottled(t *testing.T) {
+	tuner, _ := fakeTuner(8, 16)
+	tuner.done(nil)
+	tuner.done(&statusError{what: "分片下载失败", code: http.StatusTooManyRequests, text: "429 Too Many Requests"})
+	if got := tuner.Limit(); got != 4 {
+		t.Errorf("limit after 429 = %d, want 4", got)
+	}
+	tuner.done(&statusError{what: "分片下载失败", code: http.StatusForbidden, text: "403 Forbidden"})
+	if got := tuner.Limit(); got != 2 {
+		t.Errorf("limit after 403 = %d, want 2", got)
+	}
+}
+
+func TestTunerBacksOffOnErrorRate(t *testing.T) {
+	tuner, now := fakeTuner(8, 16)
+	tuner.record(1) // 开始窗口
+	for i := 0; i < 4; i++ {
+		tuner.done(nil)
+	}
+	tuner.done(errors.New("connection reset"))
+	tuner.done(errors.New("connection reset"))
+	*now = now.Add(tuneWindow)
+	tuner.record(1)
+	if got := tuner.Limit(); got != 4 {
+		t.Errorf("limit after 2/6 failures = %d, want 4", got)
+	}
+}
+
+func TestTunerRaisesWhileThroughputImproves(t *testing.T) {
+	tuner, now := fakeTuner(4, 6)
+	tuner.record(1) // 开始窗口
+
+	// 速度随线程数增加，直到上限
+	speed := 1 << 20
+	for want := 5; want <= 6; want++ {
+		runWindow(tuner, now, speed*tuner.Limit())
+		if got := tuner.Limit(); got != want {
+			t.Fatalf("limit = %d, want %d", got, want)
+		}
+	}
+	runWindow(tuner, now, speed*tuner.Limit())
+	if got := tuner.Limit(); got != 6 {
+		t.Errorf("limit should not exceed the maximum, got %d", got)
+	}
+}
+
+func TestTunerRevertsWhenThroughputDrops(t *testing.T) {
+	tuner, now := fakeTuner(4, 16)
+	tuner.record(1)
+
+	runWindow(tuner, now, 8<<20)
+	if got := tuner.Limit(); got != 5 {
+		t.Fatalf("limit = %d, want 5", got)
+	}
+	// 增加线程后速度下降，退回并保持
+	runWindow(tuner, now, 6<<20)
+	if got := tuner.Limit(); got != 4 {
+		t.Fatalf("limit = %d, want 4", got)
+	}
+	for i := 0; i < tuneHoldWindows; i++ {
+		runWindow(tuner, now, 6<<20)
+		if got := tuner.Limit(); got != 4 {
+			t.Fatalf("limit changed during hold: %d", got)
+		}
+	}
+}
+
+func TestTunerFixedWhenNotAdaptive(t *testing.T) {
+	tuner := newThreadTuner(8, 8, false)
+	tuner.done(&statusError{code: http.StatusTooManyRequests})
+	if got := tuner.Limit(); got != 8 {
+		t.Errorf("limit = %d, want 8", got)
+	}
+}
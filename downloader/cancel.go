@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+// 取消任务时对已下载部分的处理
+const (
+	CancelKeepPartial   = "keep"   // 保留已下载的部分和续传状态，之后可以继续下载
+	CancelRemovePartial = "remove" // 删除未完成的文件、临时分段和续传状态
+)
+
+// ErrCancelled 任务被取消
+var ErrCancelled = errors.New("下载已取消")
+
+// IsCancelled 判断任务是否因取消而结束
+func IsCancelled(err error) bool {
+	return errors.Is(err, ErrCancelled)
+}
+
+// SetCancelPolicy 设置取消任务时对已下载部分的处理，默认为 CancelKeepPartial
+func (d *Downloader) SetCancelPolicy(policy string) {
+	d.cancelPolicy = policy
+}
+
+// Cancel 取消下载器中的所有任务（包括排队中的任务），正在进行的请求会立即中断
+func (d *Downloader) Cancel() {
+	d.cancel()
+	d.mu.Lock()
+	tasks := append([]*DownloadTask(nil), d.tasks...)
+	d.mu.Unlock()
+	for _, task := range tasks {
+		task.Cancel()
+	}
+}
+
+// Context 任务的 context，任务取消或下载器取消时结束
+func (task *DownloadTask) Context() context.Context {
+	return task.ctx
+}
+
+// waitWhilePaused 暂停时等待继续，任务取消时返回错误
+func (task *DownloadTask) waitWhilePaused() error {
+	for task.isPaused.Load() {
+		if err := sleepContext(task.ctx, 100*time.Millisecond); err != nil {
+			return err
+		}
+	}
+	return task.ctx.Err()
+}
+
+// sleepContext 等待指定时间，ctx 结束时立即返回
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// removePartial 删除取消的任务自己留下的文件：续传状态、HLS 临时分段，以及未完成的目标文件。
+// 目标文件只在任务已经开始写入，或续传状态表明它是之前未完成的分片下载时删除，
+// 尚未开始的任务不会删除目标位置已有的文件（如覆盖下载时原来的完整文件）
+func removePartial(task *DownloadTask) {
+	state := loadResumeState(task.FilePath)
+	if task.wroteFile.Load() || (state != nil && len(state.Parts) > 0) {
+		os.Remove(utils.GetAndroidSafeFilePath(task.FilePath))
+	}
+	if output := task.OutputPath(); output != task.FilePath && task.wroteFile.Load() {
+		os.Remove(utils.GetAndroidSafeFilePath(output))
+	}
+	removeResumeState(task.FilePath)
+	os.RemoveAll(utils.GetAndroidSafeFilePath(hlsTmpDir(task.FilePath)))
+}
+
+// hlsTmpDir M3U8 任务保存分段的临时目录，目录名固定以便重启后继续使用已下载的分段
+func hlsTmpDir(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), ".tmp_"+filepath.Base(filePath))
+}
@@ -0,0 +1,258 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// H.264 NAL 单元类型
+const (
+	nalIDR = 5
+	nalSPS = 7
+	nalPPS = 8
+	nalAUD = 9
+)
+
+// splitAnnexB 按起始码（00 00 01 或 00 00 00 01）拆分 H.264 NAL 单元
+func splitAnnexB(data []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+2 < len(data); {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			i++
+			continue
+		}
+		if start >= 0 {
+			end := i
+			for end > start && data[end-1] == 0 {
+				end-- // 去掉四字节起始码的前导0
+			}
+			if end > start {
+				nals = append(nals, data[start:end])
+			}
+		}
+		i += 3
+		start = i
+	}
+	if start >= 0 && start < len(data) {
+		nals = append(nals, data[start:])
+	}
+	return nals
+}
+
+// avcToLengthPrefixed 将一个访问单元转换为 MP4 使用的4字节长度前缀格式，
+// 参数集保存在 avcC 中，不写入样本
+func avcToLengthPrefixed(nals [][]byte) (sample []byte, keyframe bool) {
+	for _, nal := range nals {
+		switch nal[0] & 0x1F {
+		case nalSPS, nalPPS, nalAUD:
+			continue
+		case nalIDR:
+			keyframe = true
+		}
+		sample = binary.BigEndian.AppendUint32(sample, uint32(len(nal)))
+		sample = append(sample, nal...)
+	}
+	return sample, keyframe
+}
+
+// bitReader 按位读取去除防竞争字节后的 RBSP
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func newRBSPReader(nal []byte) *bitReader {
+	rbsp := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return &bitReader{data: rbsp}
+}
+
+func (br *bitReader) bit() uint {
+	if br.pos >= len(br.data)*8 {
+		br.pos++
+		return 0
+	}
+	b := br.data[br.pos/8] >> (7 - uint(br.pos%8)) & 1
+	br.pos++
+	return uint(b)
+}
+
+func (br *bitReader) bits(n int) uint {
+	var v uint
+	for i := 0; i < n; i++ {
+		v = v<<1 | br.bit()
+	}
+	return v
+}
+
+// ue 无符号指数哥伦布编码
+func (br *bitReader) ue() uint {
+	zeros := 0
+	for br.bit() == 0 && zeros < 32 {
+		zeros++
+	}
+	return 1<<zeros - 1 + br.bits(zeros)
+}
+
+// se 有符号指数哥伦布编码
+func (br *bitReader) se() int {
+	v := br.ue()
+	if v%2 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+func (br *bitReader) overrun() bool {
+	return br.pos > len(br.data)*8
+}
+
+// parseSPSSize 从 SPS 中解析视频的显示宽高
+func parseSPSSize(sps []byte) (width, height int, err error) {
+	br := newRBSPReader(sps)
+	br.bits(8) // nal header
+	profile := br.bits(8)
+	br.bits(16) // constraint flags, level_idc
+	br.ue()     // seq_parameter_set_id
+
+	chromaFormat := uint(1)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = br.ue()
+		if chromaFormat == 3 {
+			br.bit() // separate_colour_plane_flag
+		}
+		br.ue()  // bit_depth_luma_minus8
+		br.ue()  // bit_depth_chroma_minus8
+		br.bit() // qpprime_y_zero_transform_bypass_flag
+		if br.bit() == 1 {
+			count := 8
+			if chromaFormat == 3 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				if br.bit() == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for j := 0; j < size; j++ {
+					if next != 0 {
+						next = (last + br.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	br.ue() // log2_max_frame_num_minus4
+	switch br.ue() {
+	case 0:
+		br.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		br.bit()
+		br.se()
+		br.se()
+		cycle := br.ue()
+		for i := uint(0); i < cycle && !br.overrun(); i++ {
+			br.se()
+		}
+	}
+	br.ue()  // max_num_ref_frames
+	br.bit() // gaps_in_frame_num_value_allowed_flag
+	widthMbs := int(br.ue()) + 1
+	heightMapUnits := int(br.ue()) + 1
+	frameMbsOnly := int(br.bit())
+	if frameMbsOnly == 0 {
+		br.bit() // mb_adaptive_frame_field_flag
+	}
+	br.bit() // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom int
+	if br.bit() == 1 {
+		cropLeft, cropRight = int(br.ue()), int(br.ue())
+		cropTop, cropBottom = int(br.ue()), int(br.ue())
+	}
+	if br.overrun() {
+		return 0, 0, fmt.Errorf("SPS 数据不完整")
+	}
+
+	cropUnitX, cropUnitY := 1, 2-frameMbsOnly
+	switch chromaFormat {
+	case 1:
+		cropUnitX, cropUnitY = 2, 2*(2-frameMbsOnly)
+	case 2:
+		cropUnitX = 2
+	}
+
+	width = widthMbs*16 - (cropLeft+cropRight)*cropUnitX
+	height = (2-frameMbsOnly)*heightMapUnits*16 - (cropTop+cropBottom)*cropUnitY
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("无效的视频尺寸: %dx%d", width, height)
+	}
+	return width, height, nil
+}
+
+// adtsSampleRates ADTS 头中采样率索引对应的采样率
+var adtsSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// adtsHeader AAC 帧的 ADTS 头
+type adtsHeader struct {
+	Profile    int // AAC 对象类型减1
+	RateIndex  int
+	Channels   int
+	HeaderSize int
+	FrameSize  int // 包含 ADTS 头的帧长度
+}
+
+func parseADTSHeader(data []byte) (*adtsHeader, error) {
+	if len(data) < 7 || data[0] != 0xFF || data[1]&0xF6 != 0xF0 {
+		return nil, fmt.Errorf("无效的ADTS头")
+	}
+	h := &adtsHeader{
+		Profile:    int(data[2] >> 6),
+		RateIndex:  int(data[2] >> 2 & 0x0F),
+		Channels:   int(data[2]&0x01)<<2 | int(data[3]>>6),
+		HeaderSize: 7,
+		FrameSize:  int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5]>>5),
+	}
+	if data[1]&0x01 == 0 {
+		h.HeaderSize = 9 // 带 CRC
+	}
+	if h.RateIndex >= len(adtsSampleRates) || h.FrameSize < h.HeaderSize {
+		return nil, fmt.Errorf("无效的ADTS头")
+	}
+	return h, nil
+}
+
+func (h *adtsHeader) sampleRate() int {
+	return adtsSampleRates[h.RateIndex]
+}
+
+// audioSpecificConfig 生成 esds 中的 AudioSpecificConfig
+func (h *adtsHeader) audioSpecificConfig() []byte {
+	objectType := h.Profile + 1
+	return []byte{
+		byte(objectType<<3 | h.RateIndex>>1),
+		byte(h.RateIndex&1<<7 | h.Channels<<3),
+	}
+}
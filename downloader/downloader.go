@@ -1,10 +1,12 @@
 package downloader
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -25,18 +27,24 @@ type DownloadTask struct {
 	Error           error
 
 	progress   func(float64, string, int64, int64)
-	cancelFunc func()
+	ctx        context.Context // 所有请求和等待使用的 context，取消任务或下载器时结束
+	cancelFunc context.CancelFunc
+	tuner      *threadTuner // 限制同时进行的请求数，自动调节时在进度中显示线程数
+	bus        *eventBus    // 下载器的事件订阅者
 	isPaused   atomic.Bool
+	wroteFile  atomic.Bool // 已经开始写入目标文件，取消时据此判断目标文件是否由任务创建
 	wg         sync.WaitGroup
 
 	// 进度更新相关
 	progressMutex    sync.Mutex
 	lastProgressTime time.Time
 	lastDownloaded   int64
+	lastBytes        int64 // 用于计算字节速度，M3U8 任务的 lastDownloaded 为分段数
 
 	// 状态相关
 	statusMutex sync.RWMutex
 	status      string
+	outputPath  string // 实际保存的路径，与 FilePath 不同时（M3U8 保存为 TS 文件）才设置
 }
 
 // Status getter and setter methods for thread safety
@@ -52,13 +60,31 @@ func (task *DownloadTask) SetStatus(status string) {
 	task.status = status
 }
 
+// OutputPath 实际保存的文件路径。FilePath 在任务创建后不变，续传状态和临时目录都按它计算；
+// M3U8 无法封装为 MP4 时保存为同名的 .ts 文件，此时返回 .ts 文件的路径
+func (task *DownloadTask) OutputPath() string {
+	task.statusMutex.RLock()
+	defer task.statusMutex.RUnlock()
+	if task.outputPath != "" {
+		return task.outputPath
+	}
+	return task.FilePath
+}
+
+func (task *DownloadTask) setOutputPath(path string) {
+	task.statusMutex.Lock()
+	defer task.statusMutex.Unlock()
+	task.outputPath = path
+}
+
 type ProgressManager struct {
 	tasks      map[*DownloadTask]bool
 	tasksMutex sync.RWMutex
 	ticker     *time.Ticker
 	stopChan   chan struct{}
 	started    bool
-	startMutex sync.Mutex // 保护started字段
+	startMutex sync.Mutex   // 保护started字段
+	limiter    *RateLimiter // 用于在速度后标注限速
 }
 
 func NewProgressManager() *ProgressManager {
@@ -74,7 +100,13 @@ func (pm *ProgressManager) AddTask(task *DownloadTask) {
 
 	task.progressMutex.Lock()
 	task.lastProgressTime = time.Now()
-	task.lastDownloaded = 0
+	// 续传的任务从已下载的进度开始计算速度
+	if task.TotalSize < 0 {
+		task.lastDownloaded = atomic.LoadInt64(&task.DownloadedParts)
+	} else {
+		task.lastDownloaded = atomic.LoadInt64(&task.Downloaded)
+	}
+	task.lastBytes = atomic.LoadInt64(&task.Downloaded)
 	task.progressMutex.Unlock()
 
 	pm.tasks[task] = true
@@ -149,10 +181,6 @@ func (pm *ProgressManager) updateAllTasks() {
 
 	now := time.Now()
 	for _, task := range taskList {
-		if task.progress == nil {
-			continue
-		}
-
 		status := task.Status()
 		if status != "downloading" && status != "merging" {
 			continue
@@ -166,67 +194,88 @@ func (pm *ProgressManager) updateSingleTask(task *DownloadTask, now time.Time, s
 	task.progressMutex.Lock()
 	defer task.progressMutex.Unlock()
 
-	if task.TotalSize < 0 {
-		// M3U8下载的进度计算（TotalSize为负数存储总段数）
-		totalParts := -task.TotalSize
-
-		if status == "merging" {
-			// 合并阶段显示90%进度
-			if task.progress != nil {
-				task.progress(90, "合并中", atomic.LoadInt64(&task.Downloaded), -1)
-			}
-			return
+	if task.TotalSize < 0 && status == "merging" {
+		// 合并阶段显示90%进度
+		if task.progress != nil {
+			task.progress(90, "合并中", atomic.LoadInt64(&task.Downloaded), -1)
 		}
+		return
+	}
+	if task.TotalSize == 0 || status != "downloading" {
+		return
+	}
 
-		// 下载阶段的进度计算
-		completed := atomic.LoadInt64(&task.DownloadedParts)
-
-		if task.lastProgressTime.IsZero() {
-			task.lastProgressTime = now
+	downloaded := atomic.LoadInt64(&task.Downloaded)
+	completed := atomic.LoadInt64(&task.DownloadedParts)
+	if task.lastProgressTime.IsZero() {
+		task.lastProgressTime = now
+		task.lastBytes = downloaded
+		task.lastDownloaded = downloaded
+		if task.TotalSize < 0 {
 			task.lastDownloaded = completed
-			return
 		}
+		return
+	}
 
-		timeDiff := now.Sub(task.lastProgressTime).Seconds()
-		if timeDiff > 0 {
+	timeDiff := now.Sub(task.lastProgressTime).Seconds()
+	if timeDiff <= 0 {
+		return
+	}
+	ev := Event{
+		Type:        EventProgress,
+		Time:        now,
+		Bytes:       downloaded,
+		Total:       task.TotalSize,
+		BytesPerSec: float64(downloaded-task.lastBytes) / timeDiff,
+		RateLimit:   pm.limiter.Limit(),
+	}
+	if task.tuner != nil && task.tuner.adaptive {
+		ev.Threads = task.tuner.Limit()
+	}
+
+	if task.TotalSize < 0 {
+		// M3U8下载的进度计算（TotalSize为负数存储总段数）
+		totalParts := -task.TotalSize
+		ev.Total = -1
+		ev.SegmentsDone = int(completed)
+		ev.SegmentsTotal = int(totalParts)
+		if task.progress != nil {
 			speed := float64(completed-task.lastDownloaded) / timeDiff
 			percent := float64(completed) / float64(totalParts) * 90 // 最多到90%，留10%给合并
-			if task.progress != nil {
-				task.progress(percent, fmt.Sprintf("%.2f ts/s (%d/%d)", speed, completed, totalParts), atomic.LoadInt64(&task.Downloaded), -1)
-			}
-			task.lastProgressTime = now
-			task.lastDownloaded = completed
+			task.progress(percent, pm.limiter.withLimit(task.withThreads(fmt.Sprintf("%.2f ts/s (%d/%d)", speed, completed, totalParts))), downloaded, -1)
 		}
-	} else if task.TotalSize > 0 {
+		task.lastDownloaded = completed
+	} else {
 		// 普通文件下载的进度计算
-		downloaded := atomic.LoadInt64(&task.Downloaded)
-		if task.lastProgressTime.IsZero() {
-			task.lastProgressTime = now
-			task.lastDownloaded = downloaded
-			return
-		}
-
-		timeDiff := now.Sub(task.lastProgressTime).Seconds()
-		if timeDiff > 0 {
-			bytes := downloaded - task.lastDownloaded
-			speed := float64(bytes) / timeDiff / 1024 / 1024
+		if task.progress != nil {
+			speed := ev.BytesPerSec / 1024 / 1024
 			progress := float64(downloaded) / float64(task.TotalSize) * 100
-			if task.progress != nil {
-				task.progress(progress, fmt.Sprintf("%.2f MB/s", speed), downloaded, task.TotalSize)
-			}
-			task.lastProgressTime = now
-			task.lastDownloaded = downloaded
+			task.progress(progress, pm.limiter.withLimit(task.withThreads(fmt.Sprintf("%.2f MB/s", speed))), downloaded, task.TotalSize)
 		}
+		task.lastDownloaded = downloaded
 	}
+	task.lastProgressTime = now
+	task.lastBytes = downloaded
+	task.emit(ev)
 }
 
 type Downloader struct {
 	concurrentFiles int
 	perFileThreads  int
 	tasks           []*DownloadTask
+	started         int           // 已经开始的任务数，之后加入的任务由下一次 Start 开始
+	slots           chan struct{} // 同时下载的文件数限制
 	mu              sync.Mutex
 	client          *http.Client
 	progressManager *ProgressManager
+	variantPolicy   string       // 主播放列表的清晰度选择策略
+	limiter         *RateLimiter // 限速器，默认为 GlobalLimiter
+	adaptive        bool         // 是否自动调节每个文件的线程数，perFileThreads 为上限
+	adaptiveThreads atomic.Int32 // 自动调节得到的线程数，后续文件从该值开始
+	cancelPolicy    string       // 取消任务时对已下载部分的处理
+	bus             eventBus     // 见 Subscribe
+	ctx             context.Context
+	cancel          context.CancelFunc
 }
 
 func NewDownloader(concurrentFiles, perFileThreads int) *Downloader {
@@ -243,10 +292,20 @@ func NewDownloader(concurrentFiles, perFileThreads int) *Downloader {
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
+	progressManager := NewProgressManager()
+	progressManager.limiter = globalLimiter
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Downloader{
+		cancelPolicy:    CancelKeepPartial,
+		ctx:             ctx,
+		cancel:          cancel,
 		concurrentFiles: concurrentFiles,
+		slots:           make(chan struct{}, concurrentFiles),
 		perFileThreads:  perFileThreads,
-		progressManager: NewProgressManager(),
+		progressManager: progressManager,
+		variantPolicy:   VariantHighest,
+		limiter:         globalLimiter,
 		client: &http.Client{
 			Timeout:   0,
 			Transport: transport,
@@ -254,39 +313,110 @@ func NewDownloader(concurrentFiles, perFileThreads int) *Downloader {
 	}
 }
 
+// SetVariantPolicy 设置遇到主播放列表时的清晰度选择策略，见 ValidateVariantPolicy
+func (d *Downloader) SetVariantPolicy(policy string) {
+	d.variantPolicy = policy
+}
+
+// SetAdaptive 设置是否根据速度和错误率自动调节每个文件的线程数（分片或 M3U8 分段的并发数），
+// 开启后线程数从较小的值开始，不超过创建下载器时指定的线程数
+func (d *Downloader) SetAdaptive(enabled bool) {
+	d.adaptive = enabled
+	start := adaptiveStartThreads
+	if start > d.perFileThreads {
+		start = d.perFileThreads
+	}
+	d.adaptiveThreads.Store(int32(start))
+}
+
+// newTuner 为一个文件创建线程限制
+func (d *Downloader) newTuner() *threadTuner {
+	if !d.adaptive {
+		return newThreadTuner(d.perFileThreads, d.perFileThreads, false)
+	}
+	t := newThreadTuner(int(d.adaptiveThreads.Load()), d.perFileThreads, true)
+	t.onChange = func(limit int) { d.adaptiveThreads.Store(int32(limit)) }
+	return t
+}
+
+// SetRateLimiter 使用单独的限速器代替全局限速器，nil 表示不限速
+func (d *Downloader) SetRateLimiter(limiter *RateLimiter) {
+	d.limiter = limiter
+	d.progressManager.limiter = limiter
+}
+
 func (d *Downloader) AddTask(url, filePath string, progressFunc func(float64, string, int64, int64)) *DownloadTask {
 	task := &DownloadTask{
 		URL:      url,
 		FilePath: filePath,
 		progress: progressFunc,
+		bus:      &d.bus,
 	}
+	task.ctx, task.cancelFunc = context.WithCancel(d.ctx)
 	task.SetStatus("pending")
 	d.mu.Lock()
 	d.tasks = append(d.tasks, task)
 	d.mu.Unlock()
+	task.emit(Event{Type: EventQueued})
 	return task
 }
 
+// Start 开始下载上次调用之后加入的任务，可以多次调用，同时下载的文件数不超过 concurrentFiles
 func (d *Downloader) Start() {
-	semaphore := make(chan struct{}, d.concurrentFiles)
+	d.mu.Lock()
+	pending := d.tasks[d.started:]
+	d.started = len(d.tasks)
+	d.mu.Unlock()
 
-	for _, task := range d.tasks {
+	for _, task := range pending {
 		task.wg.Add(1)
 		go func(t *DownloadTask) {
 			defer t.wg.Done()
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
-			if err := d.downloadFile(t); err != nil {
+			// 排队中的任务被取消时不再等待
+			select {
+			case d.slots <- struct{}{}:
+			case <-t.ctx.Done():
+				d.finishCancelled(t)
+				return
+			}
+			defer func() { <-d.slots }()
+			t.emit(Event{Type: EventStarted})
+			err := d.downloadFile(t)
+			if err != nil && t.ctx.Err() != nil {
+				d.finishCancelled(t)
+				return
+			}
+			if err != nil {
 				t.Error = err
-				t.SetStatus("error")
+				status, label := "error", "错误"
+				if IsCorrupt(err) {
+					status, label = "corrupt", "文件损坏"
+				}
+				t.SetStatus(status)
 				if t.progress != nil {
-					t.progress(0, fmt.Sprintf("错误： %v", err), -1, -1)
+					t.progress(0, fmt.Sprintf("%s： %v", label, err), -1, -1)
 				}
+				t.emit(Event{Type: EventFailed, Err: err})
+				return
 			}
+			t.emit(t.completedEvent())
 		}(task)
 	}
 }
 
+// finishCancelled 任务取消后按策略处理已下载的部分
+func (d *Downloader) finishCancelled(task *DownloadTask) {
+	task.Error = ErrCancelled
+	task.SetStatus("cancelled")
+	if d.cancelPolicy == CancelRemovePartial {
+		removePartial(task)
+	}
+	if task.progress != nil {
+		task.progress(0, ErrCancelled.Error(), -1, -1)
+	}
+	task.emit(Event{Type: EventCancelled, Err: ErrCancelled})
+}
+
 func (d *Downloader) downloadRegularFile(task *DownloadTask) error {
 	task.SetStatus("preparing")
 	task.StartTime = time.Now()
@@ -297,7 +427,7 @@ func (d *Downloader) downloadRegularFile(task *DownloadTask) error {
 	}
 
 	// HEAD 请求判断是否支持 Range
-	req, err := http.NewRequest("HEAD", task.URL, nil)
+	req, err := http.NewRequestWithContext(task.ctx, "HEAD", task.URL, nil)
 	if err != nil {
 		return err
 	}
@@ -319,23 +449,42 @@ func (d *Downloader) downloadRegularFile(task *DownloadTask) error {
 	}
 	task.TotalSize, _ = strconv.ParseInt(sizeStr, 10, 64)
 
-	return d.downloadMultiThread(task)
+	remote := newResumeState(task.FilePath, task.URL)
+	remote.ContentLength = task.TotalSize
+	remote.ETag = resp.Header.Get("ETag")
+	remote.LastModified = resp.Header.Get("Last-Modified")
+
+	return d.downloadMultiThread(task, remote)
 }
 
-func (d *Downloader) downloadMultiThread(task *DownloadTask) error {
-	file, err := utils.CreateFile(task.FilePath)
+func (d *Downloader) downloadMultiThread(task *DownloadTask, remote *resumeState) error {
+	// 状态文件与远程文件一致时沿用已下载的分片，否则重新分片
+	state := loadResumeState(task.FilePath)
+	if state == nil || !state.matchesRemote(remote) || !utils.IsFileExists(task.FilePath) {
+		state = remote
+		state.Parts = splitParts(task.TotalSize, d.partCount(task.TotalSize))
+	} else {
+		// 地址中的签名参数可能已变化，使用本次获取的地址
+		state.URL = task.URL
+	}
+
+	task.wroteFile.Store(true)
+	file, err := utils.OpenFile(task.FilePath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	// 预分配文件大小
+	// 预分配文件大小（保留已下载的内容）
 	if err := file.Truncate(task.TotalSize); err != nil {
 		return err
 	}
 
-	partSize := task.TotalSize / int64(d.perFileThreads)
-	var wg sync.WaitGroup
+	atomic.StoreInt64(&task.Downloaded, state.completedBytes())
+	stopSaving := state.autoSave()
+
+	tuner := d.newTuner()
+	task.tuner = tuner
 
 	task.SetStatus("downloading")
 
@@ -343,97 +492,255 @@ func (d *Downloader) downloadMultiThread(task *DownloadTask) error {
 	d.progressManager.AddTask(task)
 	defer d.progressManager.RemoveTask(task)
 
-	for i := 0; i < d.perFileThreads; i++ {
-		wg.Add(1)
-		start := int64(i) * partSize
-		end := start + partSize - 1
-		if i == d.perFileThreads-1 {
-			end = task.TotalSize - 1
+	// 下载未完成的分片后校验，校验失败时重新下载损坏的分片
+	for round := 0; ; round++ {
+		fetchErr := d.fetchParts(task, file, state, tuner)
+		err = verifyParts(task, file, state)
+		if err != nil && fetchErr != nil {
+			// 分片请求失败时报告请求的错误
+			err = fetchErr
+		}
+		if ctxErr := task.ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		if err == nil || round == verifyRounds || sleepContext(task.ctx, time.Second) != nil {
+			break
+		}
+		task.SetStatus("downloading")
+	}
+	stopSaving()
+
+	if err == nil {
+		removeResumeState(task.FilePath)
+		task.SetStatus("completed")
+		if task.progress != nil {
+			task.progress(100, "Completed", atomic.LoadInt64(&task.TotalSize), atomic.LoadInt64(&task.TotalSize))
 		}
+	}
+	return err
+}
 
-		go func(start, end int64) {
-			defer wg.Done()
-			req, err := http.NewRequest("GET", task.URL, nil)
-			if err != nil {
-				task.Error = err
-				return
-			}
-			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+// fetchParts 下载所有未完成的分片，返回最后一个失败分片的错误
+func (d *Downloader) fetchParts(task *DownloadTask, file *os.File, state *resumeState, tuner *threadTuner) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var lastErr error
 
-			resp, err := d.client.Do(req)
-			if err != nil {
-				task.Error = err
-				return
+	for _, part := range state.Parts {
+		if task.ctx.Err() != nil {
+			break
+		}
+		if state.partOffset(part) > part.End {
+			continue // 该分片已下载完成
+		}
+
+		wg.Add(1)
+		tuner.acquire()
+		go func(part *byteRange) {
+			defer wg.Done()
+			defer tuner.release()
+			// 自动调节时会尝试较多的连接，分片失败（如被限流）后从已下载的位置重试
+			attempts := 1
+			if d.adaptive {
+				attempts = partAttempts
 			}
-			defer resp.Body.Close()
-
-			buf := make([]byte, 32*1024)
-			offset := start
-			for {
-				if task.isPaused.Load() {
-					time.Sleep(100 * time.Millisecond)
-					continue
-				}
-				n, err := resp.Body.Read(buf)
-				if n > 0 {
-					_, err = file.WriteAt(buf[:n], offset)
-					if err != nil {
-						task.Error = err
-						return
-					}
-					offset += int64(n)
-					atomic.AddInt64(&task.Downloaded, int64(n))
-				}
-				if err == io.EOF {
+			for attempt := 0; attempt < attempts; attempt++ {
+				if attempt > 0 && sleepContext(task.ctx, time.Second) != nil {
 					break
 				}
-				if err != nil {
-					task.Error = err
+				err := d.downloadPart(task, file, state, part, tuner)
+				tuner.done(err)
+				if err == nil {
 					return
 				}
+				if attempt == attempts-1 {
+					mu.Lock()
+					lastErr = err
+					mu.Unlock()
+				}
 			}
-		}(start, end)
+		}(part)
 	}
 
 	wg.Wait()
+	return lastErr
+}
 
-	if task.Error == nil {
-		task.SetStatus("completed")
-		if task.progress != nil {
-			task.progress(100, "Completed", atomic.LoadInt64(&task.TotalSize), atomic.LoadInt64(&task.TotalSize))
+// verifyParts 检查所有分片是否下载完整、文件大小是否与 Content-Length 一致，
+// MP4 文件还会检查 box 结构，结构损坏时将出错位置所在的分片标记为未下载
+func verifyParts(task *DownloadTask, file *os.File, state *resumeState) error {
+	task.setPhase("verifying", EventVerifying)
+	incomplete := 0
+	for _, part := range state.Parts {
+		if state.partOffset(part) <= part.End {
+			incomplete++
+		}
+	}
+	if incomplete > 0 {
+		return corruptf("%d 个分片不完整", incomplete)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != task.TotalSize {
+		return corruptf("文件大小 %d 与 Content-Length %d 不一致", info.Size(), task.TotalSize)
+	}
+
+	if looksLikeMP4(file) {
+		if offset, err := verifyMP4(file, task.TotalSize); err != nil {
+			if offset >= task.TotalSize {
+				offset = task.TotalSize - 1 // 缺少 box 时重新下载最后一个分片
+			}
+			if part := state.resetPartAt(offset); part != nil {
+				atomic.AddInt64(&task.Downloaded, -(part.End - part.Start + 1))
+			}
+			return err
 		}
 	}
-	return task.Error
+	return nil
 }
 
-func (d *Downloader) downloadSingleThread(task *DownloadTask) error {
-	resp, err := d.client.Get(task.URL)
+// downloadPart 从分片已下载的位置继续下载该分片
+func (d *Downloader) downloadPart(task *DownloadTask, file *os.File, state *resumeState, part *byteRange, tuner *threadTuner) error {
+	start := state.partOffset(part)
+	req, err := http.NewRequestWithContext(task.ctx, "GET", task.URL, nil)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, part.End))
 
-	file, err := utils.CreateFile(task.FilePath)
+	resp, err := d.client.Do(req)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		// 错误页面等内容不能写入文件
+		return newStatusError("分片下载失败", resp)
+	}
+
+	buf := make([]byte, 32*1024)
+	offset := start
+	for {
+		if err := task.waitWhilePaused(); err != nil {
+			return err
+		}
+		n, err := resp.Body.Read(buf)
+		if waitErr := d.limiter.WaitNContext(task.ctx, n); waitErr != nil {
+			return waitErr
+		}
+		tuner.record(n)
+		if n > 0 {
+			if _, err := file.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+			state.advance(part, int64(n))
+			atomic.AddInt64(&task.Downloaded, int64(n))
+		}
+		if err == io.EOF {
+			if offset <= part.End {
+				// 服务器提前结束了响应
+				return corruptf("分片数据不完整，收到 %d 字节，应为 %d 字节", offset-start, part.End-start+1)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// partCount 普通文件的分片数。自动调节时按分片大小切分（不少于线程上限），
+// 以便线程数变化后仍有足够的分片分配给新的线程
+func (d *Downloader) partCount(size int64) int {
+	if !d.adaptive {
+		return d.perFileThreads
+	}
+	count := int(size / adaptivePartSize)
+	if count < d.perFileThreads {
+		count = d.perFileThreads
+	}
+	if count > d.perFileThreads*4 {
+		count = d.perFileThreads * 4
+	}
+	return count
+}
+
+func (d *Downloader) downloadSingleThread(task *DownloadTask) error {
+	// 服务器不支持 Range 时无法续传，丢弃旧的状态重新下载
+	removeResumeState(task.FilePath)
 
-	task.SetStatus("downloading")
 	task.StartTime = time.Now()
+	task.SetStatus("downloading")
 
 	// 将任务添加到进度管理器
 	d.progressManager.AddTask(task)
 	defer d.progressManager.RemoveTask(task)
 
+	// 无法只重新下载损坏的部分，校验失败时重新下载整个文件
+	var err error
+	for round := 0; ; round++ {
+		if err = d.fetchWhole(task); err == nil {
+			task.setPhase("verifying", EventVerifying)
+			if looksLikeMP4File(task.FilePath) {
+				err = verifyMP4File(task.FilePath)
+			}
+		}
+		if ctxErr := task.ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		if err == nil || round == verifyRounds || sleepContext(task.ctx, time.Second) != nil {
+			break
+		}
+		task.SetStatus("downloading")
+	}
+	if err != nil {
+		return err
+	}
+
+	task.SetStatus("completed")
+	if task.progress != nil {
+		task.progress(100, "Completed", task.Downloaded, task.TotalSize)
+	}
+	return nil
+}
+
+// fetchWhole 下载整个文件，响应带有 Content-Length 时检查收到的字节数
+func (d *Downloader) fetchWhole(task *DownloadTask) error {
+	req, err := http.NewRequestWithContext(task.ctx, "GET", task.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("下载失败", resp)
+	}
+
+	task.wroteFile.Store(true)
+	file, err := utils.CreateFile(task.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	atomic.StoreInt64(&task.Downloaded, 0)
 	buf := make([]byte, 32*1024)
 
 	for {
-		if task.isPaused.Load() {
-			time.Sleep(100 * time.Millisecond)
-			continue
+		if err := task.waitWhilePaused(); err != nil {
+			return err
 		}
 		n, err := resp.Body.Read(buf)
+		if waitErr := d.limiter.WaitNContext(task.ctx, n); waitErr != nil {
+			return waitErr
+		}
 		if n > 0 {
 			_, err := file.Write(buf[:n])
 			if err != nil {
@@ -449,32 +756,60 @@ func (d *Downloader) downloadSingleThread(task *DownloadTask) error {
 		}
 	}
 
-	task.SetStatus("completed")
-	if task.progress != nil {
-		task.progress(100, "Completed", task.Downloaded, task.TotalSize)
+	if downloaded := atomic.LoadInt64(&task.Downloaded); resp.ContentLength >= 0 && downloaded != resp.ContentLength {
+		return corruptf("收到 %d 字节，与 Content-Length %d 不一致", downloaded, resp.ContentLength)
 	}
 	return nil
 }
 
+// withThreads 自动调节线程数时在速度后标注当前的线程数
+func (task *DownloadTask) withThreads(speed string) string {
+	if task.tuner == nil || !task.tuner.adaptive {
+		return speed
+	}
+	return fmt.Sprintf("%s [%d线程]", speed, task.tuner.Limit())
+}
+
 func (task *DownloadTask) Pause() {
-	task.isPaused.Store(true)
+	if !task.isPaused.Swap(true) {
+		task.emit(Event{Type: EventPaused})
+	}
 }
 
 func (task *DownloadTask) Resume() {
-	task.isPaused.Store(false)
+	if task.isPaused.Swap(false) {
+		task.emit(Event{Type: EventResumed})
+	}
 }
 
+// Cancel 取消任务，正在进行的请求会立即中断，已下载的部分按下载器的取消策略处理。
+// 已结束的任务不受影响
 func (task *DownloadTask) Cancel() {
+	switch task.Status() {
+	case "completed", "error", "corrupt":
+		return
+	}
 	if task.cancelFunc != nil {
 		task.cancelFunc()
 	}
-	task.SetStatus("cancelled")
 }
 
 func (task *DownloadTask) Wait() {
 	task.wg.Wait()
 }
 
+// Err 返回任务结束时的错误，下载完成时返回 nil
+func (task *DownloadTask) Err() error {
+	status := task.Status()
+	if status == "completed" {
+		return nil
+	}
+	if task.Error != nil {
+		return task.Error
+	}
+	return fmt.Errorf("下载未完成: %s", status)
+}
+
 func (d *Downloader) downloadFile(task *DownloadTask) error {
 	if strings.Contains(strings.ToLower(task.URL), ".m3u8") {
 		return d.downloadM3U8(task)
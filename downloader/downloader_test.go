@@ -0,0 +1,657 @@
+package downloader_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/downloader"
+	"github.com/itsHenry35/tal_downloader/faketal"
+)
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+// download 下载单个文件并等待完成
+func download(t *testing.T, url, filePath string, threads int) *downloader.DownloadTask {
+	t.Helper()
+	d := downloader.NewDownloader(1, threads)
+	task := d.AddTask(url, filePath, nil)
+	d.Start()
+	task.Wait()
+	return task
+}
+
+// mp4Boxes 返回 MP4 文件顶层 box 的类型
+func mp4Boxes(t *testing.T, data []byte) []string {
+	t.Helper()
+	var boxes []string
+	for off := 0; off+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[off:]))
+		boxType := string(data[off+4 : off+8])
+		if size == 1 && off+16 <= len(data) {
+			size = int(binary.BigEndian.Uint64(data[off+8:]))
+		}
+		if size < 8 || off+size > len(data) {
+			t.Fatalf("invalid box %q at %d (size %d)", boxType, off, size)
+		}
+		boxes = append(boxes, boxType)
+		off += size
+	}
+	return boxes
+}
+
+func assertMP4(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxes := mp4Boxes(t, data)
+	has := make(map[string]bool)
+	for _, b := range boxes {
+		has[b] = true
+	}
+	if len(boxes) == 0 || boxes[0] != "ftyp" || !has["moov"] || !has["mdat"] {
+		t.Errorf("unexpected MP4 structure: %v", boxes)
+	}
+}
+
+func assertNoLeftovers(t *testing.T, dir string) {
+	t.Helper()
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.Name() != "video.mp4" {
+			t.Errorf("unexpected file left in output directory: %s", e.Name())
+		}
+	}
+}
+
+func TestDownloadMultiThread(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	data := randomData(3<<20 + 12345)
+	url := srv.AddFile("lecture/video.mp4", data)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "video.mp4")
+
+	task := download(t, url, path, 4)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from source")
+	}
+	// 1次 HEAD 加上4个分片
+	if n := srv.Requests("/media/lecture/video.mp4"); n != 5 {
+		t.Errorf("video requested %d times, want 5", n)
+	}
+	assertNoLeftovers(t, dir)
+}
+
+func TestDownloadWithoutRange(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	data := randomData(256 << 10)
+	url := srv.AddFileWithoutRange("video.mp4", data)
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	task := download(t, url, path, 4)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from source")
+	}
+}
+
+func TestDownloadResumesAfterTruncation(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	data := randomData(2 << 20)
+	url := srv.AddFile("video.mp4", data)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "video.mp4")
+
+	// 分片的响应总是在中途断开，重新获取也无法完成
+	srv.InjectFault(&faketal.Fault{Path: "/media/video.mp4", Method: "GET", Truncate: 100 << 10})
+	task := download(t, url, path, 2)
+	if task.Err() == nil {
+		t.Fatal("expected the truncated download to fail")
+	}
+	if status := task.Status(); status != "error" {
+		t.Errorf("status = %s, want error", status)
+	}
+	if !downloader.HasResumeState(path) {
+		t.Fatal("resume state should be kept after a failed download")
+	}
+
+	srv.ClearFaults()
+	task = download(t, url, path, 2)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Error("resumed file differs from source")
+	}
+	if downloader.HasResumeState(path) {
+		t.Error("resume state should be removed after completion")
+	}
+}
+
+func TestDownloadRefetchesTruncatedPart(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	data := randomData(2 << 20)
+	url := srv.AddFile("video.mp4", data)
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	// 只有一个分片的响应断开，校验后重新获取该分片
+	srv.InjectFault(&faketal.Fault{Path: "/media/video.mp4", Method: "GET", Times: 1, Truncate: 100 << 10})
+	task := download(t, url, path, 2)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from source")
+	}
+	// HEAD、两个分片和一次重新获取
+	if n := srv.Requests("/media/video.mp4"); n != 4 {
+		t.Errorf("video requested %d times, want 4", n)
+	}
+}
+
+func TestDownloadRangeServerError(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	data := randomData(1 << 20)
+	url := srv.AddFile("video.mp4", data)
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	// HEAD 成功，分片请求返回 500，错误页面不能被当作视频内容写入
+	srv.InjectFault(&faketal.Fault{Path: "/media/video.mp4", Method: "GET", Times: 1, Status: 500})
+	task := download(t, url, path, 2)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from source")
+	}
+
+	// 一直失败时报告请求的错误而不是校验失败
+	srv.InjectFault(&faketal.Fault{Path: "/media/video.mp4", Method: "GET", Status: 500})
+	task = download(t, url, filepath.Join(t.TempDir(), "video.mp4"), 2)
+	if err := task.Err(); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("err = %v, want the server error", err)
+	}
+	if status := task.Status(); status != "error" {
+		t.Errorf("status = %s, want error", status)
+	}
+}
+
+// mp4File 生成结构完整的 MP4 文件：ftyp、带子 box 的 moov 和随机内容的 mdat
+func mp4File(payload int) []byte {
+	box := func(boxType string, content []byte) []byte {
+		b := make([]byte, 8, 8+len(content))
+		binary.BigEndian.PutUint32(b, uint32(8+len(content)))
+		copy(b[4:], boxType)
+		return append(b, content...)
+	}
+	var data []byte
+	data = append(data, box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))...)
+	data = append(data, box("moov", box("mvhd", make([]byte, 100)))...)
+	return append(data, box("mdat", randomData(payload))...)
+}
+
+func TestDownloadRefetchesCorruptMP4(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	data := mp4File(1 << 20)
+	url := srv.AddFile("video.mp4", data)
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	// 响应中 moov 的头部被破坏，状态码和长度正常
+	srv.InjectFault(&faketal.Fault{Path: "/media/video.mp4", Method: "GET", Times: 1, Corrupt: true, CorruptOffset: 24})
+	task := download(t, url, path, 1)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from source")
+	}
+	if n := srv.Requests("/media/video.mp4"); n != 3 {
+		t.Errorf("video requested %d times, want 3", n)
+	}
+
+	// 一直损坏时任务状态为 corrupt
+	srv.InjectFault(&faketal.Fault{Path: "/media/video.mp4", Method: "GET", Corrupt: true, CorruptOffset: 24})
+	task = download(t, url, filepath.Join(t.TempDir(), "video.mp4"), 1)
+	if !downloader.IsCorrupt(task.Err()) {
+		t.Errorf("err = %v, want a corrupt error", task.Err())
+	}
+	if status := task.Status(); status != "corrupt" {
+		t.Errorf("status = %s, want corrupt", status)
+	}
+}
+
+func hlsSegments(count, frames int) [][]byte {
+	segments := make([][]byte, count)
+	for i := range segments {
+		segments[i] = faketal.TSSegment(i, frames)
+	}
+	return segments
+}
+
+func TestDownloadM3U8MergesToMP4(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	url := srv.AddHLS("lecture", hlsSegments(5, 50), faketal.HLSOptions{})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "video.mp4")
+
+	task := download(t, url, path, 3)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if task.FilePath != path {
+		t.Errorf("FilePath = %s, want %s", task.FilePath, path)
+	}
+	assertMP4(t, path)
+	assertNoLeftovers(t, dir)
+}
+
+func TestDownloadM3U8Encrypted(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	url := srv.AddHLS("lecture", hlsSegments(3, 25), faketal.HLSOptions{Encrypt: true})
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	task := download(t, url, path, 2)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	assertMP4(t, path)
+	if n := srv.Requests("/media/lecture/key.bin"); n != 1 {
+		t.Errorf("key requested %d times, want 1", n)
+	}
+}
+
+func TestDownloadM3U8RetriesSegment(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	url := srv.AddHLS("lecture", hlsSegments(3, 25), faketal.HLSOptions{})
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	srv.InjectFault(&faketal.Fault{Path: "/media/lecture/seg001.ts", Times: 1, Status: 500})
+	task := download(t, url, path, 2)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	assertMP4(t, path)
+	if n := srv.Requests("/media/lecture/seg001.ts"); n != 2 {
+		t.Errorf("segment requested %d times, want 2", n)
+	}
+}
+
+func TestDownloadM3U8SelectsVariant(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	low := srv.AddHLS("low", hlsSegments(2, 25), faketal.HLSOptions{})
+	high := srv.AddHLS("high", hlsSegments(2, 25), faketal.HLSOptions{})
+	master := srv.AddMasterPlaylist("master.m3u8",
+		faketal.Variant{Bandwidth: 400000, Width: 640, Height: 360, URL: low},
+		faketal.Variant{Bandwidth: 2000000, Width: 1280, Height: 720, URL: high},
+	)
+
+	for _, tt := range []struct {
+		policy string
+		want   string
+	}{
+		{downloader.VariantHighest, "/media/high/"},
+		{downloader.VariantLowest, "/media/low/"},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			before := srv.Requests(tt.want)
+			d := downloader.NewDownloader(1, 2)
+			d.SetVariantPolicy(tt.policy)
+			task := d.AddTask(master, filepath.Join(t.TempDir(), "video.mp4"), nil)
+			d.Start()
+			task.Wait()
+			if err := task.Err(); err != nil {
+				t.Fatal(err)
+			}
+			// 播放列表加上两个分段
+			if n := srv.Requests(tt.want) - before; n != 3 {
+				t.Errorf("%s requested %d times, want 3", tt.want, n)
+			}
+		})
+	}
+}
+
+func TestDownloadRateLimit(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	data := randomData(1 << 20)
+	url := srv.AddFile("video.mp4", data)
+
+	var speeds []string
+	d := downloader.NewDownloader(1, 4)
+	d.SetRateLimiter(downloader.NewRateLimiter(512 << 10))
+	task := d.AddTask(url, filepath.Join(t.TempDir(), "video.mp4"), func(_ float64, speed string, _, _ int64) {
+		speeds = append(speeds, speed)
+	})
+	start := time.Now()
+	d.Start()
+	task.Wait()
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 初始令牌为一秒的流量，剩余的 512KB 需要约1秒
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("download finished in %v, expected the rate limit to slow it down", elapsed)
+	}
+	limited := false
+	for _, s := range speeds {
+		limited = limited || strings.Contains(s, "限速 512KB/s")
+	}
+	if !limited {
+		t.Errorf("progress should report the limit, got %v", speeds)
+	}
+}
+
+func TestDownloadAdaptiveBacksOffWhenThrottled(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	data := randomData(2 << 20)
+	url := srv.AddFile("video.mp4", data)
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	// 超过3个连接时返回 429，每个请求都较慢以便连接同时存在
+	srv.LimitConnections(3)
+	srv.InjectFault(&faketal.Fault{Path: "/media/video.mp4", Method: "GET", Delay: 20 * time.Millisecond})
+
+	var mu sync.Mutex
+	var speeds []string
+	d := downloader.NewDownloader(1, 16)
+	d.SetAdaptive(true)
+	task := d.AddTask(url, path, func(_ float64, speed string, _, _ int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		speeds = append(speeds, speed)
+	})
+	d.Start()
+	task.Wait()
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from source")
+	}
+	if srv.Throttled() == 0 {
+		t.Error("expected the server to throttle some requests")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	reported := false
+	for _, s := range speeds {
+		reported = reported || strings.Contains(s, "线程]")
+	}
+	if !reported {
+		t.Errorf("progress should report the thread count, got %v", speeds)
+	}
+}
+
+func TestDownloadM3U8RefetchesCorruptSegment(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	url := srv.AddHLS("lecture", hlsSegments(3, 25), faketal.HLSOptions{})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "video.mp4")
+
+	srv.InjectFault(&faketal.Fault{Path: "/media/lecture/seg002.ts", Times: 1, Corrupt: true, CorruptOffset: 188 * 5})
+	task := download(t, url, path, 2)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+	assertMP4(t, path)
+	assertNoLeftovers(t, dir)
+	if n := srv.Requests("/media/lecture/seg002.ts"); n != 2 {
+		t.Errorf("segment requested %d times, want 2", n)
+	}
+}
+
+func TestDownloadM3U8MissingSegment(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	url := srv.AddHLS("lecture", hlsSegments(3, 25), faketal.HLSOptions{})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "video.mp4")
+
+	// 损坏的分段不能被合并进视频
+	srv.InjectFault(&faketal.Fault{Path: "/media/lecture/seg001.ts", Corrupt: true})
+	task := download(t, url, path, 2)
+	if !downloader.IsCorrupt(task.Err()) {
+		t.Fatalf("err = %v, want a corrupt error", task.Err())
+	}
+	if status := task.Status(); status != "corrupt" {
+		t.Errorf("status = %s, want corrupt", status)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("no output file should be written when segments are missing")
+	}
+	if !downloader.HasResumeState(path) {
+		t.Error("resume state should be kept for the good segments")
+	}
+}
+
+func TestDownloadM3U8FallsBackToTS(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	// 分段只有 PAT 和 PMT，结构完整但没有可封装的视频帧
+	segments := hlsSegments(3, 25)
+	for i := range segments {
+		segments[i] = segments[i][:2*188]
+	}
+	url := srv.AddHLS("lecture", segments, faketal.HLSOptions{})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "video.mp4")
+
+	d := downloader.NewDownloader(1, 2)
+	events, unsubscribe := d.Subscribe(64)
+	defer unsubscribe()
+	task := d.AddTask(url, path, nil)
+	d.Start()
+	got := collectEvents(t, events)
+	if err := task.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 目标路径不变，实际保存的路径和事件中的路径为 TS 文件，原因通过 warning 事件报告
+	want := filepath.Join(dir, "video.ts")
+	if task.FilePath != path || task.OutputPath() != want {
+		t.Errorf("FilePath = %s, OutputPath = %s, want %s and %s", task.FilePath, task.OutputPath(), path, want)
+	}
+	var warned bool
+	for _, ev := range got {
+		switch ev.Type {
+		case downloader.EventWarning:
+			warned = ev.Err != nil && ev.Path == want
+		case downloader.EventCompleted:
+			if ev.Path != want {
+				t.Errorf("completed event path = %s, want %s", ev.Path, want)
+			}
+		}
+	}
+	if !warned {
+		t.Errorf("no warning event for the TS fallback in %v", eventTypes(got))
+	}
+	data, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 3*2*188 {
+		t.Errorf("TS file has %d bytes, want %d", len(data), 3*2*188)
+	}
+	// 只留下 TS 文件，没有 MP4、临时目录和续传状态
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("output directory has %d entries, want only video.ts", len(entries))
+	}
+}
+
+// startSlowDownload 以较低的限速开始下载，便于在下载过程中取消
+func startSlowDownload(t *testing.T, srv *faketal.Server, policy string) (*downloader.Downloader, *downloader.DownloadTask, string) {
+	t.Helper()
+	url := srv.AddFile("video.mp4", randomData(2<<20))
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	d := downloader.NewDownloader(1, 2)
+	d.SetRateLimiter(downloader.NewRateLimiter(256 << 10))
+	d.SetCancelPolicy(policy)
+	task := d.AddTask(url, path, nil)
+	d.Start()
+	for task.Status() != "downloading" {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(300 * time.Millisecond)
+	return d, task, path
+}
+
+// waitCancelled 取消后任务应很快结束
+func waitCancelled(t *testing.T, task *downloader.DownloadTask) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		task.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("task did not stop after cancel")
+	}
+	if !downloader.IsCancelled(task.Err()) {
+		t.Errorf("err = %v, want cancelled", task.Err())
+	}
+	if status := task.Status(); status != "cancelled" {
+		t.Errorf("status = %s, want cancelled", status)
+	}
+}
+
+func TestCancelKeepsPartialForResume(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	_, task, path := startSlowDownload(t, srv, downloader.CancelKeepPartial)
+	task.Cancel()
+	waitCancelled(t, task)
+	if !downloader.HasResumeState(path) {
+		t.Error("resume state should be kept")
+	}
+	if task.Context().Err() == nil {
+		t.Error("task context should be done after cancel")
+	}
+}
+
+func TestCancelRemovesPartial(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	_, task, path := startSlowDownload(t, srv, downloader.CancelRemovePartial)
+	task.Cancel()
+	waitCancelled(t, task)
+	if _, err := os.Stat(path); err == nil {
+		t.Error("partial file should be removed")
+	}
+	if downloader.HasResumeState(path) {
+		t.Error("resume state should be removed")
+	}
+}
+
+func TestCancelDownloader(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	d, running, _ := startSlowDownload(t, srv, downloader.CancelKeepPartial)
+	// 同时下载一个文件，第二个任务仍在排队
+	queued := d.AddTask(srv.AddFile("other.mp4", randomData(1<<20)), filepath.Join(t.TempDir(), "other.mp4"), nil)
+	d.Start()
+	d.Cancel()
+	waitCancelled(t, running)
+	waitCancelled(t, queued)
+}
+
+func TestCancelQueuedKeepsExistingFile(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	d, running, _ := startSlowDownload(t, srv, downloader.CancelRemovePartial)
+	// 覆盖下载时目标位置已有完整的文件，任务还没有开始就被取消
+	existing := filepath.Join(t.TempDir(), "other.mp4")
+	if err := os.WriteFile(existing, []byte("complete"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	queued := d.AddTask(srv.AddFile("other.mp4", randomData(1<<20)), existing, nil)
+	d.Start()
+	d.Cancel()
+	waitCancelled(t, running)
+	waitCancelled(t, queued)
+	if data, err := os.ReadFile(existing); err != nil || string(data) != "complete" {
+		t.Errorf("existing file should be kept, got %q, %v", data, err)
+	}
+}
+
+func TestCancelM3U8RemovesSegments(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	url := srv.AddHLS("lecture", hlsSegments(8, 25), faketal.HLSOptions{})
+	dir := t.TempDir()
+	srv.InjectFault(&faketal.Fault{Path: "/media/lecture/seg", Delay: 200 * time.Millisecond})
+
+	d := downloader.NewDownloader(1, 2)
+	d.SetCancelPolicy(downloader.CancelRemovePartial)
+	task := d.AddTask(url, filepath.Join(dir, "video.mp4"), nil)
+	d.Start()
+	time.Sleep(300 * time.Millisecond)
+	task.Cancel()
+	waitCancelled(t, task)
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("unexpected files left after cancel: %v", entries)
+	}
+}
@@ -0,0 +1,189 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+// EventType 下载事件的类型
+type EventType int
+
+const (
+	EventQueued    EventType = iota // 任务加入下载器
+	EventStarted                    // 任务开始下载（获得了同时下载的名额）
+	EventProgress                   // 下载进度，约每100毫秒一次
+	EventMerging                    // 合并 M3U8 分段
+	EventVerifying                  // 校验下载的文件或分段
+	EventCompleted                  // 下载完成
+	EventFailed                     // 下载失败（包括校验失败）
+	EventCancelled                  // 任务被取消
+	EventPaused                     // 任务暂停
+	EventResumed                    // 任务继续
+	EventWarning                    // 任务继续进行，但结果与预期不同（如 M3U8 无法封装为 MP4，保存为 TS 文件）
+)
+
+var eventTypeNames = []string{"queued", "started", "progress", "merging", "verifying", "completed", "failed", "cancelled", "paused", "resumed", "warning"}
+
+func (t EventType) String() string {
+	if int(t) < len(eventTypeNames) {
+		return eventTypeNames[t]
+	}
+	return "unknown"
+}
+
+// Event 下载过程中的一个事件，只有与事件类型相关的字段有值
+type Event struct {
+	Type EventType
+	Task *DownloadTask
+	Path string // 实际保存的文件路径，见 DownloadTask.OutputPath
+	Time time.Time
+
+	// EventProgress
+	Bytes         int64   // 已下载的字节数
+	Total         int64   // 文件总字节数，未知时（M3U8 或服务器未返回长度）为 -1
+	SegmentsDone  int     // M3U8 已完成的分段数
+	SegmentsTotal int     // M3U8 的总分段数，普通文件为 0
+	BytesPerSec   float64 // 最近一次统计的速度
+	Threads       int     // 自动调节时当前的线程数，否则为 0
+	RateLimit     int64   // 当前生效的限速（字节/秒），0 表示不限速
+
+	// EventCompleted
+	Size     int64         // 最终文件大小
+	Duration time.Duration // 从开始下载到完成的时间
+
+	// EventFailed、EventCancelled、EventWarning
+	Err error
+}
+
+// Percent 进度百分比，M3U8 按分段计算，总大小未知时返回 -1
+func (e Event) Percent() float64 {
+	switch {
+	case e.SegmentsTotal > 0:
+		return float64(e.SegmentsDone) / float64(e.SegmentsTotal) * 100
+	case e.Total > 0:
+		return float64(e.Bytes) / float64(e.Total) * 100
+	}
+	return -1
+}
+
+// Speed 进度事件的速度文本，标注分段数、线程数和限速，如 "1.50 MB/s (3/10) [4线程] (限速 2MB/s)"
+func (e Event) Speed() string {
+	speed := fmt.Sprintf("%.2f MB/s", e.BytesPerSec/1024/1024)
+	if e.SegmentsTotal > 0 {
+		speed += fmt.Sprintf(" (%d/%d)", e.SegmentsDone, e.SegmentsTotal)
+	}
+	if e.Threads > 0 {
+		speed += fmt.Sprintf(" [%d线程]", e.Threads)
+	}
+	if e.RateLimit > 0 {
+		speed += fmt.Sprintf(" (限速 %s/s)", FormatRate(e.RateLimit))
+	}
+	return speed
+}
+
+// eventBus 将事件分发给所有订阅者
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers []*subscriber
+}
+
+type subscriber struct {
+	events chan Event
+	done   chan struct{}
+	mu     sync.Mutex
+	closed bool
+}
+
+// Subscribe 订阅下载器中所有任务的事件，buffer 为通道的缓冲大小。
+// 订阅者需要持续读取通道直到取消订阅：进度事件在通道满时会被丢弃，其他事件会等待读取。
+// 调用返回的函数取消订阅并关闭通道
+func (d *Downloader) Subscribe(buffer int) (<-chan Event, func()) {
+	sub := &subscriber{
+		events: make(chan Event, buffer),
+		done:   make(chan struct{}),
+	}
+	d.bus.mu.Lock()
+	d.bus.subscribers = append(d.bus.subscribers, sub)
+	d.bus.mu.Unlock()
+
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			d.bus.mu.Lock()
+			for i, s := range d.bus.subscribers {
+				if s == sub {
+					d.bus.subscribers = append(d.bus.subscribers[:i], d.bus.subscribers[i+1:]...)
+					break
+				}
+			}
+			d.bus.mu.Unlock()
+
+			close(sub.done) // 让正在等待发送的事件放弃
+			sub.mu.Lock()
+			sub.closed = true
+			close(sub.events)
+			sub.mu.Unlock()
+		})
+	}
+}
+
+func (b *eventBus) publish(ev Event) {
+	b.mu.RLock()
+	subscribers := append([]*subscriber(nil), b.subscribers...)
+	b.mu.RUnlock()
+
+	for _, sub := range subscribers {
+		sub.send(ev)
+	}
+}
+
+func (s *subscriber) send(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if ev.Type == EventProgress {
+		select {
+		case s.events <- ev:
+		default:
+		}
+		return
+	}
+	select {
+	case s.events <- ev:
+	case <-s.done:
+	}
+}
+
+// emit 发布任务的事件
+func (task *DownloadTask) emit(ev Event) {
+	if task.bus == nil {
+		return
+	}
+	ev.Task = task
+	ev.Path = task.OutputPath()
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	task.bus.publish(ev)
+}
+
+// setPhase 更新任务状态并发布对应的事件（合并、校验）
+func (task *DownloadTask) setPhase(status string, typ EventType) {
+	task.SetStatus(status)
+	task.emit(Event{Type: typ})
+}
+
+// completedEvent 下载完成的事件，大小为最终文件的大小
+func (task *DownloadTask) completedEvent() Event {
+	ev := Event{Type: EventCompleted, Size: task.Downloaded, Duration: time.Since(task.StartTime)}
+	if info, err := os.Stat(utils.GetAndroidSafeFilePath(task.OutputPath())); err == nil {
+		ev.Size = info.Size()
+	}
+	return ev
+}
@@ -0,0 +1,156 @@
+package downloader_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/downloader"
+	"github.com/itsHenry35/tal_downloader/faketal"
+)
+
+// collectEvents 读取事件直到任务结束
+func collectEvents(t *testing.T, events <-chan downloader.Event) []downloader.Event {
+	t.Helper()
+	var got []downloader.Event
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			got = append(got, ev)
+			switch ev.Type {
+			case downloader.EventCompleted, downloader.EventFailed, downloader.EventCancelled:
+				return got
+			}
+		case <-timeout:
+			t.Fatalf("no terminal event, got %d events", len(got))
+		}
+	}
+}
+
+func eventTypes(events []downloader.Event) []downloader.EventType {
+	types := make([]downloader.EventType, 0, len(events))
+	for _, ev := range events {
+		if ev.Type != downloader.EventProgress {
+			types = append(types, ev.Type)
+		}
+	}
+	return types
+}
+
+func TestEventsRegularFile(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	data := randomData(1 << 20)
+	url := srv.AddFile("video.mp4", data)
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	d := downloader.NewDownloader(1, 2)
+	d.SetRateLimiter(downloader.NewRateLimiter(512 << 10))
+	events, unsubscribe := d.Subscribe(64)
+	defer unsubscribe()
+	task := d.AddTask(url, path, nil)
+	d.Start()
+	got := collectEvents(t, events)
+
+	want := []downloader.EventType{downloader.EventQueued, downloader.EventStarted, downloader.EventVerifying, downloader.EventCompleted}
+	if types := eventTypes(got); !equalTypes(types, want) {
+		t.Errorf("events = %v, want %v", types, want)
+	}
+	var progress int
+	for _, ev := range got {
+		if ev.Task != task {
+			t.Errorf("%v event for another task", ev.Type)
+		}
+		if ev.Type == downloader.EventProgress {
+			progress++
+			if ev.Total != int64(len(data)) || ev.Bytes > ev.Total || ev.BytesPerSec < 0 {
+				t.Errorf("unexpected progress event: %+v", ev)
+			}
+		}
+	}
+	if progress == 0 {
+		t.Error("no progress events")
+	}
+	last := got[len(got)-1]
+	if last.Path != path || last.Size != int64(len(data)) || last.Duration <= 0 {
+		t.Errorf("completed event = path %s size %d duration %v", last.Path, last.Size, last.Duration)
+	}
+}
+
+func TestEventsM3U8(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	url := srv.AddHLS("lecture", hlsSegments(4, 25), faketal.HLSOptions{})
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	d := downloader.NewDownloader(1, 2)
+	events, unsubscribe := d.Subscribe(64)
+	defer unsubscribe()
+	d.AddTask(url, path, nil)
+	d.Start()
+	got := collectEvents(t, events)
+
+	want := []downloader.EventType{downloader.EventQueued, downloader.EventStarted, downloader.EventVerifying, downloader.EventMerging, downloader.EventCompleted}
+	if types := eventTypes(got); !equalTypes(types, want) {
+		t.Errorf("events = %v, want %v", types, want)
+	}
+	for _, ev := range got {
+		if ev.Type == downloader.EventProgress && (ev.SegmentsTotal != 4 || ev.Total != -1) {
+			t.Errorf("unexpected progress event: %+v", ev)
+		}
+	}
+}
+
+func TestEventsFailedAndPause(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	url := srv.AddFile("video.mp4", randomData(64<<10))
+	srv.InjectFault(&faketal.Fault{Path: "/media/video.mp4", Status: 404})
+	path := filepath.Join(t.TempDir(), "video.mp4")
+
+	d := downloader.NewDownloader(1, 2)
+	events, unsubscribe := d.Subscribe(64)
+	defer unsubscribe()
+	task := d.AddTask(url, path, nil)
+	task.Pause()
+	task.Pause()
+	task.Resume()
+	d.Start()
+	got := collectEvents(t, events)
+
+	want := []downloader.EventType{downloader.EventQueued, downloader.EventPaused, downloader.EventResumed, downloader.EventStarted, downloader.EventFailed}
+	if types := eventTypes(got); !equalTypes(types, want) {
+		t.Errorf("events = %v, want %v", types, want)
+	}
+	if err := got[len(got)-1].Err; err == nil || err != task.Err() {
+		t.Errorf("failed event err = %v, want %v", err, task.Err())
+	}
+}
+
+func TestUnsubscribeClosesChannel(t *testing.T) {
+	d := downloader.NewDownloader(1, 1)
+	events, unsubscribe := d.Subscribe(0)
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Error("channel should be closed after unsubscribe")
+	}
+	// 没有订阅者时发布事件不应阻塞
+	d.AddTask("http://127.0.0.1:1/video.mp4", filepath.Join(t.TempDir(), "video.mp4"), nil)
+}
+
+func equalTypes(a, b []downloader.EventType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// keyCache 按地址缓存 HLS 密钥，同一播放列表中的分段共用
+type keyCache struct {
+	ctx    context.Context // 所属任务的 context
+	client *http.Client
+	mu     sync.Mutex
+	keys   map[string][]byte
+}
+
+func newKeyCache(ctx context.Context, client *http.Client) *keyCache {
+	return &keyCache{
+		ctx:    ctx,
+		client: client,
+		keys:   make(map[string][]byte),
+	}
+}
+
+// get 获取密钥，未缓存时通过下载器的客户端请求
+func (kc *keyCache) get(uri string) ([]byte, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	if key, ok := kc.keys[uri]; ok {
+		return key, nil
+	}
+
+	req, err := http.NewRequestWithContext(kc.ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := kc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取密钥失败: %s", resp.Status)
+	}
+
+	key, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 16 {
+		return nil, fmt.Errorf("无效的密钥长度: %d", len(key))
+	}
+
+	kc.keys[uri] = key
+	return key, nil
+}
+
+// segmentIV 分段的 IV，未指定时为媒体序列号的16字节大端表示
+func segmentIV(seg *hlsSegment) []byte {
+	if seg.Key.IV != nil {
+		return seg.Key.IV
+	}
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint64(iv[8:], uint64(seg.Sequence))
+	return iv
+}
+
+// decryptSegment 使用 AES-128-CBC 解密分段并去除 PKCS7 填充
+func decryptSegment(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("加密分段长度无效: %d", len(data))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(plain) {
+		return nil, fmt.Errorf("解密失败: 填充无效")
+	}
+	for _, b := range plain[len(plain)-padding:] {
+		if int(b) != padding {
+			return nil, fmt.Errorf("解密失败: 填充无效")
+		}
+	}
+	return plain[:len(plain)-padding], nil
+}
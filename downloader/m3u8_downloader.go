@@ -1,12 +1,13 @@
 package downloader
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,89 +16,183 @@ import (
 	"github.com/itsHenry35/tal_downloader/utils"
 )
 
+// segmentName 分段在临时目录中的文件名，独立音轨的分段以 audio_ 开头
+func segmentName(i int, audio bool) string {
+	if audio {
+		return fmt.Sprintf("audio_%05d.ts", i)
+	}
+	return fmt.Sprintf("%05d.ts", i)
+}
+
 func (d *Downloader) downloadM3U8(task *DownloadTask) error {
 	task.SetStatus("preparing")
 	task.StartTime = time.Now()
 
-	// 创建临时目录
-	tmpDir := filepath.Join(filepath.Dir(task.FilePath), fmt.Sprintf(".tmp_%d_%s", time.Now().UnixNano(), filepath.Base(task.FilePath)))
+	tmpDir := hlsTmpDir(task.FilePath)
+	actualTmpDir := utils.GetAndroidSafeFilePath(tmpDir)
 
-	// 使用安卓安全的目录创建
-	if err := utils.Mkdir(tmpDir); err != nil {
+	// 获取m3u8内容，主播放列表会按策略选择码率
+	video, audio, err := d.loadPlaylists(task.ctx, task.URL)
+	if err != nil {
 		return err
 	}
+	keys := newKeyCache(task.ctx, d.client)
 
-	// 获取m3u8内容
-	resp, err := d.client.Get(task.URL)
-	if err != nil {
-		return err
+	// 视频分段在前，独立音轨的分段使用单独的文件名排在其后
+	var tsList []hlsItem
+	for i, seg := range video.Segments {
+		tsList = append(tsList, hlsItem{seg: seg, name: segmentName(i, false)})
+	}
+	if audio != nil {
+		for i, seg := range audio.Segments {
+			tsList = append(tsList, hlsItem{seg: seg, name: segmentName(i, true), audio: true})
+		}
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	// 播放列表与状态文件一致时沿用已下载的分段，否则清空临时目录重新下载
+	state := loadResumeState(task.FilePath)
+	if state == nil || !state.matchesPlaylist(task.URL, len(tsList)) {
+		os.RemoveAll(actualTmpDir)
+		state = newResumeState(task.FilePath, task.URL)
+		state.SegmentCount = len(tsList)
+	} else {
+		state.URL = task.URL
+	}
+
+	// 使用安卓安全的目录创建
+	if err := utils.Mkdir(tmpDir); err != nil {
 		return err
 	}
 
-	lines := strings.Split(string(body), "\n")
-	var tsList []string
-	baseURL := task.URL[:strings.LastIndex(task.URL, "/")+1]
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" && !strings.HasPrefix(line, "#") {
-			if !strings.HasPrefix(line, "http") {
-				line = baseURL + line
-			}
-			tsList = append(tsList, line)
+	// 统计已完成且文件仍存在的分段
+	completed := state.completedSegments()
+	var resumedParts, resumedBytes int64
+	for idx := range completed {
+		if idx >= len(tsList) {
+			delete(completed, idx)
+			continue
 		}
+		info, statErr := os.Stat(filepath.Join(actualTmpDir, tsList[idx].name))
+		if statErr != nil {
+			delete(completed, idx)
+			continue
+		}
+		resumedParts++
+		resumedBytes += info.Size()
 	}
+	atomic.StoreInt64(&task.DownloadedParts, resumedParts)
+	atomic.StoreInt64(&task.Downloaded, resumedBytes)
+	stopSaving := state.autoSave()
 
-	task.TotalSize = int64(len(tsList))
 	task.SetStatus("downloading")
 
 	// 使用负数存储总段数，便于进度管理器识别M3U8任务
 	task.TotalSize = -int64(len(tsList))
 
+	tuner := d.newTuner()
+	task.tuner = tuner
+
 	// 将任务添加到进度管理器
 	d.progressManager.AddTask(task)
 
-	var wg sync.WaitGroup
-	concurrency := d.perFileThreads
-	sem := make(chan struct{}, concurrency)
-
-	for idx, tsURL := range tsList {
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(i int, url string) {
-			defer wg.Done()
-			defer func() { <-sem }()
-
-			filePath := filepath.Join(tmpDir, fmt.Sprintf("%05d.ts", i))
-			for attempt := 0; attempt < 3; attempt++ {
-				err := downloadTS(d.client, url, filePath, task)
-				if err == nil {
-					atomic.AddInt64(&task.DownloadedParts, 1)
-					return
-				}
-				time.Sleep(time.Second)
+	// 下载未完成的分段，返回最后一个失败分段的错误
+	fetch := func(indices []int) error {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var lastErr error
+		for _, idx := range indices {
+			if task.ctx.Err() != nil {
+				break
 			}
-		}(idx, tsURL)
+			wg.Add(1)
+			tuner.acquire()
+			go func(i int, item hlsItem) {
+				defer wg.Done()
+				defer tuner.release()
+
+				filePath := filepath.Join(tmpDir, item.name)
+				var err error
+				for attempt := 0; attempt < 3; attempt++ {
+					err = downloadTS(d.client, d.limiter, tuner, item.seg, keys, filePath, task)
+					tuner.done(err)
+					if err == nil {
+						state.markSegment(i)
+						atomic.AddInt64(&task.DownloadedParts, 1)
+						return
+					}
+					if sleepContext(task.ctx, time.Second) != nil {
+						break
+					}
+				}
+				mu.Lock()
+				lastErr = err
+				mu.Unlock()
+			}(idx, tsList[idx])
+		}
+		wg.Wait()
+		return lastErr
 	}
 
-	wg.Wait()
+	var pending []int
+	for idx := range tsList {
+		if !completed[idx] {
+			pending = append(pending, idx)
+		}
+	}
+
+	// 合并前确认所有分段都已下载且结构完整，缺失或损坏的分段重新下载
+	var fetchErr error
+	for round := 0; ; round++ {
+		fetchErr = fetch(pending)
+		if err := task.ctx.Err(); err != nil {
+			stopSaving()
+			d.progressManager.RemoveTask(task)
+			return err
+		}
+		task.setPhase("verifying", EventVerifying)
+		pending = verifySegments(actualTmpDir, tsList, state, task)
+		if len(pending) == 0 || round == verifyRounds {
+			break
+		}
+		task.SetStatus("downloading")
+	}
+	stopSaving()
+
+	if len(pending) > 0 {
+		d.progressManager.RemoveTask(task)
+		if fetchErr != nil {
+			return fmt.Errorf("%d 个分段下载失败: %w", len(pending), fetchErr)
+		}
+		return corruptf("%d/%d 个分段缺失或损坏", len(pending), len(tsList))
+	}
 
 	// 进入合并阶段，进度管理器会自动显示90%进度
-	task.SetStatus("merging")
+	task.setPhase("merging", EventMerging)
 
-	// 合并TS文件
-	err = mergeTSFiles(tmpDir, task.FilePath)
+	// 合并TS分段并封装为MP4，有独立音轨时按时间交错写入音视频分段
+	writeTS := func(w io.Writer) error {
+		if audio != nil {
+			return muxTSFiles(tsParts(tmpDir, tsList, false), tsParts(tmpDir, tsList, true), w)
+		}
+		return mergeTSFiles(tmpDir, tsList, w)
+	}
+	task.wroteFile.Store(true)
+	err = remuxToMP4(writeTS, tmpDir, task.FilePath)
+	if err == nil {
+		err = verifyMP4File(task.FilePath)
+	}
+	if err != nil && task.ctx.Err() == nil {
+		// 分段都已通过校验，无法封装为MP4（编码不支持或解析失败）时保存为TS文件，扩展名与内容保持一致
+		os.Remove(utils.GetAndroidSafeFilePath(task.FilePath))
+		task.setOutputPath(strings.TrimSuffix(task.FilePath, filepath.Ext(task.FilePath)) + ".ts")
+		task.emit(Event{Type: EventWarning, Err: fmt.Errorf("无法封装为MP4，保存为TS文件: %w", err)})
+		err = writeTSFile(writeTS, task.OutputPath())
+	}
 
-	// 清理临时目录（这也是合并过程的一部分）
-	if utils.IsAndroid() {
-		actualPath := utils.GetAndroidSafeFilePath(tmpDir)
-		os.RemoveAll(actualPath)
-	} else {
-		os.RemoveAll(tmpDir)
+	// 合并成功后清理临时目录和状态文件（这也是合并过程的一部分），失败时保留以便续传
+	if err == nil {
+		os.RemoveAll(actualTmpDir)
+		removeResumeState(task.FilePath)
 	}
 
 	// 合并和清理都完成后，先从进度管理器移除任务，再设置完成状态
@@ -108,9 +203,9 @@ func (d *Downloader) downloadM3U8(task *DownloadTask) error {
 		// 手动发送最终完成进度
 		if task.progress != nil {
 			// 获取最终文件大小
-			actualOutputPath := task.FilePath
+			actualOutputPath := task.OutputPath()
 			if utils.IsAndroid() {
-				actualOutputPath = utils.GetAndroidSafeFilePath(task.FilePath)
+				actualOutputPath = utils.GetAndroidSafeFilePath(actualOutputPath)
 			}
 
 			stat, statErr := os.Stat(actualOutputPath)
@@ -125,12 +220,140 @@ func (d *Downloader) downloadM3U8(task *DownloadTask) error {
 	return err
 }
 
-func downloadTS(client *http.Client, url, filePath string, task *DownloadTask) error {
-	resp, err := client.Get(url)
+// verifySegments 检查所有分段是否已下载且 TS 结构完整，返回需要重新下载的分段。
+// 损坏的分段会被删除并标记为未完成
+func verifySegments(actualTmpDir string, items []hlsItem, state *resumeState, task *DownloadTask) []int {
+	completed := state.completedSegments()
+	var pending []int
+	for idx, item := range items {
+		if !completed[idx] {
+			pending = append(pending, idx)
+			continue
+		}
+		path := filepath.Join(actualTmpDir, item.name)
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = verifyTS(data)
+		}
+		if err != nil {
+			os.Remove(path)
+			state.unmarkSegment(idx)
+			atomic.AddInt64(&task.DownloadedParts, -1)
+			atomic.AddInt64(&task.Downloaded, -int64(len(data)))
+			pending = append(pending, idx)
+		}
+	}
+	return pending
+}
+
+// hlsItem 待下载的一个分段及其在临时目录中的文件名
+type hlsItem struct {
+	seg   *hlsSegment
+	name  string
+	audio bool
+}
+
+// fetchPlaylist 获取播放列表内容
+func (d *Downloader) fetchPlaylist(ctx context.Context, playlistURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", playlistURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("获取播放列表失败: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// loadPlaylists 获取媒体播放列表。地址为主播放列表时按清晰度策略选择码率，
+// 码率使用独立音轨（#EXT-X-MEDIA）时同时返回音轨的播放列表，否则 audio 为 nil
+func (d *Downloader) loadPlaylists(ctx context.Context, playlistURL string) (video, audio *mediaPlaylist, err error) {
+	body, err := d.fetchPlaylist(ctx, playlistURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isMasterPlaylist(body) {
+		video, err = parseMediaPlaylist(body, playlistURL)
+		return video, nil, err
+	}
+
+	master, err := parseMasterPlaylist(body, playlistURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	variant := master.selectVariant(d.variantPolicy)
+
+	body, err = d.fetchPlaylist(ctx, variant.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if video, err = parseMediaPlaylist(body, variant.URL); err != nil {
+		return nil, nil, err
+	}
+
+	if rendition := master.audioRendition(variant); rendition != nil {
+		body, err = d.fetchPlaylist(ctx, rendition.URL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("获取音轨失败: %v", err)
+		}
+		if audio, err = parseMediaPlaylist(body, rendition.URL); err != nil {
+			return nil, nil, err
+		}
+		if len(audio.Segments) == 0 {
+			audio = nil
+		}
+	}
+	return video, audio, nil
+}
+
+// tsParts 按播放列表时长计算视频或音频分段在时间轴上的起点
+func tsParts(tmpDir string, items []hlsItem, audio bool) []tsPart {
+	var parts []tsPart
+	start := 0.0
+	for _, item := range items {
+		if item.audio != audio {
+			continue
+		}
+		parts = append(parts, tsPart{Path: filepath.Join(tmpDir, item.name), Start: start})
+		start += item.seg.Duration
+	}
+	return parts
+}
+
+func downloadTS(client *http.Client, limiter *RateLimiter, tuner *threadTuner, seg *hlsSegment, keys *keyCache, filePath string, task *DownloadTask) error {
+	// 加密分段需要先获取密钥，整段读入内存解密后再写入
+	var key []byte
+	if seg.Key != nil {
+		var err error
+		key, err = keys.get(seg.Key.URI)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(task.ctx, "GET", seg.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("下载分段失败", resp)
+	}
 
 	out, err := utils.CreateFile(filePath)
 	if err != nil {
@@ -138,20 +361,29 @@ func downloadTS(client *http.Client, url, filePath string, task *DownloadTask) e
 	}
 	defer out.Close()
 
+	var dest io.Writer = out
+	var encrypted bytes.Buffer
+	if key != nil {
+		dest = &encrypted
+	}
+
 	// 使用缓冲读取以支持暂停功能
 	buf := make([]byte, 32*1024)
 	var totalBytes int64
 
 	for {
-		// 检查暂停状态
-		if task.isPaused.Load() {
-			time.Sleep(100 * time.Millisecond)
-			continue
+		// 暂停时等待，任务取消时立即结束
+		if err := task.waitWhilePaused(); err != nil {
+			return err
 		}
 
 		n, err := resp.Body.Read(buf)
+		if waitErr := limiter.WaitNContext(task.ctx, n); waitErr != nil {
+			return waitErr
+		}
+		tuner.record(n)
 		if n > 0 {
-			_, writeErr := out.Write(buf[:n])
+			_, writeErr := dest.Write(buf[:n])
 			if writeErr != nil {
 				return writeErr
 			}
@@ -165,39 +397,44 @@ func downloadTS(client *http.Client, url, filePath string, task *DownloadTask) e
 		}
 	}
 
+	if key != nil {
+		plain, err := decryptSegment(encrypted.Bytes(), key, segmentIV(seg))
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(plain); err != nil {
+			return err
+		}
+	}
+
 	atomic.AddInt64(&task.Downloaded, totalBytes)
 	return nil
 }
 
-func mergeTSFiles(tmpDir, outputFile string) error {
+// writeTSFile 将 TS 流直接写入文件
+func writeTSFile(writeTS func(io.Writer) error, outputFile string) error {
 	out, err := utils.CreateFile(outputFile)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
+	if err := writeTS(out); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// mergeTSFiles 按播放列表顺序拼接分段
+func mergeTSFiles(tmpDir string, items []hlsItem, out io.Writer) error {
 	// 获取实际的临时目录路径
 	actualTmpDir := tmpDir
 	if utils.IsAndroid() {
 		actualTmpDir = utils.GetAndroidSafeFilePath(tmpDir)
 	}
 
-	files, err := os.ReadDir(actualTmpDir)
-	if err != nil {
-		return err
-	}
-
-	// 按名字排序，确保顺序
-	var tsFiles []string
-	for _, f := range files {
-		if strings.HasSuffix(f.Name(), ".ts") {
-			tsFiles = append(tsFiles, f.Name())
-		}
-	}
-	sort.Strings(tsFiles)
-
-	for _, name := range tsFiles {
-		path := filepath.Join(actualTmpDir, name)
+	for _, item := range items {
+		path := filepath.Join(actualTmpDir, item.name)
 		in, err := os.Open(path)
 		if err != nil {
 			return err
@@ -208,5 +445,5 @@ func mergeTSFiles(tmpDir, outputFile string) error {
 			return err
 		}
 	}
-	return out.Sync()
+	return nil
 }
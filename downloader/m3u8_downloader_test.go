@@ -0,0 +1,49 @@
+package downloader
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+// tempPaths 下载 filePath 时会创建的所有临时路径
+func tempPaths(filePath string) []string {
+	tmpDir := hlsTmpDir(filePath)
+	return []string{
+		resumeStatePath(filePath),
+		resumeStatePath(filePath) + ".tmp",
+		tmpDir,
+		filepath.Join(tmpDir, segmentName(99999, false)),
+		filepath.Join(tmpDir, segmentName(99999, true)),
+		filepath.Join(tmpDir, "mdat.tmp"),
+		manifestPath(filepath.Dir(filePath)) + ".tmp",
+	}
+}
+
+func TestTempPathsFitLimits(t *testing.T) {
+	// 文件名按模板截断后，附属文件的文件名也不超过255字节
+	tmpl, err := utils.ParseFileTemplate("{title}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := tmpl.Render(utils.FileTemplateVars{Title: strings.Repeat("长", 200), Ext: "mp4"})
+	for _, path := range tempPaths(filepath.Join(t.TempDir(), name)) {
+		if n := len(filepath.Base(path)); n > 255 {
+			t.Errorf("%s: name is %d bytes", filepath.Base(path), n)
+		}
+	}
+
+	// 缩短后的完整路径加上临时文件也不超过限制
+	base := t.TempDir()
+	rel := filepath.Join(strings.Repeat("目", 100), strings.Repeat("长", 200)+".mp4")
+	max := len(utf16.Encode([]rune(base))) + 80
+	filePath := utils.FitPathLength(base, rel, max)
+	for _, path := range tempPaths(filePath) {
+		if n := len(utf16.Encode([]rune(path))); n > max {
+			t.Errorf("%s: path is %d characters, limit %d", path, n, max)
+		}
+	}
+}
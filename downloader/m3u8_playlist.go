@@ -0,0 +1,327 @@
+package downloader
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hlsKey 分段的加密信息（#EXT-X-KEY）
+type hlsKey struct {
+	Method string
+	URI    string
+	IV     []byte // 为 nil 时使用分段的媒体序列号作为 IV
+}
+
+// hlsSegment 媒体播放列表中的一个分段
+type hlsSegment struct {
+	URL      string
+	Sequence int64
+	Duration float64 // #EXTINF 中的时长（秒）
+	Key      *hlsKey // 为 nil 时表示未加密
+}
+
+// mediaPlaylist 媒体播放列表
+type mediaPlaylist struct {
+	MediaSequence int64
+	Segments      []*hlsSegment
+}
+
+// resolveURL 将播放列表中的相对地址解析为绝对地址
+func resolveURL(base *url.URL, ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// parseAttributes 解析 KEY=VALUE,KEY="VALUE" 形式的属性列表
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				value, s = s, ""
+			} else {
+				value, s = s[:end], s[end:]
+			}
+		}
+		attrs[key] = value
+
+		s = strings.TrimPrefix(s, ",")
+	}
+	return attrs
+}
+
+// parseKey 解析 #EXT-X-KEY 标签
+func parseKey(attrs map[string]string, base *url.URL) (*hlsKey, error) {
+	method := attrs["METHOD"]
+	switch method {
+	case "NONE", "":
+		return nil, nil
+	case "AES-128":
+	default:
+		return nil, fmt.Errorf("不支持的加密方式: %s", method)
+	}
+
+	if attrs["URI"] == "" {
+		return nil, fmt.Errorf("加密分段缺少密钥地址")
+	}
+	key := &hlsKey{
+		Method: method,
+		URI:    resolveURL(base, attrs["URI"]),
+	}
+
+	if iv := attrs["IV"]; iv != "" {
+		iv = strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+		decoded, err := hex.DecodeString(iv)
+		if err != nil || len(decoded) != 16 {
+			return nil, fmt.Errorf("无效的IV: %s", attrs["IV"])
+		}
+		key.IV = decoded
+	}
+	return key, nil
+}
+
+// parseMediaPlaylist 解析媒体播放列表，处理分段地址、媒体序列号和中途轮换的密钥
+func parseMediaPlaylist(body, playlistURL string) (*mediaPlaylist, error) {
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist := &mediaPlaylist{}
+	var currentKey *hlsKey
+	sequence := int64(0)
+	duration := 0.0
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			value := strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:")
+			if n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+				playlist.MediaSequence = n
+				sequence = n
+			}
+
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			currentKey, err = parseKey(parseAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:")), base)
+			if err != nil {
+				return nil, err
+			}
+
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.TrimPrefix(line, "#EXTINF:")
+			if idx := strings.IndexByte(value, ','); idx >= 0 {
+				value = value[:idx]
+			}
+			duration, _ = strconv.ParseFloat(strings.TrimSpace(value), 64)
+
+		case strings.HasPrefix(line, "#"):
+			continue
+
+		default:
+			playlist.Segments = append(playlist.Segments, &hlsSegment{
+				URL:      resolveURL(base, line),
+				Sequence: sequence,
+				Duration: duration,
+				Key:      currentKey,
+			})
+			sequence++
+			duration = 0
+		}
+	}
+
+	return playlist, nil
+}
+
+// 清晰度选择策略，也可以是表示最大高度的数字（如 "720"）
+const (
+	VariantHighest = "highest"
+	VariantLowest  = "lowest"
+)
+
+// ValidateVariantPolicy 校验清晰度选择策略
+func ValidateVariantPolicy(policy string) error {
+	if policy == VariantHighest || policy == VariantLowest {
+		return nil
+	}
+	if height, err := strconv.Atoi(policy); err != nil || height <= 0 {
+		return fmt.Errorf("无效的清晰度选择: %s（可选 highest、lowest 或最大高度，如 720）", policy)
+	}
+	return nil
+}
+
+// hlsVariant 主播放列表中的一个码率（#EXT-X-STREAM-INF）
+type hlsVariant struct {
+	URL       string
+	Bandwidth int64
+	Width     int
+	Height    int
+	Audio     string // 对应 #EXT-X-MEDIA 的 GROUP-ID
+}
+
+// hlsRendition 备选媒体（#EXT-X-MEDIA）
+type hlsRendition struct {
+	Type       string
+	GroupID    string
+	Name       string
+	URL        string // 为空时表示该媒体已包含在码率的分段中
+	Default    bool
+	AutoSelect bool
+}
+
+// masterPlaylist 主播放列表
+type masterPlaylist struct {
+	Variants   []*hlsVariant
+	Renditions []*hlsRendition
+}
+
+// isMasterPlaylist 判断播放列表是否为主播放列表
+func isMasterPlaylist(body string) bool {
+	return strings.Contains(body, "#EXT-X-STREAM-INF")
+}
+
+// parseMasterPlaylist 解析主播放列表
+func parseMasterPlaylist(body, playlistURL string) (*masterPlaylist, error) {
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist := &masterPlaylist{}
+	var pending *hlsVariant
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			pending = &hlsVariant{Audio: attrs["AUDIO"]}
+			pending.Bandwidth, _ = strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+			if res := strings.SplitN(strings.ToLower(attrs["RESOLUTION"]), "x", 2); len(res) == 2 {
+				pending.Width, _ = strconv.Atoi(res[0])
+				pending.Height, _ = strconv.Atoi(res[1])
+			}
+
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
+			rendition := &hlsRendition{
+				Type:       attrs["TYPE"],
+				GroupID:    attrs["GROUP-ID"],
+				Name:       attrs["NAME"],
+				Default:    attrs["DEFAULT"] == "YES",
+				AutoSelect: attrs["AUTOSELECT"] == "YES",
+			}
+			if attrs["URI"] != "" {
+				rendition.URL = resolveURL(base, attrs["URI"])
+			}
+			playlist.Renditions = append(playlist.Renditions, rendition)
+
+		case strings.HasPrefix(line, "#"):
+			continue
+
+		default:
+			if pending != nil {
+				pending.URL = resolveURL(base, line)
+				playlist.Variants = append(playlist.Variants, pending)
+				pending = nil
+			}
+		}
+	}
+
+	if len(playlist.Variants) == 0 {
+		return nil, fmt.Errorf("主播放列表中没有可用的码率")
+	}
+	return playlist, nil
+}
+
+// selectVariant 按策略选择码率：最高、最低或不超过指定高度中最高的
+func (mp *masterPlaylist) selectVariant(policy string) *hlsVariant {
+	variants := make([]*hlsVariant, len(mp.Variants))
+	copy(variants, mp.Variants)
+	sort.SliceStable(variants, func(i, j int) bool {
+		if variants[i].Height != variants[j].Height {
+			return variants[i].Height < variants[j].Height
+		}
+		return variants[i].Bandwidth < variants[j].Bandwidth
+	})
+
+	switch policy {
+	case VariantLowest:
+		return variants[0]
+	case VariantHighest, "":
+		return variants[len(variants)-1]
+	}
+
+	maxHeight, err := strconv.Atoi(policy)
+	if err != nil {
+		return variants[len(variants)-1]
+	}
+	selected := variants[0]
+	for _, v := range variants {
+		if v.Height <= maxHeight {
+			selected = v
+		}
+	}
+	return selected
+}
+
+// audioRendition 码率对应的独立音轨，音频已包含在视频分段中时返回 nil
+func (mp *masterPlaylist) audioRendition(variant *hlsVariant) *hlsRendition {
+	if variant.Audio == "" {
+		return nil
+	}
+
+	var candidates []*hlsRendition
+	for _, r := range mp.Renditions {
+		if r.Type == "AUDIO" && r.GroupID == variant.Audio {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	selected := candidates[0]
+	for _, r := range candidates {
+		if r.Default {
+			selected = r
+			break
+		}
+		if r.AutoSelect && !selected.AutoSelect {
+			selected = r
+		}
+	}
+	if selected.URL == "" {
+		return nil
+	}
+	return selected
+}
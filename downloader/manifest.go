@@ -0,0 +1,309 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+// ManifestFileName 下载目录中记录已完成文件的清单，与下载的文件放在同一目录
+const ManifestFileName = ".tal_manifest.json"
+
+// manifestMu 保护清单文件的读写，多个任务可能同时完成
+var manifestMu sync.Mutex
+
+// ManifestEntry 清单中的一讲：来源和下载完成时文件的大小与校验和
+type ManifestEntry struct {
+	LiveID     int       `json:"live_id"`
+	Extensive  bool      `json:"extensive,omitempty"`
+	Definition string    `json:"definition,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	File       string    `json:"file"` // 清单所在目录中的文件名
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	ModTime    time.Time `json:"mod_time"` // 修改时间不变时不再重新计算校验和
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// matches 记录是否是 source 描述的回放：讲次和是否为延伸内容相同，清晰度和来源地址（不含查询参数，
+// 其中的签名每次获取都会变化）在双方都已知时也要相同。获取回放地址前查找时 source 只有讲次
+func (e *ManifestEntry) matches(source ManifestEntry) bool {
+	if e.LiveID != source.LiveID || e.Extensive != source.Extensive {
+		return false
+	}
+	if e.Definition != "" && source.Definition != "" && e.Definition != source.Definition {
+		return false
+	}
+	if e.URL != "" && source.URL != "" && sourcePath(e.URL) != sourcePath(source.URL) {
+		return false
+	}
+	return true
+}
+
+// sameKey 两条记录是否是同一讲的同一清晰度，RecordDownload 时替换旧记录
+func (e *ManifestEntry) sameKey(other *ManifestEntry) bool {
+	return e.LiveID == other.LiveID && e.Extensive == other.Extensive && e.Definition == other.Definition
+}
+
+// sameRecord 两条记录是否记录了同一文件的同一状态，更新清单前确认记录没有被其他任务替换
+func (e *ManifestEntry) sameRecord(other *ManifestEntry) bool {
+	return e.sameKey(other) && e.File == other.File && e.Size == other.Size && e.SHA256 == other.SHA256 && e.ModTime.Equal(other.ModTime)
+}
+
+// sourcePath 来源地址去掉查询参数和片段
+func sourcePath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Path
+}
+
+// manifest 一个目录的清单
+type manifest struct {
+	Entries []*ManifestEntry `json:"entries"`
+
+	dir string
+}
+
+func manifestPath(dir string) string {
+	return filepath.Join(dir, ManifestFileName)
+}
+
+// loadManifest 读取目录中的清单，不存在或无法解析时返回空清单
+func loadManifest(dir string) *manifest {
+	m := &manifest{dir: dir}
+	data, err := os.ReadFile(utils.GetAndroidSafeFilePath(manifestPath(dir)))
+	if err == nil {
+		_ = json.Unmarshal(data, m)
+	}
+	return m
+}
+
+// save 先写入临时文件再重命名，避免中途退出留下损坏的清单
+func (m *manifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	actualPath := utils.GetAndroidSafeFilePath(manifestPath(m.dir))
+	tmpPath := actualPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, actualPath)
+}
+
+func (m *manifest) find(source ManifestEntry) *ManifestEntry {
+	for _, e := range m.Entries {
+		if e.matches(source) {
+			return e
+		}
+	}
+	return nil
+}
+
+// put 添加或替换一讲同一清晰度的记录
+func (m *manifest) put(entry *ManifestEntry) {
+	for i, e := range m.Entries {
+		if e.sameKey(entry) {
+			m.Entries[i] = entry
+			return
+		}
+	}
+	m.Entries = append(m.Entries, entry)
+}
+
+func (m *manifest) remove(entry *ManifestEntry) {
+	for i, e := range m.Entries {
+		if e == entry {
+			m.Entries = append(m.Entries[:i], m.Entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// fileHash 计算文件的 SHA-256
+func fileHash(filePath string) (string, error) {
+	file, err := os.Open(utils.GetAndroidSafeFilePath(filePath))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// RecordDownload 将下载完成的文件写入所在目录的清单，entry 中的文件名、大小和校验和由文件计算
+func RecordDownload(filePath string, entry ManifestEntry) error {
+	info, err := os.Stat(utils.GetAndroidSafeFilePath(filePath))
+	if err != nil {
+		return err
+	}
+	hash, err := fileHash(filePath)
+	if err != nil {
+		return err
+	}
+	entry.File = filepath.Base(filePath)
+	entry.Size = info.Size()
+	entry.SHA256 = hash
+	entry.ModTime = info.ModTime()
+	entry.RecordedAt = time.Now()
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	m := loadManifest(filepath.Dir(filePath))
+	m.put(&entry)
+	return m.save()
+}
+
+// 本地副本的状态，见 FindLocalCopy
+const (
+	CopyMissing  = "missing"  // 没有本地副本
+	CopyComplete = "complete" // 有经过校验的完整副本，路径可能与目标路径不同（改名或修改了保存路径模板）
+	CopyPartial  = "partial"  // 目标路径上的文件不完整（如崩溃留下的空文件），需要重新下载
+)
+
+// LocalCopy 一讲在本地的副本
+type LocalCopy struct {
+	Status string
+	Path   string // 完整副本或不完整文件的路径
+}
+
+// FindLocalCopy 查找 source 描述的回放（讲次、是否为延伸内容，已获取回放地址时还有清晰度和来源地址）
+// 已下载的副本。先查找目标路径所在目录和 searchDirs（如之前下载的目录）中的清单，
+// 记录的文件被改名时按大小和校验和在目录中查找并更新清单；没有记录时校验目标路径上的文件，
+// 完整的 MP4 文件和之前版本以 .mp4 文件名保存的 TS 流会被加入清单。
+// 目标路径有续传状态时文件是中断的下载，视为不完整
+func FindLocalCopy(filePath string, source ManifestEntry, searchDirs ...string) LocalCopy {
+	seen := make(map[string]bool)
+	for _, dir := range append([]string{filepath.Dir(filePath)}, searchDirs...) {
+		dir = filepath.Clean(dir)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		if path, ok := findInManifest(dir, source); ok {
+			return LocalCopy{Status: CopyComplete, Path: path}
+		}
+	}
+
+	if HasResumeState(filePath) {
+		return LocalCopy{Status: CopyPartial, Path: filePath}
+	}
+	info, err := os.Stat(utils.GetAndroidSafeFilePath(filePath))
+	if err != nil {
+		return LocalCopy{Status: CopyMissing}
+	}
+	// 多线程下载的文件预先分配了完整大小，MP4 还要检查数据中没有未写入的区域
+	if info.Size() == 0 || (verifyMP4Payload(filePath) != nil && verifyTSFile(filePath) != nil) {
+		return LocalCopy{Status: CopyPartial, Path: filePath}
+	}
+
+	// 之前版本下载的完整文件，加入清单。无法确定下载时的清晰度和来源，不记录
+	hash, err := fileHash(filePath)
+	if err == nil {
+		manifestMu.Lock()
+		m := loadManifest(filepath.Dir(filePath))
+		m.put(&ManifestEntry{LiveID: source.LiveID, Extensive: source.Extensive, File: filepath.Base(filePath), Size: info.Size(), SHA256: hash, ModTime: info.ModTime(), RecordedAt: time.Now()})
+		_ = m.save()
+		manifestMu.Unlock()
+	}
+	return LocalCopy{Status: CopyComplete, Path: filePath}
+}
+
+// findInManifest 在目录的清单中查找一讲并校验文件，文件被改名时更新清单，文件已不存在或被修改时删除记录。
+// 校验和在锁外计算，更新时重新读取清单，期间被替换的记录不受影响
+func findInManifest(dir string, source ManifestEntry) (string, bool) {
+	manifestMu.Lock()
+	found := loadManifest(dir).find(source)
+	manifestMu.Unlock()
+	if found == nil {
+		return "", false
+	}
+
+	entry := *found
+	path := filepath.Join(dir, entry.File)
+	ok, err := entry.check(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if renamed := entry.findRenamed(dir); renamed != "" {
+			path, ok = filepath.Join(dir, renamed), true
+			entry.File = renamed
+		}
+	}
+	if ok && entry.sameRecord(found) {
+		return path, true
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	m := loadManifest(dir)
+	for _, e := range m.Entries {
+		if !e.sameRecord(found) {
+			continue
+		}
+		if ok {
+			*e = entry
+		} else {
+			m.remove(e)
+		}
+		_ = m.save()
+		break
+	}
+	return path, ok
+}
+
+// check 检查文件与记录是否一致，修改时间变化时重新计算校验和
+func (e *ManifestEntry) check(path string) (bool, error) {
+	info, err := os.Stat(utils.GetAndroidSafeFilePath(path))
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != e.Size {
+		return false, nil
+	}
+	if info.ModTime().Equal(e.ModTime) {
+		return true, nil
+	}
+	hash, err := fileHash(path)
+	if err != nil || hash != e.SHA256 {
+		return false, err
+	}
+	e.ModTime = info.ModTime()
+	return true, nil
+}
+
+// findRenamed 在目录中查找大小和校验和与记录相同的文件，返回文件名
+func (e *ManifestEntry) findRenamed(dir string) string {
+	entries, err := os.ReadDir(utils.GetAndroidSafeFilePath(dir))
+	if err != nil {
+		return ""
+	}
+	for _, de := range entries {
+		if de.IsDir() || de.Name() == ManifestFileName {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil || info.Size() != e.Size {
+			continue
+		}
+		if hash, err := fileHash(filepath.Join(dir, de.Name())); err == nil && hash == e.SHA256 {
+			e.ModTime = info.ModTime()
+			return de.Name()
+		}
+	}
+	return ""
+}
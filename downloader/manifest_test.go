@@ -0,0 +1,240 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sampleMP4 生成结构完整的 MP4 文件，mdat 中填充 fill
+func sampleMP4(payload int, fill byte) []byte {
+	box := func(boxType string, content []byte) []byte {
+		b := make([]byte, 8, 8+len(content))
+		binary.BigEndian.PutUint32(b, uint32(8+len(content)))
+		copy(b[4:], boxType)
+		return append(b, content...)
+	}
+	var data []byte
+	data = append(data, box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))...)
+	data = append(data, box("moov", box("mvhd", make([]byte, 100)))...)
+	return append(data, box("mdat", bytes.Repeat([]byte{fill}, payload))...)
+}
+
+func TestManifestFindsRenamedAndMovedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "第1讲.mp4")
+	if err := os.WriteFile(path, sampleMP4(4096, 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordDownload(path, ManifestEntry{LiveID: 1001, Definition: "高清", URL: "http://example.com/1.mp4"}); err != nil {
+		t.Fatalf("RecordDownload: %v", err)
+	}
+
+	if local := FindLocalCopy(path, ManifestEntry{LiveID: 1001}); local.Status != CopyComplete || local.Path != path {
+		t.Errorf("recorded file = %+v, want complete at %s", local, path)
+	}
+	if local := FindLocalCopy(filepath.Join(dir, "第2讲.mp4"), ManifestEntry{LiveID: 1002}); local.Status != CopyMissing {
+		t.Errorf("other lecture = %+v, want missing", local)
+	}
+
+	// 改名后按大小和校验和找到文件并更新清单
+	renamed := filepath.Join(dir, "01 开学第一课.mp4")
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if local := FindLocalCopy(path, ManifestEntry{LiveID: 1001}); local.Status != CopyComplete || local.Path != renamed {
+		t.Errorf("renamed file = %+v, want complete at %s", local, renamed)
+	}
+	if entry := loadManifest(dir).find(ManifestEntry{LiveID: 1001}); entry == nil || entry.File != filepath.Base(renamed) {
+		t.Errorf("manifest entry = %+v, want file %s", entry, filepath.Base(renamed))
+	}
+
+	// 使用新的保存路径时在之前的下载目录中查找
+	newPath := filepath.Join(t.TempDir(), "课程", "第1讲.mp4")
+	if local := FindLocalCopy(newPath, ManifestEntry{LiveID: 1001}); local.Status != CopyMissing {
+		t.Errorf("without search dirs = %+v, want missing", local)
+	}
+	if local := FindLocalCopy(newPath, ManifestEntry{LiveID: 1001}, dir); local.Status != CopyComplete || local.Path != renamed {
+		t.Errorf("with search dirs = %+v, want complete at %s", local, renamed)
+	}
+
+	// 文件被修改后不再视为已下载，记录被删除
+	if err := os.WriteFile(renamed, sampleMP4(4096, 2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(renamed, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if local := FindLocalCopy(newPath, ManifestEntry{LiveID: 1001}, dir); local.Status != CopyMissing {
+		t.Errorf("modified file = %+v, want missing", local)
+	}
+	if entry := loadManifest(dir).find(ManifestEntry{LiveID: 1001}); entry != nil {
+		t.Errorf("entry for modified file kept: %+v", entry)
+	}
+}
+
+func TestManifestPartialAndLegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	empty := filepath.Join(dir, "第1讲.mp4")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if local := FindLocalCopy(empty, ManifestEntry{LiveID: 1}); local.Status != CopyPartial || local.Path != empty {
+		t.Errorf("empty file = %+v, want partial", local)
+	}
+
+	truncated := filepath.Join(dir, "第2讲.mp4")
+	data := sampleMP4(4096, 1)
+	if err := os.WriteFile(truncated, data[:len(data)-100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if local := FindLocalCopy(truncated, ManifestEntry{LiveID: 2}); local.Status != CopyPartial {
+		t.Errorf("truncated file = %+v, want partial", local)
+	}
+
+	// 没有清单记录的完整文件（之前版本下载的）被加入清单
+	legacy := filepath.Join(dir, "第3讲.mp4")
+	if err := os.WriteFile(legacy, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if local := FindLocalCopy(legacy, ManifestEntry{LiveID: 3}); local.Status != CopyComplete || local.Path != legacy {
+		t.Errorf("legacy file = %+v, want complete", local)
+	}
+	if entry := loadManifest(dir).find(ManifestEntry{LiveID: 3}); entry == nil || entry.Size != int64(len(data)) {
+		t.Errorf("legacy file not recorded: %+v", entry)
+	}
+
+	if local := FindLocalCopy(filepath.Join(dir, "第4讲.mp4"), ManifestEntry{LiveID: 4}); local.Status != CopyMissing {
+		t.Errorf("missing file = %+v, want missing", local)
+	}
+
+	// 之前版本将 HLS 回放的 TS 流保存为 .mp4 文件名，视为完整文件，截断的仍需重新下载
+	var ts []byte
+	for i := 0; i < 10; i++ {
+		ts = append(ts, tsPacket(0x100, byte(i))...)
+	}
+	legacyTS := filepath.Join(dir, "第5讲.mp4")
+	if err := os.WriteFile(legacyTS, ts, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if local := FindLocalCopy(legacyTS, ManifestEntry{LiveID: 5}); local.Status != CopyComplete || local.Path != legacyTS {
+		t.Errorf("legacy TS file = %+v, want complete", local)
+	}
+	truncatedTS := filepath.Join(dir, "第6讲.mp4")
+	if err := os.WriteFile(truncatedTS, ts[:len(ts)-100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if local := FindLocalCopy(truncatedTS, ManifestEntry{LiveID: 6}); local.Status != CopyPartial {
+		t.Errorf("truncated TS file = %+v, want partial", local)
+	}
+}
+
+func TestManifestInterruptedDownloads(t *testing.T) {
+	dir := t.TempDir()
+	data := sampleMP4(64<<10, 1)
+
+	// 多线程下载先把文件扩展到完整大小，中断后 moov 在前的文件结构完整，数据中留有成段的0
+	for name, zeroFrom := range map[string]int{"tail": len(data) - 8192, "middle": len(data) / 2} {
+		path := filepath.Join(dir, name+".mp4")
+		partial := append([]byte(nil), data...)
+		for i := zeroFrom; i < zeroFrom+8192 && i < len(partial); i++ {
+			partial[i] = 0
+		}
+		if err := os.WriteFile(path, partial, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if local := FindLocalCopy(path, ManifestEntry{LiveID: 1}); local.Status != CopyPartial {
+			t.Errorf("zero-filled %s = %+v, want partial", name, local)
+		}
+	}
+
+	// 截断后重新扩展到原来大小的文件
+	preallocated := filepath.Join(dir, "preallocated.mp4")
+	if err := os.WriteFile(preallocated, data[:len(data)/3], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(preallocated, int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if local := FindLocalCopy(preallocated, ManifestEntry{LiveID: 2}); local.Status != CopyPartial {
+		t.Errorf("truncated then zero-filled file = %+v, want partial", local)
+	}
+
+	// 有续传状态的文件即使结构完整也是中断的下载
+	resuming := filepath.Join(dir, "resuming.mp4")
+	if err := os.WriteFile(resuming, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(resumeStatePath(resuming), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if local := FindLocalCopy(resuming, ManifestEntry{LiveID: 3}); local.Status != CopyPartial {
+		t.Errorf("file with resume state = %+v, want partial", local)
+	}
+	if entry := loadManifest(dir).find(ManifestEntry{LiveID: 3}); entry != nil {
+		t.Errorf("interrupted download recorded: %+v", entry)
+	}
+
+	// TS 流检查整个文件，不只是开头和结尾
+	var ts []byte
+	for i := 0; i < 10000; i++ {
+		ts = append(ts, tsPacket(0x100, byte(i))...)
+	}
+	ts[5000*tsPacketSize] = 0
+	brokenTS := filepath.Join(dir, "broken-ts.mp4")
+	if err := os.WriteFile(brokenTS, ts, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if local := FindLocalCopy(brokenTS, ManifestEntry{LiveID: 4}); local.Status != CopyPartial {
+		t.Errorf("TS file damaged in the middle = %+v, want partial", local)
+	}
+}
+
+func TestManifestMatchesDefinitionAndSource(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "第1讲.mp4")
+	if err := os.WriteFile(path, sampleMP4(4096, 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordDownload(path, ManifestEntry{LiveID: 1, Definition: "高清", URL: "http://cdn1.example.com/v/1.mp4?sign=a"}); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(t.TempDir(), "第1讲.mp4")
+
+	tests := []struct {
+		name   string
+		source ManifestEntry
+		want   string
+	}{
+		{"before fetching source", ManifestEntry{LiveID: 1}, CopyComplete},
+		{"same source with new signature", ManifestEntry{LiveID: 1, Definition: "高清", URL: "http://cdn2.example.com/v/1.mp4?sign=b"}, CopyComplete},
+		{"other definition", ManifestEntry{LiveID: 1, Definition: "超清", URL: "http://cdn1.example.com/v/1.mp4"}, CopyMissing},
+		{"other source", ManifestEntry{LiveID: 1, Definition: "高清", URL: "http://cdn1.example.com/v/1-new.mp4"}, CopyMissing},
+		{"extensive", ManifestEntry{LiveID: 1, Extensive: true}, CopyMissing},
+	}
+	for _, tt := range tests {
+		if local := FindLocalCopy(other, tt.source, dir); local.Status != tt.want {
+			t.Errorf("%s: status = %s, want %s", tt.name, local.Status, tt.want)
+		}
+	}
+
+	// 另一清晰度的下载单独记录，不替换原来的记录
+	hd := filepath.Join(dir, "第1讲_超清.mp4")
+	if err := os.WriteFile(hd, sampleMP4(8192, 3), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordDownload(hd, ManifestEntry{LiveID: 1, Definition: "超清"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(loadManifest(dir).Entries); n != 2 {
+		t.Errorf("manifest has %d entries, want 2", n)
+	}
+	if local := FindLocalCopy(other, ManifestEntry{LiveID: 1, Definition: "超清"}, dir); local.Status != CopyComplete || local.Path != hd {
+		t.Errorf("definition lookup = %+v, want %s", local, hd)
+	}
+}
@@ -0,0 +1,553 @@
+package downloader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+const (
+	// mp4MovieTimescale mvhd、tkhd 和编辑列表使用的时间刻度（毫秒）
+	mp4MovieTimescale = 1000
+	// mp4VideoTimescale 视频轨道直接沿用 PES 的 90kHz 时间戳
+	mp4VideoTimescale = 90000
+	// aacFrameSamples 每个 AAC 帧包含的采样数
+	aacFrameSamples = 1024
+)
+
+// mp4Sample 一个样本的索引信息，样本数据写在临时的 mdat 文件中
+type mp4Sample struct {
+	Size      uint32
+	Duration  uint32
+	DTS       int64
+	CTSOffset int32
+	Keyframe  bool
+}
+
+// mp4Chunk mdat 中同一轨道的连续样本
+type mp4Chunk struct {
+	Offset int64
+	Count  uint32
+}
+
+type mp4Track struct {
+	ID        uint32
+	Timescale uint32
+	samples   []mp4Sample
+	chunks    []mp4Chunk
+	firstPTS  int64 // 90kHz，用于音视频对齐
+	lastPTS   int64 // 展开时间戳回绕时的参考值
+}
+
+// mediaDuration 轨道在自身时间刻度下的时长
+func (t *mp4Track) mediaDuration() uint64 {
+	var total uint64
+	for _, s := range t.samples {
+		total += uint64(s.Duration)
+	}
+	return total
+}
+
+// hasCompositionOffsets 是否存在 B 帧（各帧显示时间与解码时间的差值不同）
+func (t *mp4Track) hasCompositionOffsets() bool {
+	for _, s := range t.samples {
+		if s.CTSOffset != t.samples[0].CTSOffset {
+			return true
+		}
+	}
+	return false
+}
+
+// mp4Remuxer 将 TS 中的 H.264/AAC 重新封装为 MP4
+type mp4Remuxer struct {
+	mdat      *bufio.Writer
+	mdatSize  int64
+	lastTrack *mp4Track
+
+	video *mp4Track
+	audio *mp4Track
+
+	sps, pps      []byte
+	width, height int
+
+	adts         *adtsHeader
+	audioPending []byte
+}
+
+// writeSample 追加样本数据，与上一个样本属于同一轨道时合并到同一个 chunk
+func (m *mp4Remuxer) writeSample(track *mp4Track, data []byte, sample mp4Sample) error {
+	if _, err := m.mdat.Write(data); err != nil {
+		return err
+	}
+	if m.lastTrack == track {
+		track.chunks[len(track.chunks)-1].Count++
+	} else {
+		track.chunks = append(track.chunks, mp4Chunk{Offset: m.mdatSize, Count: 1})
+		m.lastTrack = track
+	}
+	sample.Size = uint32(len(data))
+	track.samples = append(track.samples, sample)
+	m.mdatSize += int64(len(data))
+	return nil
+}
+
+func (m *mp4Remuxer) onVideo(pes *pesPacket) error {
+	if pes.PTS < 0 {
+		return nil
+	}
+
+	nals := splitAnnexB(pes.Payload)
+	for _, nal := range nals {
+		switch nal[0] & 0x1F {
+		case nalSPS:
+			if m.sps == nil {
+				width, height, err := parseSPSSize(nal)
+				if err != nil {
+					return err
+				}
+				m.sps = append([]byte(nil), nal...)
+				m.width, m.height = width, height
+			}
+		case nalPPS:
+			if m.pps == nil {
+				m.pps = append([]byte(nil), nal...)
+			}
+		}
+	}
+
+	data, keyframe := avcToLengthPrefixed(nals)
+	if len(data) == 0 {
+		return nil
+	}
+
+	if m.video == nil {
+		// 从第一个带参数集的关键帧开始
+		if !keyframe || m.sps == nil || m.pps == nil {
+			return nil
+		}
+		m.video = &mp4Track{ID: 1, Timescale: mp4VideoTimescale, firstPTS: pes.PTS, lastPTS: pes.PTS}
+	}
+
+	pts := unwrapTimestamp(pes.PTS, m.video.lastPTS)
+	dts := unwrapTimestamp(pes.DTS, pts)
+	m.video.lastPTS = pts
+	return m.writeSample(m.video, data, mp4Sample{
+		DTS:       dts,
+		CTSOffset: int32(pts - dts),
+		Keyframe:  keyframe,
+	})
+}
+
+func (m *mp4Remuxer) onAudio(pes *pesPacket) error {
+	// 只保留与视频同时或之后的音频，视频开始前的帧无法对齐
+	if m.video == nil {
+		return nil
+	}
+
+	data := pes.Payload
+	if len(m.audioPending) > 0 {
+		data = append(m.audioPending, data...)
+		m.audioPending = nil
+	}
+
+	for len(data) > 0 {
+		if len(data) < 7 {
+			m.audioPending = append([]byte(nil), data...)
+			break
+		}
+		header, err := parseADTSHeader(data)
+		if err != nil {
+			data = data[1:] // 丢失同步时逐字节查找下一帧
+			continue
+		}
+		if header.FrameSize > len(data) {
+			// 跨 PES 包的帧
+			m.audioPending = append([]byte(nil), data...)
+			break
+		}
+
+		if m.audio == nil {
+			if pes.PTS < 0 {
+				return nil
+			}
+			m.adts = header
+			m.audio = &mp4Track{ID: 2, Timescale: uint32(header.sampleRate()), firstPTS: pes.PTS}
+		}
+		if err := m.writeSample(m.audio, data[header.HeaderSize:header.FrameSize], mp4Sample{
+			Duration: aacFrameSamples,
+			Keyframe: true,
+		}); err != nil {
+			return err
+		}
+		data = data[header.FrameSize:]
+	}
+	return nil
+}
+
+// finishVideoDurations 根据 DTS 计算每个视频样本的时长，时间戳不连续时沿用上一帧的时长
+func (m *mp4Remuxer) finishVideoDurations() {
+	samples := m.video.samples
+	last := uint32(mp4VideoTimescale / 25)
+	for i := range samples {
+		if i+1 < len(samples) {
+			delta := samples[i+1].DTS - samples[i].DTS
+			if delta > 0 && delta < mp4VideoTimescale*10 {
+				last = uint32(delta)
+			}
+		}
+		samples[i].Duration = last
+	}
+}
+
+// remuxToMP4 读取 writeTS 写出的 TS 流，重新封装为 moov 在前的 MP4 文件。
+// 样本数据先写入临时目录中的 mdat 文件，完成后再与 moov 一起写入输出文件
+func remuxToMP4(writeTS func(io.Writer) error, tmpDir, outputFile string) error {
+	mdatPath := utils.GetAndroidSafeFilePath(filepath.Join(tmpDir, "mdat.tmp"))
+	mdatFile, err := os.Create(mdatPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(mdatPath)
+	defer mdatFile.Close()
+
+	m := &mp4Remuxer{mdat: bufio.NewWriterSize(mdatFile, 1<<20)}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTS(pw))
+	}()
+	err = newTSDemuxer(m.onVideo, m.onAudio).run(pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+	if m.video == nil || len(m.video.samples) == 0 {
+		return fmt.Errorf("没有可封装的视频帧")
+	}
+	if err := m.mdat.Flush(); err != nil {
+		return err
+	}
+	m.finishVideoDurations()
+
+	// moov 的大小与 chunk 偏移量的具体值无关，先计算大小再确定 mdat 的位置
+	ftyp := m.buildFtyp()
+	mdatHeader := mp4MdatHeader(m.mdatSize)
+	dataStart := int64(len(ftyp)) + int64(len(m.buildMoov(0, false))) + int64(len(mdatHeader))
+	useCo64 := dataStart+m.mdatSize > math.MaxUint32
+	moov := m.buildMoov(dataStart, useCo64)
+
+	out, err := utils.CreateFile(outputFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, part := range [][]byte{ftyp, moov, mdatHeader} {
+		if _, err := out.Write(part); err != nil {
+			return err
+		}
+	}
+	if _, err := mdatFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, mdatFile); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+func mp4MdatHeader(size int64) []byte {
+	if size+8 <= math.MaxUint32 {
+		header := binary.BigEndian.AppendUint32(nil, uint32(size+8))
+		return append(header, "mdat"...)
+	}
+	// 超过4GB时使用64位长度
+	header := binary.BigEndian.AppendUint32(nil, 1)
+	header = append(header, "mdat"...)
+	return binary.BigEndian.AppendUint64(header, uint64(size+16))
+}
+
+// mp4Box 生成一个 box，payload 按顺序拼接
+func mp4Box(boxType string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	box := make([]byte, 0, size)
+	box = binary.BigEndian.AppendUint32(box, uint32(size))
+	box = append(box, boxType...)
+	for _, p := range payload {
+		box = append(box, p...)
+	}
+	return box
+}
+
+// mp4FullBox 生成带 version 和 flags 的 box
+func mp4FullBox(boxType string, version byte, flags uint32, payload ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(boxType, append([][]byte{header}, payload...)...)
+}
+
+// mp4Fields 按大端序写入整数字段
+func mp4Fields(values ...interface{}) []byte {
+	var b []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case uint8:
+			b = append(b, v)
+		case uint16:
+			b = binary.BigEndian.AppendUint16(b, v)
+		case uint32:
+			b = binary.BigEndian.AppendUint32(b, v)
+		case int32:
+			b = binary.BigEndian.AppendUint32(b, uint32(v))
+		case uint64:
+			b = binary.BigEndian.AppendUint64(b, v)
+		case []byte:
+			b = append(b, v...)
+		case string:
+			b = append(b, v...)
+		default:
+			panic(fmt.Sprintf("unsupported mp4 field type %T", v))
+		}
+	}
+	return b
+}
+
+// mp4Matrix 单位变换矩阵
+var mp4Matrix = mp4Fields(
+	uint32(0x00010000), uint32(0), uint32(0),
+	uint32(0), uint32(0x00010000), uint32(0),
+	uint32(0), uint32(0), uint32(0x40000000),
+)
+
+func (m *mp4Remuxer) buildFtyp() []byte {
+	return mp4Box("ftyp", mp4Fields("isom", uint32(512), "isom", "iso2", "avc1", "mp41"))
+}
+
+// movieDuration 将轨道时间换算为毫秒
+func movieDuration(duration uint64, timescale uint32) uint32 {
+	return uint32(duration * mp4MovieTimescale / uint64(timescale))
+}
+
+func (m *mp4Remuxer) buildMoov(dataStart int64, useCo64 bool) []byte {
+	// 以最早出现的音频或视频为起点，较晚开始的轨道前插入空白编辑
+	start := m.video.firstPTS
+	if m.audio != nil && m.audio.firstPTS < start {
+		start = m.audio.firstPTS
+	}
+
+	var traks [][]byte
+	var movieLength uint32
+	for _, track := range []*mp4Track{m.video, m.audio} {
+		if track == nil {
+			continue
+		}
+		trak, length := m.buildTrak(track, start, dataStart, useCo64)
+		traks = append(traks, trak)
+		if length > movieLength {
+			movieLength = length
+		}
+	}
+
+	mvhd := mp4FullBox("mvhd", 0, 0, mp4Fields(
+		uint32(0), uint32(0), // creation_time, modification_time
+		uint32(mp4MovieTimescale), movieLength,
+		uint32(0x00010000), uint16(0x0100), // rate, volume
+		make([]byte, 10), mp4Matrix, make([]byte, 24),
+		uint32(len(traks)+1), // next_track_ID
+	))
+	return mp4Box("moov", append([][]byte{mvhd}, traks...)...)
+}
+
+// buildTrak 生成轨道，同时返回包含空白编辑在内的轨道时长（毫秒）
+func (m *mp4Remuxer) buildTrak(track *mp4Track, start, dataStart int64, useCo64 bool) ([]byte, uint32) {
+	isVideo := track == m.video
+	mediaDuration := track.mediaDuration()
+	length := movieDuration(mediaDuration, track.Timescale)
+
+	// 编辑列表：空白编辑对齐音视频起点；视频从第一帧的显示时间开始
+	var edits [][]byte
+	entries := uint32(1)
+	if delay := movieDuration(uint64(track.firstPTS-start), mp4VideoTimescale); delay > 0 {
+		edits = append(edits, mp4Fields(delay, int32(-1), uint16(1), uint16(0)))
+		entries++
+		length += delay
+	}
+	mediaTime := int32(0)
+	if track.hasCompositionOffsets() {
+		mediaTime = track.samples[0].CTSOffset
+	}
+	edits = append(edits, mp4Fields(movieDuration(mediaDuration, track.Timescale), mediaTime, uint16(1), uint16(0)))
+	elst := mp4FullBox("elst", 0, 0, append([][]byte{mp4Fields(entries)}, edits...)...)
+
+	var volume uint16
+	var width, height uint32
+	handler, handlerName := "vide", "VideoHandler"
+	mediaHeader := mp4FullBox("vmhd", 0, 1, make([]byte, 8))
+	if isVideo {
+		width, height = uint32(m.width)<<16, uint32(m.height)<<16
+	} else {
+		volume = 0x0100
+		handler, handlerName = "soun", "SoundHandler"
+		mediaHeader = mp4FullBox("smhd", 0, 0, make([]byte, 4))
+	}
+
+	tkhd := mp4FullBox("tkhd", 0, 0x03, mp4Fields( // track_enabled | track_in_movie
+		uint32(0), uint32(0), track.ID, uint32(0), length,
+		make([]byte, 8), uint16(0), uint16(0), volume, uint16(0),
+		mp4Matrix, width, height,
+	))
+	mdhd := mp4FullBox("mdhd", 0, 0, mp4Fields(
+		uint32(0), uint32(0), track.Timescale, uint32(mediaDuration),
+		uint16(0x55C4), uint16(0), // language "und"
+	))
+	hdlr := mp4FullBox("hdlr", 0, 0, mp4Fields(uint32(0), handler, make([]byte, 12), handlerName, uint8(0)))
+	dinf := mp4Box("dinf", mp4FullBox("dref", 0, 0, mp4Fields(uint32(1)), mp4FullBox("url ", 0, 1)))
+
+	minf := mp4Box("minf", mediaHeader, dinf, m.buildStbl(track, dataStart, useCo64))
+	mdia := mp4Box("mdia", mdhd, hdlr, minf)
+	return mp4Box("trak", tkhd, mp4Box("edts", elst), mdia), length
+}
+
+func (m *mp4Remuxer) buildStbl(track *mp4Track, dataStart int64, useCo64 bool) []byte {
+	var sampleEntry []byte
+	if track == m.video {
+		sampleEntry = m.buildAVC1()
+	} else {
+		sampleEntry = m.buildMP4A()
+	}
+	boxes := [][]byte{mp4FullBox("stsd", 0, 0, mp4Fields(uint32(1)), sampleEntry)}
+
+	// stts：连续相同时长的样本合并为一项
+	var stts []byte
+	var sttsCount uint32
+	for i := 0; i < len(track.samples); {
+		j := i
+		for j < len(track.samples) && track.samples[j].Duration == track.samples[i].Duration {
+			j++
+		}
+		stts = append(stts, mp4Fields(uint32(j-i), track.samples[i].Duration)...)
+		sttsCount++
+		i = j
+	}
+	boxes = append(boxes, mp4FullBox("stts", 0, 0, mp4Fields(sttsCount), stts))
+
+	// ctts：仅在存在 B 帧时写入
+	if track.hasCompositionOffsets() {
+		var ctts []byte
+		var cttsCount uint32
+		for i := 0; i < len(track.samples); {
+			j := i
+			for j < len(track.samples) && track.samples[j].CTSOffset == track.samples[i].CTSOffset {
+				j++
+			}
+			ctts = append(ctts, mp4Fields(uint32(j-i), track.samples[i].CTSOffset)...)
+			cttsCount++
+			i = j
+		}
+		boxes = append(boxes, mp4FullBox("ctts", 1, 0, mp4Fields(cttsCount), ctts))
+	}
+
+	if track == m.video {
+		var stss []byte
+		var stssCount uint32
+		for i, s := range track.samples {
+			if s.Keyframe {
+				stss = binary.BigEndian.AppendUint32(stss, uint32(i+1))
+				stssCount++
+			}
+		}
+		boxes = append(boxes, mp4FullBox("stss", 0, 0, mp4Fields(stssCount), stss))
+	}
+
+	// stsc：每个 chunk 的样本数变化时才需要新的一项
+	var stsc []byte
+	var stscCount uint32
+	for i, c := range track.chunks {
+		if i == 0 || c.Count != track.chunks[i-1].Count {
+			stsc = append(stsc, mp4Fields(uint32(i+1), c.Count, uint32(1))...)
+			stscCount++
+		}
+	}
+	boxes = append(boxes, mp4FullBox("stsc", 0, 0, mp4Fields(stscCount), stsc))
+
+	stsz := make([]byte, 0, 4*len(track.samples))
+	for _, s := range track.samples {
+		stsz = binary.BigEndian.AppendUint32(stsz, s.Size)
+	}
+	boxes = append(boxes, mp4FullBox("stsz", 0, 0, mp4Fields(uint32(0), uint32(len(track.samples))), stsz))
+
+	var offsets []byte
+	for _, c := range track.chunks {
+		if useCo64 {
+			offsets = binary.BigEndian.AppendUint64(offsets, uint64(dataStart+c.Offset))
+		} else {
+			offsets = binary.BigEndian.AppendUint32(offsets, uint32(dataStart+c.Offset))
+		}
+	}
+	offsetBox := "stco"
+	if useCo64 {
+		offsetBox = "co64"
+	}
+	boxes = append(boxes, mp4FullBox(offsetBox, 0, 0, mp4Fields(uint32(len(track.chunks))), offsets))
+
+	return mp4Box("stbl", boxes...)
+}
+
+func (m *mp4Remuxer) buildAVC1() []byte {
+	avcC := mp4Box("avcC", mp4Fields(
+		uint8(1), m.sps[1], m.sps[2], m.sps[3], // configurationVersion, profile, compatibility, level
+		uint8(0xFF),                            // lengthSizeMinusOne = 3
+		uint8(0xE1), uint16(len(m.sps)), m.sps, // 1个 SPS
+		uint8(1), uint16(len(m.pps)), m.pps, // 1个 PPS
+	))
+	return mp4Box("avc1", mp4Fields(
+		make([]byte, 6), uint16(1), // reserved, data_reference_index
+		make([]byte, 16),
+		uint16(m.width), uint16(m.height),
+		uint32(0x00480000), uint32(0x00480000), // 72 dpi
+		uint32(0), uint16(1), // reserved, frame_count
+		make([]byte, 32),               // compressorname
+		uint16(0x0018), uint16(0xFFFF), // depth, pre_defined
+	), avcC)
+}
+
+func (m *mp4Remuxer) buildMP4A() []byte {
+	asc := m.adts.audioSpecificConfig()
+	decoderSpecific := mp4Descriptor(0x05, asc)
+	decoderConfig := mp4Descriptor(0x04, mp4Fields(
+		uint8(0x40), uint8(0x15), // MPEG-4 Audio, AudioStream
+		[]byte{0, 0, 0}, uint32(0), uint32(0), // bufferSizeDB, maxBitrate, avgBitrate
+		decoderSpecific,
+	))
+	esDescriptor := mp4Descriptor(0x03, mp4Fields(
+		uint16(0), uint8(0), // ES_ID, flags
+		decoderConfig,
+		mp4Descriptor(0x06, []byte{0x02}), // SLConfigDescriptor
+	))
+
+	channels := uint16(m.adts.Channels)
+	if channels == 0 {
+		channels = 2
+	}
+	return mp4Box("mp4a", mp4Fields(
+		make([]byte, 6), uint16(1),
+		make([]byte, 8),
+		channels, uint16(16), // channelcount, samplesize
+		uint16(0), uint16(0),
+		uint32(m.adts.sampleRate())<<16,
+	), mp4FullBox("esds", 0, 0, esDescriptor))
+}
+
+// mp4Descriptor 生成 esds 中的描述符，长度固定使用4字节编码
+func mp4Descriptor(tag byte, payload []byte) []byte {
+	n := len(payload)
+	return append([]byte{tag, 0x80 | byte(n>>21), 0x80 | byte(n>>14), 0x80 | byte(n>>7), byte(n & 0x7F)}, payload...)
+}
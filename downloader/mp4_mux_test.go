@@ -0,0 +1,243 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	muxTestVideoPID = 0x100
+	muxTestAudioPID = 0x101
+	// muxTestAudioFrame 测试音频帧的负载长度（不含 ADTS 头）
+	muxTestAudioFrame = 100
+)
+
+// muxTestSPS 320x240 Baseline 档次的 SPS
+var muxTestSPS = []byte{0x67, 0x42, 0xc0, 0x1e, 0xda, 0x05, 0x07, 0xe4}
+
+// muxTestStream 生成测试用 TS 流，记录各 PID 的连续计数器
+type muxTestStream struct {
+	buf bytes.Buffer
+	cc  map[uint16]byte
+}
+
+func newMuxTestStream(streams []tsStream) *muxTestStream {
+	s := &muxTestStream{cc: make(map[uint16]byte)}
+	s.buf.Write(psiPacket(tsPATPID, 0, buildPAT(muxPMTPID)))
+	s.buf.Write(psiPacket(muxPMTPID, 0, buildPMT(streams[0].PID, streams)))
+	return s
+}
+
+// writePES 将一个 PES 包拆分为 TS 包，最后一个包用自适应字段填充
+func (s *muxTestStream) writePES(pid uint16, streamID byte, pts int64, payload []byte) {
+	pes := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0x80, 5}
+	pes = append(pes,
+		0x21|byte(pts>>29)&0x0E, byte(pts>>22), byte(pts>>14)|1, byte(pts>>7), byte(pts<<1)|1)
+	pes = append(pes, payload...)
+	if streamID != 0xE0 {
+		binary.BigEndian.PutUint16(pes[4:6], uint16(len(pes)-6))
+	}
+
+	for start := true; len(pes) > 0; start = false {
+		pkt := make([]byte, 4, tsPacketSize)
+		pkt[0] = tsSyncByte
+		binary.BigEndian.PutUint16(pkt[1:3], pid)
+		if start {
+			pkt[1] |= 0x40
+		}
+		pkt[3] = 0x10 | s.cc[pid]&0x0F
+		s.cc[pid]++
+
+		n := len(pes)
+		if n > tsPacketSize-4 {
+			n = tsPacketSize - 4
+		} else if n < tsPacketSize-4 {
+			pkt[3] |= 0x20
+			stuffing := tsPacketSize - 5 - n
+			pkt = append(pkt, byte(stuffing))
+			if stuffing > 0 {
+				pkt = append(pkt, 0x00)
+				pkt = append(pkt, bytes.Repeat([]byte{0xFF}, stuffing-1)...)
+			}
+		}
+		s.buf.Write(append(pkt, pes[:n]...))
+		pes = pes[n:]
+	}
+}
+
+// muxTestVideoFrame 生成一帧 Annex B 格式的 H.264 数据，关键帧前附带参数集
+func muxTestVideoFrame(keyframe bool) []byte {
+	startCode := []byte{0, 0, 0, 1}
+	var frame []byte
+	if keyframe {
+		frame = append(frame, startCode...)
+		frame = append(frame, muxTestSPS...)
+		frame = append(frame, startCode...)
+		frame = append(frame, 0x68, 0xce, 0x38, 0x80)
+		frame = append(frame, startCode...)
+		frame = append(frame, 0x65)
+	} else {
+		frame = append(frame, startCode...)
+		frame = append(frame, 0x41)
+	}
+	return append(frame, bytes.Repeat([]byte{0x88}, 300)...)
+}
+
+// muxTestAudioFrames 生成 count 个 44.1kHz 双声道 AAC LC 的 ADTS 帧
+func muxTestAudioFrames(count int) []byte {
+	size := 7 + muxTestAudioFrame
+	var data []byte
+	for i := 0; i < count; i++ {
+		data = append(data,
+			0xFF, 0xF1, 0x01<<6|4<<2, 2<<6|byte(size>>11), byte(size>>3), byte(size)<<5|0x1F, 0xFC)
+		data = append(data, bytes.Repeat([]byte{byte(i + 1)}, muxTestAudioFrame)...)
+	}
+	return data
+}
+
+// mp4Boxes 返回 data 中所有类型为 boxType 的顶层 box 的内容
+func mp4Boxes(t *testing.T, data []byte, boxType string) [][]byte {
+	t.Helper()
+	var found [][]byte
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("truncated box header")
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatalf("invalid %q box size %d", data[4:8], size)
+		}
+		if string(data[4:8]) == boxType {
+			found = append(found, data[8:size])
+		}
+		data = data[size:]
+	}
+	return found
+}
+
+// mp4Path 按路径查找第一个匹配的 box
+func mp4Path(t *testing.T, data []byte, path ...string) []byte {
+	t.Helper()
+	for _, boxType := range path {
+		boxes := mp4Boxes(t, data, boxType)
+		if len(boxes) == 0 {
+			t.Fatalf("box %q not found", boxType)
+		}
+		data = boxes[0]
+	}
+	return data
+}
+
+func TestRemuxToMP4(t *testing.T) {
+	const videoFrames, audioFrames = 25, 40
+	s := newMuxTestStream([]tsStream{
+		{Type: streamTypeH264, PID: muxTestVideoPID},
+		{Type: streamTypeAAC, PID: muxTestAudioPID},
+	})
+
+	// 音频按固定大小切分，帧会跨越 PES 包
+	audio := muxTestAudioFrames(audioFrames)
+	const chunk = 250
+	for i := 0; i < videoFrames; i++ {
+		pts := int64(90000 + i*3600)
+		s.writePES(muxTestVideoPID, 0xE0, pts, muxTestVideoFrame(i == 0))
+		if start := i * chunk; start < len(audio) {
+			end := start + chunk
+			if end > len(audio) {
+				end = len(audio)
+			}
+			s.writePES(muxTestAudioPID, 0xC0, pts, audio[start:end])
+		}
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.mp4")
+	err := remuxToMP4(func(w io.Writer) error {
+		_, err := w.Write(s.buf.Bytes())
+		return err
+	}, dir, out)
+	if err != nil {
+		t.Fatalf("remuxToMP4: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// moov 在 mdat 之前
+	var order []string
+	for rest := data; len(rest) >= 8; rest = rest[binary.BigEndian.Uint32(rest):] {
+		order = append(order, string(rest[4:8]))
+	}
+	if len(order) != 3 || order[0] != "ftyp" || order[1] != "moov" || order[2] != "mdat" {
+		t.Fatalf("top-level boxes = %v, want [ftyp moov mdat]", order)
+	}
+	mdat := mp4Path(t, data, "mdat")
+	mdatStart := len(data) - len(mdat)
+
+	counts := make(map[string]int)
+	var mdatUsed int
+	for _, trak := range mp4Boxes(t, mp4Path(t, data, "moov"), "trak") {
+		handler := string(mp4Path(t, trak, "mdia", "hdlr")[8:12])
+		stbl := mp4Path(t, trak, "mdia", "minf", "stbl")
+		stsz := mp4Path(t, stbl, "stsz")
+		count := int(binary.BigEndian.Uint32(stsz[8:12]))
+		counts[handler] = count
+		for i := 0; i < count; i++ {
+			mdatUsed += int(binary.BigEndian.Uint32(stsz[12+4*i:]))
+		}
+
+		stco := mp4Path(t, stbl, "stco")
+		first := int(binary.BigEndian.Uint32(stco[8:12]))
+		if first < mdatStart || first >= len(data) {
+			t.Fatalf("%s chunk offset %d outside mdat", handler, first)
+		}
+		switch handler {
+		case "vide":
+			avc1 := mp4Path(t, mp4Path(t, stbl, "stsd")[8:], "avc1")
+			if w, h := binary.BigEndian.Uint16(avc1[24:]), binary.BigEndian.Uint16(avc1[26:]); w != 320 || h != 240 {
+				t.Errorf("video size = %dx%d, want 320x240", w, h)
+			}
+			if stss := mp4Path(t, stbl, "stss"); binary.BigEndian.Uint32(stss[4:8]) != 1 {
+				t.Errorf("sync samples = %d, want 1", binary.BigEndian.Uint32(stss[4:8]))
+			}
+			// 样本为长度前缀格式，参数集只保存在 avcC 中
+			if nal := data[first+4]; nal != 0x65 {
+				t.Errorf("first video sample starts with NAL 0x%02X, want IDR", nal)
+			}
+		case "soun":
+			if got := data[first : first+muxTestAudioFrame]; !bytes.Equal(got, bytes.Repeat([]byte{1}, muxTestAudioFrame)) {
+				t.Error("first audio sample still carries the ADTS header")
+			}
+		}
+	}
+	if counts["vide"] != videoFrames || counts["soun"] != audioFrames {
+		t.Errorf("sample counts = %v, want %d video and %d audio", counts, videoFrames, audioFrames)
+	}
+	if mdatUsed != len(mdat) {
+		t.Errorf("samples cover %d bytes, mdat holds %d", mdatUsed, len(mdat))
+	}
+}
+
+func TestRemuxToMP4WithoutVideo(t *testing.T) {
+	s := newMuxTestStream([]tsStream{{Type: streamTypeAAC, PID: muxTestAudioPID}})
+	s.writePES(muxTestAudioPID, 0xC0, 90000, muxTestAudioFrames(4))
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.mp4")
+	err := remuxToMP4(func(w io.Writer) error {
+		_, err := w.Write(s.buf.Bytes())
+		return err
+	}, dir, out)
+	if !errors.Is(err, errUnsupportedStream) {
+		t.Fatalf("remuxToMP4 = %v, want errUnsupportedStream", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("output file created for a failed remux: %v", err)
+	}
+}
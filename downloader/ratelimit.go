@@ -0,0 +1,254 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLimiterWait 单次等待令牌的最长时间，限速在运行中调整后能很快生效
+const maxLimiterWait = 100 * time.Millisecond
+
+// ScheduleRule 按时段限速的一条规则，[Start, End) 为一天中的分钟数，End 小于 Start 时跨越午夜
+type ScheduleRule struct {
+	Start int
+	End   int
+	Limit int64 // 每秒字节数，0 表示不限速
+}
+
+// contains 判断一天中的第 minute 分钟是否在该时段内
+func (r ScheduleRule) contains(minute int) bool {
+	if r.Start <= r.End {
+		return minute >= r.Start && minute < r.End
+	}
+	return minute >= r.Start || minute < r.End
+}
+
+func (r ScheduleRule) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d=%s", r.Start/60, r.Start%60, r.End/60, r.End%60, FormatRate(r.Limit))
+}
+
+// RateLimiter 令牌桶限速器，同一个限速器下的所有下载共享带宽。
+// 令牌不足时允许透支，之后的读取等待令牌恢复为正数，平均速度不超过限速
+type RateLimiter struct {
+	mu       sync.Mutex
+	limit    int64 // 不在时段规则内时的限速
+	schedule []ScheduleRule
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+// NewRateLimiter 创建限速器，limit 为每秒字节数，0 表示不限速
+func NewRateLimiter(limit int64) *RateLimiter {
+	return &RateLimiter{limit: limit, now: time.Now}
+}
+
+// globalLimiter 所有下载器默认共享的限速器
+var globalLimiter = NewRateLimiter(0)
+
+// GlobalLimiter 返回所有下载器默认共享的限速器，修改会立即作用于正在进行的下载
+func GlobalLimiter() *RateLimiter {
+	return globalLimiter
+}
+
+// SetLimit 设置不在时段规则内时的限速，0 表示不限速
+func (l *RateLimiter) SetLimit(limit int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+}
+
+// SetSchedule 设置按时段限速的规则，按顺序使用第一条匹配的规则
+func (l *RateLimiter) SetSchedule(rules []ScheduleRule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.schedule = append([]ScheduleRule(nil), rules...)
+}
+
+// Schedule 返回按时段限速的规则
+func (l *RateLimiter) Schedule() []ScheduleRule {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ScheduleRule(nil), l.schedule...)
+}
+
+// BaseLimit 返回不在时段规则内时的限速
+func (l *RateLimiter) BaseLimit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// Limit 返回当前生效的限速（考虑时段规则），0 表示不限速
+func (l *RateLimiter) Limit() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.currentLimit(l.now())
+}
+
+func (l *RateLimiter) currentLimit(now time.Time) int64 {
+	minute := now.Hour()*60 + now.Minute()
+	for _, rule := range l.schedule {
+		if rule.contains(minute) {
+			return rule.Limit
+		}
+	}
+	return l.limit
+}
+
+// WaitN 读取 n 字节后调用，按限速等待
+func (l *RateLimiter) WaitN(n int) {
+	_ = l.WaitNContext(context.Background(), n)
+}
+
+// WaitNContext 与 WaitN 相同，ctx 结束时停止等待并返回错误
+func (l *RateLimiter) WaitNContext(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		now := l.now()
+		limit := l.currentLimit(now)
+		if limit <= 0 {
+			l.tokens, l.last = 0, time.Time{}
+			l.mu.Unlock()
+			return nil
+		}
+
+		// 补充令牌，桶的容量为一秒的流量
+		if l.last.IsZero() {
+			l.tokens = float64(limit)
+		} else {
+			l.tokens += now.Sub(l.last).Seconds() * float64(limit)
+			if l.tokens > float64(limit) {
+				l.tokens = float64(limit)
+			}
+		}
+		l.last = now
+
+		if l.tokens > 0 {
+			l.tokens -= float64(n)
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration(-l.tokens / float64(limit) * float64(time.Second))
+		l.mu.Unlock()
+
+		if wait > maxLimiterWait {
+			wait = maxLimiterWait
+		}
+		if wait < time.Millisecond {
+			wait = time.Millisecond
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// ParseRate 解析速度，如 "2MB"、"512KB"、"1.5M"、"0"（不限速），不带单位时为字节
+func ParseRate(rate string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(rate))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/S"), "B")
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier, s = 1024, strings.TrimSuffix(s, "K")
+	case strings.HasSuffix(s, "M"):
+		multiplier, s = 1024*1024, strings.TrimSuffix(s, "M")
+	case strings.HasSuffix(s, "G"):
+		multiplier, s = 1024*1024*1024, strings.TrimSuffix(s, "G")
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("无效的速度: %q（示例: 2MB、512KB、0 表示不限速）", rate)
+	}
+	return int64(value * multiplier), nil
+}
+
+// FormatRate 将每秒字节数格式化为 ParseRate 接受的格式，0 表示不限速
+func FormatRate(limit int64) string {
+	switch {
+	case limit <= 0:
+		return "0"
+	case limit >= 1024*1024 && limit*100%(1024*1024) == 0:
+		return strconv.FormatFloat(float64(limit)/1024/1024, 'f', -1, 64) + "MB"
+	case limit >= 1024 && limit%1024 == 0:
+		return strconv.FormatInt(limit/1024, 10) + "KB"
+	}
+	return strconv.FormatInt(limit, 10) + "B"
+}
+
+// parseClock 解析 "HH:MM"，返回一天中的分钟数（"24:00" 为 1440）
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		if strings.TrimSpace(s) == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("无效的时间: %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseSchedule 解析按时段限速的规则，格式为逗号分隔的 "开始-结束=速度"，
+// 如 "09:00-18:00=2MB,18:00-09:00=0"；结束早于开始时表示跨越午夜
+func ParseSchedule(s string) ([]ScheduleRule, error) {
+	var rules []ScheduleRule
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		span, rate, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("无效的时段规则 %q（示例: 09:00-18:00=2MB）", item)
+		}
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("无效的时段规则 %q（示例: 09:00-18:00=2MB）", item)
+		}
+
+		var rule ScheduleRule
+		var err error
+		if rule.Start, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if rule.End, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		if rule.Start == rule.End {
+			return nil, fmt.Errorf("时段 %q 的开始和结束时间相同", span)
+		}
+		if rule.Limit, err = ParseRate(rate); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// withLimit 在进度中的速度后标注当前生效的限速
+func (l *RateLimiter) withLimit(speed string) string {
+	if limit := l.Limit(); limit > 0 {
+		return fmt.Sprintf("%s (限速 %s/s)", speed, FormatRate(limit))
+	}
+	return speed
+}
+
+// FormatSchedule 将时段规则格式化为 ParseSchedule 接受的格式
+func FormatSchedule(rules []ScheduleRule) string {
+	parts := make([]string, len(rules))
+	for i, rule := range rules {
+		parts[i] = rule.String()
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"2MB", 2 << 20},
+		{"2mb/s", 2 << 20},
+		{"1.5M", 3 << 19},
+		{"512KB", 512 << 10},
+		{"1000", 1000},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+		if back, _ := ParseRate(FormatRate(got)); back != got {
+			t.Errorf("FormatRate(%d) = %q does not round-trip", got, FormatRate(got))
+		}
+	}
+	for _, bad := range []string{"", "fast", "-1MB"} {
+		if _, err := ParseRate(bad); err == nil {
+			t.Errorf("ParseRate(%q) should fail", bad)
+		}
+	}
+}
+
+func TestScheduleLimit(t *testing.T) {
+	rules, err := ParseSchedule("09:00-18:00=2MB, 22:00-07:00=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FormatSchedule(rules); got != "09:00-18:00=2MB,22:00-07:00=0" {
+		t.Errorf("FormatSchedule = %q", got)
+	}
+
+	l := NewRateLimiter(512 << 10)
+	l.SetSchedule(rules)
+	for _, tt := range []struct {
+		clock string
+		want  int64
+	}{
+		{"08:59", 512 << 10},
+		{"09:00", 2 << 20},
+		{"17:59", 2 << 20},
+		{"18:00", 512 << 10},
+		{"23:30", 0},
+		{"03:00", 0},
+		{"07:00", 512 << 10},
+	} {
+		now, _ := time.Parse("15:04", tt.clock)
+		l.now = func() time.Time { return now }
+		if got := l.Limit(); got != tt.want {
+			t.Errorf("limit at %s = %d, want %d", tt.clock, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"09:00-18:00", "9-18=1MB", "09:00-09:00=1MB", "09:00-18:00=x"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("ParseSchedule(%q) should fail", bad)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	const limit = 256 << 10
+	l := NewRateLimiter(limit)
+
+	// 第一秒的流量来自初始令牌，之后按限速等待
+	start := time.Now()
+	for read := 0; read < 2*limit; read += 32 << 10 {
+		l.WaitN(32 << 10)
+	}
+	elapsed := time.Since(start)
+	if elapsed < 800*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("reading 2x the limit took %v, want about 1s", elapsed)
+	}
+
+	// 运行中取消限速后立即恢复
+	l.SetLimit(0)
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		l.WaitN(1 << 20)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("unlimited reads took %v", elapsed)
+	}
+}
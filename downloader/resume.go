@@ -0,0 +1,231 @@
+package downloader
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+// resumeStateSuffix 断点续传状态文件的后缀，状态文件与目标文件放在同一目录
+const resumeStateSuffix = ".dlstate"
+
+// resumeSaveInterval 下载过程中保存状态文件的间隔
+const resumeSaveInterval = time.Second
+
+// byteRange 多线程下载的一个分片，[Start, End] 闭区间，Done 为从 Start 起已写入的字节数
+type byteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+// resumeState 断点续传状态，普通文件记录分片进度，M3U8 记录已完成的 TS 序号
+type resumeState struct {
+	URL           string       `json:"url"`
+	ContentLength int64        `json:"contentLength,omitempty"`
+	ETag          string       `json:"etag,omitempty"`
+	LastModified  string       `json:"lastModified,omitempty"`
+	Parts         []*byteRange `json:"parts,omitempty"`
+	SegmentCount  int          `json:"segmentCount,omitempty"`
+	Segments      []int        `json:"segments,omitempty"`
+
+	path string
+	mu   sync.Mutex
+}
+
+func resumeStatePath(filePath string) string {
+	return filePath + resumeStateSuffix
+}
+
+// HasResumeState 判断文件是否有未完成的下载（存在断点续传状态文件）
+func HasResumeState(filePath string) bool {
+	return utils.IsFileExists(resumeStatePath(filePath))
+}
+
+func newResumeState(filePath, sourceURL string) *resumeState {
+	return &resumeState{
+		URL:  sourceURL,
+		path: resumeStatePath(filePath),
+	}
+}
+
+// loadResumeState 读取状态文件，不存在或无法解析时返回 nil
+func loadResumeState(filePath string) *resumeState {
+	path := resumeStatePath(filePath)
+	data, err := os.ReadFile(utils.GetAndroidSafeFilePath(path))
+	if err != nil {
+		return nil
+	}
+
+	state := &resumeState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil
+	}
+	state.path = path
+	return state
+}
+
+func removeResumeState(filePath string) {
+	_ = os.Remove(utils.GetAndroidSafeFilePath(resumeStatePath(filePath)))
+}
+
+// save 先写入临时文件再重命名，避免中途退出留下损坏的状态文件
+func (s *resumeState) save() error {
+	s.mu.Lock()
+	data, err := json.Marshal(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	actualPath := utils.GetAndroidSafeFilePath(s.path)
+	tmpPath := actualPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, actualPath)
+}
+
+// autoSave 定期保存状态，返回的函数停止保存并立即写入一次
+func (s *resumeState) autoSave() func() {
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+
+	go func() {
+		defer close(doneChan)
+		ticker := time.NewTicker(resumeSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = s.save()
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stopChan)
+		<-doneChan
+		_ = s.save()
+	}
+}
+
+// sameSource 比较两个地址是否指向同一资源，忽略签名等查询参数
+func sameSource(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return ua.Host == ub.Host && ua.Path == ub.Path
+}
+
+// matchesRemote 判断状态文件是否对应同一个远程文件
+func (s *resumeState) matchesRemote(remote *resumeState) bool {
+	if len(s.Parts) == 0 || s.ContentLength != remote.ContentLength || !sameSource(s.URL, remote.URL) {
+		return false
+	}
+	if s.ETag != "" && remote.ETag != "" {
+		return s.ETag == remote.ETag
+	}
+	if s.LastModified != "" && remote.LastModified != "" {
+		return s.LastModified == remote.LastModified
+	}
+	return true
+}
+
+// matchesPlaylist 判断状态文件是否对应同一个播放列表
+func (s *resumeState) matchesPlaylist(playlistURL string, segmentCount int) bool {
+	return s.SegmentCount == segmentCount && sameSource(s.URL, playlistURL)
+}
+
+// splitParts 将文件平均分成 n 个分片
+func splitParts(totalSize int64, n int) []*byteRange {
+	partSize := totalSize / int64(n)
+	parts := make([]*byteRange, 0, n)
+	for i := 0; i < n; i++ {
+		start := int64(i) * partSize
+		end := start + partSize - 1
+		if i == n-1 {
+			end = totalSize - 1
+		}
+		parts = append(parts, &byteRange{Start: start, End: end})
+	}
+	return parts
+}
+
+// completedBytes 已下载的总字节数
+func (s *resumeState) completedBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total int64
+	for _, part := range s.Parts {
+		total += part.Done
+	}
+	return total
+}
+
+// partOffset 分片下一个待下载的字节位置
+func (s *resumeState) partOffset(part *byteRange) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return part.Start + part.Done
+}
+
+// advance 记录分片新写入的字节数
+func (s *resumeState) advance(part *byteRange, n int64) {
+	s.mu.Lock()
+	part.Done += n
+	s.mu.Unlock()
+}
+
+// resetPartAt 将包含 offset 的分片标记为未下载，返回该分片
+func (s *resumeState) resetPartAt(offset int64) *byteRange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, part := range s.Parts {
+		if offset >= part.Start && offset <= part.End {
+			part.Done = 0
+			return part
+		}
+	}
+	return nil
+}
+
+// completedSegments 已完成的 TS 序号
+func (s *resumeState) completedSegments() map[int]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	done := make(map[int]bool, len(s.Segments))
+	for _, idx := range s.Segments {
+		done[idx] = true
+	}
+	return done
+}
+
+// markSegment 记录一个已完成的 TS 序号
+func (s *resumeState) markSegment(idx int) {
+	s.mu.Lock()
+	s.Segments = append(s.Segments, idx)
+	s.mu.Unlock()
+}
+
+// unmarkSegment 将 TS 序号标记为未完成（校验失败需要重新下载）
+func (s *resumeState) unmarkSegment(idx int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, done := range s.Segments {
+		if done == idx {
+			s.Segments = append(s.Segments[:i], s.Segments[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package downloader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// errUnsupportedStream TS 流中的编码无法封装为 MP4（仅支持 H.264 视频和 AAC 音频）
+var errUnsupportedStream = errors.New("不支持的音视频编码")
+
+// PMT 中的流类型
+const (
+	streamTypeMPEG1Video = 0x01
+	streamTypeMPEG2Video = 0x02
+	streamTypeMPEG1Audio = 0x03
+	streamTypeMPEG2Audio = 0x04
+	streamTypeAAC        = 0x0F
+	streamTypeMPEG4Video = 0x10
+	streamTypeH264       = 0x1B
+	streamTypeHEVC       = 0x24
+	streamTypeAC3        = 0x81
+	streamTypeEAC3       = 0x87
+)
+
+// pesPacket 一个完整的 PES 包
+type pesPacket struct {
+	PTS     int64 // 90kHz 时间戳，缺失时为 -1
+	DTS     int64
+	Payload []byte
+}
+
+// tsDemuxer 从 TS 流中提取 H.264 视频和 AAC 音频的 PES 包
+type tsDemuxer struct {
+	pmtPID   uint16
+	videoPID uint16 // 0 表示没有该类型的流（PID 0 固定为 PAT）
+	audioPID uint16
+	buffers  map[uint16][]byte
+
+	onVideo func(*pesPacket) error
+	onAudio func(*pesPacket) error
+}
+
+func newTSDemuxer(onVideo, onAudio func(*pesPacket) error) *tsDemuxer {
+	return &tsDemuxer{
+		pmtPID:  0x1FFF,
+		buffers: make(map[uint16][]byte),
+		onVideo: onVideo,
+		onAudio: onAudio,
+	}
+}
+
+// run 读取整个 TS 流，末尾不完整的包会被忽略
+func (dm *tsDemuxer) run(r io.Reader) error {
+	br := bufio.NewReaderSize(r, 1<<20)
+	pkt := make([]byte, tsPacketSize)
+	for {
+		if _, err := io.ReadFull(br, pkt); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		if pkt[0] != tsSyncByte {
+			return fmt.Errorf("无效的TS包")
+		}
+		if err := dm.handlePacket(pkt); err != nil {
+			return err
+		}
+	}
+
+	if dm.videoPID == 0 {
+		return fmt.Errorf("未找到视频流: %w", errUnsupportedStream)
+	}
+	if err := dm.flush(dm.videoPID); err != nil {
+		return err
+	}
+	if dm.audioPID != 0 {
+		return dm.flush(dm.audioPID)
+	}
+	return nil
+}
+
+func (dm *tsDemuxer) handlePacket(pkt []byte) error {
+	pid := packetPID(pkt)
+	switch {
+	case pid == tsPATPID:
+		if section := psiSection(pkt); section != nil {
+			if pmtPID, ok := parsePAT(section); ok {
+				dm.pmtPID = pmtPID
+			}
+		}
+
+	case pid == dm.pmtPID:
+		if section := psiSection(pkt); section != nil {
+			if program, ok := parsePMT(section, pid); ok {
+				return dm.setProgram(program)
+			}
+		}
+
+	case pid != 0 && (pid == dm.videoPID || pid == dm.audioPID):
+		payload := packetPayload(pkt)
+		if pkt[1]&0x40 != 0 {
+			// 新的 PES 包开始，先交付上一个
+			if err := dm.flush(pid); err != nil {
+				return err
+			}
+			dm.buffers[pid] = append(make([]byte, 0, 64*1024), payload...)
+		} else if buf, ok := dm.buffers[pid]; ok {
+			dm.buffers[pid] = append(buf, payload...)
+		}
+	}
+	return nil
+}
+
+// setProgram 根据 PMT 选择视频和音频流，遇到无法封装的编码时返回 errUnsupportedStream
+func (dm *tsDemuxer) setProgram(program *tsProgram) error {
+	var videoPID, audioPID uint16
+	for _, s := range program.Streams {
+		switch s.Type {
+		case streamTypeH264:
+			if videoPID == 0 {
+				videoPID = s.PID
+			}
+		case streamTypeAAC:
+			if audioPID == 0 {
+				audioPID = s.PID
+			}
+		case streamTypeMPEG1Video, streamTypeMPEG2Video, streamTypeMPEG4Video, streamTypeHEVC,
+			streamTypeMPEG1Audio, streamTypeMPEG2Audio, streamTypeAC3, streamTypeEAC3:
+			return fmt.Errorf("流类型 0x%02X: %w", s.Type, errUnsupportedStream)
+		}
+	}
+
+	// 分段之间流的 PID 发生变化时交付旧流中剩余的数据
+	if dm.videoPID != 0 && dm.videoPID != videoPID {
+		if err := dm.flush(dm.videoPID); err != nil {
+			return err
+		}
+	}
+	if dm.audioPID != 0 && dm.audioPID != audioPID {
+		if err := dm.flush(dm.audioPID); err != nil {
+			return err
+		}
+	}
+	dm.videoPID, dm.audioPID = videoPID, audioPID
+	return nil
+}
+
+func (dm *tsDemuxer) flush(pid uint16) error {
+	data, ok := dm.buffers[pid]
+	if !ok {
+		return nil
+	}
+	delete(dm.buffers, pid)
+
+	pes, ok := parsePES(data)
+	if !ok {
+		return nil // 不完整的 PES 包（如流中途开始的部分）
+	}
+	if pid == dm.videoPID {
+		return dm.onVideo(pes)
+	}
+	return dm.onAudio(pes)
+}
+
+// parsePES 解析 PES 包头中的时间戳
+func parsePES(data []byte) (*pesPacket, bool) {
+	if len(data) < 9 || data[0] != 0 || data[1] != 0 || data[2] != 1 {
+		return nil, false
+	}
+	flags := data[7]
+	payloadStart := 9 + int(data[8])
+	if payloadStart > len(data) {
+		return nil, false
+	}
+
+	pes := &pesPacket{PTS: -1, DTS: -1}
+	if flags&0x80 != 0 && len(data) >= 14 {
+		pes.PTS = readPESTimestamp(data[9:14])
+		pes.DTS = pes.PTS
+	}
+	if flags&0xC0 == 0xC0 && len(data) >= 19 {
+		pes.DTS = readPESTimestamp(data[14:19])
+	}
+
+	payload := data[payloadStart:]
+	// PES_packet_length 不为0时以其为准，去掉末尾可能的填充
+	if length := int(data[4])<<8 | int(data[5]); length != 0 && 6+length >= payloadStart && 6+length < len(data) {
+		payload = data[payloadStart : 6+length]
+	}
+	pes.Payload = payload
+	return pes, true
+}
+
+func readPESTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// tsTimestampWrap PES 时间戳为33位，长视频中可能回绕
+const tsTimestampWrap = int64(1) << 33
+
+// unwrapTimestamp 将回绕的时间戳展开为与参考值最接近的值
+func unwrapTimestamp(ts, ref int64) int64 {
+	ts += ref - ref%tsTimestampWrap
+	if ts-ref > tsTimestampWrap/2 {
+		ts -= tsTimestampWrap
+	} else if ref-ts > tsTimestampWrap/2 {
+		ts += tsTimestampWrap
+	}
+	return ts
+}
@@ -0,0 +1,366 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsPATPID     = 0x0000
+	// muxPMTPID 合并音视频时新节目映射表使用的 PID
+	muxPMTPID = 0x0FFF
+)
+
+// tsStream 节目映射表（PMT）中的一路基本流
+type tsStream struct {
+	Type        byte
+	PID         uint16
+	Descriptors []byte
+}
+
+// tsProgram 从 PAT/PMT 中解析出的节目信息
+type tsProgram struct {
+	PMTPID  uint16
+	PCRPID  uint16
+	Streams []tsStream
+}
+
+// tsPart 待合并的一个分段文件及其在时间轴上的起点
+type tsPart struct {
+	Path  string
+	Start float64
+}
+
+func packetPID(pkt []byte) uint16 {
+	return binary.BigEndian.Uint16(pkt[1:3]) & 0x1FFF
+}
+
+// packetPayload 返回 TS 包的负载（跳过自适应字段）
+func packetPayload(pkt []byte) []byte {
+	afc := (pkt[3] >> 4) & 0x3
+	offset := 4
+	if afc == 2 || afc == 0 {
+		return nil
+	}
+	if afc == 3 {
+		offset += 1 + int(pkt[4])
+	}
+	if offset >= tsPacketSize {
+		return nil
+	}
+	return pkt[offset:]
+}
+
+// psiSection 返回包中 PSI 表的内容（仅处理起始于该包的表）
+func psiSection(pkt []byte) []byte {
+	if pkt[1]&0x40 == 0 {
+		return nil
+	}
+	payload := packetPayload(pkt)
+	if len(payload) == 0 {
+		return nil
+	}
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil
+	}
+	section := payload[1+pointer:]
+	length := int(binary.BigEndian.Uint16(section[1:3])&0x0FFF) + 3
+	if length > len(section) {
+		return nil
+	}
+	return section[:length]
+}
+
+// parsePAT 返回节目关联表中第一个节目（跳过 program_number 为0的网络信息）的 PMT PID
+func parsePAT(section []byte) (uint16, bool) {
+	if section[0] != 0x00 || len(section) < 16 {
+		return 0, false
+	}
+	for i := 8; i+4 <= len(section)-4; i += 4 {
+		if binary.BigEndian.Uint16(section[i:i+2]) != 0 {
+			return binary.BigEndian.Uint16(section[i+2:i+4]) & 0x1FFF, true
+		}
+	}
+	return 0, false
+}
+
+// parsePMT 解析节目映射表中的基本流
+func parsePMT(section []byte, pmtPID uint16) (*tsProgram, bool) {
+	if section[0] != 0x02 || len(section) < 16 {
+		return nil, false
+	}
+	program := &tsProgram{
+		PMTPID: pmtPID,
+		PCRPID: binary.BigEndian.Uint16(section[8:10]) & 0x1FFF,
+	}
+	infoLen := int(binary.BigEndian.Uint16(section[10:12]) & 0x0FFF)
+	for i := 12 + infoLen; i+5 <= len(section)-4; {
+		esInfoLen := int(binary.BigEndian.Uint16(section[i+3:i+5]) & 0x0FFF)
+		if i+5+esInfoLen > len(section)-4 {
+			break
+		}
+		program.Streams = append(program.Streams, tsStream{
+			Type:        section[i],
+			PID:         binary.BigEndian.Uint16(section[i+1:i+3]) & 0x1FFF,
+			Descriptors: append([]byte(nil), section[i+5:i+5+esInfoLen]...),
+		})
+		i += 5 + esInfoLen
+	}
+	return program, true
+}
+
+// readTSProgram 从分段文件中读取第一个节目的 PMT
+func readTSProgram(data []byte) (*tsProgram, error) {
+	pmtPID := uint16(0x1FFF)
+	for off := 0; off+tsPacketSize <= len(data); off += tsPacketSize {
+		pkt := data[off : off+tsPacketSize]
+		if pkt[0] != tsSyncByte {
+			return nil, fmt.Errorf("无效的TS包")
+		}
+
+		section := psiSection(pkt)
+		if section == nil {
+			continue
+		}
+
+		switch packetPID(pkt) {
+		case tsPATPID:
+			if pid, ok := parsePAT(section); ok {
+				pmtPID = pid
+			}
+		case pmtPID:
+			if program, ok := parsePMT(section, pmtPID); ok {
+				return program, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("未找到节目映射表")
+}
+
+// crc32MPEG2 PSI 表使用的 CRC32（多项式 0x04C11DB7，不反转）
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// psiPacket 将 PSI 表封装为单个 TS 包
+func psiPacket(pid uint16, cc byte, section []byte) []byte {
+	pkt := make([]byte, tsPacketSize)
+	pkt[0] = tsSyncByte
+	binary.BigEndian.PutUint16(pkt[1:3], 0x4000|pid) // payload_unit_start_indicator
+	pkt[3] = 0x10 | (cc & 0x0F)
+	pkt[4] = 0 // pointer_field
+	n := copy(pkt[5:], section)
+	for i := 5 + n; i < tsPacketSize; i++ {
+		pkt[i] = 0xFF
+	}
+	return pkt
+}
+
+// finishSection 填写表长度并追加 CRC
+func finishSection(section []byte) []byte {
+	length := len(section) - 3 + 4
+	section[1] = 0xB0 | byte(length>>8)
+	section[2] = byte(length)
+	return binary.BigEndian.AppendUint32(section, crc32MPEG2(section))
+}
+
+func buildPAT(pmtPID uint16) []byte {
+	section := []byte{
+		0x00, 0, 0, // table_id, section_length（稍后填写）
+		0x00, 0x01, // transport_stream_id
+		0xC1,       // version 0, current_next_indicator
+		0x00, 0x00, // section_number, last_section_number
+		0x00, 0x01, // program_number
+		0xE0 | byte(pmtPID>>8), byte(pmtPID),
+	}
+	return finishSection(section)
+}
+
+func buildPMT(pcrPID uint16, streams []tsStream) []byte {
+	section := []byte{
+		0x02, 0, 0, // table_id, section_length（稍后填写）
+		0x00, 0x01, // program_number
+		0xC1,
+		0x00, 0x00,
+		0xE0 | byte(pcrPID>>8), byte(pcrPID),
+		0xF0, 0x00, // program_info_length
+	}
+	for _, s := range streams {
+		section = append(section,
+			s.Type,
+			0xE0|byte(s.PID>>8), byte(s.PID),
+			0xF0|byte(len(s.Descriptors)>>8), byte(len(s.Descriptors)))
+		section = append(section, s.Descriptors...)
+	}
+	return finishSection(section)
+}
+
+// tsMuxer 将独立的视频和音频分段合并为一个 TS 文件
+type tsMuxer struct {
+	out      io.Writer
+	pat, pmt []byte
+	patCC    byte
+	pmtCC    byte
+	// 音频基本流的 PID 映射（与视频 PID 冲突时重新分配）
+	audioPIDs map[uint16]uint16
+	videoPMT  uint16
+}
+
+// newTSMuxer 根据视频和音频的节目信息生成合并后的 PAT/PMT
+func newTSMuxer(out io.Writer, video, audio *tsProgram) *tsMuxer {
+	m := &tsMuxer{
+		out:       out,
+		audioPIDs: make(map[uint16]uint16),
+		videoPMT:  video.PMTPID,
+	}
+
+	used := map[uint16]bool{tsPATPID: true, muxPMTPID: true}
+	streams := make([]tsStream, 0, len(video.Streams)+len(audio.Streams))
+	for _, s := range video.Streams {
+		used[s.PID] = true
+		streams = append(streams, s)
+	}
+
+	next := uint16(0x0200)
+	for _, s := range audio.Streams {
+		pid := s.PID
+		for used[pid] {
+			pid = next
+			next++
+		}
+		used[pid] = true
+		m.audioPIDs[s.PID] = pid
+		s.PID = pid
+		streams = append(streams, s)
+	}
+
+	m.pat = buildPAT(muxPMTPID)
+	m.pmt = buildPMT(video.PCRPID, streams)
+	return m
+}
+
+func (m *tsMuxer) writeTables() error {
+	if _, err := m.out.Write(psiPacket(tsPATPID, m.patCC, m.pat)); err != nil {
+		return err
+	}
+	m.patCC++
+	if _, err := m.out.Write(psiPacket(muxPMTPID, m.pmtCC, m.pmt)); err != nil {
+		return err
+	}
+	m.pmtCC++
+	return nil
+}
+
+// writeSegment 写入一个分段的基本流数据，丢弃原有的 PAT/PMT
+func (m *tsMuxer) writeSegment(data []byte, isAudio bool) error {
+	if err := m.writeTables(); err != nil {
+		return err
+	}
+
+	for off := 0; off+tsPacketSize <= len(data); off += tsPacketSize {
+		pkt := data[off : off+tsPacketSize]
+		if pkt[0] != tsSyncByte {
+			return fmt.Errorf("无效的TS包")
+		}
+
+		pid := packetPID(pkt)
+		if pid == tsPATPID || (!isAudio && pid == m.videoPMT) {
+			continue
+		}
+
+		if isAudio {
+			newPID, ok := m.audioPIDs[pid]
+			if !ok {
+				continue // 音频分段中的其他数据（如 SDT）
+			}
+			if newPID != pid {
+				pkt = append([]byte(nil), pkt...)
+				pkt[1] = pkt[1]&0xE0 | byte(newPID>>8)
+				pkt[2] = byte(newPID)
+			}
+		}
+
+		if _, err := m.out.Write(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// muxTSFiles 按时间顺序交错合并视频和音频分段，写出同时包含音视频的 TS 流
+func muxTSFiles(video, audio []tsPart, out io.Writer) error {
+	if len(video) == 0 || len(audio) == 0 {
+		return fmt.Errorf("没有可合并的分段")
+	}
+
+	readPart := func(part tsPart) ([]byte, error) {
+		return os.ReadFile(utils.GetAndroidSafeFilePath(part.Path))
+	}
+
+	firstVideo, err := readPart(video[0])
+	if err != nil {
+		return err
+	}
+	videoProgram, err := readTSProgram(firstVideo)
+	if err != nil {
+		return fmt.Errorf("读取视频节目信息失败: %v", err)
+	}
+	firstAudio, err := readPart(audio[0])
+	if err != nil {
+		return err
+	}
+	audioProgram, err := readTSProgram(firstAudio)
+	if err != nil {
+		return fmt.Errorf("读取音频节目信息失败: %v", err)
+	}
+
+	type muxItem struct {
+		part    tsPart
+		isAudio bool
+	}
+	items := make([]muxItem, 0, len(video)+len(audio))
+	for _, p := range video {
+		items = append(items, muxItem{part: p})
+	}
+	for _, p := range audio {
+		items = append(items, muxItem{part: p, isAudio: true})
+	}
+	// 起点相同时视频在前，保证播放器先拿到视频的 PCR
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].part.Start != items[j].part.Start {
+			return items[i].part.Start < items[j].part.Start
+		}
+		return !items[i].isAudio && items[j].isAudio
+	})
+
+	muxer := newTSMuxer(out, videoProgram, audioProgram)
+	for _, item := range items {
+		data, err := readPart(item.part)
+		if err != nil {
+			return err
+		}
+		if err := muxer.writeSegment(data, item.isAudio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+// verifyRounds 下载完成后校验失败时重新获取损坏部分的次数
+const verifyRounds = 2
+
+// CorruptError 下载的数据没有通过校验（长度不符、MP4/TS 结构损坏、缺少分段），
+// 任务状态为 "corrupt"
+type CorruptError struct {
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return "文件校验失败: " + e.Reason
+}
+
+// Corrupt 供不依赖本包的代码（如下载记录）识别校验失败
+func (e *CorruptError) Corrupt() bool {
+	return true
+}
+
+func corruptf(format string, args ...interface{}) error {
+	return &CorruptError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// IsCorrupt 判断错误是否为校验失败
+func IsCorrupt(err error) bool {
+	var ce *CorruptError
+	return errors.As(err, &ce)
+}
+
+// mp4ContainerBoxes 需要检查子 box 的容器
+var mp4ContainerBoxes = map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true, "moof": true, "traf": true}
+
+// looksLikeMP4 判断文件开头是否为 ftyp box，不是 MP4 的文件不做结构检查
+func looksLikeMP4(r io.ReaderAt) bool {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return false
+	}
+	return string(header[4:8]) == "ftyp"
+}
+
+// looksLikeMP4File 判断本地文件是否为 MP4
+func looksLikeMP4File(filePath string) bool {
+	file, err := os.Open(utils.GetAndroidSafeFilePath(filePath))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return looksLikeMP4(file)
+}
+
+// verifyMP4 检查 MP4 的 box 结构：box 必须首尾相接且恰好到达文件末尾，
+// 并包含 ftyp、moov 和 mdat。失败时返回出错位置，用于重新下载所在的分片
+func verifyMP4(r io.ReaderAt, size int64) (int64, error) {
+	seen := make(map[string]bool)
+	if off, err := verifyBoxes(r, 0, size, seen); err != nil {
+		return off, err
+	}
+	for _, box := range []string{"ftyp", "moov", "mdat"} {
+		if !seen[box] {
+			return size, corruptf("MP4 缺少 %s", box)
+		}
+	}
+	return 0, nil
+}
+
+func verifyBoxes(r io.ReaderAt, start, end int64, seen map[string]bool) (int64, error) {
+	var header [16]byte
+	for off := start; off < end; {
+		if end-off < 8 {
+			return off, corruptf("MP4 在 %d 处有多余的数据", off)
+		}
+		if _, err := r.ReadAt(header[:8], off); err != nil {
+			return off, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = end - off // 延伸到末尾
+		case 1:
+			if _, err := r.ReadAt(header[8:16], off+8); err != nil {
+				return off, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize || off+boxSize > end {
+			return off, corruptf("MP4 在 %d 处的 box %q 大小无效", off, boxType)
+		}
+		if start == 0 {
+			seen[boxType] = true
+		}
+		if mp4ContainerBoxes[boxType] {
+			if bad, err := verifyBoxes(r, off+headerSize, off+boxSize, seen); err != nil {
+				return bad, err
+			}
+		}
+		off += boxSize
+	}
+	return 0, nil
+}
+
+// verifyMP4File 检查本地 MP4 文件的结构
+func verifyMP4File(filePath string) error {
+	file, err := os.Open(utils.GetAndroidSafeFilePath(filePath))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = verifyMP4(file, info.Size())
+	return err
+}
+
+// zeroRunLimit 回放数据中连续为0的字节达到此长度时，认为是多线程下载预先分配、尚未写入的区域
+const zeroRunLimit = 4096
+
+// verifyMP4Payload 检查本地 MP4 文件的结构，并检查 mdat 中没有预先分配、尚未写入的区域。
+// 多线程下载会先把文件扩展到完整大小，中断后 moov 在前的文件结构完整，只有数据中留有成段的0
+func verifyMP4Payload(filePath string) error {
+	file, err := os.Open(utils.GetAndroidSafeFilePath(filePath))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if _, err := verifyMP4(file, size); err != nil {
+		return err
+	}
+	var header [16]byte
+	for off := int64(0); off < size; {
+		// 结构已经检查过，这里只按顶层 box 的大小跳转
+		if _, err := file.ReadAt(header[:8], off); err != nil {
+			return err
+		}
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - off
+		case 1:
+			if _, err := file.ReadAt(header[8:16], off+8); err != nil {
+				return err
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if string(header[4:8]) == "mdat" {
+			if err := checkZeroRuns(file, off+headerSize, off+boxSize); err != nil {
+				return err
+			}
+		}
+		off += boxSize
+	}
+	return nil
+}
+
+// checkZeroRuns 检查 [start, end) 中没有 zeroRunLimit 以上连续的0字节
+func checkZeroRuns(r io.ReaderAt, start, end int64) error {
+	buf := make([]byte, 1<<20)
+	run := 0
+	for off := start; off < end; {
+		n := int64(len(buf))
+		if n > end-off {
+			n = end - off
+		}
+		if _, err := r.ReadAt(buf[:n], off); err != nil {
+			return err
+		}
+		for i, b := range buf[:n] {
+			if b != 0 {
+				run = 0
+				continue
+			}
+			if run++; run >= zeroRunLimit {
+				return corruptf("MP4 在 %d 处有未写入的数据", off+int64(i)+1-int64(run))
+			}
+		}
+		off += n
+	}
+	return nil
+}
+
+// verifyTSFile 逐包检查本地 TS 文件（之前版本将 HLS 回放直接保存为 .mp4 文件名的 TS 流），
+// 连续计数器跨读取的块连续检查
+func verifyTSFile(filePath string) error {
+	file, err := os.Open(utils.GetAndroidSafeFilePath(filePath))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size == 0 || size%tsPacketSize != 0 {
+		return corruptf("TS 文件长度 %d 不是 %d 的整数倍", size, tsPacketSize)
+	}
+	v := newTSVerifier()
+	buf := make([]byte, 4096*tsPacketSize)
+	for off := int64(0); off < size; {
+		n := int64(len(buf))
+		if n > size-off {
+			n = size - off
+		}
+		if _, err := file.ReadAt(buf[:n], off); err != nil {
+			return err
+		}
+		if err := v.check(buf[:n]); err != nil {
+			return err
+		}
+		off += n
+	}
+	return nil
+}
+
+// verifyTS 检查 TS 分段：长度为包大小的整数倍、每个包以同步字节开头、
+// 各 PID 的连续计数器依次递增（允许重复一次和带不连续标志的跳变）
+func verifyTS(data []byte) error {
+	if len(data) == 0 {
+		return corruptf("分段为空")
+	}
+	if len(data)%tsPacketSize != 0 {
+		return corruptf("分段长度 %d 不是 %d 的整数倍", len(data), tsPacketSize)
+	}
+	return newTSVerifier().check(data)
+}
+
+// tsVerifier 按顺序检查 TS 包，记录各 PID 上一个包的连续计数器
+type tsVerifier struct {
+	last map[uint16]byte
+	off  int64 // 已检查的字节数
+}
+
+func newTSVerifier() *tsVerifier {
+	return &tsVerifier{last: make(map[uint16]byte)}
+}
+
+// check 检查接在已检查数据之后的 data，长度须为包大小的整数倍
+func (v *tsVerifier) check(data []byte) error {
+	for i := 0; i < len(data); i += tsPacketSize {
+		off := v.off + int64(i)
+		pkt := data[i : i+tsPacketSize]
+		if pkt[0] != tsSyncByte {
+			return corruptf("分段在 %d 处缺少同步字节", off)
+		}
+		pid := uint16(pkt[1]&0x1F)<<8 | uint16(pkt[2])
+		afc := pkt[3] >> 4 & 0x03
+		if pid == 0x1FFF || afc&0x01 == 0 {
+			continue // 空包和不带负载的包不计数
+		}
+		cc := pkt[3] & 0x0F
+		prev, ok := v.last[pid]
+		v.last[pid] = cc
+		if !ok || cc == (prev+1)&0x0F || cc == prev {
+			continue
+		}
+		if afc&0x02 != 0 && pkt[4] > 0 && pkt[5]&0x80 != 0 {
+			continue // discontinuity_indicator
+		}
+		return corruptf("分段在 %d 处的连续计数器不连续（PID %d）", off, pid)
+	}
+	v.off += int64(len(data))
+	return nil
+}
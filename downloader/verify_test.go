@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// tsPacket 生成一个带负载的 TS 包
+func tsPacket(pid uint16, cc byte) []byte {
+	pkt := make([]byte, tsPacketSize)
+	pkt[0] = tsSyncByte
+	pkt[1] = byte(pid >> 8)
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | cc&0x0F
+	return pkt
+}
+
+func TestVerifyTS(t *testing.T) {
+	var good []byte
+	for i := 0; i < 20; i++ {
+		good = append(good, tsPacket(0x100, byte(i))...)
+		good = append(good, tsPacket(0x101, byte(i*2))...)
+		good = append(good, tsPacket(0x101, byte(i*2+1))...)
+	}
+	if err := verifyTS(good); err != nil {
+		t.Fatalf("valid segment rejected: %v", err)
+	}
+
+	lostSync := append([]byte(nil), good...)
+	lostSync[tsPacketSize*7] = 0
+	skipped := append(tsPacket(0x100, 0), tsPacket(0x100, 2)...)
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": good[:len(good)-10],
+		"lost sync": lostSync,
+		"skipped":   skipped,
+	} {
+		if err := verifyTS(data); !IsCorrupt(err) {
+			t.Errorf("%s: err = %v, want a corrupt error", name, err)
+		}
+	}
+
+	// 带不连续标志的跳变是允许的
+	jump := tsPacket(0x100, 5)
+	jump[3] = 0x30 | 5
+	jump[4], jump[5] = 1, 0x80
+	if err := verifyTS(append(tsPacket(0x100, 0), jump...)); err != nil {
+		t.Errorf("discontinuity rejected: %v", err)
+	}
+}
+
+func TestVerifyMP4(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("isom0000"))
+	moov := mp4Box("moov", mp4Box("trak", mp4Box("tkhd", make([]byte, 20))))
+	mdat := mp4Box("mdat", make([]byte, 1000))
+	good := bytes.Join([][]byte{ftyp, moov, mdat}, nil)
+	if _, err := verifyMP4(bytes.NewReader(good), int64(len(good))); err != nil {
+		t.Fatalf("valid file rejected: %v", err)
+	}
+
+	// moov 中子 box 的大小超出了 moov
+	badChild := append([]byte(nil), good...)
+	binary.BigEndian.PutUint32(badChild[len(ftyp)+16:], 1000)
+	off, err := verifyMP4(bytes.NewReader(badChild), int64(len(badChild)))
+	if !IsCorrupt(err) || off != int64(len(ftyp)+16) {
+		t.Errorf("bad child: off = %d, err = %v", off, err)
+	}
+
+	// 文件被截断
+	short := good[:len(good)-100]
+	if _, err := verifyMP4(bytes.NewReader(short), int64(len(short))); !IsCorrupt(err) {
+		t.Errorf("truncated file: err = %v", err)
+	}
+
+	noMoov := bytes.Join([][]byte{ftyp, mdat}, nil)
+	if _, err := verifyMP4(bytes.NewReader(noMoov), int64(len(noMoov))); !IsCorrupt(err) {
+		t.Errorf("missing moov: err = %v", err)
+	}
+}
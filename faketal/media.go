@@ -0,0 +1,134 @@
+package faketal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// mediaPrefix 视频文件的地址前缀
+const mediaPrefix = "/media/"
+
+// HLSKey 加密分段使用的 AES-128 密钥
+var HLSKey = []byte("0123456789abcdef")
+
+// mediaFile 服务器上的一个文件
+type mediaFile struct {
+	data    []byte
+	noRange bool // 不支持 Range 请求（HEAD 不返回 Accept-Ranges）
+}
+
+// MediaURL 返回文件名对应的地址
+func (s *Server) MediaURL(name string) string {
+	return s.URL + mediaPrefix + name
+}
+
+// AddFile 发布一个支持 Range 请求的文件，返回其地址
+func (s *Server) AddFile(name string, data []byte) string {
+	return s.addMedia(name, &mediaFile{data: data})
+}
+
+// AddFileWithoutRange 发布一个不支持 Range 请求的文件，下载器会回退到单线程下载
+func (s *Server) AddFileWithoutRange(name string, data []byte) string {
+	return s.addMedia(name, &mediaFile{data: data, noRange: true})
+}
+
+func (s *Server) addMedia(name string, file *mediaFile) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.media[name] = file
+	return s.MediaURL(name)
+}
+
+// HLSOptions 媒体播放列表的选项
+type HLSOptions struct {
+	SegmentDuration float64 // 每个分段的时长（秒），默认 2
+	Encrypt         bool    // 使用 AES-128 加密分段，密钥为 HLSKey，IV 为媒体序列号
+}
+
+// AddHLS 发布一个点播的媒体播放列表及其分段，返回播放列表的地址。
+// 分段保存在 name 目录下，播放列表中使用相对地址引用
+func (s *Server) AddHLS(name string, segments [][]byte, opts HLSOptions) string {
+	if opts.SegmentDuration <= 0 {
+		opts.SegmentDuration = 2
+	}
+
+	var playlist strings.Builder
+	fmt.Fprintf(&playlist, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", int(opts.SegmentDuration+0.999))
+	if opts.Encrypt {
+		s.addMedia(path.Join(name, "key.bin"), &mediaFile{data: HLSKey})
+		playlist.WriteString("#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n")
+	}
+	for i, data := range segments {
+		if opts.Encrypt {
+			data = encryptSegment(data, i)
+		}
+		segName := fmt.Sprintf("seg%03d.ts", i)
+		s.addMedia(path.Join(name, segName), &mediaFile{data: data})
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", opts.SegmentDuration, segName)
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	return s.addMedia(path.Join(name, "index.m3u8"), &mediaFile{data: []byte(playlist.String())})
+}
+
+// Variant 主播放列表中的一个码率
+type Variant struct {
+	Bandwidth int
+	Width     int
+	Height    int
+	URL       string // 媒体播放列表的地址
+}
+
+// AddMasterPlaylist 发布引用多个码率的主播放列表，返回其地址
+func (s *Server) AddMasterPlaylist(name string, variants ...Variant) string {
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	for _, v := range variants {
+		fmt.Fprintf(&playlist, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s\n", v.Bandwidth, v.Width, v.Height, v.URL)
+	}
+	return s.addMedia(name, &mediaFile{data: []byte(playlist.String())})
+}
+
+// encryptSegment 使用 HLSKey 和序列号 IV 进行 AES-128-CBC 加密（PKCS7 填充）
+func encryptSegment(data []byte, sequence int) []byte {
+	block, _ := aes.NewCipher(HLSKey)
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	plain := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, plain)
+	return out
+}
+
+func (s *Server) serveMedia(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, mediaPrefix)
+	s.mu.Lock()
+	file, ok := s.media[name]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if strings.HasSuffix(name, ".m3u8") {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	}
+	if file.noRange {
+		// 不返回 Content-Length，与不支持 Range 的分块传输服务器一致
+		if r.Method != http.MethodHead {
+			w.Write(file.data)
+		}
+		return
+	}
+	// ServeContent 处理 HEAD、Range 和 Accept-Ranges
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(file.data))
+}
@@ -0,0 +1,620 @@
+// Package faketal 提供基于 httptest 的好未来接口模拟服务器，用于在不访问线上服务的情况下
+// 对登录、课程、回放接口和下载器进行端到端测试。
+//
+// 服务器同时充当 passport、课程和回放接口，以及视频文件（mp4、m3u8 和 TS 分段）的地址；
+// 数据通过 Account/Student/Course/Lecture 等夹具预先设置，
+// 并可以通过 Fault 注入慢响应、HTTP 错误、截断的响应和登录过期。
+package faketal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/config"
+	"github.com/itsHenry35/tal_downloader/models"
+)
+
+// Lecture 一讲及其回放地址
+type Lecture struct {
+	models.Lecture
+	VideoURLs   []string            // 直播回放（playback/v1/video/init）返回的地址
+	Definitions map[string][]string // 录播（record/v1/resources）返回的各清晰度地址
+	Message     string              // 没有回放时接口返回的提示
+}
+
+// Course 课程及其讲次
+type Course struct {
+	models.Course
+	Lectures []*Lecture
+}
+
+// Student 账号下的一个学员
+type Student struct {
+	UID      int
+	Nickname string
+	Courses  []*Course
+}
+
+// Account 可以登录的账号，第一个学员为登录后的默认学员
+type Account struct {
+	Platform string // 平台ID，登录时按客户端ID区分平台
+	Username string // 手机号或学员编号
+	Password string
+	Phone    string // 短信登录使用的手机号
+	SMSCode  string
+	// StudentIDOnly 为 true 时 passport 登录失败，只能通过学员编号登录
+	StudentIDOnly bool
+	Students      []*Student
+}
+
+// session 已签发的 token 对应的登录态
+type session struct {
+	account *Account
+	student *Student
+	expired bool
+}
+
+// Server 模拟的好未来服务器
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	accounts  []*Account
+	codes     map[string]*session // passport 登录得到的 code
+	tokens    map[string]*session
+	nextToken int // code 和 token 的序号
+	media     map[string]*mediaFile
+	faults    []*Fault
+	requests  map[string]int           // 按路径统计的请求次数
+	last      map[string]*http.Request // 各路径最近一次请求（不含请求体）
+
+	connLimit   int // 媒体文件同时下载的连接数上限，0 表示不限制
+	activeConns int
+	throttled   int // 因超过连接数上限返回 429 的次数
+}
+
+// NewServer 启动模拟服务器，测试结束时需调用 Close
+func NewServer() *Server {
+	s := &Server{
+		codes:    make(map[string]*session),
+		tokens:   make(map[string]*session),
+		media:    make(map[string]*mediaFile),
+		requests: make(map[string]int),
+		last:     make(map[string]*http.Request),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Platform 返回指定平台（"ledu"、"xes"）的副本，接口地址均指向模拟服务器
+func (s *Server) Platform(id string) *config.Platform {
+	base, err := config.GetPlatform(id)
+	if err != nil {
+		panic(fmt.Sprintf("faketal: %v", err))
+	}
+	p := *base
+	p.PassportAPIBase = s.URL
+	p.CourseAPIBase = s.URL
+	p.ClassroomAPIBase = s.URL
+	return &p
+}
+
+// AddAccount 添加可以登录的账号
+func (s *Server) AddAccount(account *Account) *Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts = append(s.accounts, account)
+	return account
+}
+
+// Requests 返回路径以 prefix 开头的请求次数
+func (s *Server) Requests(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for path, n := range s.requests {
+		if strings.HasPrefix(path, prefix) {
+			count += n
+		}
+	}
+	return count
+}
+
+// LastRequest 返回路径最近一次的请求，用于检查请求头；没有请求时返回 nil
+func (s *Server) LastRequest(path string) *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last[path]
+}
+
+// ExpireTokens 使所有已签发的 token 失效，之后的请求按登录过期响应
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.tokens {
+		sess.expired = true
+	}
+}
+
+// issueToken 为学员签发新的 token
+func (s *Server) issueToken(account *Account, student *Student) string {
+	s.nextToken++
+	token := fmt.Sprintf("token-%d-%d", student.UID, s.nextToken)
+	s.tokens[token] = &session{account: account, student: student}
+	return token
+}
+
+func (s *Server) platformClientID(account *Account) string {
+	if p, err := config.GetPlatform(account.Platform); err == nil {
+		return p.ClientID
+	}
+	return ""
+}
+
+// findAccount 按平台客户端ID和匹配函数查找账号
+func (s *Server) findAccount(clientID string, match func(*Account) bool) *Account {
+	for _, account := range s.accounts {
+		if s.platformClientID(account) == clientID && match(account) {
+			return account
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	s.last[r.URL.Path] = r.Clone(r.Context())
+	fault := s.matchFault(r)
+	limited := s.connLimit > 0 && r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, mediaPrefix)
+	if limited {
+		if s.activeConns >= s.connLimit {
+			// 与部分 CDN 一样，连接数过多时拒绝请求
+			s.throttled++
+			s.mu.Unlock()
+			writeError(w, http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+			return
+		}
+		s.activeConns++
+	}
+	s.mu.Unlock()
+	if limited {
+		defer func() {
+			s.mu.Lock()
+			s.activeConns--
+			s.mu.Unlock()
+		}()
+	}
+
+	if fault != nil {
+		var handled bool
+		if w, handled = fault.apply(w); handled {
+			return
+		}
+	}
+
+	if strings.HasPrefix(r.URL.Path, mediaPrefix) {
+		s.serveMedia(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case "/v1/web/login/pwd":
+		s.handlePasswordLogin(w, r)
+	case "/v1/web/login/sms/send":
+		s.handleSendSMS(w, r)
+	case "/v1/web/login/sms":
+		s.handleSMSLogin(w, r)
+	case "/passport/v1/login/student/code":
+		s.handleCodeLogin(w, r)
+	case "/passport/v1/login/student/password":
+		s.handleStudentIDLogin(w, r)
+	case "/passport/v1/students/account-list":
+		s.handleAccountList(w, r)
+	case "/passport/v2/login/student/change-stu":
+		s.handleChangeStudent(w, r)
+	case "/course/v1/student/course/list":
+		s.handleCourseList(w, r)
+	case "/course/v1/student/course/user-live-list":
+		s.handleLectureList(w, r)
+	case "/playback/v1/video/init":
+		s.handlePlayback(w, r)
+	case "/classroom-ai/record/v1/resources":
+		s.handleRecordResources(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writePassportError passport 接口的业务错误，HTTP 状态码为 200
+func writePassportError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"errcode": code, "errmsg": msg})
+}
+
+// writeError 课程和回放接口的错误
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{"code": status, "msg": msg})
+}
+
+// ExpiredTokenCode 过期 token 得到的业务错误码。真实接口对过期 token 的错误码和错误信息没有公开，
+// 也没有记录下来的响应，这里只模拟已知的形式：HTTP 200 和 errcode/errmsg 格式的业务错误。
+// 错误码和错误信息是模拟服务器自己的，客户端不应依赖它们判断登录是否过期
+const ExpiredTokenCode = 99401
+
+// writeExpired token 过期或无效时的响应
+func writeExpired(w http.ResponseWriter) {
+	writePassportError(w, ExpiredTokenCode, "faketal: token expired")
+}
+
+// passportCode 签发 passport 登录的 code
+func (s *Server) passportCode(account *Account) string {
+	s.nextToken++
+	code := fmt.Sprintf("code-%d", s.nextToken)
+	s.codes[code] = &session{account: account, student: account.Students[0]}
+	return code
+}
+
+func (s *Server) handlePasswordLogin(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	username, password := r.PostForm.Get("symbol"), r.PostForm.Get("password")
+	account := s.findAccount(r.Header.Get("client-id"), func(a *Account) bool {
+		return !a.StudentIDOnly && a.Username == username && a.Password == password
+	})
+	if account == nil {
+		writePassportError(w, 11001, "账号或密码错误")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"errcode": 0,
+		"data":    map[string]string{"code": s.passportCode(account)},
+	})
+}
+
+func (s *Server) handleSendSMS(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	phone := r.PostForm.Get("phone")
+	account := s.findAccount(r.Header.Get("client-id"), func(a *Account) bool {
+		return a.Phone != "" && a.Phone == phone
+	})
+	if account == nil {
+		writePassportError(w, 11002, "手机号未注册")
+		return
+	}
+	writePassportError(w, 0, "")
+}
+
+func (s *Server) handleSMSLogin(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	phone, code := r.PostForm.Get("phone"), r.PostForm.Get("sms_code")
+	account := s.findAccount(r.Header.Get("client-id"), func(a *Account) bool {
+		return a.Phone != "" && a.Phone == phone && a.SMSCode == code
+	})
+	if account == nil {
+		writePassportError(w, 11003, "验证码错误")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"errcode": 0,
+		"data":    map[string]string{"code": s.passportCode(account)},
+	})
+}
+
+// authResponse getFinalAuth、学员编号登录和切换学员的响应
+func (s *Server) authResponse(w http.ResponseWriter, account *Account, student *Student) {
+	writeJSON(w, http.StatusOK, models.AuthFinalResponse{
+		Token:    s.issueToken(account, student),
+		UserID:   student.UID,
+		Nickname: student.Nickname,
+	})
+}
+
+func decodeBody(r *http.Request) map[string]string {
+	body := make(map[string]string)
+	json.NewDecoder(r.Body).Decode(&body)
+	return body
+}
+
+func (s *Server) handleCodeLogin(w http.ResponseWriter, r *http.Request) {
+	body := decodeBody(r)
+	sess, ok := s.codes[body["code"]]
+	if !ok || s.platformClientID(sess.account) != body["clientId"] {
+		writeError(w, http.StatusBadRequest, "无效的code")
+		return
+	}
+	delete(s.codes, body["code"])
+	s.authResponse(w, sess.account, sess.student)
+}
+
+func (s *Server) handleStudentIDLogin(w http.ResponseWriter, r *http.Request) {
+	body := decodeBody(r)
+	account := s.findAccount(body["clientId"], func(a *Account) bool {
+		return a.Username == body["account"] && a.Password == body["password"]
+	})
+	if account == nil {
+		writeError(w, http.StatusBadRequest, "学员编号或密码错误")
+		return
+	}
+	s.authResponse(w, account, account.Students[0])
+}
+
+// authenticate 根据 token 请求头查找登录态，失败时已写入响应
+func (s *Server) authenticate(w http.ResponseWriter, token string) *session {
+	sess, ok := s.tokens[token]
+	if !ok || sess.expired {
+		writeExpired(w)
+		return nil
+	}
+	return sess
+}
+
+func (s *Server) handleAccountList(w http.ResponseWriter, r *http.Request) {
+	body := decodeBody(r)
+	sess := s.authenticate(w, body["signToken"])
+	if sess == nil {
+		return
+	}
+	accounts := make(models.StudentAccountListResponse, 0, len(sess.account.Students))
+	for _, student := range sess.account.Students {
+		accounts = append(accounts, &models.StudentAccount{
+			PuUID:                 student.UID,
+			Nickname:              student.Nickname,
+			IsCurrentLoginAccount: student == sess.student,
+		})
+	}
+	writeJSON(w, http.StatusOK, accounts)
+}
+
+func (s *Server) handleChangeStudent(w http.ResponseWriter, r *http.Request) {
+	body := decodeBody(r)
+	sess := s.authenticate(w, body["signToken"])
+	if sess == nil {
+		return
+	}
+	for _, student := range sess.account.Students {
+		if strconv.Itoa(student.UID) == body["stuPuId"] {
+			s.authResponse(w, sess.account, student)
+			return
+		}
+	}
+	writeError(w, http.StatusBadRequest, "学员不存在")
+}
+
+// studentSession 校验 token 和 stuId 参数，返回请求的学员
+func (s *Server) studentSession(w http.ResponseWriter, r *http.Request) *Student {
+	sess := s.authenticate(w, r.Header.Get("token"))
+	if sess == nil {
+		return nil
+	}
+	if stuID := r.URL.Query().Get("stuId"); stuID != "" && stuID != strconv.Itoa(sess.student.UID) {
+		writeError(w, http.StatusBadRequest, "学员不匹配")
+		return nil
+	}
+	return sess.student
+}
+
+// paginate 按 page/perPage 参数截取列表，页码从1开始，超出范围时返回空列表
+func paginate(r *http.Request, total int) (from, to int) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("perPage"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = 10
+	}
+	from = (page - 1) * perPage
+	if from > total {
+		from = total
+	}
+	to = from + perPage
+	if to > total {
+		to = total
+	}
+	return from, to
+}
+
+func (s *Server) handleCourseList(w http.ResponseWriter, r *http.Request) {
+	student := s.studentSession(w, r)
+	if student == nil {
+		return
+	}
+	from, to := paginate(r, len(student.Courses))
+	courses := make([]models.Course, 0, to-from)
+	for _, c := range student.Courses[from:to] {
+		courses = append(courses, c.Course)
+	}
+	writeJSON(w, http.StatusOK, courses)
+}
+
+func (s *Server) handleLectureList(w http.ResponseWriter, r *http.Request) {
+	student := s.studentSession(w, r)
+	if student == nil {
+		return
+	}
+	courseID := r.URL.Query().Get("stdCourseId")
+	for _, c := range student.Courses {
+		if c.CourseID != courseID {
+			continue
+		}
+		from, to := paginate(r, len(c.Lectures))
+		lectures := make([]models.Lecture, 0, to-from)
+		for _, l := range c.Lectures[from:to] {
+			lectures = append(lectures, l.Lecture)
+		}
+		writeJSON(w, http.StatusOK, lectures)
+		return
+	}
+	writeError(w, http.StatusNotFound, "课程不存在")
+}
+
+// findLecture 按 stdCourseId 和 liveId 请求头查找学员的一讲
+func (s *Server) findLecture(w http.ResponseWriter, r *http.Request) *Lecture {
+	sess := s.authenticate(w, r.Header.Get("token"))
+	if sess == nil {
+		return nil
+	}
+	courseID, liveID := r.Header.Get("stdCourseId"), r.Header.Get("liveId")
+	for _, c := range sess.student.Courses {
+		if c.CourseID != courseID {
+			continue
+		}
+		for _, l := range c.Lectures {
+			if strconv.Itoa(l.LiveID) == liveID {
+				return l
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, "讲次不存在")
+	return nil
+}
+
+func (s *Server) handlePlayback(w http.ResponseWriter, r *http.Request) {
+	lecture := s.findLecture(w, r)
+	if lecture == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, models.VideoUrlResponse{VideoURLs: lecture.VideoURLs, Message: lecture.Message})
+}
+
+func (s *Server) handleRecordResources(w http.ResponseWriter, r *http.Request) {
+	lecture := s.findLecture(w, r)
+	if lecture == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, models.RecordModeVideoUrlResponse{Definitions: lecture.Definitions, Message: lecture.Message})
+}
+
+// Fault 注入的故障，路径匹配的请求按设置失败
+type Fault struct {
+	Path   string        // 匹配的路径前缀，为空时匹配所有请求
+	Method string        // 匹配的请求方法，为空时匹配所有方法
+	Times  int           // 生效次数，0 表示一直生效
+	Delay  time.Duration // 响应前等待的时间
+	// Status 非0时直接返回该状态码
+	Status int
+	// Truncate 大于0时只发送响应的前 Truncate 字节，随后断开连接
+	Truncate int64
+	// ExpiredToken 为 true 时按登录过期响应（见 writeExpired）
+	ExpiredToken bool
+	// Corrupt 为 true 时正常响应，但翻转响应内容中从 CorruptOffset 开始的16个字节
+	Corrupt       bool
+	CorruptOffset int64
+
+	hits int
+}
+
+// LimitConnections 限制媒体文件同时进行的 GET 请求数，超过时返回 429，0 表示不限制
+func (s *Server) LimitConnections(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connLimit = n
+}
+
+// Throttled 返回因超过连接数上限被拒绝的请求数
+func (s *Server) Throttled() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.throttled
+}
+
+// InjectFault 注入故障，多个故障匹配同一请求时使用最先注入的
+func (s *Server) InjectFault(f *Fault) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, f)
+	return f
+}
+
+// ClearFaults 移除所有故障
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// matchFault 查找与请求匹配且仍然生效的故障，调用时需持有锁
+func (s *Server) matchFault(r *http.Request) *Fault {
+	for _, f := range s.faults {
+		if !strings.HasPrefix(r.URL.Path, f.Path) || (f.Method != "" && f.Method != r.Method) {
+			continue
+		}
+		if f.Times > 0 && f.hits >= f.Times {
+			continue
+		}
+		f.hits++
+		return f
+	}
+	return nil
+}
+
+// apply 执行故障，handled 为 true 时已经写入响应；
+// 否则返回需要继续使用的 ResponseWriter（截断时包装原来的）
+func (f *Fault) apply(w http.ResponseWriter) (http.ResponseWriter, bool) {
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	switch {
+	case f.ExpiredToken:
+		writeExpired(w)
+		return w, true
+	case f.Status != 0:
+		writeError(w, f.Status, http.StatusText(f.Status))
+		return w, true
+	case f.Truncate > 0:
+		return &truncatingWriter{ResponseWriter: w, remaining: f.Truncate}, false
+	case f.Corrupt:
+		return &corruptingWriter{ResponseWriter: w, offset: f.CorruptOffset}, false
+	}
+	return w, false
+}
+
+// corruptingWriter 翻转响应内容中的一段字节，状态码和长度不变
+type corruptingWriter struct {
+	http.ResponseWriter
+	offset  int64
+	written int64
+}
+
+func (cw *corruptingWriter) Write(p []byte) (int, error) {
+	out := append([]byte(nil), p...)
+	for i := range out {
+		if pos := cw.written + int64(i); pos >= cw.offset && pos < cw.offset+16 {
+			out[i] ^= 0xFF
+		}
+	}
+	cw.written += int64(len(p))
+	return cw.ResponseWriter.Write(out)
+}
+
+// truncatingWriter 写出指定字节数后中断连接，客户端会读到不完整的响应
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int64
+}
+
+func (tw *truncatingWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) < tw.remaining {
+		tw.remaining -= int64(len(p))
+		return tw.ResponseWriter.Write(p)
+	}
+	tw.ResponseWriter.Write(p[:tw.remaining])
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	// 由 net/http 中止处理并关闭连接，且不记录日志
+	panic(http.ErrAbortHandler)
+}
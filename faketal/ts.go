@@ -0,0 +1,272 @@
+package faketal
+
+import (
+	"encoding/binary"
+)
+
+// 生成的 TS 分段的参数
+const (
+	tsPacketSize = 188
+	pmtPID       = 0x1000
+	videoPID     = 0x0100
+	audioPID     = 0x0101
+
+	frameDuration   = 3600 // 25fps，90kHz 时间戳
+	audioSampleRate = 44100
+	aacFrameSamples = 1024
+	timestampOffset = 90000 // 第一帧的时间戳（1秒），与真实的直播回放一样不从0开始
+
+	// VideoWidth, VideoHeight 生成的视频的分辨率
+	VideoWidth  = 320
+	VideoHeight = 240
+)
+
+// TSSegment 生成一个包含 H.264 视频和 AAC 音频的 TS 分段，可以被封装为 MP4。
+// index 为分段序号，决定分段的时间戳；每个分段 frames 帧（25fps），第一帧为关键帧。
+// 视频和音频的负载为填充数据，不能解码播放
+func TSSegment(index, frames int) []byte {
+	w := &tsWriter{cc: make(map[uint16]byte)}
+	w.psi(0, buildPAT())
+	w.psi(pmtPID, buildPMT())
+
+	start := int64(index * frames * frameDuration)
+	end := start + int64(frames*frameDuration)
+
+	// 视频帧与时间范围内的音频帧交错写入
+	audioFrame := (start*audioSampleRate + 90000*aacFrameSamples - 1) / (90000 * aacFrameSamples)
+	for i := 0; i < frames; i++ {
+		pts := start + int64(i*frameDuration)
+		w.pes(videoPID, 0xE0, pts+timestampOffset, videoAccessUnit(i == 0, index*frames+i))
+
+		for {
+			audioPTS := audioFrame * aacFrameSamples * 90000 / audioSampleRate
+			if audioPTS >= pts+frameDuration || audioPTS >= end {
+				break
+			}
+			w.pes(audioPID, 0xC0, audioPTS+timestampOffset, adtsFrame(int(audioFrame)))
+			audioFrame++
+		}
+	}
+	return w.buf
+}
+
+// tsWriter 将 PSI 表和 PES 包封装为 TS 包
+type tsWriter struct {
+	buf []byte
+	cc  map[uint16]byte // 各 PID 的连续计数器
+}
+
+func (w *tsWriter) header(pid uint16, start bool, adaptation bool) []byte {
+	cc := w.cc[pid]
+	w.cc[pid] = (cc + 1) & 0x0F
+
+	b1 := byte(pid >> 8)
+	if start {
+		b1 |= 0x40
+	}
+	afc := byte(0x10)
+	if adaptation {
+		afc = 0x30
+	}
+	return []byte{0x47, b1, byte(pid), afc | cc}
+}
+
+func (w *tsWriter) psi(pid uint16, section []byte) {
+	pkt := append(w.header(pid, true, false), 0) // pointer_field
+	pkt = append(pkt, section...)
+	for len(pkt) < tsPacketSize {
+		pkt = append(pkt, 0xFF)
+	}
+	w.buf = append(w.buf, pkt...)
+}
+
+// pes 写入一个 PES 包，DTS 与 PTS 相同
+func (w *tsWriter) pes(pid uint16, streamID byte, pts int64, payload []byte) {
+	pes := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0x80, 5}
+	pes = append(pes, encodeTimestamp(0x2, pts)...)
+	pes = append(pes, payload...)
+	if length := len(pes) - 6; streamID != 0xE0 && length <= 0xFFFF {
+		binary.BigEndian.PutUint16(pes[4:6], uint16(length)) // 视频包长度写0表示不限
+	}
+
+	for first := true; len(pes) > 0; first = false {
+		n := len(pes)
+		if n >= tsPacketSize-4 {
+			n = tsPacketSize - 4
+			w.buf = append(w.buf, w.header(pid, first, false)...)
+		} else {
+			// 最后一个包用自适应字段填充到188字节
+			w.buf = append(w.buf, w.header(pid, first, true)...)
+			stuffing := tsPacketSize - 4 - 1 - n
+			w.buf = append(w.buf, byte(stuffing))
+			if stuffing > 0 {
+				w.buf = append(w.buf, 0x00) // 自适应字段标志
+				for i := 1; i < stuffing; i++ {
+					w.buf = append(w.buf, 0xFF)
+				}
+			}
+		}
+		w.buf = append(w.buf, pes[:n]...)
+		pes = pes[n:]
+	}
+}
+
+func encodeTimestamp(prefix byte, ts int64) []byte {
+	return []byte{
+		prefix<<4 | byte(ts>>29)&0x0E | 1,
+		byte(ts >> 22),
+		byte(ts>>14)&0xFE | 1,
+		byte(ts >> 7),
+		byte(ts<<1) | 1,
+	}
+}
+
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func finishSection(section []byte) []byte {
+	length := len(section) - 3 + 4
+	section[1] = 0xB0 | byte(length>>8)
+	section[2] = byte(length)
+	return binary.BigEndian.AppendUint32(section, crc32MPEG2(section))
+}
+
+func buildPAT() []byte {
+	return finishSection([]byte{
+		0x00, 0, 0,
+		0x00, 0x01, 0xC1, 0x00, 0x00,
+		0x00, 0x01, 0xE0 | pmtPID>>8, pmtPID & 0xFF,
+	})
+}
+
+func buildPMT() []byte {
+	return finishSection([]byte{
+		0x02, 0, 0,
+		0x00, 0x01, 0xC1, 0x00, 0x00,
+		0xE0 | videoPID>>8, videoPID & 0xFF, // PCR PID
+		0xF0, 0x00,
+		0x1B, 0xE0 | videoPID>>8, videoPID & 0xFF, 0xF0, 0x00, // H.264
+		0x0F, 0xE0 | audioPID>>8, audioPID & 0xFF, 0xF0, 0x00, // AAC
+	})
+}
+
+// videoAccessUnit 生成一帧 Annex B 格式的 H.264 数据，关键帧带 SPS/PPS
+func videoAccessUnit(keyframe bool, n int) []byte {
+	startCode := []byte{0, 0, 0, 1}
+	au := append(append([]byte(nil), startCode...), 0x09, 0xF0) // AUD
+	if keyframe {
+		au = append(append(au, startCode...), buildSPS(VideoWidth, VideoHeight)...)
+		au = append(append(au, startCode...), 0x68, 0xCE, 0x38, 0x80) // PPS
+		au = append(append(au, startCode...), 0x65)                   // IDR
+	} else {
+		au = append(append(au, startCode...), 0x41)
+	}
+	return append(au, fillerBytes(n, 600)...)
+}
+
+// adtsFrame 生成一帧带 ADTS 头的 AAC LC 数据（44.1kHz 双声道）
+func adtsFrame(n int) []byte {
+	payload := fillerBytes(n, 120)
+	length := 7 + len(payload)
+	const profile, rateIndex, channels = 1, 4, 2
+	header := []byte{
+		0xFF, 0xF1,
+		profile<<6 | rateIndex<<2 | channels>>2,
+		byte(channels&3<<6 | length>>11),
+		byte(length >> 3),
+		byte(length&7<<5 | 0x1F),
+		0xFC,
+	}
+	return append(header, payload...)
+}
+
+// fillerBytes 不含0字节的填充数据，避免出现起始码
+func fillerBytes(seed, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(0x80 | (seed+i)&0x7F)
+	}
+	return b
+}
+
+// bitWriter 按位写入 RBSP
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (bw *bitWriter) bits(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if bw.n%8 == 0 {
+			bw.data = append(bw.data, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			bw.data[len(bw.data)-1] |= 1 << (7 - uint(bw.n%8))
+		}
+		bw.n++
+	}
+}
+
+// ue 无符号指数哥伦布编码
+func (bw *bitWriter) ue(v uint) {
+	v++
+	size := 0
+	for x := v; x > 1; x >>= 1 {
+		size++
+	}
+	bw.bits(0, size)
+	bw.bits(v, size+1)
+}
+
+// buildSPS 生成指定分辨率（16的倍数）的 Baseline SPS
+func buildSPS(width, height int) []byte {
+	bw := &bitWriter{}
+	bw.bits(0x67, 8) // nal header
+	bw.bits(66, 8)   // profile_idc: Baseline
+	bw.bits(0xC0, 8) // constraint flags
+	bw.bits(30, 8)   // level_idc
+	bw.ue(0)         // seq_parameter_set_id
+	bw.ue(0)         // log2_max_frame_num_minus4
+	bw.ue(2)         // pic_order_cnt_type
+	bw.ue(1)         // max_num_ref_frames
+	bw.bits(0, 1)    // gaps_in_frame_num_value_allowed_flag
+	bw.ue(uint(width/16 - 1))
+	bw.ue(uint(height/16 - 1))
+	bw.bits(1, 1) // frame_mbs_only_flag
+	bw.bits(1, 1) // direct_8x8_inference_flag
+	bw.bits(0, 1) // frame_cropping_flag
+	bw.bits(0, 1) // vui_parameters_present_flag
+	bw.bits(1, 1) // rbsp_stop_one_bit
+	return addEmulationPrevention(bw.data)
+}
+
+// addEmulationPrevention 在连续两个0字节后插入防竞争字节
+func addEmulationPrevention(rbsp []byte) []byte {
+	out := make([]byte, 0, len(rbsp)+4)
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/itsHenry35/tal_downloader/cli"
 	"github.com/itsHenry35/tal_downloader/config"
 	"github.com/itsHenry35/tal_downloader/ui"
 	"github.com/itsHenry35/tal_downloader/utils"
@@ -10,7 +14,18 @@ import (
 )
 
 func main() {
-	myApp := app.NewWithID("com.itshenry.tal_downloader")
+	// 命令行模式：在创建 Fyne 应用之前处理，不需要图形环境
+	if len(os.Args) > 1 && os.Args[1] == "cli" {
+		os.Exit(cli.Run(os.Args[2:]))
+	}
+
+	myApp := app.NewWithID(config.AppID)
+	utils.SetRootPath(myApp.Storage().RootURI().Path())
+
+	// 加载 platforms.json 中配置的额外平台
+	if err := utils.LoadPlatformConfig(); err != nil {
+		fmt.Printf("加载平台配置失败: %v\n", err)
+	}
 
 	// 安卓平台启动时清理临时文件夹
 	if utils.IsAndroid() {
@@ -29,6 +44,9 @@ func main() {
 	// Start with login screen
 	uiManager.ShowLogin()
 
+	// 在后台检查保存账号的登录状态
+	uiManager.CheckSavedUsers()
+
 	window.SetContent(mainContainer)
 	window.ShowAndRun()
 }
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// 下载任务记录的状态
+const (
+	JobStatusQueued    = "queued"
+	JobStatusCompleted = "completed"
+	JobStatusError     = "error"
+	JobStatusCorrupt   = "corrupt" // 下载完成但没有通过校验
+)
+
+// DownloadJob 下载队列中的一讲（持久化保存，用于恢复未完成的下载和查看下载记录）
+type DownloadJob struct {
+	FilePath     string    `json:"file_path"`            // 目标文件路径（唯一标识）
+	Platform     string    `json:"platform"`             // 平台
+	StudentID    string    `json:"student_id"`           // 学员ID
+	StudentName  string    `json:"student_name"`         // 学员昵称
+	Course       Course    `json:"course"`               // 课程
+	Lecture      Lecture   `json:"lecture"`              // 讲
+	LectureIndex int       `json:"lecture_index"`        // 讲次下标（从0开始）
+	Extensive    bool      `json:"extensive"`            // 是否为延伸内容
+	Definition   string    `json:"definition,omitempty"` // 实际下载的清晰度
+	Status       string    `json:"status"`               // 状态
+	Error        string    `json:"error,omitempty"`
+	Bytes        int64     `json:"bytes"`       // 文件大小
+	CreatedAt    time.Time `json:"created_at"`  // 加入队列时间
+	StartedAt    time.Time `json:"started_at"`  // 开始下载时间
+	FinishedAt   time.Time `json:"finished_at"` // 结束时间
+}
+
+// IsUnfinished 是否为未完成（可恢复）的任务，校验失败的任务也需要重新下载
+func (job *DownloadJob) IsUnfinished() bool {
+	return job.Status == JobStatusQueued || job.Status == JobStatusCorrupt
+}
+
+// DownloadJobsData 所有下载任务记录
+type DownloadJobsData struct {
+	Jobs []DownloadJob `json:"jobs"`
+}
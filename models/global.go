@@ -14,14 +14,6 @@ type Course struct {
 	EndLiveNum  int    `json:"endLiveNum"`
 }
 
-type Lecture struct {
-	LiveID         int    `json:"liveId"`
-	LiveTypeString string `json:"liveTypeString"`
-	ClassID        string `json:"stdClassId"`
-	SubjectID      string `json:"stdSubject"`
-	LecturerID     string `json:"lecturerId"`
-}
-
 type StudentAccount struct {
 	PuUID                 int    `json:"pu_uid"`
 	Nickname              string `json:"nickname"`
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lecture 一讲，对应 user-live-list 接口返回的一项
+type Lecture struct {
+	LiveID         int    `json:"liveId"`
+	LiveTypeString string `json:"liveTypeString"`
+	ClassID        string `json:"stdClassId"`
+	SubjectID      string `json:"stdSubject"`
+	LecturerID     string `json:"lecturerId"`
+
+	Title       string      `json:"liveName"`      // 讲次标题
+	Num         int         `json:"liveNum"`       // 讲次序号，列表中有取消或调课的讲次时与下标不一致
+	StartTime   LectureTime `json:"liveStartTime"` // 计划开始时间
+	EndTime     LectureTime `json:"liveEndTime"`   // 计划结束时间
+	TeacherName string      `json:"lecturerName"`  // 主讲老师
+	Status      int         `json:"liveStatus"`    // 见 LectureStatus*，0 表示接口没有返回
+}
+
+// liveStatus 的取值
+const (
+	LectureStatusNotStarted = 1
+	LectureStatusLive       = 2
+	LectureStatusEnded      = 3
+	LectureStatusCancelled  = 4
+)
+
+// Number 讲次序号，接口没有返回时使用列表下标加1
+func (l *Lecture) Number(index int) int {
+	if l.Num > 0 {
+		return l.Num
+	}
+	return index + 1
+}
+
+// Duration 计划时长，没有开始或结束时间时为0
+func (l *Lecture) Duration() time.Duration {
+	if l.StartTime.IsZero() || l.EndTime.Before(l.StartTime.Time) {
+		return 0
+	}
+	return l.EndTime.Sub(l.StartTime.Time)
+}
+
+// StatusText 讲次状态的显示文本，未知状态返回空字符串
+func (l *Lecture) StatusText() string {
+	switch l.Status {
+	case LectureStatusNotStarted:
+		return "未开始"
+	case LectureStatusLive:
+		return "直播中"
+	case LectureStatusEnded:
+		return "已结束"
+	case LectureStatusCancelled:
+		return "已取消"
+	}
+	return ""
+}
+
+// Ended 是否已经结束（可以下载回放）。按讲次状态判断，已取消的讲不算结束；
+// 接口没有返回状态时按结束时间判断，也没有结束时间时才按课程的已结束讲数判断
+func (l *Lecture) Ended(index int, course *Course) bool {
+	switch l.Status {
+	case LectureStatusEnded:
+		return true
+	case LectureStatusNotStarted, LectureStatusLive, LectureStatusCancelled:
+		return false
+	}
+	if !l.EndTime.IsZero() {
+		return l.EndTime.Before(time.Now())
+	}
+	return index < course.EndLiveNum
+}
+
+// EndedLectures 已结束的讲在列表中的下标
+func EndedLectures(lectures []*Lecture, course *Course) []int {
+	var indices []int
+	for j, lecture := range lectures {
+		if lecture.Ended(j, course) {
+			indices = append(indices, j)
+		}
+	}
+	return indices
+}
+
+// TimeErr 开始或结束时间无法解析时返回 *TimeFormatError
+func (l *Lecture) TimeErr() error {
+	if err := l.StartTime.Err(); err != nil {
+		return err
+	}
+	return l.EndTime.Err()
+}
+
+// Name 讲次序号和标题，如 "第3讲 二次函数"
+func (l *Lecture) Name(index int) string {
+	if l.Title == "" {
+		return fmt.Sprintf("第%d讲", l.Number(index))
+	}
+	return fmt.Sprintf("第%d讲 %s", l.Number(index), l.Title)
+}
+
+// Label 讲次的显示名称，包括开始时间、老师和时长，如 "第3讲 二次函数 · 2024-05-12 18:30 · 张老师 · 90分钟"
+func (l *Lecture) Label(index int) string {
+	label := l.Name(index)
+	var details []string
+	if !l.StartTime.IsZero() {
+		details = append(details, l.StartTime.In(LectureLocation).Format("2006-01-02 15:04"))
+	}
+	if l.TeacherName != "" {
+		details = append(details, l.TeacherName)
+	}
+	if d := l.Duration(); d > 0 {
+		details = append(details, fmt.Sprintf("%d分钟", int(d.Minutes())))
+	}
+	if len(details) > 0 {
+		label += " · " + strings.Join(details, " · ")
+	}
+	return label
+}
+
+// LectureLocation 接口中不带时区的时间均为北京时间
+var LectureLocation = time.FixedZone("CST", 8*3600)
+
+// TimeFormatError 接口返回了无法解析的时间
+type TimeFormatError struct {
+	Value string // 接口返回的原始值
+}
+
+func (e *TimeFormatError) Error() string {
+	return fmt.Sprintf("无法解析时间: %s", e.Value)
+}
+
+// LectureTime 接口返回的时间，可能是秒或毫秒时间戳，也可能是 "2006-01-02 15:04:05" 格式的字符串。
+// 无法解析的值视为没有时间，不影响整个讲次列表的解析，原始值见 Err
+type LectureTime struct {
+	time.Time
+	err error
+}
+
+// Err 时间无法解析时返回 *TimeFormatError
+func (t LectureTime) Err() error {
+	return t.err
+}
+
+func (t LectureTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("0"), nil
+	}
+	return []byte(strconv.FormatInt(t.Unix(), 10)), nil
+}
+
+func (t *LectureTime) UnmarshalJSON(data []byte) error {
+	*t = LectureTime{}
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	s := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			t.err = &TimeFormatError{Value: string(data)}
+			return nil
+		}
+	}
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			t.Time = time.UnixMilli(n)
+		} else {
+			t.Time = time.Unix(n, 0)
+		}
+		return nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", time.RFC3339} {
+		if parsed, err := time.ParseInLocation(layout, s, LectureLocation); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	t.err = &TimeFormatError{Value: s}
+	return nil
+}
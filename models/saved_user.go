@@ -7,15 +7,17 @@ type SavedUser struct {
 	Nickname string `json:"nickname"` // 昵称
 	Token    string `json:"token"`    // token
 	Platform string `json:"platform"` // 平台
+	Password string `json:"-"`        // 密码，仅在选择记住密码时保存，用于登录过期后自动重新登录
 }
 
 // SavedUserEncrypted 加密保存的用户信息（用于JSON存储）
 type SavedUserEncrypted struct {
-	Username          string `json:"username"`           // 用户名（明文）
-	EncryptedUserID   string `json:"encrypted_user_id"`  // 加密的用户ID
-	EncryptedNickname string `json:"encrypted_nickname"` // 加密的昵称
-	EncryptedToken    string `json:"encrypted_token"`    // 加密的token
-	Platform          string `json:"platform"`           // 平台（明文）
+	Username          string `json:"username"`                     // 用户名（明文）
+	EncryptedUserID   string `json:"encrypted_user_id"`            // 加密的用户ID
+	EncryptedNickname string `json:"encrypted_nickname"`           // 加密的昵称
+	EncryptedToken    string `json:"encrypted_token"`              // 加密的token
+	Platform          string `json:"platform"`                     // 平台（明文）
+	EncryptedPassword string `json:"encrypted_password,omitempty"` // 加密的密码（可选）
 }
 
 // SavedUsersData 所有保存的用户信息
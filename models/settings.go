@@ -0,0 +1,39 @@
+package models
+
+// Settings 用户设置，图形界面和命令行共用
+type Settings struct {
+	MaxConcurrentDownloads int    `json:"max_concurrent_downloads"` // 同时下载的文件数
+	ThreadCount            int    `json:"thread_count"`             // 每个文件的下载线程数，自动调节时为上限
+	AdaptiveThreads        bool   `json:"adaptive_threads"`         // 根据速度和错误率自动调节线程数
+	RateLimit              int64  `json:"rate_limit"`               // 下载限速（每秒字节数），0 表示不限速
+	RateSchedule           string `json:"rate_schedule,omitempty"`  // 按时段限速的规则，见 downloader.ParseSchedule
+	FileTemplate           string `json:"file_template,omitempty"`  // 保存路径模板，为空时使用默认模板，见 utils.ParseFileTemplate
+}
+
+// 设置允许的取值范围
+const (
+	MaxConcurrentDownloadsLimit = 64
+	ThreadCountLimit            = 64
+)
+
+// Normalize 将超出范围的设置修正到允许的范围内，未设置（0）时使用 defaults 中的值
+func (s *Settings) Normalize(defaults Settings) {
+	s.MaxConcurrentDownloads = clampSetting(s.MaxConcurrentDownloads, defaults.MaxConcurrentDownloads, MaxConcurrentDownloadsLimit)
+	s.ThreadCount = clampSetting(s.ThreadCount, defaults.ThreadCount, ThreadCountLimit)
+	if s.RateLimit < 0 {
+		s.RateLimit = 0
+	}
+}
+
+func clampSetting(value, def, max int) int {
+	if value <= 0 {
+		value = def
+	}
+	if value > max {
+		value = max
+	}
+	if value < 1 {
+		value = 1
+	}
+	return value
+}
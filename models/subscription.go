@@ -0,0 +1,106 @@
+package models
+
+import "time"
+
+// Subscription 同步的课程：一个学员的指定课程或当前全部课程。同步时只下载上次同步以来新结束的讲
+type Subscription struct {
+	Platform    string   `json:"platform"`               // 平台
+	Username    string   `json:"username,omitempty"`     // 同步时登录使用的保存账号
+	StudentID   string   `json:"student_id"`             // 学员ID
+	StudentName string   `json:"student_name,omitempty"` // 学员昵称
+	AllCourses  bool     `json:"all_courses,omitempty"`  // 同步学员当前的全部课程，包括之后新报名的
+	CourseIDs   []string `json:"course_ids,omitempty"`   // 同步的课程ID，AllCourses 时忽略
+	Extensive   bool     `json:"extensive,omitempty"`    // 同时下载延伸内容，否则只报告新的延伸内容
+	Definition  string   `json:"definition,omitempty"`   // 清晰度偏好，为空时使用最高清晰度
+	Path        string   `json:"path"`                   // 下载目录（平台下载目录）
+
+	Courses  map[string]*SyncState `json:"courses,omitempty"` // 课程ID -> 同步状态
+	LastSync time.Time             `json:"last_sync,omitempty"`
+}
+
+// SyncState 一门课程的同步状态，按 liveId 记录
+type SyncState struct {
+	Synced          []int `json:"synced,omitempty"`           // 已下载（或本地已有）的讲
+	SyncedExtensive []int `json:"synced_extensive,omitempty"` // 已下载延伸内容的讲
+	Extensive       []int `json:"extensive,omitempty"`        // 已发现有延伸内容的讲
+}
+
+// Key 订阅的唯一标识（平台 + 学员ID），每个学员一个订阅
+func (s *Subscription) Key() string {
+	return s.Platform + "/" + s.StudentID
+}
+
+// Covers 课程是否在订阅中
+func (s *Subscription) Covers(courseID string) bool {
+	if s.AllCourses {
+		return true
+	}
+	for _, id := range s.CourseIDs {
+		if id == courseID {
+			return true
+		}
+	}
+	return false
+}
+
+// State 课程的同步状态，没有时创建
+func (s *Subscription) State(courseID string) *SyncState {
+	if s.Courses == nil {
+		s.Courses = make(map[string]*SyncState)
+	}
+	state, ok := s.Courses[courseID]
+	if !ok {
+		state = &SyncState{}
+		s.Courses[courseID] = state
+	}
+	return state
+}
+
+// IsSynced 一讲（或其延伸内容）是否已同步
+func (st *SyncState) IsSynced(liveID int, extensive bool) bool {
+	if extensive {
+		return containsInt(st.SyncedExtensive, liveID)
+	}
+	return containsInt(st.Synced, liveID)
+}
+
+// MarkSynced 记录一讲（或其延伸内容）已同步
+func (st *SyncState) MarkSynced(liveID int, extensive bool) {
+	if st.IsSynced(liveID, extensive) {
+		return
+	}
+	if extensive {
+		st.SyncedExtensive = append(st.SyncedExtensive, liveID)
+	} else {
+		st.Synced = append(st.Synced, liveID)
+	}
+}
+
+// HasExtensive 是否已发现一讲有延伸内容
+func (st *SyncState) HasExtensive(liveID int) bool {
+	return containsInt(st.Extensive, liveID)
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscriptionsData 所有订阅
+type SubscriptionsData struct {
+	Subscriptions []*Subscription `json:"subscriptions"`
+}
+
+// Find 按平台和学员ID查找订阅
+func (d *SubscriptionsData) Find(platform, studentID string) *Subscription {
+	for _, sub := range d.Subscriptions {
+		if sub.Platform == platform && sub.StudentID == studentID {
+			return sub
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package models
+
+// VideoSource 一讲回放的一种清晰度及其下载地址
+type VideoSource struct {
+	Definition string `json:"definition"` // 清晰度名称，如 "超清"；接口未区分清晰度时为空
+	URL        string `json:"url"`
+}
+
+// 清晰度偏好，也可以是具体的清晰度名称（如 "超清"）
+const (
+	DefinitionHighest = "highest"
+	DefinitionLowest  = "lowest"
+)
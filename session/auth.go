@@ -0,0 +1,119 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+// ErrNoCredentials 保存的账号没有记住密码，无法自动重新登录
+var ErrNoCredentials = errors.New("没有保存密码，无法自动重新登录")
+
+// ExpiredError 会话的登录已过期，且无法自动重新登录
+type ExpiredError struct {
+	Session *Session
+	Err     error // 自动重新登录失败的原因
+}
+
+func (e *ExpiredError) Error() string {
+	return fmt.Sprintf("%s 的登录已过期，请重新登录", e.Session.Label())
+}
+
+// Unwrap 使 api.IsAuthExpired 对该错误成立
+func (e *ExpiredError) Unwrap() error {
+	return &api.Error{Kind: api.ErrAuthExpired, Err: e.Err}
+}
+
+// Validate 检查会话的 token 是否有效，无效时使用保存的密码重新登录，并把新的 token 写回保存的账号。
+// 接口对过期 token 的业务错误码没有公开，因此除了明确的登录过期（见 api.Client.ValidateToken），
+// 业务错误也尝试重新登录，重新登录后验证通过才说明原来的 token 已失效。
+// 明确过期但无法重新登录时返回 *ExpiredError；没有保存密码时业务错误原样返回，显示接口自己的错误信息。
+// 网络错误等无法判断 token 是否有效的情况原样返回错误
+func (s *Session) Validate() error {
+	err := s.Client.ValidateToken()
+	if err == nil || (!api.IsAuthExpired(err) && api.KindOf(err) != api.ErrBusiness) {
+		return err
+	}
+	if rerr := s.Reauthenticate(); rerr != nil {
+		if api.IsAuthExpired(err) {
+			return &ExpiredError{Session: s, Err: rerr}
+		}
+		return err
+	}
+	return s.Client.ValidateToken()
+}
+
+// reauthLocks 每个客户端一把锁。共用客户端的会话和下载协程同时发现 token 失效时只重新登录一次
+var reauthLocks sync.Map
+
+// savedUserMu 保护保存账号的修改，同一账号下不同学员的会话共用 SavedUser
+var savedUserMu sync.Mutex
+
+func reauthLock(client *api.Client) *sync.Mutex {
+	lock, _ := reauthLocks.LoadOrStore(client, new(sync.Mutex))
+	return lock.(*sync.Mutex)
+}
+
+// Reauthenticate 使用保存的密码重新登录，登录后切换回会话原来的学员。登录和切换在客户端的副本上进行，
+// 完成后一次性替换会话客户端的 token，正在进行的请求不会读到中间状态。
+// 保存的账号沿用之前选择的学员（见 switch-student），得到该学员的 token 时写回
+func (s *Session) Reauthenticate() error {
+	user := s.SavedUser
+	if user == nil || user.Password == "" {
+		return ErrNoCredentials
+	}
+
+	stale, studentID := s.Client.GetAuth()
+	lock := reauthLock(s.Client)
+	lock.Lock()
+	defer lock.Unlock()
+	if token, _ := s.Client.GetAuth(); token != stale {
+		return nil // 等待期间其他请求已经重新登录
+	}
+
+	client := s.Client.Clone()
+	auth, err := client.LoginWithPassword(user.Username, user.Password)
+	if err != nil {
+		return err
+	}
+	client.SetAuth(auth.Token, auth.UserID)
+
+	// 登录后是账号的默认学员，切换到会话原来的学员
+	if studentID == "" {
+		studentID = auth.UserID
+	}
+	if studentID != auth.UserID {
+		if err := client.SwitchStudentAccount(auth.UserID, studentID); err != nil {
+			return fmt.Errorf("切换回学员失败: %w", err)
+		}
+	}
+	token, _ := client.GetAuth()
+	s.Client.SetAuth(token, studentID)
+
+	savedUserMu.Lock()
+	defer savedUserMu.Unlock()
+	savedID := user.UserID
+	if savedID == "" {
+		savedID = auth.UserID
+	}
+	switch savedID {
+	case studentID:
+		user.Token = token
+	case auth.UserID:
+		user.Token = auth.Token
+	default:
+		// 没有得到保存的学员的 token，该学员的会话验证时会自己重新登录
+		return nil
+	}
+	user.UserID = savedID
+	if savedID == auth.UserID && auth.Nickname != "" {
+		user.Nickname = auth.Nickname
+	}
+	if err := utils.SaveUser(*user); err != nil {
+		return fmt.Errorf("保存用户信息失败: %w", err)
+	}
+	return nil
+}
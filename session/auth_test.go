@@ -0,0 +1,131 @@
+package session_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/faketal"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+const (
+	loginPath  = "/v1/web/login/pwd"
+	switchPath = "/passport/v2/login/student/change-stu"
+)
+
+// openSecondStudent 登录后切换到账号下的第二个学员，返回该学员的会话
+func openSecondStudent(t *testing.T, password string) (*faketal.Server, *session.Session) {
+	t.Helper()
+	utils.SetRootPath(t.TempDir())
+	t.Cleanup(func() { utils.SetRootPath("") })
+
+	srv := faketal.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddAccount(&faketal.Account{
+		Platform: "ledu",
+		Username: "13800000000",
+		Password: "secret",
+		Students: []*faketal.Student{{UID: 1001, Nickname: "小明"}, {UID: 1002, Nickname: "小红"}},
+	})
+
+	client := api.NewClient(srv.Platform("ledu"))
+	auth, err := client.LoginWithPassword("13800000000", "secret")
+	if err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+	client.SetAuth(auth.Token, auth.UserID)
+	base := &session.Session{Client: client, StudentName: "小明", SavedUser: &models.SavedUser{
+		Username: "13800000000",
+		Password: password,
+		Platform: "ledu",
+		UserID:   auth.UserID,
+		Token:    auth.Token,
+		Nickname: "小明",
+	}}
+	s, err := session.ForStudent(base, &models.StudentAccount{PuUID: 1002, Nickname: "小红"})
+	if err != nil {
+		t.Fatalf("切换学员失败: %v", err)
+	}
+	return srv, s
+}
+
+func TestReauthenticateKeepsStudent(t *testing.T) {
+	srv, s := openSecondStudent(t, "secret")
+	srv.ExpireTokens()
+	logins, switches := srv.Requests(loginPath), srv.Requests(switchPath)
+
+	if err := s.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if got := srv.Requests(loginPath) - logins; got != 1 {
+		t.Errorf("re-authentication logged in %d times, want 1", got)
+	}
+	if got := srv.Requests(switchPath) - switches; got != 1 {
+		t.Errorf("re-authentication switched student %d times, want 1", got)
+	}
+	if s.StudentID() != "1002" {
+		t.Errorf("student after re-authentication = %s, want 1002", s.StudentID())
+	}
+	if _, err := s.Client.GetCourseList(); err != nil {
+		t.Errorf("GetCourseList after re-authentication: %v", err)
+	}
+
+	// 保存的账号是第一个学员，没有得到它的 token 时不写回
+	if s.SavedUser.UserID != "1001" {
+		t.Errorf("saved user switched to %s", s.SavedUser.UserID)
+	}
+}
+
+func TestConcurrentValidateLogsInOnce(t *testing.T) {
+	srv, s := openSecondStudent(t, "secret")
+	srv.ExpireTokens()
+	logins := srv.Requests(loginPath)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Validate()
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("Validate: %v", err)
+		}
+	}
+	if got := srv.Requests(loginPath) - logins; got != 1 {
+		t.Errorf("concurrent re-authentication logged in %d times, want 1", got)
+	}
+}
+
+func TestValidateWithoutCredentials(t *testing.T) {
+	srv, s := openSecondStudent(t, "")
+	srv.ExpireTokens()
+	logins := srv.Requests(loginPath)
+
+	// 无法重新登录确认时，接口的业务错误原样返回
+	err := s.Validate()
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) || apiErr.Kind != api.ErrBusiness || apiErr.Code != faketal.ExpiredTokenCode {
+		t.Fatalf("Validate = %v, want the business error", err)
+	}
+	if srv.Requests(loginPath) != logins {
+		t.Error("logged in without a saved password")
+	}
+
+	// 明确的登录过期无法重新登录时返回 ExpiredError
+	srv.InjectFault(&faketal.Fault{Path: "/passport/v1/students/account-list", Times: 1, Status: 401})
+	err = s.Validate()
+	var expired *session.ExpiredError
+	if !errors.As(err, &expired) || !api.IsAuthExpired(err) || !errors.Is(expired.Err, session.ErrNoCredentials) {
+		t.Errorf("Validate = %v, want ExpiredError", err)
+	}
+}
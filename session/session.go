@@ -0,0 +1,164 @@
+// Package session 管理多个同时登录的学员，每个学员使用独立的 api.Client
+package session
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/config"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+// Session 一个已登录学员的客户端
+type Session struct {
+	Client      *api.Client
+	StudentName string            // 学员昵称
+	SavedUser   *models.SavedUser // 对应的保存账号，直接使用 token 登录时为 nil
+}
+
+// StudentID 当前学员ID
+func (s *Session) StudentID() string {
+	_, studentID := s.Client.GetAuth()
+	return studentID
+}
+
+// Platform 学员所属的平台
+func (s *Session) Platform() *config.Platform {
+	return s.Client.Platform()
+}
+
+// Key 会话的唯一标识（平台 + 学员ID）
+func (s *Session) Key() string {
+	return s.Platform().Name + "/" + s.StudentID()
+}
+
+// Label 会话的显示名称
+func (s *Session) Label() string {
+	if s.StudentName == "" {
+		return fmt.Sprintf("%s (%s)", s.StudentID(), s.Platform().Name)
+	}
+	return fmt.Sprintf("%s (%s)", s.StudentName, s.Platform().Name)
+}
+
+// DirName 同时下载多个学员时，学员的下载目录名
+func (s *Session) DirName() string {
+	if s.StudentName != "" {
+		return utils.SanitizeFileName(s.StudentName)
+	}
+	return s.StudentID()
+}
+
+// Manager 保存多个已登录的会话，可以来自不同的保存账号、平台或同一账号下的不同学员
+type Manager struct {
+	mu       sync.RWMutex
+	sessions []*Session
+}
+
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// Add 添加会话，同一平台的同一学员只保留最后添加的会话
+func (m *Manager) Add(s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, existing := range m.sessions {
+		if existing.Key() == s.Key() {
+			m.sessions[i] = s
+			return
+		}
+	}
+	m.sessions = append(m.sessions, s)
+}
+
+// Remove 移除会话
+func (m *Manager) Remove(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, s := range m.sessions {
+		if s.Key() == key {
+			m.sessions = append(m.sessions[:i], m.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// Clear 移除所有会话
+func (m *Manager) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions = nil
+}
+
+// Get 按标识查找会话
+func (m *Manager) Get(key string) *Session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, s := range m.sessions {
+		if s.Key() == key {
+			return s
+		}
+	}
+	return nil
+}
+
+// Sessions 所有会话（按添加顺序）
+func (m *Manager) Sessions() []*Session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make([]*Session, len(m.sessions))
+	copy(result, m.sessions)
+	return result
+}
+
+// Len 会话数量
+func (m *Manager) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.sessions)
+}
+
+// OpenSavedUser 使用保存的账号创建会话（学员为账号保存时的学员），opts 传给 api.NewClient
+func OpenSavedUser(user models.SavedUser, opts ...api.Option) (*Session, error) {
+	platform, err := config.GetPlatform(user.Platform)
+	if err != nil {
+		return nil, err
+	}
+	client := api.NewClient(platform, opts...)
+	client.SetAuth(user.Token, user.UserID)
+	return &Session{Client: client, StudentName: user.Nickname, SavedUser: &user}, nil
+}
+
+// ForStudent 为同一账号下的另一个学员创建会话。
+// 切换在复制出的客户端上进行，不影响 base 的登录态
+func ForStudent(base *Session, account *models.StudentAccount) (*Session, error) {
+	studentID := fmt.Sprint(account.PuUID)
+	if studentID == base.StudentID() {
+		return base, nil
+	}
+
+	client := base.Client.Clone()
+	if err := client.SwitchStudentAccount(base.StudentID(), studentID); err != nil {
+		return nil, fmt.Errorf("切换到学员 %s 失败: %w", account.Nickname, err)
+	}
+	client.SetAuth("", studentID)
+	return &Session{Client: client, StudentName: account.Nickname, SavedUser: base.SavedUser}, nil
+}
+
+// ForStudents 为账号下选中的学员分别创建会话
+func ForStudents(base *Session, accounts []*models.StudentAccount) ([]*Session, error) {
+	sessions := make([]*Session, 0, len(accounts))
+	for _, account := range accounts {
+		s, err := ForStudent(base, account)
+		if err != nil {
+			return nil, err
+		}
+		if s == base && base.StudentName == "" {
+			base.StudentName = account.Nickname
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,85 @@
+// Package subscription 计算订阅课程需要同步的讲次：与上次同步的状态比较，
+// 找出新结束的讲和新出现的延伸内容
+package subscription
+
+import (
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
+)
+
+// CourseDiff 一门订阅课程的同步结果，讲次均为 Lectures 中的下标
+type CourseDiff struct {
+	Course   *models.Course
+	Lectures []*models.Lecture // 课程的全部讲次
+
+	New          []int // 上次同步以来新结束、需要下载的讲
+	NewExtensive []int // 需要下载延伸内容的讲（订阅包含延伸内容时）
+	// FoundExtensive 本次新发现有延伸内容的讲，订阅不包含延伸内容时只报告
+	FoundExtensive []int
+}
+
+// Empty 是否没有需要下载或报告的内容
+func (d *CourseDiff) Empty() bool {
+	return len(d.New) == 0 && len(d.NewExtensive) == 0 && len(d.FoundExtensive) == 0
+}
+
+// Diff 获取学员的课程和讲次，与订阅的同步状态比较。发现的延伸内容记录在 sub 中，
+// 调用方需要用 utils.UpdateSubscription 保存；已下载的讲在下载完成时由 utils.MarkSynced 记录。
+// 获取某门课程的讲次失败时跳过该课程，错误在 errs 中返回
+func Diff(s *session.Session, sub *models.Subscription) (diffs []*CourseDiff, errs []error, err error) {
+	courses, err := s.Client.GetCourseList()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, course := range courses {
+		if !sub.Covers(course.CourseID) {
+			continue
+		}
+		lectures, err := s.Client.GetLectures(course.CourseID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		diffs = append(diffs, diffCourse(s, sub, course, lectures))
+	}
+	sub.LastSync = time.Now()
+	return diffs, errs, nil
+}
+
+func diffCourse(s *session.Session, sub *models.Subscription, course *models.Course, lectures []*models.Lecture) *CourseDiff {
+	d := &CourseDiff{Course: course, Lectures: lectures}
+	state := sub.State(course.CourseID)
+
+	for j, lecture := range lectures {
+		if lecture.Status == models.LectureStatusCancelled || !lecture.Ended(j, course) {
+			continue
+		}
+		if !state.IsSynced(lecture.LiveID, false) {
+			d.New = append(d.New, j)
+		}
+
+		hasExtensive := state.HasExtensive(lecture.LiveID)
+		if !hasExtensive && extensiveAvailable(s, course, lecture) {
+			// 延伸内容可能在回放之后才上线，每次同步都检查还没有发现延伸内容的讲
+			hasExtensive = true
+			state.Extensive = append(state.Extensive, lecture.LiveID)
+			d.FoundExtensive = append(d.FoundExtensive, j)
+		}
+		if hasExtensive && sub.Extensive && !state.IsSynced(lecture.LiveID, true) {
+			d.NewExtensive = append(d.NewExtensive, j)
+		}
+	}
+	return d
+}
+
+// extensiveAvailable 一讲是否有延伸内容（能否获取到延伸课程的回放地址）。
+// 网络错误等情况也视为没有，下次同步时再检查
+func extensiveAvailable(s *session.Session, course *models.Course, lecture *models.Lecture) bool {
+	probe := *lecture
+	probe.LiveTypeString = "ONLINE_REAL_RECORD"
+	_, err := s.Client.GetVideoSources(&probe, course.CourseID, course.TutorID)
+	return err == nil
+}
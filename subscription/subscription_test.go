@@ -0,0 +1,85 @@
+package subscription_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/faketal"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
+	"github.com/itsHenry35/tal_downloader/subscription"
+)
+
+func TestDiff(t *testing.T) {
+	srv := faketal.NewServer()
+	defer srv.Close()
+
+	ended := func(id, num int) *faketal.Lecture {
+		return &faketal.Lecture{Lecture: models.Lecture{LiveID: id, Num: num, LiveTypeString: "SMALL_CLASS_MODE", Status: models.LectureStatusEnded}}
+	}
+	math := &faketal.Course{Course: models.Course{CourseID: "math", CourseName: "数学", EndLiveNum: 3}, Lectures: []*faketal.Lecture{
+		ended(101, 1), ended(102, 2), ended(103, 3),
+		{Lecture: models.Lecture{LiveID: 104, Num: 4, Status: models.LectureStatusNotStarted}},
+	}}
+	// 第2讲有延伸内容
+	math.Lectures[1].Definitions = map[string][]string{"高清": {"https://cdn.example.com/102/ext.mp4"}}
+	english := &faketal.Course{Course: models.Course{CourseID: "english", CourseName: "英语", EndLiveNum: 1}, Lectures: []*faketal.Lecture{ended(201, 1)}}
+	srv.AddAccount(&faketal.Account{
+		Platform: "ledu",
+		Username: "13800000000",
+		Password: "secret",
+		Students: []*faketal.Student{{UID: 1001, Nickname: "小明", Courses: []*faketal.Course{math, english}}},
+	})
+
+	client := api.NewClient(srv.Platform("ledu"))
+	client.SetRetryPolicy(api.RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	auth, err := client.LoginWithPassword("13800000000", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetAuth(auth.Token, auth.UserID)
+	s := &session.Session{Client: client}
+
+	sub := &models.Subscription{Platform: "ledu", StudentID: "1001", CourseIDs: []string{"math"}}
+	sub.State("math").MarkSynced(101, false)
+
+	diffs, errs, err := subscription.Diff(s, sub)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("Diff: %v %v", err, errs)
+	}
+	if len(diffs) != 1 || diffs[0].Course.CourseID != "math" {
+		t.Fatalf("diffs = %+v, want only the subscribed course", diffs)
+	}
+	d := diffs[0]
+	if !reflect.DeepEqual(d.New, []int{1, 2}) {
+		t.Errorf("new = %v, want [1 2]", d.New)
+	}
+	if !reflect.DeepEqual(d.FoundExtensive, []int{1}) || len(d.NewExtensive) != 0 {
+		t.Errorf("found extensive = %v, new extensive = %v", d.FoundExtensive, d.NewExtensive)
+	}
+	if sub.LastSync.IsZero() || !sub.State("math").HasExtensive(102) {
+		t.Errorf("subscription state not updated: %+v", sub.State("math"))
+	}
+
+	// 已发现的延伸内容不再报告，订阅包含延伸内容时下载
+	sub.Extensive = true
+	sub.State("math").MarkSynced(102, false)
+	sub.State("math").MarkSynced(103, false)
+	probes := srv.Requests("/classroom-ai/record/v1/resources")
+	diffs, _, _ = subscription.Diff(s, sub)
+	d = diffs[0]
+	if len(d.New) != 0 || len(d.FoundExtensive) != 0 || !reflect.DeepEqual(d.NewExtensive, []int{1}) {
+		t.Errorf("second diff = new %v, found %v, extensive %v", d.New, d.FoundExtensive, d.NewExtensive)
+	}
+	if n := srv.Requests("/classroom-ai/record/v1/resources") - probes; n != 2 {
+		t.Errorf("probed %d lectures, want 2 (lectures without known extension content)", n)
+	}
+
+	sub.State("math").MarkSynced(102, true)
+	diffs, _, _ = subscription.Diff(s, sub)
+	if !diffs[0].Empty() {
+		t.Errorf("fully synced course diff = %+v", diffs[0])
+	}
+}
@@ -0,0 +1,127 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/api"
+	"github.com/itsHenry35/tal_downloader/config"
+	"github.com/itsHenry35/tal_downloader/session"
+	"github.com/itsHenry35/tal_downloader/utils"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// baseSession 当前登录账号（apiClient）对应的会话
+func (m *Manager) baseSession() *session.Session {
+	s := &session.Session{Client: m.apiClient, SavedUser: m.savedUser}
+	if m.savedUser != nil {
+		s.StudentName = m.savedUser.Nickname
+	}
+	return s
+}
+
+// savedUserKey 保存账号的标识
+func savedUserKey(platform, username string) string {
+	return platform + "/" + username
+}
+
+// CheckSavedUsers 启动时在后台检查保存账号的登录状态。
+// 过期且记住了密码的账号自动重新登录，其余的在账号列表中标记为已过期
+func (m *Manager) CheckSavedUsers() {
+	go func() {
+		data, err := utils.LoadSavedUsers()
+		if err != nil {
+			return
+		}
+		for _, user := range data.Users {
+			s, err := session.OpenSavedUser(user)
+			if err != nil {
+				continue
+			}
+			err = s.Validate()
+			fyne.Do(func() {
+				m.expiredUsers[savedUserKey(user.Platform, user.Username)] = api.IsAuthExpired(err)
+			})
+		}
+	}()
+}
+
+// withValidSessions 切换页面前检查会话的登录状态（间隔 config.TokenCheckInterval 以上才重新检查），
+// 登录有效或无法判断（如网络错误）时执行 next，已过期且无法自动重新登录时提示重新登录，
+// 接口返回业务错误时显示接口的错误信息
+func (m *Manager) withValidSessions(sessions []*session.Session, next func()) {
+	var pending []*session.Session
+	for _, s := range sessions {
+		if s.Client == nil || time.Since(m.tokenCheckedAt[s.Key()]) < config.TokenCheckInterval {
+			continue
+		}
+		pending = append(pending, s)
+	}
+	if len(pending) == 0 {
+		next()
+		return
+	}
+
+	progressDialog := dialog.NewProgressInfinite("检查中...", "正在检查登录状态", m.window)
+	progressDialog.Show()
+
+	go func() {
+		var expired *session.Session
+		var rejected error
+		var checked []string
+		for _, s := range pending {
+			err := s.Validate()
+			if api.IsAuthExpired(err) {
+				expired = s
+				break
+			}
+			if api.KindOf(err) == api.ErrBusiness {
+				rejected = fmt.Errorf("%s: %w", s.Label(), err)
+				break
+			}
+			if err == nil {
+				checked = append(checked, s.Key())
+			}
+		}
+
+		fyne.Do(func() {
+			progressDialog.Dismiss()
+			for _, key := range checked {
+				m.tokenCheckedAt[key] = time.Now()
+			}
+			if expired != nil {
+				m.promptRelogin(expired)
+				return
+			}
+			if rejected != nil {
+				utils.ShowErrorDialog(rejected, m.window)
+				return
+			}
+			next()
+		})
+	}()
+}
+
+// promptRelogin 提示会话的登录已过期，确认后返回登录页面并预填该账号
+func (m *Manager) promptRelogin(s *session.Session) {
+	message := "登录已过期，请重新登录"
+	if s.SavedUser != nil || s.StudentName != "" {
+		message = fmt.Sprintf("%s 的登录已过期，请重新登录", s.Label())
+	}
+	utils.ShowCustomConfirm("登录已过期", "重新登录", "取消",
+		container.NewVBox(widget.NewLabel(message)),
+		func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			m.reloginUser = s.SavedUser
+			if s.SavedUser != nil {
+				m.expiredUsers[savedUserKey(s.SavedUser.Platform, s.SavedUser.Username)] = true
+			}
+			m.ShowLogin()
+		}, m.window)
+}
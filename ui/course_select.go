@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"path/filepath"
 
-	"github.com/itsHenry35/tal_downloader/config"
 	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
 	"github.com/itsHenry35/tal_downloader/utils"
 
 	"fyne.io/fyne/v2"
@@ -17,22 +17,37 @@ import (
 
 type CourseSelectionScreen struct {
 	manager           *Manager
-	courseChecks      map[string]*widget.Check
-	courses           []*models.Course
+	courseChecks      map[string]*widget.Check // 课程标识（见 courseSelection.key）-> 复选框
+	courses           []*courseSelection       // 所有选中学员的课程，讲次在开始下载时填写
 	downloadPath      string
 	extensiveCheck    *widget.Check
 	overwriteCheck    *widget.Check
+	definitionSelect  *widget.Select
 	container         *fyne.Container
 	courseList        *fyne.Container
-	lectureSelections map[string][]int // courseID -> selected lecture indices
+	lectureSelections map[string][]int             // 课程标识 -> selected lecture indices
+	endedOnly         map[string]bool              // 课程标识 -> 没有在对话框中选择讲次，下载所有已结束的讲
+	lectures          map[string][]*models.Lecture // 课程标识 -> 打开过选择对话框的课程的讲次列表
 }
 
-func getDownloadFolderName() string {
-	return fmt.Sprintf("%s-下载", config.PlatformName)
+// definitionOptions 清晰度选项的显示文本与对应的清晰度偏好
+var definitionOptions = []struct {
+	label      string
+	definition string
+}{
+	{"最高清晰度", models.DefinitionHighest},
+	{"最低清晰度", models.DefinitionLowest},
+	{"超清", "超清"},
+	{"高清", "高清"},
+	{"标清", "标清"},
+}
+
+func (m *Manager) getDownloadFolderName() string {
+	return m.apiClient.Platform().DownloadFolderName()
 }
 
 func NewCourseSelectionScreen(manager *Manager) fyne.CanvasObject {
-	downloadPath := filepath.Join(".", getDownloadFolderName())
+	downloadPath := filepath.Join(".", manager.getDownloadFolderName())
 	if utils.IsAndroid() {
 		// 安卓使用应用存储的temp目录
 		downloadPath = "temp"
@@ -42,6 +57,8 @@ func NewCourseSelectionScreen(manager *Manager) fyne.CanvasObject {
 		manager:           manager,
 		courseChecks:      make(map[string]*widget.Check),
 		lectureSelections: make(map[string][]int),
+		endedOnly:         make(map[string]bool),
+		lectures:          make(map[string][]*models.Lecture),
 		downloadPath:      downloadPath,
 	}
 	cs.loadCourses()
@@ -58,18 +75,97 @@ func (cs *CourseSelectionScreen) loadCourses() {
 			progressDialog.Dismiss()
 		})
 
-		courses, err := cs.manager.apiClient.GetCourseList()
-		if err != nil {
-			utils.ShowErrorDialog(err, cs.manager.window)
-			return
+		sessions := cs.manager.sessions.Sessions()
+		var courses []*courseSelection
+		for _, s := range sessions {
+			list, err := s.Client.GetCourseList()
+			if err != nil {
+				if len(sessions) > 1 {
+					err = fmt.Errorf("获取 %s 的课程失败: %w", s.Label(), err)
+				}
+				cs.manager.showAPIError(err)
+				continue
+			}
+			for _, course := range list {
+				courses = append(courses, &courseSelection{session: s, course: course})
+			}
 		}
-		cs.courses = courses
+
 		fyne.Do(func() {
+			cs.courses = courses
 			cs.updateCourseList()
+			if len(sessions) == 1 {
+				cs.promptRestoreDownloads(sessions[0])
+			}
 		})
 	}()
 }
 
+// promptRestoreDownloads 学员有未完成的下载时，询问是否继续（每次运行只提示一次）
+func (cs *CourseSelectionScreen) promptRestoreDownloads(s *session.Session) {
+	key := s.Key()
+	if cs.manager.restorePrompted[key] {
+		return
+	}
+	cs.manager.restorePrompted[key] = true
+
+	jobs, err := utils.GetUnfinishedDownloadJobs(s.Platform().Name, s.StudentID())
+	if err != nil || len(jobs) == 0 {
+		return
+	}
+
+	utils.ShowCustomConfirm("恢复下载", "继续下载", "忽略",
+		container.NewVBox(widget.NewLabel(fmt.Sprintf("上次有 %d 讲未下载完成，是否继续下载？", len(jobs)))),
+		func(confirmed bool) {
+			if confirmed {
+				cs.restoreDownloads(s, jobs)
+			}
+		}, cs.manager.window)
+}
+
+// restoreDownloads 按下载记录恢复下载，文件保存到记录中的路径
+// 一次只恢复与第一条记录延伸内容选项和清晰度相同的任务，其余的在下次启动时再提示
+func (cs *CourseSelectionScreen) restoreDownloads(s *session.Session, jobs []models.DownloadJob) {
+	first := jobs[0]
+	downloadPath := filepath.Dir(filepath.Dir(first.FilePath))
+
+	var selections []*courseSelection
+	selected := make(map[string]*courseSelection)
+	for _, job := range jobs {
+		if job.Extensive != first.Extensive || job.Definition != first.Definition {
+			continue
+		}
+
+		sel, ok := selected[job.Course.CourseID]
+		if !ok {
+			// 优先使用最新的课程信息（已结束讲数可能有变化）
+			saved := job.Course
+			sel = &courseSelection{session: s, course: &saved, paths: make(map[int]string), extensive: first.Extensive}
+			for _, c := range cs.courses {
+				if c.session == s && c.course.CourseID == job.Course.CourseID {
+					sel.course = c.course
+					break
+				}
+			}
+			selected[job.Course.CourseID] = sel
+			selections = append(selections, sel)
+		}
+		sel.lectures = append(sel.lectures, job.LectureIndex)
+		sel.paths[job.LectureIndex] = job.FilePath
+	}
+
+	cs.manager.selections = selections
+	cs.manager.downloadPath = downloadPath
+	// 沿用上次下载的清晰度，以便继续下载同一个文件
+	cs.manager.definition = first.Definition
+	if cs.manager.definition == "" {
+		cs.manager.definition = models.DefinitionHighest
+	}
+	// 未完成的文件会断点续传，已完成的文件直接跳过
+	cs.manager.isOverwrite = false
+	cs.manager.ShowDownloadProgress()
+}
+
 func (cs *CourseSelectionScreen) buildUI() {
 	title := widget.NewLabelWithStyle("选择要下载的课程", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
 
@@ -85,6 +181,13 @@ func (cs *CourseSelectionScreen) buildUI() {
 
 	cs.extensiveCheck = widget.NewCheck("下载延伸课程", nil)
 	cs.overwriteCheck = widget.NewCheck("覆盖已下载文件", nil)
+	labels := make([]string, len(definitionOptions))
+	for i, option := range definitionOptions {
+		labels[i] = option.label
+	}
+	cs.definitionSelect = widget.NewSelect(labels, nil)
+	cs.definitionSelect.SetSelectedIndex(0)
+	definitionRow := container.NewHBox(widget.NewLabel("清晰度:"), cs.definitionSelect)
 	scrollContainer := container.NewStack(scroll)
 
 	// 安卓平台不显示路径选择
@@ -98,7 +201,7 @@ func (cs *CourseSelectionScreen) buildUI() {
 					return
 				}
 				if uri != nil {
-					cs.downloadPath = filepath.Join(uri.Path(), getDownloadFolderName())
+					cs.downloadPath = filepath.Join(uri.Path(), cs.manager.getDownloadFolderName())
 					pathLabel.SetText(fmt.Sprintf("下载路径: %s", cs.downloadPath))
 				}
 			}, cs.manager.window)
@@ -113,28 +216,30 @@ func (cs *CourseSelectionScreen) buildUI() {
 	}
 
 	selectAllButton := widget.NewButton("全选", func() {
-		for courseID, check := range cs.courseChecks {
-			check.SetChecked(true)
-			// 获取对应课程的讲数并全选
-			for _, course := range cs.courses {
-				if course.CourseID == courseID {
-					lectures := make([]int, course.EndLiveNum)
-					for i := range lectures {
-						lectures[i] = i
-					}
-					cs.lectureSelections[courseID] = lectures
-					break
-				}
-			}
+		for _, sel := range cs.courses {
+			key := sel.key()
+			cs.courseChecks[key].SetChecked(true)
+			// 全选对应课程的所有讲
+			cs.selectEnded(key, sel.course)
 		}
 	})
 	deselectAllButton := widget.NewButton("取消全选", func() {
-		for courseID, check := range cs.courseChecks {
+		for key, check := range cs.courseChecks {
 			check.SetChecked(false)
-			cs.lectureSelections[courseID] = []int{}
+			cs.lectureSelections[key] = []int{}
+			delete(cs.endedOnly, key)
 		}
 	})
 
+	historyButton := widget.NewButton("下载记录", func() {
+		showDownloadHistoryDialog(cs.manager.window)
+	})
+
+	settingsButton := widget.NewButton("下载设置", cs.manager.showSettingsDialog)
+
+	subscribeButton := widget.NewButton("订阅", cs.subscribeSelected)
+	syncButton := widget.NewButton("同步订阅", cs.syncSubscriptions)
+
 	downloadButton := widget.NewButton("开始下载", cs.startDownload)
 	downloadButton.Importance = widget.HighImportance
 
@@ -155,11 +260,13 @@ func (cs *CourseSelectionScreen) buildUI() {
 		optionsContainer = container.NewVBox(
 			cs.extensiveCheck,
 			cs.overwriteCheck,
+			definitionRow,
 			pathContainer,
 		)
 	} else {
 		optionsContainer = container.NewVBox(
 			cs.extensiveCheck,
+			definitionRow,
 		)
 	}
 
@@ -171,6 +278,10 @@ func (cs *CourseSelectionScreen) buildUI() {
 				selectAllButton,
 				deselectAllButton,
 				layout.NewSpacer(),
+				historyButton,
+				settingsButton,
+				subscribeButton,
+				syncButton,
 				downloadButton,
 			),
 		),
@@ -188,35 +299,38 @@ func (cs *CourseSelectionScreen) buildUI() {
 
 func (cs *CourseSelectionScreen) updateCourseList() {
 	cs.courseList.Objects = nil
+	multiStudent := cs.manager.sessions.Len() > 1
+	var lastSession *session.Session
 	// 添加课程复选框
-	for _, course := range cs.courses {
-		courseCopy := course // 避免闭包问题
+	for _, sel := range cs.courses {
+		sel := sel // 避免闭包问题
+		course := sel.course
+		key := sel.key()
+
+		// 同时选择多个学员时按学员分组显示
+		if multiStudent && sel.session != lastSession {
+			lastSession = sel.session
+			cs.courseList.Add(widget.NewLabelWithStyle(sel.session.Label(), fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+		}
 
 		check := widget.NewCheck(course.SubjectName+" - "+course.CourseName, func(checked bool) {
-			if checked && len(cs.lectureSelections[courseCopy.CourseID]) == 0 {
+			if checked && len(cs.lectureSelections[key]) == 0 {
 				// 如果勾选但没有选择讲数，默认全选
-				lectures := make([]int, courseCopy.EndLiveNum)
-				for i := range lectures {
-					lectures[i] = i
-				}
-				cs.lectureSelections[courseCopy.CourseID] = lectures
+				cs.selectEnded(key, course)
 			} else if !checked {
 				// 取消勾选时清空选择
-				cs.lectureSelections[courseCopy.CourseID] = []int{}
+				cs.lectureSelections[key] = []int{}
+				delete(cs.endedOnly, key)
 			}
 		})
-		cs.courseChecks[course.CourseID] = check
+		cs.courseChecks[key] = check
 
 		// 默认全选所有讲
-		lectures := make([]int, course.EndLiveNum)
-		for i := range lectures {
-			lectures[i] = i
-		}
-		cs.lectureSelections[course.CourseID] = lectures
+		cs.selectEnded(key, course)
 
 		// 创建选择讲数的按钮
 		selectLecturesBtn := widget.NewButton("...", func() {
-			cs.showLectureSelectionDialog(courseCopy)
+			cs.showLectureSelectionDialog(sel)
 		})
 
 		// 创建课程行
@@ -226,20 +340,80 @@ func (cs *CourseSelectionScreen) updateCourseList() {
 	cs.courseList.Refresh()
 }
 
-func (cs *CourseSelectionScreen) showLectureSelectionDialog(course *models.Course) {
+// selectEnded 选择课程所有已结束的讲。讲次列表还没有获取时先按已结束讲数填写，
+// 开始下载时再按讲次状态确定（见 courseSelection.selectedLectures）
+func (cs *CourseSelectionScreen) selectEnded(key string, course *models.Course) {
+	if lectures, ok := cs.lectures[key]; ok {
+		cs.lectureSelections[key] = models.EndedLectures(lectures, course)
+		delete(cs.endedOnly, key)
+		return
+	}
+	lectures := make([]int, course.EndLiveNum)
+	for i := range lectures {
+		lectures[i] = i
+	}
+	cs.lectureSelections[key] = lectures
+	cs.endedOnly[key] = true
+}
+
+// showLectureSelectionDialog 获取课程的讲次列表后显示选择对话框
+func (cs *CourseSelectionScreen) showLectureSelectionDialog(sel *courseSelection) {
+	key := sel.key()
+	if lectures, ok := cs.lectures[key]; ok {
+		cs.showLectureChecks(sel, lectures)
+		return
+	}
+
+	progressDialog := dialog.NewProgressInfinite("加载中...", "正在获取讲次列表", cs.manager.window)
+	progressDialog.Show()
+	go func() {
+		lectures, err := sel.session.Client.GetLectures(sel.course.CourseID)
+		fyne.Do(func() {
+			progressDialog.Dismiss()
+			if err != nil {
+				cs.manager.showAPIError(err)
+				return
+			}
+			cs.lectures[key] = lectures
+			cs.showLectureChecks(sel, lectures)
+		})
+	}()
+}
+
+// showLectureChecks 显示讲次的标题、时间、老师和时长，尚未结束或已取消的讲不能选择
+func (cs *CourseSelectionScreen) showLectureChecks(sel *courseSelection, lectures []*models.Lecture) {
+	course := sel.course
+	key := sel.key()
+
 	// 创建讲数选择列表
-	lectureChecks := make([]*widget.Check, course.EndLiveNum)
-	selectedLectures := cs.lectureSelections[course.CourseID]
+	lectureChecks := make([]*widget.Check, len(lectures))
+	selectedLectures := cs.lectureSelections[key]
+	available := 0
 
 	// 创建一个map来快速查找已选中的讲
 	selectedMap := make(map[int]bool)
 	for _, idx := range selectedLectures {
 		selectedMap[idx] = true
 	}
+	if cs.endedOnly[key] {
+		selectedMap = make(map[int]bool)
+		for _, idx := range models.EndedLectures(lectures, course) {
+			selectedMap[idx] = true
+		}
+	}
 
-	for i := 0; i < course.EndLiveNum; i++ {
-		lectureChecks[i] = widget.NewCheck(fmt.Sprintf("第%d讲", i+1), nil)
+	for i, lecture := range lectures {
+		label := lecture.Label(i)
+		if status := lecture.StatusText(); status != "" && lecture.Status != models.LectureStatusEnded {
+			label += " (" + status + ")"
+		}
+		lectureChecks[i] = widget.NewCheck(label, nil)
+		if lecture.Status == models.LectureStatusCancelled || !lecture.Ended(i, course) {
+			lectureChecks[i].Disable()
+			continue
+		}
 		lectureChecks[i].SetChecked(selectedMap[i])
+		available++
 	}
 
 	// 创建滚动容器
@@ -253,7 +427,9 @@ func (cs *CourseSelectionScreen) showLectureSelectionDialog(course *models.Cours
 	// 全选和全不选按钮
 	selectAllBtn := widget.NewButton("全选", func() {
 		for _, check := range lectureChecks {
-			check.SetChecked(true)
+			if !check.Disabled() {
+				check.SetChecked(true)
+			}
 		}
 	})
 
@@ -274,19 +450,20 @@ func (cs *CourseSelectionScreen) showLectureSelectionDialog(course *models.Cours
 				selected = append(selected, i)
 			}
 		}
-		cs.lectureSelections[course.CourseID] = selected
+		cs.lectureSelections[key] = selected
+		delete(cs.endedOnly, key)
 
 		// 更新主复选框状态
-		if check, ok := cs.courseChecks[course.CourseID]; ok {
+		if check, ok := cs.courseChecks[key]; ok {
 			if len(selected) == 0 {
 				check.SetChecked(false)
-			} else if len(selected) == course.EndLiveNum {
+			} else if len(selected) == available {
 				check.SetChecked(true)
 			} else {
 				// 部分选中状态 - Fyne不支持三态复选框，所以保持勾选但修改文本提示
 				check.SetChecked(true)
 				check.Text = fmt.Sprintf("%s - %s (已选%d/%d讲)",
-					course.SubjectName, course.CourseName, len(selected), course.EndLiveNum)
+					course.SubjectName, course.CourseName, len(selected), available)
 				check.Refresh()
 			}
 		}
@@ -313,24 +490,22 @@ func (cs *CourseSelectionScreen) showLectureSelectionDialog(course *models.Cours
 	)
 
 	d = dialog.NewCustomWithoutButtons("选择讲数", content, cs.manager.window)
-	d.Resize(fyne.NewSize(400, 500))
+	d.Resize(fyne.NewSize(600, 500))
 	d.Show()
 }
 
 func (cs *CourseSelectionScreen) startDownload() {
-	var selectedCourses []*models.Course
-	selectedLectures := make(map[string][]int)
-
-	for _, course := range cs.courses {
-		if check, ok := cs.courseChecks[course.CourseID]; ok && check.Checked {
-			if lectures, ok := cs.lectureSelections[course.CourseID]; ok && len(lectures) > 0 {
-				selectedCourses = append(selectedCourses, course)
-				selectedLectures[course.CourseID] = lectures
+	var selections []*courseSelection
+	for _, sel := range cs.courses {
+		key := sel.key()
+		if check, ok := cs.courseChecks[key]; ok && check.Checked {
+			if lectures := cs.lectureSelections[key]; len(lectures) > 0 {
+				selections = append(selections, &courseSelection{session: sel.session, course: sel.course, lectures: lectures, endedOnly: cs.endedOnly[key], extensive: cs.extensiveCheck.Checked})
 			}
 		}
 	}
 
-	if len(selectedCourses) == 0 {
+	if len(selections) == 0 {
 		dialog.ShowInformation("提示", "未选择任何课程", cs.manager.window)
 		return
 	}
@@ -340,10 +515,9 @@ func (cs *CourseSelectionScreen) startDownload() {
 		return
 	}
 
-	cs.manager.selectedCourses = selectedCourses
-	cs.manager.selectedLectures = selectedLectures
+	cs.manager.selections = selections
 	cs.manager.downloadPath = cs.downloadPath
-	cs.manager.isExtensive = cs.extensiveCheck.Checked
+	cs.manager.definition = definitionOptions[cs.definitionSelect.SelectedIndex()].definition
 	if utils.IsAndroid() {
 		cs.manager.isOverwrite = false
 	} else {
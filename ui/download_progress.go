@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/itsHenry35/tal_downloader/downloader"
@@ -21,10 +22,11 @@ import (
 
 type ProgressUpdate struct {
 	filePath  string
-	progress  float64
+	progress  float64 // 小于0时不更新进度条和大小
 	speed     string
 	currSize  int64
 	totalSize int64
+	status    string // 非空时为任务的最终状态：completed 或 failed
 }
 
 type DownloadProgressScreen struct {
@@ -32,9 +34,11 @@ type DownloadProgressScreen struct {
 	progressBars       map[string]*widget.ProgressBar
 	speedLabels        map[string]*widget.Label
 	sizeLabels         map[string]*widget.Label
-	saveButtons        map[string]*widget.Button           // 新增：保存按钮映射
-	pauseResumeButtons map[string]*widget.Button           // 新增：每个任务的暂停/继续按钮映射
-	taskMap            map[string]*downloader.DownloadTask // 新增：文件路径到任务的映射
+	saveButtons        map[string]*widget.Button                       // 新增：保存按钮映射
+	pauseResumeButtons map[string]*widget.Button                       // 新增：每个任务的暂停/继续按钮映射
+	taskMap            map[string]*downloader.DownloadTask             // 新增：文件路径到任务的映射
+	taskPaths          map[*downloader.DownloadTask]string             // 任务到界面中文件路径的映射，用于处理下载事件
+	taskJobs           map[*downloader.DownloadTask]models.DownloadJob // 任务的下载记录，下载完成后写入清单和订阅的同步状态
 	downloadTasks      []*downloader.DownloadTask
 	pauseButton        *widget.Button
 	isPaused           bool
@@ -81,6 +85,8 @@ func NewDownloadProgressScreen(manager *Manager) fyne.CanvasObject {
 		saveButtons:        make(map[string]*widget.Button),
 		pauseResumeButtons: make(map[string]*widget.Button),
 		taskMap:            make(map[string]*downloader.DownloadTask),
+		taskPaths:          make(map[*downloader.DownloadTask]string),
+		taskJobs:           make(map[*downloader.DownloadTask]models.DownloadJob),
 		courseContainers:   make(map[string]*fyne.Container),
 		courseFoldState:    make(map[string]bool),
 		courseFoldButtons:  make(map[string]*widget.Button),
@@ -151,13 +157,13 @@ func (ds *DownloadProgressScreen) applyProgressUpdate(update ProgressUpdate) {
 	saveBtn, hasSaveBtn := ds.saveButtons[filePath]
 	ds.uiMapsMutex.RUnlock()
 
-	if hasBar {
+	if hasBar && progress >= 0 {
 		bar.SetValue(progress / 100)
 	}
 
 	if hasSpeedLabel {
-		if progress >= 100 {
-			speedLabel.SetText("已完成")
+		if update.status == "completed" {
+			speedLabel.SetText(strings.TrimSpace("已完成 " + update.speed))
 			// 隐藏暂停/继续按钮（下载成功）
 			if hasPauseBtn {
 				pauseBtn.Hide()
@@ -167,7 +173,7 @@ func (ds *DownloadProgressScreen) applyProgressUpdate(update ProgressUpdate) {
 				saveBtn.Show()
 			}
 		} else {
-			if strings.Contains(speed, "错误") {
+			if update.status == "failed" {
 				speedLabel.SetText(speed)
 				speedLabel.Importance = widget.DangerImportance
 				// 下载失败时，将暂停按钮改为继续
@@ -180,8 +186,8 @@ func (ds *DownloadProgressScreen) applyProgressUpdate(update ProgressUpdate) {
 		}
 	}
 
-	if hasSizeLabel {
-		if progress >= 100 {
+	if hasSizeLabel && progress >= 0 {
+		if update.status == "completed" {
 			sizeLabel.SetText(fmt.Sprintf("大小: %s", utils.FormatFileSize(totalSize)))
 		} else {
 			sizeLabel.SetText(fmt.Sprintf("已下载: %s / %s", utils.FormatFileSize(currSize), utils.FormatFileSize(totalSize)))
@@ -193,9 +199,7 @@ func (ds *DownloadProgressScreen) buildUI() {
 	title := widget.NewLabelWithStyle("下载进度", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
 
 	// 返回按钮
-	backButton := widget.NewButton("←", func() {
-		ds.manager.ShowCourseSelection()
-	})
+	backButton := widget.NewButton("←", ds.confirmLeave)
 	backButton.Importance = widget.LowImportance
 
 	// 滚动区
@@ -217,12 +221,14 @@ func (ds *DownloadProgressScreen) buildUI() {
 		})
 		footer = container.NewHBox(
 			ds.pauseButton,
+			ds.manager.newRateLimitControls(),
 			layout.NewSpacer(),
 			openFolderButton,
 		)
 	} else {
 		footer = container.NewHBox(
 			ds.pauseButton,
+			ds.manager.newRateLimitControls(),
 			layout.NewSpacer(),
 		)
 	}
@@ -256,45 +262,68 @@ func (ds *DownloadProgressScreen) buildUI() {
 
 func (ds *DownloadProgressScreen) startDownloads() {
 	progressList := ds.progressList
-	dl := ds.manager.downloader
+	dl := ds.manager.newDownloader()
+	ds.manager.downloader = dl
+	// HLS 主播放列表中的码率跟随清晰度偏好，选择具体清晰度时使用最高码率
+	if ds.manager.definition == models.DefinitionLowest {
+		dl.SetVariantPolicy(downloader.VariantLowest)
+	} else {
+		dl.SetVariantPolicy(downloader.VariantHighest)
+	}
 
 	var wg sync.WaitGroup
 
-	for i, course := range ds.manager.selectedCourses {
+	// 设置在保存时已经检查过，模板无效时使用默认模板
+	template, err := utils.ParseFileTemplate(ds.manager.settings.FileTemplate)
+	if err != nil {
+		template, _ = utils.ParseFileTemplate("")
+	}
+
+	// 同时下载多个学员时，模板中没有学员的课程放在单独的目录中
+	multiStudent := ds.manager.sessions.Len() > 1
+
+	for i, sel := range ds.manager.selections {
+		course := sel.course
+		key := sel.key()
 		courseName := fmt.Sprintf("%s - %s", course.SubjectName, course.CourseName)
 		safeName := utils.SanitizeFileName(courseName)
 
-		var courseDir string
+		baseDir := ds.manager.downloadPath
 		if utils.IsAndroid() {
 			// 安卓使用相对路径
-			courseDir = filepath.Join("temp", safeName)
-		} else {
-			courseDir = filepath.Join(ds.manager.downloadPath, safeName)
+			baseDir = "temp"
+		}
+		if multiStudent && !template.Uses("student") {
+			baseDir = filepath.Join(baseDir, sel.session.DirName())
+		}
+		if sel.baseDir != "" {
+			baseDir = sel.baseDir
 		}
 
 		if i != 0 {
 			progressList.Add(widget.NewSeparator())
 		}
 
-		// 获取选中的讲
-		selectedLectureIndices := ds.manager.selectedLectures[course.CourseID]
-		selectedCount := len(selectedLectureIndices)
-
 		// 添加课程标题
-		courseLabel := widget.NewLabelWithStyle(
-			fmt.Sprintf("课程 %d/%d: %s (下载%d讲)", i+1, len(ds.manager.selectedCourses), safeName, selectedCount),
-			fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+		title := fmt.Sprintf("课程 %d/%d: %s (下载%d讲)", i+1, len(ds.manager.selections), safeName, len(sel.lectures))
+		if sel.extensive {
+			title += " 延伸内容"
+		}
+		if multiStudent {
+			title = fmt.Sprintf("[%s] %s", sel.session.StudentName, title)
+		}
+		courseLabel := widget.NewLabelWithStyle(title, fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
 		btn := widget.NewButton("-", func() {
-			ds.toggleCourseFold(course.CourseID)
+			ds.toggleCourseFold(key)
 		})
-		ds.courseFoldButtons[course.CourseID] = btn
+		ds.courseFoldButtons[key] = btn
 		header := container.NewHBox(
 			btn,
 			courseLabel,
 		)
 		courseBox := container.NewVBox()
-		ds.courseContainers[course.CourseID] = courseBox
-		ds.courseFoldState[course.CourseID] = true // 默认展开
+		ds.courseContainers[key] = courseBox
+		ds.courseFoldState[key] = true // 默认展开
 
 		progressList.Add(container.NewVBox(
 			header,
@@ -303,97 +332,168 @@ func (ds *DownloadProgressScreen) startDownloads() {
 		))
 
 		wg.Add(1)
-		go func(course *models.Course, courseDir string, selectedIndices []int) {
+		go func(sel *courseSelection, key, baseDir string) {
 			defer wg.Done()
+			course := sel.course
 
-			lectures, err := ds.manager.apiClient.GetLectures(course.CourseID)
+			lectures, err := sel.session.Client.GetLectures(course.CourseID)
 			if err != nil {
-				utils.ShowErrorDialog(err, ds.manager.window)
+				ds.manager.showAPIError(err)
 				return
 			}
 
 			// 创建选中索引的map以便快速查找
 			selectedMap := make(map[int]bool)
-			for _, idx := range selectedIndices {
+			for _, idx := range sel.selectedLectures(lectures) {
 				selectedMap[idx] = true
 			}
 
-			// 只下载选中的讲，并且不超过endLiveNum
+			// 只下载选中且已结束的讲
 			for j, lecture := range lectures {
 				// 跳过未选中的讲
 				if !selectedMap[j] {
 					continue
 				}
 
-				// 确保不超过已结束的讲数
-				if j >= course.EndLiveNum {
+				// 跳过已取消和尚未结束的讲
+				name := lecture.Name(j)
+				if lecture.Status == models.LectureStatusCancelled {
+					fyne.Do(func() {
+						ds.addErrorItem(key, name, "该讲已取消")
+					})
+					continue
+				}
+				if !lecture.Ended(j, course) {
 					fyne.Do(func() {
-						ds.addErrorItem(course.CourseID, fmt.Sprintf("第%d讲", j+1), "该讲尚未开始")
+						ds.addErrorItem(key, name, "该讲尚未开始")
 					})
 					continue
 				}
 
-				fileName := fmt.Sprintf("第%d讲.mp4", j+1)
-				if ds.manager.isExtensive {
+				if sel.extensive {
 					lecture.LiveTypeString = "ONLINE_REAL_RECORD" // 强制设为延伸课程类型
-					fileName = fmt.Sprintf("第%d讲_延伸内容.mp4", j+1)
 				}
-				filePath := filepath.Join(courseDir, fileName)
-
-				// 检查文件是否存在
-				if !utils.IsAndroid() {
-					if utils.IsFileExists(filePath) && !ds.manager.isOverwrite {
+				// 路径中使用清晰度时先获取回放地址
+				var source models.VideoSource
+				var sourceErr error
+				fetched := sel.paths == nil && template.Uses("definition")
+				if fetched {
+					if source, sourceErr = sel.session.Client.GetVideoSource(lecture, course.CourseID, course.TutorID, ds.manager.definition); sourceErr != nil {
+						fyne.Do(func() {
+							ds.addErrorItem(key, name, sourceErr.Error())
+						})
+						continue
+					}
+				}
+				filePath, ok := sel.paths[j]
+				if !ok {
+					vars := utils.LectureFileVars(sel.session.Platform().Name, sel.session.DirName(), course, lecture, j, sel.extensive)
+					vars.Definition = source.Definition
+					filePath = template.RenderPath(baseDir, vars)
+				}
+				fileName := filepath.Base(filePath)
+
+				job := ds.newDownloadJob(sel, lecture, j, filePath)
+
+				// 按清单查找已下载的副本（有续传状态的文件尚未下载完成），不完整的文件重新下载
+				status := "等待中..."
+				if !utils.IsAndroid() && !ds.manager.isOverwrite && !downloader.HasResumeState(filePath) {
+					lookup := downloader.ManifestEntry{LiveID: lecture.LiveID, Extensive: sel.extensive, Definition: source.Definition, URL: source.URL}
+					local := downloader.FindLocalCopy(filePath, lookup, utils.GetDownloadedDirs(lecture.LiveID, sel.extensive)...)
+					switch local.Status {
+					case downloader.CopyComplete:
+						status = "文件已存在"
+						if local.Path != filePath {
+							status = "文件已存在: " + local.Path
+						}
+						markSynced(job)
 						fyne.Do(func() {
-							ds.addProgressItem(course.CourseID, fileName, filePath, true, -1)
+							ds.addProgressItem(key, fileName, filePath, status, true, -1)
 						})
 						continue
+					case downloader.CopyPartial:
+						status = "文件不完整，等待重新下载..."
 					}
 				}
 
-				videoURL, err := ds.manager.apiClient.GetVideoURL(lecture, course.CourseID, course.TutorID)
-				if err != nil {
-					fyne.Do(func() {
-						ds.addErrorItem(course.CourseID, fileName, err.Error())
-					})
-					continue
+				if !fetched {
+					if source, sourceErr = sel.session.Client.GetVideoSource(lecture, course.CourseID, course.TutorID, ds.manager.definition); sourceErr != nil {
+						job.Status = models.JobStatusError
+						job.Error = sourceErr.Error()
+						utils.QueueDownloadJob(job)
+						fyne.Do(func() {
+							ds.addErrorItem(key, fileName, job.Error)
+						})
+						continue
+					}
 				}
 
-				task := dl.AddTask(videoURL, filePath, func(progress float64, speed string, currsize int64, totalSize int64) {
-					ds.updateProgress(filePath, progress, speed, currsize, totalSize)
-				})
+				job.Definition = source.Definition
+
+				task := dl.AddTask(source.URL, filePath, nil)
+				if err := utils.QueueDownloadJob(job); err != nil {
+					fmt.Printf("保存下载记录失败: %v\n", err)
+				}
 
 				// 线程安全地添加任务
 				ds.tasksMutex.Lock()
 				ds.downloadTasks = append(ds.downloadTasks, task)
 				ds.taskMap[filePath] = task // 保存任务映射
+				ds.taskPaths[task] = filePath
+				ds.taskJobs[task] = job
 				ds.tasksMutex.Unlock()
 
 				fyne.Do(func() {
-					ds.addProgressItem(course.CourseID, fileName, filePath, false, task.TotalSize)
+					ds.addProgressItem(key, fileName, filePath, status, false, task.TotalSize)
 				})
 			}
-		}(course, courseDir, selectedLectureIndices)
+		}(sel, key, baseDir)
 
 	}
 
 	// 等待所有任务添加完成后，只启动一次下载器
 	go func() {
 		wg.Wait()
+		events, unsubscribe := dl.Subscribe(256)
+		defer unsubscribe()
 		dl.Start()
+
+		ds.tasksMutex.RLock()
+		remaining := len(ds.downloadTasks)
+		ds.tasksMutex.RUnlock()
+		for remaining > 0 {
+			if ds.handleEvent(<-events) {
+				remaining--
+			}
+		}
 	}()
 
 	progressList.Refresh()
 }
 
-func (ds *DownloadProgressScreen) addProgressItem(courseID, fileName, filePath string, exists bool, totalSize int64) {
+// newDownloadJob 创建一讲的下载记录
+func (ds *DownloadProgressScreen) newDownloadJob(sel *courseSelection, lecture *models.Lecture, index int, filePath string) models.DownloadJob {
+	return models.DownloadJob{
+		FilePath:     filePath,
+		Platform:     sel.session.Platform().Name,
+		StudentID:    sel.session.StudentID(),
+		StudentName:  sel.session.StudentName,
+		Course:       *sel.course,
+		Lecture:      *lecture,
+		LectureIndex: index,
+		Extensive:    sel.extensive,
+	}
+}
+
+// addProgressItem 添加一讲的进度条，status 为初始的状态文字，exists 表示文件已存在、不需要下载
+func (ds *DownloadProgressScreen) addProgressItem(courseID, fileName, filePath, status string, exists bool, totalSize int64) {
 	progress := widget.NewProgressBar()
 
 	fileLabel := widget.NewLabel(fileName)
-	speedLabel := widget.NewLabel("等待中...")
+	speedLabel := widget.NewLabel(status)
 	sizeLabel := widget.NewLabel(fmt.Sprintf("大小: %s", utils.FormatFileSize(totalSize)))
 	if exists {
 		progress.SetValue(1.0)
-		speedLabel.SetText("文件已存在")
 	}
 
 	// 创建单独的暂停/继续按钮
@@ -462,6 +562,61 @@ func (ds *DownloadProgressScreen) addErrorItem(courseID, fileName, errorMsg stri
 	}
 }
 
+// handleEvent 将下载事件显示到界面，任务结束时更新下载记录，返回任务是否已结束
+func (ds *DownloadProgressScreen) handleEvent(ev downloader.Event) bool {
+	ds.tasksMutex.RLock()
+	filePath, ok := ds.taskPaths[ev.Task]
+	job := ds.taskJobs[ev.Task]
+	ds.tasksMutex.RUnlock()
+	if !ok {
+		return false
+	}
+
+	switch ev.Type {
+	case downloader.EventProgress:
+		percent := ev.Percent()
+		if ev.SegmentsTotal > 0 {
+			percent *= 0.9 // 最多到90%，留10%给合并
+		}
+		ds.updateProgress(filePath, percent, ev.Speed(), ev.Bytes, ev.Total)
+	case downloader.EventVerifying:
+		ds.updateChannel <- ProgressUpdate{filePath: filePath, progress: -1, speed: "校验中"}
+	case downloader.EventMerging:
+		ds.updateChannel <- ProgressUpdate{filePath: filePath, progress: 90, speed: "合并中", currSize: atomic.LoadInt64(&ev.Task.Downloaded), totalSize: -1}
+	case downloader.EventWarning:
+		ds.updateChannel <- ProgressUpdate{filePath: filePath, progress: -1, speed: "警告： " + ev.Err.Error()}
+	case downloader.EventCompleted:
+		note := ""
+		if ev.Path != ev.Task.FilePath {
+			note = "（已保存为 " + filepath.Base(ev.Path) + "）"
+		}
+		ds.updateChannel <- ProgressUpdate{filePath: filePath, progress: 100, speed: note, totalSize: ev.Size, status: "completed"}
+		utils.FinishDownloadJob(filePath, ev.Task.StartTime, ev.Size, nil)
+		markSynced(job)
+		// 计算校验和需要读取整个文件，不阻塞事件处理
+		source := downloader.ManifestEntry{LiveID: job.Lecture.LiveID, Extensive: job.Extensive, Definition: job.Definition, URL: ev.Task.URL}
+		go func(path string) {
+			if err := downloader.RecordDownload(path, source); err != nil {
+				fmt.Printf("更新下载清单失败: %v\n", err)
+			}
+		}(ev.Path)
+		return true
+	case downloader.EventFailed:
+		label := "错误"
+		if downloader.IsCorrupt(ev.Err) {
+			label = "文件损坏"
+		}
+		ds.updateChannel <- ProgressUpdate{filePath: filePath, progress: -1, speed: fmt.Sprintf("%s： %v", label, ev.Err), status: "failed"}
+		utils.FinishDownloadJob(filePath, ev.Task.StartTime, atomic.LoadInt64(&ev.Task.Downloaded), ev.Err)
+		return true
+	case downloader.EventCancelled:
+		// 保持未完成状态，之后可以继续下载
+		ds.updateChannel <- ProgressUpdate{filePath: filePath, progress: -1, speed: ev.Err.Error(), status: "failed"}
+		return true
+	}
+	return false
+}
+
 func (ds *DownloadProgressScreen) updateProgress(filePath string, progress float64, speed string, currSize int64, totalSize int64) {
 	// 发送更新到通道，避免阻塞
 	select {
@@ -477,6 +632,35 @@ func (ds *DownloadProgressScreen) updateProgress(filePath string, progress float
 	}
 }
 
+// hasActiveTasks 是否有未结束的任务
+func (ds *DownloadProgressScreen) hasActiveTasks() bool {
+	ds.tasksMutex.RLock()
+	defer ds.tasksMutex.RUnlock()
+	for _, task := range ds.downloadTasks {
+		switch task.Status() {
+		case "completed", "error", "corrupt", "cancelled":
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// confirmLeave 返回课程选择页面，有未结束的任务时确认后停止下载
+func (ds *DownloadProgressScreen) confirmLeave() {
+	if !ds.hasActiveTasks() {
+		ds.manager.leaveDownloads()
+		return
+	}
+	utils.ShowCustomConfirm("返回上级", "确定", "取消",
+		container.NewVBox(widget.NewLabel(leaveDownloadsMessage)),
+		func(confirmed bool) {
+			if confirmed {
+				ds.manager.leaveDownloads()
+			}
+		}, ds.manager.window)
+}
+
 func (ds *DownloadProgressScreen) togglePause() {
 	ds.isPaused = !ds.isPaused
 
@@ -0,0 +1,83 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/utils"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// jobStatusText 下载记录状态的显示文本
+func jobStatusText(job models.DownloadJob) string {
+	switch job.Status {
+	case models.JobStatusCompleted:
+		return "已完成"
+	case models.JobStatusError:
+		return "失败: " + job.Error
+	case models.JobStatusCorrupt:
+		return "文件损坏: " + job.Error
+	default:
+		return "未完成"
+	}
+}
+
+// jobTitle 下载记录的显示标题
+func jobTitle(job models.DownloadJob) string {
+	title := fmt.Sprintf("%s - %s %s", job.Course.SubjectName, job.Course.CourseName, job.Lecture.Name(job.LectureIndex))
+	if job.Extensive {
+		title += " (延伸内容)"
+	}
+	if job.Definition != "" {
+		title += " [" + job.Definition + "]"
+	}
+	return title
+}
+
+// showDownloadHistoryDialog 显示下载记录
+func showDownloadHistoryDialog(window fyne.Window) {
+	jobs, err := utils.GetDownloadHistory()
+	if err != nil {
+		utils.ShowErrorDialog(err, window)
+		return
+	}
+
+	if len(jobs) == 0 {
+		utils.ShowInfoDialog("下载记录", "暂无下载记录", window)
+		return
+	}
+
+	list := widget.NewList(
+		func() int {
+			return len(jobs)
+		},
+		func() fyne.CanvasObject {
+			return container.NewVBox(
+				widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			job := jobs[id]
+			labels := item.(*fyne.Container).Objects
+
+			labels[0].(*widget.Label).SetText(jobTitle(job))
+
+			timeText := job.CreatedAt.Format("2006-01-02 15:04")
+			if !job.FinishedAt.IsZero() {
+				timeText = job.FinishedAt.Format("2006-01-02 15:04")
+			}
+			detail := fmt.Sprintf("%s | %s (%s) | %s | %s",
+				timeText, job.StudentName, job.Platform, utils.FormatFileSize(job.Bytes), jobStatusText(job))
+			labels[1].(*widget.Label).SetText(detail)
+		},
+	)
+
+	d := dialog.NewCustom("下载记录", "关闭", list, window)
+	d.Resize(fyne.NewSize(700, 500))
+	d.Show()
+}
@@ -26,6 +26,7 @@ type LoginScreen struct {
 	zoneSelect     *widget.Select
 	sendButton     *widget.Button
 	saveUserCheck  *widget.Check
+	rememberCheck  *widget.Check // 记住密码，仅账号密码登录时显示
 	loginMode      string
 	container      *fyne.Container
 	savedUsersData *models.SavedUsersData
@@ -37,9 +38,26 @@ func NewLoginScreen(manager *Manager) fyne.CanvasObject {
 		loginMode: "password",
 	}
 	ls.buildUI()
+	ls.prefillRelogin()
 	return ls.container
 }
 
+// prefillRelogin 登录过期后返回登录页面时，预填需要重新登录的账号
+func (ls *LoginScreen) prefillRelogin() {
+	user := ls.manager.reloginUser
+	if user == nil {
+		return
+	}
+	if platform, err := config.GetPlatform(user.Platform); err == nil {
+		ls.platformSelect.SetSelected(platform.Name)
+	}
+	ls.usernameEntry.SetText(user.Username)
+	ls.phoneEntry.SetText(user.Username)
+	// 重新登录后更新保存的账号
+	ls.saveUserCheck.SetChecked(true)
+	ls.rememberCheck.SetChecked(user.Password != "")
+}
+
 func (ls *LoginScreen) showVersionDialog() {
 	info := fmt.Sprintf(
 		"版本号: %s\n编译时间: %s\n作者: %s",
@@ -125,6 +143,9 @@ func (ls *LoginScreen) showSavedUserSelectionDialog() {
 
 		// 单选框（显示昵称和平台）
 		displayText := fmt.Sprintf("%s - %s", user.Nickname, user.Platform)
+		if ls.manager.expiredUsers[savedUserKey(user.Platform, user.Username)] {
+			displayText += "（登录已过期）"
+		}
 		checkBox := widget.NewCheck(displayText, func(checked bool) {
 			if checked {
 				selectedUserIndex = currentIndex
@@ -189,10 +210,17 @@ func (ls *LoginScreen) showSavedUserSelectionDialog() {
 // loginWithSavedUser 使用保存的用户信息直接登录
 func (ls *LoginScreen) loginWithSavedUser(user models.SavedUser) {
 	// 设置平台
-	config.SetPlatform(user.Platform)
+	platform, err := config.GetPlatform(user.Platform)
+	if err != nil {
+		utils.ShowErrorDialog(err, ls.manager.window)
+		return
+	}
+	ls.manager.apiClient.SetPlatform(platform)
 
 	// 设置认证信息
 	ls.manager.apiClient.SetAuth(user.Token, user.UserID)
+	ls.manager.savedUser = &user
+	ls.manager.reloginUser = nil
 
 	// 直接跳转到学员选择页面
 	ls.manager.ShowStudentSelection()
@@ -222,11 +250,16 @@ func (ls *LoginScreen) deleteSavedUserFromDialog(user models.SavedUser, d dialog
 func (ls *LoginScreen) buildUI() {
 	title := widget.NewLabelWithStyle("登录", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
 
-	platforms := []string{"乐读", "学而思培优"}
+	var platforms []string
+	for _, p := range config.Platforms() {
+		platforms = append(platforms, p.Name)
+	}
 	ls.platformSelect = widget.NewSelect(platforms, func(selected string) {
-		config.SetPlatform(selected)
+		if platform, err := config.GetPlatform(selected); err == nil {
+			ls.manager.apiClient.SetPlatform(platform)
+		}
 	})
-	ls.platformSelect.SetSelected("乐读") // 默认选择乐读
+	ls.platformSelect.SetSelected(config.DefaultPlatform().Name) // 默认选择乐读
 
 	platformForm := container.NewVBox(
 		widget.NewLabel("请选择平台:"),
@@ -270,6 +303,10 @@ func (ls *LoginScreen) buildUI() {
 		ls.manager.isSaveUserInfo = checked
 	})
 	ls.saveUserCheck.SetChecked(ls.manager.isSaveUserInfo)
+	ls.rememberCheck = widget.NewCheck("记住密码（登录过期后自动重新登录）", func(checked bool) {
+		ls.manager.isRememberPassword = checked
+	})
+	ls.rememberCheck.SetChecked(ls.manager.isRememberPassword)
 
 	var switchToSMS, switchToPwd *widget.Button
 
@@ -279,6 +316,7 @@ func (ls *LoginScreen) buildUI() {
 		switchToSMS.Hide()
 		switchToPwd.Show()
 		smsForm.Show()
+		ls.rememberCheck.Hide()
 		ls.phoneEntry.SetText(ls.usernameEntry.Text)
 	})
 
@@ -288,6 +326,7 @@ func (ls *LoginScreen) buildUI() {
 		switchToPwd.Hide()
 		switchToSMS.Show()
 		passwordForm.Show()
+		ls.rememberCheck.Show()
 		ls.usernameEntry.SetText(ls.phoneEntry.Text)
 	})
 	switchToPwd.Hide() // 初始隐藏短信登录按钮
@@ -323,7 +362,7 @@ func (ls *LoginScreen) buildUI() {
 		widget.NewSeparator(),
 		container.NewPadded(passwordForm),
 		container.NewPadded(smsForm),
-		container.NewHBox(ls.saveUserCheck),
+		container.NewHBox(ls.saveUserCheck, ls.rememberCheck),
 		container.NewHBox(layout.NewSpacer(), switchToSMS, switchToPwd, layout.NewSpacer()),
 	)
 
@@ -436,26 +475,31 @@ func (ls *LoginScreen) doLogin() {
 			}
 
 			ls.manager.apiClient.SetAuth(authData.Token, authData.UserID)
+			ls.manager.savedUser = nil
+			ls.manager.reloginUser = nil
 
 			// 如果选择保存用户信息，则保存
 			if ls.manager.isSaveUserInfo {
-				var usernameToSave string
+				user := models.SavedUser{
+					Username: phone,
+					Nickname: authData.Nickname,
+					Token:    authData.Token,
+					Platform: ls.manager.apiClient.Platform().Name,
+					UserID:   authData.UserID,
+				}
 				if loginMode == "password" {
-					usernameToSave = username
-				} else {
-					usernameToSave = phone
+					user.Username = username
+					if ls.manager.isRememberPassword {
+						user.Password = password
+					}
 				}
 
-				err := utils.AddUser(
-					usernameToSave,
-					authData.Nickname,
-					authData.Token,
-					config.PlatformName,
-					authData.UserID,
-				)
-				if err != nil {
+				if err := utils.SaveUser(user); err != nil {
 					// 保存失败不影响登录流程，只是显示警告
 					fmt.Printf("保存用户信息失败: %v\n", err)
+				} else {
+					ls.manager.savedUser = &user
+					delete(ls.manager.expiredUsers, savedUserKey(user.Platform, user.Username))
 				}
 			}
 
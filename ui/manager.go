@@ -1,12 +1,14 @@
 package ui
 
 import (
+	"errors"
 	"os"
+	"time"
 
 	"github.com/itsHenry35/tal_downloader/api"
-	"github.com/itsHenry35/tal_downloader/config"
 	"github.com/itsHenry35/tal_downloader/downloader"
 	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
 	"github.com/itsHenry35/tal_downloader/utils"
 
 	"fyne.io/fyne/v2"
@@ -15,33 +17,74 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
+// courseSelection 选中的课程及其所属学员
+type courseSelection struct {
+	session   *session.Session
+	course    *models.Course
+	lectures  []int          // 选中的讲（下标）
+	endedOnly bool           // 没有选择具体的讲，下载所有已结束的讲（lectures 只是按已结束讲数估计的下标）
+	paths     map[int]string // 恢复下载时沿用下载记录中的保存路径，否则按模板生成
+	extensive bool           // 下载延伸内容
+	baseDir   string         // 同步订阅时使用订阅的下载目录，为空时使用下载页面的下载路径
+}
+
+// key 课程在界面中的标识，多个学员可能报名了同一课程，同步时同一课程可能同时下载回放和延伸内容
+func (sel *courseSelection) key() string {
+	key := sel.session.Key() + "/" + sel.course.CourseID
+	if sel.extensive {
+		key += "/extensive"
+	}
+	return key
+}
+
+// selectedLectures 选中的讲在讲次列表中的下标
+func (sel *courseSelection) selectedLectures(lectures []*models.Lecture) []int {
+	if sel.endedOnly {
+		return models.EndedLectures(lectures, sel.course)
+	}
+	return sel.lectures
+}
+
 type Manager struct {
 	window               fyne.Window
 	mainContainer        *fyne.Container
 	apiClient            *api.Client
-	downloader           *downloader.Downloader
-	selectedCourses      []*models.Course
-	selectedLectures     map[string][]int // courseID -> selected lecture indices
+	downloader           *downloader.Downloader // 当前下载页面使用的下载器，每次开始下载时按设置创建
+	settings             models.Settings
+	sessions             *session.Manager // 选中的学员，每个学员一个会话
+	selections           []*courseSelection
 	downloadPath         string
-	isExtensive          bool
 	isOverwrite          bool
+	definition           string // 清晰度偏好，见 models.DefinitionHighest
 	currentScreen        string
 	isConfirmScreenShown bool
 	isSaveUserInfo       bool
+	isRememberPassword   bool
+	savedUser            *models.SavedUser    // 当前登录使用的保存账号，未保存时为 nil
+	reloginUser          *models.SavedUser    // 登录过期需要重新登录的账号，登录页面据此预填
+	tokenCheckedAt       map[string]time.Time // 各会话上次确认登录有效的时间
+	expiredUsers         map[string]bool      // 登录已过期的保存账号（平台/用户名）
+	restorePrompted      map[string]bool      // 本次运行中已提示过恢复下载的学员
 }
 
 func NewManager(window fyne.Window, mainContainer *fyne.Container) *Manager {
 	manager := &Manager{
 		window:               window,
 		mainContainer:        mainContainer,
-		apiClient:            api.NewClient(),
-		downloader:           downloader.NewDownloader(config.MaxConcurrentDownloads, config.ThreadCount),
-		selectedLectures:     make(map[string][]int),
+		apiClient:            api.NewClient(nil),
+		sessions:             session.NewManager(),
+		definition:           models.DefinitionHighest,
 		currentScreen:        "login",
 		isConfirmScreenShown: false,
 		isSaveUserInfo:       false,
+		restorePrompted:      make(map[string]bool),
+		tokenCheckedAt:       make(map[string]time.Time),
+		expiredUsers:         make(map[string]bool),
 	}
 
+	// 加载保存的设置，限速立即生效
+	manager.loadSettings()
+
 	// 设置安卓返回键处理
 	if utils.IsAndroid() {
 		window.Canvas().SetOnTypedKey(manager.handleAndroidBackKey)
@@ -94,16 +137,32 @@ func (m *Manager) handleAndroidBackKey(keyEvent *fyne.KeyEvent) {
 		// 下载进度页面，确认返回课程选择
 		m.isConfirmScreenShown = true
 		utils.ShowCustomConfirm("返回上级", "确定", "取消",
-			container.NewVBox(widget.NewLabel("确定要返回课程选择页面吗？")),
+			container.NewVBox(widget.NewLabel(leaveDownloadsMessage)),
 			func(confirmed bool) {
 				m.isConfirmScreenShown = false
 				if confirmed {
-					m.ShowCourseSelection()
+					m.leaveDownloads()
 				}
 			}, m.window)
 	}
 }
 
+// showAPIError 显示接口错误，登录过期时提示重新登录
+func (m *Manager) showAPIError(err error) {
+	if !api.IsAuthExpired(err) {
+		utils.ShowErrorDialog(err, m.window)
+		return
+	}
+	s := m.baseSession()
+	var expired *session.ExpiredError
+	if errors.As(err, &expired) {
+		s = expired.Session
+	}
+	fyne.Do(func() {
+		m.promptRelogin(s)
+	})
+}
+
 func (m *Manager) ShowLogin() {
 	m.currentScreen = "login"
 	loginScreen := NewLoginScreen(m)
@@ -112,22 +171,40 @@ func (m *Manager) ShowLogin() {
 }
 
 func (m *Manager) ShowStudentSelection() {
-	m.currentScreen = "student"
-	studentScreen := NewStudentSelectScreen(m)
-	m.mainContainer.Objects = []fyne.CanvasObject{studentScreen}
-	m.mainContainer.Refresh()
+	m.withValidSessions([]*session.Session{m.baseSession()}, func() {
+		m.currentScreen = "student"
+		studentScreen := NewStudentSelectScreen(m)
+		m.mainContainer.Objects = []fyne.CanvasObject{studentScreen}
+		m.mainContainer.Refresh()
+	})
 }
 
 func (m *Manager) ShowCourseSelection() {
-	m.currentScreen = "course"
-	courseScreen := NewCourseSelectionScreen(m)
-	m.mainContainer.Objects = []fyne.CanvasObject{courseScreen}
-	m.mainContainer.Refresh()
+	m.withValidSessions(m.sessions.Sessions(), func() {
+		m.currentScreen = "course"
+		courseScreen := NewCourseSelectionScreen(m)
+		m.mainContainer.Objects = []fyne.CanvasObject{courseScreen}
+		m.mainContainer.Refresh()
+	})
+}
+
+// leaveDownloadsMessage 离开下载页面前的确认提示
+const leaveDownloadsMessage = "返回将停止正在进行的下载，已下载的部分会保留，之后可以继续下载。"
+
+// leaveDownloads 停止当前的下载并返回课程选择页面
+func (m *Manager) leaveDownloads() {
+	if m.downloader != nil {
+		m.downloader.Cancel()
+		m.downloader = nil
+	}
+	m.ShowCourseSelection()
 }
 
 func (m *Manager) ShowDownloadProgress() {
-	m.currentScreen = "download"
-	downloadScreen := NewDownloadProgressScreen(m)
-	m.mainContainer.Objects = []fyne.CanvasObject{downloadScreen}
-	m.mainContainer.Refresh()
+	m.withValidSessions(m.sessions.Sessions(), func() {
+		m.currentScreen = "download"
+		downloadScreen := NewDownloadProgressScreen(m)
+		m.mainContainer.Objects = []fyne.CanvasObject{downloadScreen}
+		m.mainContainer.Refresh()
+	})
 }
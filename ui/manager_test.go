@@ -0,0 +1,30 @@
+package ui
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/itsHenry35/tal_downloader/models"
+)
+
+func TestSelectedLecturesSkipsCancelled(t *testing.T) {
+	// 第2讲取消，课程已结束2讲：按已结束讲数估计的下标是 [0 1]，实际已结束的是第1、3项
+	course := &models.Course{EndLiveNum: 2}
+	lectures := []*models.Lecture{
+		{LiveID: 1, Num: 1, Status: models.LectureStatusEnded},
+		{LiveID: 2, Status: models.LectureStatusCancelled},
+		{LiveID: 3, Num: 2, Status: models.LectureStatusEnded},
+		{LiveID: 4, Num: 3, Status: models.LectureStatusNotStarted},
+	}
+
+	sel := &courseSelection{course: course, lectures: []int{0, 1}, endedOnly: true}
+	if got := sel.selectedLectures(lectures); !reflect.DeepEqual(got, []int{0, 2}) {
+		t.Errorf("default selection = %v, want [0 2]", got)
+	}
+
+	// 在对话框中选择过的讲原样使用
+	sel = &courseSelection{course: course, lectures: []int{2}}
+	if got := sel.selectedLectures(lectures); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("manual selection = %v, want [2]", got)
+	}
+}
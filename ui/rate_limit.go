@@ -0,0 +1,93 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/itsHenry35/tal_downloader/downloader"
+	"github.com/itsHenry35/tal_downloader/models"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// rateLimitOptions 限速选项的显示文本与每秒字节数
+var rateLimitOptions = []struct {
+	label string
+	limit int64
+}{
+	{"不限速", 0},
+	{"512 KB/s", 512 << 10},
+	{"1 MB/s", 1 << 20},
+	{"2 MB/s", 2 << 20},
+	{"5 MB/s", 5 << 20},
+	{"10 MB/s", 10 << 20},
+}
+
+// newRateLimitControls 限速选择和按时段限速的设置按钮，修改立即作用于正在进行的下载并保存到设置
+func (m *Manager) newRateLimitControls() fyne.CanvasObject {
+	limiter := downloader.GlobalLimiter()
+
+	labels := make([]string, len(rateLimitOptions))
+	selected := -1
+	for i, option := range rateLimitOptions {
+		labels[i] = option.label
+		if option.limit == limiter.BaseLimit() {
+			selected = i
+		}
+	}
+	// 通过命令行等设置了列表之外的限速时显示为自定义选项
+	if selected < 0 {
+		labels = append(labels, downloader.FormatRate(limiter.BaseLimit())+"/s")
+		selected = len(labels) - 1
+	}
+
+	limitSelect := widget.NewSelect(labels, nil)
+	limitSelect.SetSelectedIndex(selected)
+	limitSelect.OnChanged = func(string) {
+		if i := limitSelect.SelectedIndex(); i >= 0 && i < len(rateLimitOptions) {
+			limit := rateLimitOptions[i].limit
+			limiter.SetLimit(limit)
+			m.updateSettings(func(s *models.Settings) { s.RateLimit = limit })
+		}
+	}
+
+	scheduleButton := widget.NewButton("按时段限速", m.showScheduleDialog)
+	return container.NewHBox(widget.NewLabel("限速:"), limitSelect, scheduleButton)
+}
+
+// showScheduleDialog 编辑按时段限速的规则
+func (m *Manager) showScheduleDialog() {
+	limiter := downloader.GlobalLimiter()
+
+	entry := widget.NewEntry()
+	entry.SetText(downloader.FormatSchedule(limiter.Schedule()))
+	entry.SetPlaceHolder("09:00-18:00=2MB,22:00-07:00=0")
+	entry.Validator = func(s string) error {
+		_, err := downloader.ParseSchedule(s)
+		return err
+	}
+
+	hint := widget.NewLabel("格式为 \"开始-结束=速度\"，多个时段用逗号分隔，0 表示不限速；\n不在任何时段内时使用左侧选择的限速，留空表示不按时段限速。")
+	hint.Wrapping = fyne.TextWrapWord
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("时段", entry),
+		widget.NewFormItem("", hint),
+	}
+	form := dialog.NewForm("按时段限速", "确定", "取消", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		rules, err := downloader.ParseSchedule(entry.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("时段设置无效: %v", err), m.window)
+			return
+		}
+		limiter.SetSchedule(rules)
+		m.updateSettings(func(s *models.Settings) { s.RateSchedule = downloader.FormatSchedule(rules) })
+	}, m.window)
+	form.Resize(fyne.NewSize(520, 240))
+	form.Show()
+}
@@ -0,0 +1,142 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/downloader"
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/utils"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// loadSettings 加载保存的设置并应用其中的限速
+func (m *Manager) loadSettings() {
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		fmt.Printf("加载设置失败: %v\n", err)
+	}
+	m.settings = settings
+
+	limiter := downloader.GlobalLimiter()
+	limiter.SetLimit(settings.RateLimit)
+	if schedule, err := downloader.ParseSchedule(settings.RateSchedule); err == nil {
+		limiter.SetSchedule(schedule)
+	}
+}
+
+// updateSettings 修改并保存设置
+func (m *Manager) updateSettings(update func(*models.Settings)) {
+	update(&m.settings)
+	if err := utils.SaveSettings(m.settings); err != nil {
+		utils.ShowErrorDialog(err, m.window)
+	}
+}
+
+// newDownloader 按当前设置创建下载器
+func (m *Manager) newDownloader() *downloader.Downloader {
+	dl := downloader.NewDownloader(m.settings.MaxConcurrentDownloads, m.settings.ThreadCount)
+	dl.SetAdaptive(m.settings.AdaptiveThreads)
+	return dl
+}
+
+// sampleFileVars 预览保存路径模板时使用的示例讲次
+func (m *Manager) sampleFileVars() utils.FileTemplateVars {
+	student := "学员"
+	if sessions := m.sessions.Sessions(); len(sessions) > 0 {
+		student = sessions[0].DirName()
+	}
+	return utils.FileTemplateVars{
+		Platform:   m.apiClient.Platform().Name,
+		Student:    student,
+		Subject:    "数学",
+		Course:     "示例课程",
+		CourseID:   "12345",
+		Index:      3,
+		Title:      "二次函数",
+		Date:       time.Now(),
+		Teacher:    "张老师",
+		Definition: "超清",
+		Ext:        "mp4",
+	}
+}
+
+// intValidator 检查输入是否为 [1, max] 范围内的整数
+func intValidator(max int) fyne.StringValidator {
+	return func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > max {
+			return fmt.Errorf("请输入 1-%d 之间的整数", max)
+		}
+		return nil
+	}
+}
+
+// showSettingsDialog 编辑同时下载数、线程数和保存路径模板，修改在下次开始下载时生效
+func (m *Manager) showSettingsDialog() {
+	concurrencyEntry := widget.NewEntry()
+	concurrencyEntry.SetText(strconv.Itoa(m.settings.MaxConcurrentDownloads))
+	concurrencyEntry.Validator = intValidator(models.MaxConcurrentDownloadsLimit)
+
+	threadsEntry := widget.NewEntry()
+	threadsEntry.SetText(strconv.Itoa(m.settings.ThreadCount))
+	threadsEntry.Validator = intValidator(models.ThreadCountLimit)
+
+	adaptiveCheck := widget.NewCheck("根据速度和错误率自动调节", nil)
+	adaptiveCheck.SetChecked(m.settings.AdaptiveThreads)
+
+	templateEntry := widget.NewEntry()
+	templateEntry.SetPlaceHolder(utils.DefaultFileTemplate)
+	templateEntry.SetText(m.settings.FileTemplate)
+	templateEntry.Validator = func(s string) error {
+		_, err := utils.ParseFileTemplate(s)
+		return err
+	}
+	preview := widget.NewLabel("")
+	preview.Wrapping = fyne.TextWrapWord
+	updatePreview := func(text string) {
+		template, err := utils.ParseFileTemplate(text)
+		if err != nil {
+			preview.SetText(err.Error())
+			return
+		}
+		preview.SetText("示例: " + template.Render(m.sampleFileVars()))
+	}
+	templateEntry.OnChanged = updatePreview
+	updatePreview(m.settings.FileTemplate)
+	templateHint := widget.NewLabel("可用变量: {platform} {student} {subject} {course} {courseId} {index} {index:02} {title} {date} {date:2006-01-02} {teacher} {type} {definition} {ext}；/ 分隔目录，{type:_%s} 在变量不为空时才输出")
+	templateHint.Wrapping = fyne.TextWrapWord
+
+	hint := widget.NewLabel("部分服务器在连接过多时会拒绝请求（403/429），下载经常失败时可以减少线程数或开启自动调节；\n自动调节时线程数从较少开始，不超过上面设置的线程数。修改在下次开始下载时生效。")
+	hint.Wrapping = fyne.TextWrapWord
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("同时下载文件数", concurrencyEntry),
+		widget.NewFormItem("每个文件线程数", threadsEntry),
+		widget.NewFormItem("自动调节线程数", adaptiveCheck),
+		widget.NewFormItem("", hint),
+		widget.NewFormItem("保存路径模板", templateEntry),
+		widget.NewFormItem("", preview),
+		widget.NewFormItem("", templateHint),
+	}
+	form := dialog.NewForm("下载设置", "保存", "取消", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		concurrency, _ := strconv.Atoi(concurrencyEntry.Text)
+		threads, _ := strconv.Atoi(threadsEntry.Text)
+		m.updateSettings(func(s *models.Settings) {
+			s.MaxConcurrentDownloads = concurrency
+			s.ThreadCount = threads
+			s.AdaptiveThreads = adaptiveCheck.Checked
+			s.FileTemplate = strings.TrimSpace(templateEntry.Text)
+		})
+	}, m.window)
+	form.Resize(fyne.NewSize(640, 480))
+	form.Show()
+}
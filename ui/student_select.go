@@ -3,8 +3,8 @@ package ui
 import (
 	"fmt"
 
-	"github.com/itsHenry35/tal_downloader/config"
 	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
 	"github.com/itsHenry35/tal_downloader/utils"
 
 	"fyne.io/fyne/v2"
@@ -17,9 +17,8 @@ import (
 type StudentSelectScreen struct {
 	manager      *Manager
 	studentList  models.StudentAccountListResponse
-	selected     *models.StudentAccount
 	container    *fyne.Container
-	radioGroup   *widget.RadioGroup
+	checkGroup   *widget.CheckGroup // 可同时选择多个学员
 	switchButton *widget.Button
 }
 
@@ -44,7 +43,7 @@ func (sl *StudentSelectScreen) loadStudents() {
 			progressDialog.Dismiss()
 
 			if err != nil {
-				utils.ShowErrorDialog(err, sl.manager.window)
+				sl.manager.showAPIError(err)
 				return
 			}
 
@@ -66,10 +65,9 @@ func (sl *StudentSelectScreen) loadStudents() {
 				}
 			}
 
-			sl.radioGroup.Options = options
-			sl.radioGroup.Selected = options[defaultIndex]
-			sl.selected = accounts[defaultIndex]
-			sl.radioGroup.Refresh()
+			sl.checkGroup.Options = options
+			sl.checkGroup.Selected = []string{options[defaultIndex]}
+			sl.checkGroup.Refresh()
 		})
 	}()
 }
@@ -97,7 +95,7 @@ func (sl *StudentSelectScreen) updateSavedUserNicknames(accounts models.StudentA
 	}
 
 	// 查找并更新匹配的用户昵称
-	currentPlatform := config.PlatformName
+	currentPlatform := sl.manager.apiClient.Platform().Name
 	updated := false
 	for i, user := range savedUsersData.Users {
 		if user.Platform == currentPlatform && user.UserID == currentUID {
@@ -116,7 +114,7 @@ func (sl *StudentSelectScreen) updateSavedUserNicknames(accounts models.StudentA
 }
 
 func (sl *StudentSelectScreen) buildUI() {
-	title := widget.NewLabelWithStyle("请选择学员", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
+	title := widget.NewLabelWithStyle("请选择学员（可多选）", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
 
 	// 返回按钮
 	backButton := widget.NewButton("←", func() {
@@ -124,17 +122,10 @@ func (sl *StudentSelectScreen) buildUI() {
 	})
 	backButton.Importance = widget.LowImportance
 
-	sl.radioGroup = widget.NewRadioGroup([]string{}, func(selected string) {
-		for i, option := range sl.radioGroup.Options {
-			if selected == option {
-				sl.selected = sl.studentList[i]
-				break
-			}
-		}
-	})
+	sl.checkGroup = widget.NewCheckGroup([]string{}, nil)
 
 	// 滚动区
-	scroll := container.NewScroll(sl.radioGroup)
+	scroll := container.NewScroll(sl.checkGroup)
 	scroll.SetMinSize(fyne.NewSize(400, 300)) // 初始高度
 	scrollContainer := container.NewStack(scroll)
 
@@ -173,20 +164,45 @@ func (sl *StudentSelectScreen) buildUI() {
 	sl.container = container.NewPadded(content)
 }
 
+// selectedAccounts 勾选的学员
+func (sl *StudentSelectScreen) selectedAccounts() []*models.StudentAccount {
+	checked := make(map[string]bool)
+	for _, option := range sl.checkGroup.Selected {
+		checked[option] = true
+	}
+	var accounts []*models.StudentAccount
+	for i, option := range sl.checkGroup.Options {
+		if checked[option] {
+			accounts = append(accounts, sl.studentList[i])
+		}
+	}
+	return accounts
+}
+
 func (sl *StudentSelectScreen) switchAccount() {
-	// 在主线程中读取状态，防止 goroutine 访问 UI
-	var selectedUID string
-	var currentUID string
-	var needSwitch bool
-
-	_, currentUID = sl.manager.apiClient.GetAuth()
-	if sl.selected != nil {
-		selectedUID = fmt.Sprint(sl.selected.PuUID)
-		needSwitch = selectedUID != currentUID
+	selected := sl.selectedAccounts()
+	if len(selected) == 0 {
+		dialog.ShowInformation("提示", "请至少选择一个学员", sl.manager.window)
+		return
 	}
+	if len(selected) > 1 {
+		sl.openSessions(selected)
+		return
+	}
+
+	// 在主线程中读取状态，防止 goroutine 访问 UI
+	_, currentUID := sl.manager.apiClient.GetAuth()
+	selectedUID := fmt.Sprint(selected[0].PuUID)
+	studentName := selected[0].Nickname
 
-	if !needSwitch {
+	showCourses := func() {
+		sl.manager.sessions.Clear()
+		sl.manager.sessions.Add(&session.Session{Client: sl.manager.apiClient, StudentName: studentName, SavedUser: sl.manager.savedUser})
 		sl.manager.ShowCourseSelection()
+	}
+
+	if selectedUID == currentUID {
+		showCourses()
 		return
 	}
 
@@ -201,11 +217,45 @@ func (sl *StudentSelectScreen) switchAccount() {
 			progressDialog.Dismiss()
 
 			if err != nil {
-				utils.ShowErrorDialog(err, sl.manager.window)
+				sl.manager.showAPIError(err)
 				return
 			}
 
 			sl.manager.apiClient.SetAuth("", selectedUID)
+			showCourses()
+		})
+	}()
+}
+
+// openSessions 同时选择多个学员时，为每个学员创建独立的会话，当前学员的登录态保持不变
+func (sl *StudentSelectScreen) openSessions(selected []*models.StudentAccount) {
+	_, currentUID := sl.manager.apiClient.GetAuth()
+	base := sl.manager.baseSession()
+	for _, acc := range sl.studentList {
+		if fmt.Sprint(acc.PuUID) == currentUID {
+			base.StudentName = acc.Nickname
+			break
+		}
+	}
+
+	progressDialog := dialog.NewProgressInfinite("切换中...", "正在登录选中的学员", sl.manager.window)
+	progressDialog.Show()
+
+	go func() {
+		sessions, err := session.ForStudents(base, selected)
+
+		fyne.Do(func() {
+			progressDialog.Dismiss()
+
+			if err != nil {
+				sl.manager.showAPIError(err)
+				return
+			}
+
+			sl.manager.sessions.Clear()
+			for _, s := range sessions {
+				sl.manager.sessions.Add(s)
+			}
 			sl.manager.ShowCourseSelection()
 		})
 	}()
@@ -0,0 +1,168 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/session"
+	"github.com/itsHenry35/tal_downloader/subscription"
+	"github.com/itsHenry35/tal_downloader/utils"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// markSynced 记录订阅课程中的一讲已同步（下载完成或本地已有）
+func markSynced(job models.DownloadJob) {
+	if err := utils.MarkSynced(job.Platform, job.StudentID, job.Course.CourseID, job.Lecture.LiveID, job.Extensive); err != nil {
+		fmt.Printf("更新同步状态失败: %v\n", err)
+	}
+}
+
+// subscribeSelected 订阅选中的课程，之后可以用“同步订阅”只下载新结束的讲
+func (cs *CourseSelectionScreen) subscribeSelected() {
+	courseIDs := make(map[*session.Session][]string)
+	var sessions []*session.Session
+	for _, sel := range cs.courses {
+		if check, ok := cs.courseChecks[sel.key()]; ok && check.Checked {
+			if _, ok := courseIDs[sel.session]; !ok {
+				sessions = append(sessions, sel.session)
+			}
+			courseIDs[sel.session] = append(courseIDs[sel.session], sel.course.CourseID)
+		}
+	}
+	if len(sessions) == 0 {
+		dialog.ShowInformation("提示", "未选择任何课程", cs.manager.window)
+		return
+	}
+
+	downloadPath, err := filepath.Abs(cs.downloadPath)
+	if utils.IsAndroid() || err != nil {
+		downloadPath = cs.downloadPath
+	}
+	template, _ := utils.ParseFileTemplate(cs.manager.settings.FileTemplate)
+	multiStudent := cs.manager.sessions.Len() > 1
+
+	count := 0
+	for _, s := range sessions {
+		sub := models.Subscription{
+			Platform:    s.Platform().Name,
+			StudentID:   s.StudentID(),
+			StudentName: s.StudentName,
+			CourseIDs:   courseIDs[s],
+			Extensive:   cs.extensiveCheck.Checked,
+			Definition:  definitionOptions[cs.definitionSelect.SelectedIndex()].definition,
+			Path:        downloadPath,
+		}
+		if s.SavedUser != nil {
+			sub.Username = s.SavedUser.Username
+		}
+		// 与下载时相同，多个学员时每个学员使用单独的目录
+		if multiStudent && !template.Uses("student") {
+			sub.Path = filepath.Join(downloadPath, s.DirName())
+		}
+		if _, err := utils.Subscribe(sub); err != nil {
+			utils.ShowErrorDialog(err, cs.manager.window)
+			return
+		}
+		count += len(courseIDs[s])
+	}
+	dialog.ShowInformation("订阅", fmt.Sprintf("已订阅 %d 门课程，点击“同步订阅”下载新结束的讲", count), cs.manager.window)
+}
+
+// syncSubscriptions 检查当前学员订阅的课程，确认后下载上次同步以来新结束的讲
+func (cs *CourseSelectionScreen) syncSubscriptions() {
+	data, err := utils.LoadSubscriptions()
+	if err != nil {
+		utils.ShowErrorDialog(err, cs.manager.window)
+		return
+	}
+
+	progressDialog := dialog.NewProgressInfinite("同步中...", "正在检查订阅的课程", cs.manager.window)
+	progressDialog.Show()
+
+	go func() {
+		var selections []*courseSelection
+		var lines []string
+		subscribed := false
+		for _, s := range cs.manager.sessions.Sessions() {
+			sub := data.Find(s.Platform().Name, s.StudentID())
+			if sub == nil {
+				continue
+			}
+			subscribed = true
+
+			diffs, errs, err := subscription.Diff(s, sub)
+			if err == nil && len(errs) > 0 {
+				err = errs[0]
+			}
+			if err != nil {
+				cs.manager.showAPIError(err)
+				continue
+			}
+			if err := utils.UpdateSubscription(sub); err != nil {
+				fmt.Printf("保存同步状态失败: %v\n", err)
+			}
+
+			for _, d := range diffs {
+				name := fmt.Sprintf("%s - %s", d.Course.SubjectName, d.Course.CourseName)
+				if cs.manager.sessions.Len() > 1 {
+					name = fmt.Sprintf("[%s] %s", s.StudentName, name)
+				}
+				if len(d.New) > 0 {
+					selections = append(selections, &courseSelection{session: s, course: d.Course, lectures: d.New, baseDir: sub.Path})
+					lines = append(lines, fmt.Sprintf("%s: %d 讲新回放", name, len(d.New)))
+				}
+				if len(d.NewExtensive) > 0 {
+					selections = append(selections, &courseSelection{session: s, course: d.Course, lectures: d.NewExtensive, baseDir: sub.Path, extensive: true})
+					lines = append(lines, fmt.Sprintf("%s: %d 讲延伸内容", name, len(d.NewExtensive)))
+				}
+				if !sub.Extensive {
+					for _, j := range d.FoundExtensive {
+						lines = append(lines, fmt.Sprintf("%s: %s 有新的延伸内容（订阅时勾选“下载延伸课程”可一并同步）", name, d.Lectures[j].Name(j)))
+					}
+				}
+			}
+		}
+
+		fyne.Do(func() {
+			progressDialog.Dismiss()
+			switch {
+			case !subscribed:
+				dialog.ShowInformation("同步订阅", "当前学员没有订阅的课程，请先选择课程并点击“订阅”", cs.manager.window)
+			case len(lines) == 0:
+				dialog.ShowInformation("同步订阅", "没有新结束的讲", cs.manager.window)
+			case len(selections) == 0:
+				dialog.ShowInformation("同步订阅", strings.Join(lines, "\n"), cs.manager.window)
+			default:
+				utils.ShowCustomConfirm("同步订阅", "开始下载", "取消",
+					container.NewVBox(widget.NewLabel(strings.Join(lines, "\n"))),
+					func(confirmed bool) {
+						if confirmed {
+							cs.startSync(selections)
+						}
+					}, cs.manager.window)
+			}
+		})
+	}()
+}
+
+// startSync 下载同步得到的讲次，文件保存到各订阅的下载目录
+func (cs *CourseSelectionScreen) startSync(selections []*courseSelection) {
+	for _, sel := range selections {
+		if err := utils.Mkdir(sel.baseDir); err != nil {
+			utils.ShowErrorDialog(err, cs.manager.window)
+			return
+		}
+	}
+	cs.manager.selections = selections
+	cs.manager.downloadPath = selections[0].baseDir
+	cs.manager.definition = definitionOptions[cs.definitionSelect.SelectedIndex()].definition
+	// 本地已有的讲会被跳过并记录为已同步
+	cs.manager.isOverwrite = false
+	cs.manager.ShowDownloadProgress()
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/models"
+)
+
+const DownloadJobsFileName = "download_jobs.json"
+
+// downloadJobsMutex 多个下载协程会同时更新任务记录，读写文件需要串行
+var downloadJobsMutex sync.Mutex
+
+func getDownloadJobsFilePath() string {
+	return dataFilePath(DownloadJobsFileName)
+}
+
+// LoadDownloadJobs 加载下载任务记录
+func LoadDownloadJobs() (*models.DownloadJobsData, error) {
+	downloadJobsMutex.Lock()
+	defer downloadJobsMutex.Unlock()
+	return loadDownloadJobs()
+}
+
+func loadDownloadJobs() (*models.DownloadJobsData, error) {
+	filePath := getDownloadJobsFilePath()
+
+	exists, err := dataFileExists(filePath)
+	if err != nil {
+		return &models.DownloadJobsData{}, err
+	}
+	if !exists {
+		return &models.DownloadJobsData{}, nil
+	}
+
+	read, err := os.Open(filePath)
+	if err != nil {
+		return &models.DownloadJobsData{}, err
+	}
+	defer read.Close()
+
+	var data models.DownloadJobsData
+	if err := json.NewDecoder(read).Decode(&data); err != nil {
+		// 文件损坏时从空记录开始
+		return &models.DownloadJobsData{}, nil
+	}
+	return &data, nil
+}
+
+func saveDownloadJobs(data *models.DownloadJobsData) error {
+	if err := writeDataFile(getDownloadJobsFilePath(), data); err != nil {
+		return fmt.Errorf("保存下载记录失败: %v", err)
+	}
+	return nil
+}
+
+// QueueDownloadJob 记录加入队列的任务，同一文件路径的旧记录会被替换
+func QueueDownloadJob(job models.DownloadJob) error {
+	downloadJobsMutex.Lock()
+	defer downloadJobsMutex.Unlock()
+
+	data, err := loadDownloadJobs()
+	if err != nil {
+		return err
+	}
+
+	if job.Status == "" {
+		job.Status = models.JobStatusQueued
+	}
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = time.Now()
+	}
+
+	for i, existing := range data.Jobs {
+		if existing.FilePath == job.FilePath {
+			data.Jobs[i] = job
+			return saveDownloadJobs(data)
+		}
+	}
+
+	data.Jobs = append(data.Jobs, job)
+	return saveDownloadJobs(data)
+}
+
+// FinishDownloadJob 记录任务结束（err 为 nil 时表示下载完成）
+func FinishDownloadJob(filePath string, startedAt time.Time, bytes int64, err error) error {
+	downloadJobsMutex.Lock()
+	defer downloadJobsMutex.Unlock()
+
+	data, loadErr := loadDownloadJobs()
+	if loadErr != nil {
+		return loadErr
+	}
+
+	for i := range data.Jobs {
+		job := &data.Jobs[i]
+		if job.FilePath != filePath {
+			continue
+		}
+
+		job.StartedAt = startedAt
+		job.FinishedAt = time.Now()
+		job.Bytes = bytes
+		if err != nil {
+			job.Status = models.JobStatusError
+			// 下载器的校验错误实现了 Corrupt() 方法
+			var corrupt interface{ Corrupt() bool }
+			if errors.As(err, &corrupt) && corrupt.Corrupt() {
+				job.Status = models.JobStatusCorrupt
+			}
+			job.Error = err.Error()
+		} else {
+			job.Status = models.JobStatusCompleted
+			job.Error = ""
+		}
+		return saveDownloadJobs(data)
+	}
+
+	return fmt.Errorf("未找到下载记录: %s", filePath)
+}
+
+// GetUnfinishedDownloadJobs 获取指定平台和学员未完成的任务
+func GetUnfinishedDownloadJobs(platform, studentID string) ([]models.DownloadJob, error) {
+	data, err := LoadDownloadJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []models.DownloadJob
+	for _, job := range data.Jobs {
+		if job.Platform == platform && job.StudentID == studentID && job.IsUnfinished() {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// GetDownloadHistory 获取下载记录，按加入队列的时间倒序排列
+func GetDownloadHistory() ([]models.DownloadJob, error) {
+	data, err := LoadDownloadJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := data.Jobs
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+// GetDownloadedDirs 获取一讲之前下载完成时所在的目录，用于在清单中查找被移动或改名的文件
+func GetDownloadedDirs(liveID int, extensive bool) []string {
+	data, err := LoadDownloadJobs()
+	if err != nil {
+		return nil
+	}
+
+	var dirs []string
+	for _, job := range data.Jobs {
+		if job.Status == models.JobStatusCompleted && job.Lecture.LiveID == liveID && job.Extensive == extensive {
+			dirs = append(dirs, filepath.Dir(job.FilePath))
+		}
+	}
+	return dirs
+}
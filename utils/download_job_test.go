@@ -0,0 +1,49 @@
+package utils_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+func TestDownloadJobsSurviveFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	utils.SetRootPath(dir)
+	defer utils.SetRootPath("")
+
+	if err := utils.QueueDownloadJob(models.DownloadJob{FilePath: "/videos/第1讲.mp4", Platform: "tal", StudentID: "s1"}); err != nil {
+		t.Fatalf("QueueDownloadJob: %v", err)
+	}
+
+	// 临时文件无法写入时保存失败，已有的记录不受影响
+	path := filepath.Join(dir, utils.DownloadJobsFileName)
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.QueueDownloadJob(models.DownloadJob{FilePath: "/videos/第2讲.mp4", Platform: "tal", StudentID: "s1"}); err == nil {
+		t.Fatal("write into a blocked temp file succeeded")
+	}
+	data, err := utils.LoadDownloadJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Jobs) != 1 || data.Jobs[0].FilePath != "/videos/第1讲.mp4" {
+		t.Errorf("jobs after failed write = %+v, want the first job only", data.Jobs)
+	}
+
+	if err := os.Remove(path + ".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.QueueDownloadJob(models.DownloadJob{FilePath: "/videos/第2讲.mp4", Platform: "tal", StudentID: "s1"}); err != nil {
+		t.Fatalf("QueueDownloadJob: %v", err)
+	}
+	if data, _ := utils.LoadDownloadJobs(); len(data.Jobs) != 2 {
+		t.Errorf("got %d jobs, want 2", len(data.Jobs))
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left behind: %v", err)
+	}
+}
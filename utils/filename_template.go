@@ -0,0 +1,371 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/itsHenry35/tal_downloader/models"
+)
+
+// DefaultFileTemplate 默认的保存路径模板，与之前固定的目录结构相同
+const DefaultFileTemplate = "{subject} - {course}/第{index}讲{type:_%s}.{ext}"
+
+// FileTemplateVars 文件名模板中可用的变量
+type FileTemplateVars struct {
+	Platform   string    // {platform} 平台名称
+	Student    string    // {student} 学员昵称
+	Subject    string    // {subject} 学科
+	Course     string    // {course} 课程名称
+	CourseID   string    // {courseId}
+	Index      int       // {index} 讲次序号，{index:02} 补零到2位
+	Title      string    // {title} 讲次标题
+	Date       time.Time // {date} 开始日期，{date:2006-01-02} 使用 Go 的时间格式
+	Teacher    string    // {teacher} 主讲老师
+	Type       string    // {type} 延伸内容时为 "延伸内容"，否则为空
+	Definition string    // {definition} 回放清晰度
+	Ext        string    // {ext} 扩展名，不含点
+}
+
+// LectureFileVars 一讲的模板变量，Definition 需要在获取回放地址后填写
+func LectureFileVars(platform, student string, course *models.Course, lecture *models.Lecture, index int, extensive bool) FileTemplateVars {
+	vars := FileTemplateVars{
+		Platform: platform,
+		Student:  student,
+		Subject:  course.SubjectName,
+		Course:   course.CourseName,
+		CourseID: course.CourseID,
+		Index:    lecture.Number(index),
+		Title:    lecture.Title,
+		Teacher:  lecture.TeacherName,
+		Ext:      "mp4",
+	}
+	if !lecture.StartTime.IsZero() {
+		vars.Date = lecture.StartTime.In(models.LectureLocation)
+	}
+	if extensive {
+		vars.Type = "延伸内容"
+	}
+	return vars
+}
+
+// templateNames 模板中可以使用的变量名
+var templateNames = map[string]bool{
+	"platform": true, "student": true, "subject": true, "course": true, "courseId": true, "index": true,
+	"title": true, "date": true, "teacher": true, "type": true, "definition": true, "ext": true,
+}
+
+// FileTemplate 解析后的保存路径模板。模板中的 / 分隔目录，{name} 或 {name:格式} 替换为变量的值，
+// {{ 和 }} 表示花括号本身。文本变量的格式中 %s 替换为变量的值，变量为空时整个占位符为空，
+// 如 {type:_%s}；没有使用 {ext} 时在末尾加上扩展名
+type FileTemplate struct {
+	text  string
+	parts []templatePart
+}
+
+// templatePart 模板的一段：文本或变量
+type templatePart struct {
+	literal string
+	name    string
+	format  string
+}
+
+// ParseFileTemplate 解析保存路径模板，为空时使用 DefaultFileTemplate
+func ParseFileTemplate(text string) (*FileTemplate, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		text = DefaultFileTemplate
+	}
+	t := &FileTemplate{text: text}
+
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			t.parts = append(t.parts, templatePart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '{' && strings.HasPrefix(text[i:], "{{"):
+			literal.WriteByte('{')
+			i++
+		case c == '}' && strings.HasPrefix(text[i:], "}}"):
+			literal.WriteByte('}')
+			i++
+		case c == '}':
+			return nil, fmt.Errorf("模板中有多余的 }: %s", text)
+		case c == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("模板中的 { 没有闭合: %s", text)
+			}
+			name, format, _ := strings.Cut(text[i+1:i+end], ":")
+			if err := checkTemplateVar(name, format); err != nil {
+				return nil, err
+			}
+			flush()
+			t.parts = append(t.parts, templatePart{name: name, format: format})
+			i += end
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+
+	for _, dir := range strings.FieldsFunc(t.literalText(), isPathSeparator) {
+		if strings.TrimSpace(dir) == ".." {
+			return nil, fmt.Errorf("模板不能包含上级目录: %s", text)
+		}
+	}
+	if strings.HasPrefix(text, "/") || strings.HasPrefix(text, "\\") || filepath.IsAbs(text) {
+		return nil, fmt.Errorf("模板应为下载目录中的相对路径: %s", text)
+	}
+	if !t.Uses("ext") {
+		t.parts = append(t.parts, templatePart{literal: "."}, templatePart{name: "ext"})
+	}
+	return t, nil
+}
+
+func checkTemplateVar(name, format string) error {
+	if !templateNames[name] {
+		return fmt.Errorf("未知的模板变量: {%s}", name)
+	}
+	switch name {
+	case "index":
+		if format != "" {
+			if width, err := strconv.Atoi(format); err != nil || width < 1 || width > 9 {
+				return fmt.Errorf("{index} 的格式应为位数，如 {index:02}")
+			}
+		}
+	case "date":
+	default:
+		if format != "" && !strings.Contains(format, "%s") {
+			return fmt.Errorf("{%s} 的格式中应包含 %%s，如 {%s:_%%s}", name, name)
+		}
+	}
+	return nil
+}
+
+// literalText 模板中的文本部分，用于检查目录结构
+func (t *FileTemplate) literalText() string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.name == "" {
+			b.WriteString(p.literal)
+		} else {
+			b.WriteString("x")
+		}
+	}
+	return b.String()
+}
+
+// String 返回模板的原文
+func (t *FileTemplate) String() string {
+	return t.text
+}
+
+// Uses 模板中是否使用了某个变量
+func (t *FileTemplate) Uses(name string) bool {
+	if t == nil {
+		return false
+	}
+	for _, p := range t.parts {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Render 生成下载目录中的相对路径。变量的值会经过 SanitizeFileName，
+// 每一级目录和文件名去掉首尾的空格和点，并截断到系统允许的长度
+func (t *FileTemplate) Render(vars FileTemplateVars) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.name == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		b.WriteString(SanitizeFileName(vars.value(p.name, p.format)))
+	}
+
+	components := splitPath(b.String())
+	for i := range components {
+		components[i] = limitComponent(components[i], i == len(components)-1)
+	}
+	return filepath.Join(components...)
+}
+
+// RenderPath 生成 baseDir 中的保存路径。Windows 上完整路径超过 MAX_PATH 时用 FitPathLength 缩短，
+// 否则资源管理器和播放器打不开下载的文件
+func (t *FileTemplate) RenderPath(baseDir string, vars FileTemplateVars) string {
+	rel := t.Render(vars)
+	if runtime.GOOS == "windows" {
+		return FitPathLength(baseDir, rel, windowsMaxPath)
+	}
+	return filepath.Join(baseDir, rel)
+}
+
+func (v FileTemplateVars) value(name, format string) string {
+	var s string
+	switch name {
+	case "index":
+		if format != "" {
+			width, _ := strconv.Atoi(format)
+			return fmt.Sprintf("%0*d", width, v.Index)
+		}
+		return strconv.Itoa(v.Index)
+	case "date":
+		if v.Date.IsZero() {
+			return ""
+		}
+		if format == "" {
+			format = "2006-01-02"
+		}
+		return v.Date.Format(format)
+	case "platform":
+		s = v.Platform
+	case "student":
+		s = v.Student
+	case "subject":
+		s = v.Subject
+	case "course":
+		s = v.Course
+	case "courseId":
+		s = v.CourseID
+	case "title":
+		s = v.Title
+	case "teacher":
+		s = v.Teacher
+	case "type":
+		s = v.Type
+	case "definition":
+		s = v.Definition
+	case "ext":
+		s = v.Ext
+	}
+	if s == "" || format == "" {
+		return s
+	}
+	return strings.Replace(format, "%s", s, 1)
+}
+
+// isPathSeparator 模板中 / 和 \ 都表示目录分隔
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// splitPath 按 / 和 \ 拆分路径，去掉空的部分
+func splitPath(path string) []string {
+	var components []string
+	for _, c := range strings.FieldsFunc(path, isPathSeparator) {
+		// Windows 不允许文件名以空格或点结尾
+		c = strings.Trim(c, " .")
+		if c != "" && c != "." {
+			components = append(components, c)
+		}
+	}
+	return components
+}
+
+// maxComponentLength 一级目录或文件名的最大长度：Windows 为 255 个 UTF-16 字符，其他系统为 255 字节
+const maxComponentLength = 255
+
+// sidecarReserve 为下载时在文件名后追加的后缀预留的长度，最长的是续传状态的临时文件 ".dlstate.tmp"，
+// 其余还有 ".dlstate"、".tmp" 和 HLS 分片目录的前缀 ".tmp_"
+const sidecarReserve = len(".dlstate.tmp")
+
+// pathReserve 为下载时比保存路径更长的临时路径预留的长度，最长的是 HLS 分片目录中的音频分段
+// ".tmp_<文件名>/audio_00000.ts"
+const pathReserve = len(".tmp_") + len("/audio_00000.ts")
+
+// windowsMaxPath Windows MAX_PATH 为 260 个字符，包含结尾的 NUL
+const windowsMaxPath = 259
+
+// minShortenedLength 缩短完整路径时每一级名称至少保留的字符数
+const minShortenedLength = 8
+
+// componentLength 按当前系统的规则计算文件名长度
+func componentLength(name string) int {
+	if runtime.GOOS == "windows" {
+		return len(utf16.Encode([]rune(name)))
+	}
+	return len(name)
+}
+
+// windowsReservedNames Windows 中不能用作文件名的设备名
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// limitComponent 截断过长的目录名或文件名，文件名保留扩展名，并为下载时的附属文件留出 sidecarReserve
+func limitComponent(name string, isFile bool) string {
+	ext := ""
+	limit := maxComponentLength
+	if isFile {
+		ext = filepath.Ext(name)
+		limit -= sidecarReserve
+	}
+	stem := strings.TrimSuffix(name, ext)
+	if windowsReservedNames[strings.ToUpper(stem)] {
+		stem += "_"
+	}
+	return shortenStem(stem, ext, componentLength, limit, 0)
+}
+
+// shortenStem 从 stem 末尾去掉字符，直到 length(stem+ext) 不超过 limit 或 stem 只剩 keep 个字符
+func shortenStem(stem, ext string, length func(string) int, limit, keep int) string {
+	for length(stem+ext) > limit && utf8.RuneCountInString(stem) > keep {
+		_, size := utf8.DecodeLastRuneInString(stem)
+		stem = stem[:len(stem)-size]
+	}
+	return strings.TrimRight(stem, " .") + ext
+}
+
+// utf16Length 按 UTF-16 字符计算长度，与 Windows 的 MAX_PATH 一致
+func utf16Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// FitPathLength 拼接 baseDir 和相对路径 rel，完整的绝对路径加上 pathReserve 超过 max 个 UTF-16 字符时，
+// 先缩短文件名（保留扩展名），再从最深的一级开始缩短 rel 中的目录名，每级至少保留 minShortenedLength 个字符。
+// baseDir 本身过长时无法缩短，返回尽量缩短后的路径
+func FitPathLength(baseDir, rel string, max int) string {
+	path := filepath.Join(baseDir, rel)
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	over := utf16Length(abs) + pathReserve - max
+	if over <= 0 {
+		return path
+	}
+
+	components := splitPath(rel)
+	for i := len(components) - 1; i >= 0 && over > 0; i-- {
+		name := components[i]
+		ext := ""
+		if i == len(components)-1 {
+			ext = filepath.Ext(name)
+		}
+		stem := strings.TrimSuffix(name, ext)
+		limit := utf16Length(name) - over
+		shortened := shortenStem(stem, ext, utf16Length, limit, minShortenedLength)
+		if shortened == ext {
+			// 只剩扩展名时保留原名称，避免生成以点开头的文件名
+			continue
+		}
+		over -= utf16Length(name) - utf16Length(shortened)
+		components[i] = shortened
+	}
+	return filepath.Join(append([]string{baseDir}, components...)...)
+}
@@ -0,0 +1,86 @@
+package utils_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+func TestFileTemplateRender(t *testing.T) {
+	start := time.Date(2024, 5, 12, 18, 30, 0, 0, models.LectureLocation)
+	course := &models.Course{CourseID: "c1", SubjectName: "数学", CourseName: "春季班/提高"}
+	lecture := &models.Lecture{Title: "二次函数: 图像", Num: 3, StartTime: models.LectureTime{Time: start}, TeacherName: "张老师"}
+	vars := utils.LectureFileVars("乐读", "小明", course, lecture, 0, false)
+	vars.Definition = "超清"
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", "数学 - 春季班／提高/第3讲.mp4"},
+		{"{student}/{subject}/{course}/{index:02} {title} [{definition}].{ext}", "小明/数学/春季班／提高/03 二次函数： 图像 [超清].mp4"},
+		{"{platform}/{courseId}/{date:20060102}_{teacher}{type:_%s}", "乐读/c1/20240512_张老师.mp4"},
+		{"{{{index}}}.{ext}", "{3}.mp4"},
+		{"{course}//./{index}. ", "春季班／提高/3.mp4"},
+	}
+	for _, tt := range tests {
+		tmpl, err := utils.ParseFileTemplate(tt.template)
+		if err != nil {
+			t.Errorf("%q: %v", tt.template, err)
+			continue
+		}
+		if got := tmpl.Render(vars); got != filepath.FromSlash(tt.want) {
+			t.Errorf("%q rendered %q, want %q", tt.template, got, tt.want)
+		}
+	}
+
+	extensive := utils.LectureFileVars("乐读", "小明", course, &models.Lecture{}, 4, true)
+	tmpl, _ := utils.ParseFileTemplate("")
+	if got := tmpl.Render(extensive); got != filepath.FromSlash("数学 - 春季班／提高/第5讲_延伸内容.mp4") {
+		t.Errorf("extensive rendered %q", got)
+	}
+}
+
+func TestFileTemplateErrors(t *testing.T) {
+	for _, template := range []string{"{unknown}", "{index", "a}b", "{index:x}", "{title:no-verb}", "../{course}", "/{course}"} {
+		if _, err := utils.ParseFileTemplate(template); err == nil {
+			t.Errorf("%q: expected an error", template)
+		}
+	}
+}
+
+func TestFileTemplateLimitsLength(t *testing.T) {
+	tmpl, _ := utils.ParseFileTemplate("{title}.{ext}")
+	got := tmpl.Render(utils.FileTemplateVars{Title: strings.Repeat("长", 200), Ext: "mp4"})
+	if len(got)+len(".dlstate.tmp") > 255 || !strings.HasSuffix(got, ".mp4") {
+		t.Errorf("name leaves no room for sidecar files: %d bytes", len(got))
+	}
+	if got := tmpl.Render(utils.FileTemplateVars{Title: "con", Ext: "mp4"}); got != "con_.mp4" {
+		t.Errorf("reserved name rendered %q", got)
+	}
+}
+
+func TestFitPathLength(t *testing.T) {
+	base := t.TempDir()
+	rel := filepath.Join("数学 - 课程", "第1讲.mp4")
+	if got := utils.FitPathLength(base, rel, 259); got != filepath.Join(base, rel) {
+		t.Errorf("short path changed: %q", got)
+	}
+
+	rel = filepath.Join(strings.Repeat("目", 100), strings.Repeat("长", 200)+".mp4")
+	max := len([]rune(base)) + 80
+	got := utils.FitPathLength(base, rel, max)
+	if n := len([]rune(got)) + len(".tmp_/audio_00000.ts"); n > max {
+		t.Errorf("path not fitted: %d > %d (%q)", n, max, got)
+	}
+	if !strings.HasPrefix(got, base) || !strings.HasSuffix(got, ".mp4") {
+		t.Errorf("fitted path %q lost base or extension", got)
+	}
+	if dir := filepath.Base(filepath.Dir(got)); !strings.HasPrefix(dir, "目目目目目目目目") {
+		t.Errorf("directory %q shortened below the minimum", dir)
+	}
+}
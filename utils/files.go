@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"os"
 	"strings"
 )
 
@@ -44,3 +45,12 @@ func FormatFileSize(totalsize int64) string {
 		return fmt.Sprintf("%dB", totalsize)
 	}
 }
+
+// GetFileSize 获取文件大小，文件不存在时返回 -1
+func GetFileSize(path string) int64 {
+	info, err := os.Stat(GetAndroidSafeFilePath(path))
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/url"
@@ -10,6 +11,7 @@ import (
 	"runtime"
 
 	"fyne.io/fyne/v2"
+	"github.com/itsHenry35/tal_downloader/config"
 )
 
 func IsAndroid() bool {
@@ -20,8 +22,67 @@ func CleanAndroidTempFolder() {
 	_ = os.RemoveAll(GetAndroidSafeFilePath("temp"))
 }
 
+// GetRootPath 程序数据目录（账号、设置、下载记录等）。图形界面使用 Fyne 应用的存储目录；
+// 命令行模式不启动 Fyne，使用桌面版 Fyne 相同的位置，两者共用数据
 func GetRootPath() string {
-	return fyne.CurrentApp().Storage().RootURI().Path()
+	if rootPath == "" {
+		rootPath = desktopRootPath()
+	}
+	return rootPath
+}
+
+// rootPath 程序数据目录，见 SetRootPath
+var rootPath string
+
+// SetRootPath 设置程序数据目录，图形界面启动时设为 Fyne 应用的存储目录
+func SetRootPath(path string) {
+	rootPath = path
+}
+
+// desktopRootPath 桌面版 Fyne 应用的存储目录
+func desktopRootPath() string {
+	home, _ := os.UserHomeDir()
+	var dir string
+	switch runtime.GOOS {
+	case "darwin":
+		dir = filepath.Join(home, "Library", "Preferences")
+	case "windows":
+		dir = filepath.Join(home, "AppData", "Roaming")
+	default:
+		dir, _ = os.UserConfigDir()
+	}
+	return filepath.Join(dir, "fyne", config.AppID)
+}
+
+// dataFilePath 程序数据目录中的文件路径
+func dataFilePath(name string) string {
+	return filepath.Join(GetRootPath(), name)
+}
+
+// dataFileExists 程序数据目录中的文件是否存在
+func dataFileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// writeDataFile 将 v 以 JSON 格式写入程序数据目录中的文件，目录不存在时创建目录。
+// 先写入临时文件再重命名，中途退出时原来的内容保持不变
+func writeDataFile(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
 }
 
 func GetAndroidSafeFilePath(relativePath string) string {
@@ -68,6 +129,19 @@ func CreateFile(path string) (*os.File, error) {
 	return os.Create(actualPath)
 }
 
+// OpenFile 以读写方式打开文件，不存在时创建，已有内容不会被清空
+func OpenFile(path string) (*os.File, error) {
+	actualPath := GetAndroidSafeFilePath(path)
+	if IsAndroid() {
+		// 确保目录存在
+		if err := os.MkdirAll(filepath.Dir(actualPath), 0755); err != nil {
+			return nil, fmt.Errorf("创建目录失败: %v", err)
+		}
+	}
+
+	return os.OpenFile(actualPath, os.O_RDWR|os.O_CREATE, 0644)
+}
+
 func CopyToAndroidStorage(sourcePath string, writer fyne.URIWriteCloser) error {
 	actualPath := GetAndroidSafeFilePath(sourcePath)
 
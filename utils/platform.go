@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"os"
+
+	"github.com/itsHenry35/tal_downloader/config"
+)
+
+const PlatformsFileName = "platforms.json"
+
+// LoadPlatformConfig 从程序数据目录的 platforms.json 注册额外的平台，文件不存在时不做任何事
+func LoadPlatformConfig() error {
+	filePath := dataFilePath(PlatformsFileName)
+
+	exists, err := dataFileExists(filePath)
+	if err != nil || !exists {
+		return err
+	}
+
+	read, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer read.Close()
+
+	return config.LoadPlatforms(read)
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseLectureRanges 解析讲次范围（如 "1-3,5,8-"，从1开始计数），返回从0开始的讲次下标
+// spec 为空或为 "all" 时返回全部讲次
+func ParseLectureRanges(spec string, count int) ([]int, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || strings.EqualFold(spec, "all") {
+		indices := make([]int, count)
+		for i := range indices {
+			indices[i] = i
+		}
+		return indices, nil
+	}
+
+	selected := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		start, end := part, part
+		if idx := strings.Index(part, "-"); idx >= 0 {
+			start, end = strings.TrimSpace(part[:idx]), strings.TrimSpace(part[idx+1:])
+		}
+
+		from, err := strconv.Atoi(start)
+		if err != nil || from < 1 {
+			return nil, fmt.Errorf("无效的讲次范围: %s", part)
+		}
+		to := count
+		if end != "" {
+			to, err = strconv.Atoi(end)
+			if err != nil || to < from {
+				return nil, fmt.Errorf("无效的讲次范围: %s", part)
+			}
+		}
+		if to > count {
+			to = count
+		}
+
+		for i := from; i <= to; i++ {
+			selected[i-1] = true
+		}
+	}
+
+	indices := make([]int, 0, len(selected))
+	for i := range selected {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices, nil
+}
@@ -9,10 +9,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"path/filepath"
+	"os"
 
-	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/storage"
 	"github.com/itsHenry35/tal_downloader/models"
 )
 
@@ -71,17 +69,17 @@ func decrypt(encryptedData string, key []byte) ([]byte, error) {
 	return gcm.Open(nil, nonce, ciphertext, nil)
 }
 
-func getFileURI() fyne.URI {
-	return storage.NewFileURI(filepath.Join(GetRootPath(), SavedUsersFileName))
+func getFilePath() string {
+	return dataFilePath(SavedUsersFileName)
 }
 
 // LoadSavedUsers 加载保存的用户信息
 func LoadSavedUsers() (*models.SavedUsersData, error) {
-	// 创建文件URI
-	fileURI := getFileURI()
+	// 数据文件路径
+	filePath := getFilePath()
 
 	// 检查文件是否存在
-	exists, err := storage.Exists(fileURI)
+	exists, err := dataFileExists(filePath)
 	if err != nil {
 		return &models.SavedUsersData{Users: []models.SavedUser{}}, err
 	}
@@ -92,7 +90,7 @@ func LoadSavedUsers() (*models.SavedUsersData, error) {
 	}
 
 	// 读取文件
-	read, err := storage.Reader(fileURI)
+	read, err := os.Open(filePath)
 	if err != nil {
 		return &models.SavedUsersData{Users: []models.SavedUser{}}, err
 	}
@@ -139,6 +137,15 @@ func LoadSavedUsers() (*models.SavedUsersData, error) {
 			Token:    string(tokenBytes),
 			Platform: encUser.Platform,
 		}
+
+		// 解密Password（可选）
+		if encUser.EncryptedPassword != "" {
+			passwordBytes, err := decrypt(encUser.EncryptedPassword, key)
+			if err == nil {
+				user.Password = string(passwordBytes)
+			}
+		}
+
 		users = append(users, user)
 	}
 
@@ -147,8 +154,8 @@ func LoadSavedUsers() (*models.SavedUsersData, error) {
 
 // SaveUsers 保存用户信息到文件
 func SaveUsers(data *models.SavedUsersData) error {
-	// 创建文件URI
-	fileURI := getFileURI()
+	// 数据文件路径
+	filePath := getFilePath()
 
 	// 加密用户数据
 	var encryptedUsers []models.SavedUserEncrypted
@@ -180,23 +187,20 @@ func SaveUsers(data *models.SavedUsersData) error {
 			EncryptedToken:    encryptedToken,
 			Platform:          user.Platform,
 		}
+
+		// 加密Password（仅在记住密码时保存）
+		if user.Password != "" {
+			encUser.EncryptedPassword, err = encrypt([]byte(user.Password), key)
+			if err != nil {
+				return fmt.Errorf("加密Password失败: %v", err)
+			}
+		}
 		encryptedUsers = append(encryptedUsers, encUser)
 	}
 
 	encryptedData := &models.SavedUsersDataEncrypted{Users: encryptedUsers}
 
-	// 创建写入器
-	write, err := storage.Writer(fileURI)
-	if err != nil {
-		return fmt.Errorf("创建文件写入器失败: %v", err)
-	}
-	defer write.Close()
-
-	// 编码为JSON并写入
-	encoder := json.NewEncoder(write)
-	encoder.SetIndent("", "  ")
-	err = encoder.Encode(encryptedData)
-	if err != nil {
+	if err := writeDataFile(filePath, encryptedData); err != nil {
 		return fmt.Errorf("保存用户数据失败: %v", err)
 	}
 
@@ -234,6 +238,24 @@ func AddUser(username, nickname, token, platform, userID string) error {
 	return SaveUsers(data)
 }
 
+// SaveUser 保存或替换用户信息（通过用户名和平台判断），不记住密码时清除已保存的密码
+func SaveUser(user models.SavedUser) error {
+	data, err := LoadSavedUsers()
+	if err != nil {
+		return err
+	}
+
+	for i, u := range data.Users {
+		if u.Username == user.Username && u.Platform == user.Platform {
+			data.Users[i] = user
+			return SaveUsers(data)
+		}
+	}
+
+	data.Users = append(data.Users, user)
+	return SaveUsers(data)
+}
+
 // RemoveUser 从保存列表中移除用户
 func RemoveUser(user models.SavedUser) error {
 	data, err := LoadSavedUsers()
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/itsHenry35/tal_downloader/config"
+	"github.com/itsHenry35/tal_downloader/models"
+)
+
+const SettingsFileName = "settings.json"
+
+// DefaultSettings 没有设置文件时使用的默认设置
+func DefaultSettings() models.Settings {
+	return models.Settings{
+		MaxConcurrentDownloads: config.MaxConcurrentDownloads,
+		ThreadCount:            config.ThreadCount,
+	}
+}
+
+func getSettingsFilePath() string {
+	return dataFilePath(SettingsFileName)
+}
+
+// LoadSettings 加载用户设置，文件不存在或损坏时返回默认设置
+func LoadSettings() (models.Settings, error) {
+	settings := DefaultSettings()
+	filePath := getSettingsFilePath()
+
+	exists, err := dataFileExists(filePath)
+	if err != nil || !exists {
+		return settings, err
+	}
+
+	read, err := os.Open(filePath)
+	if err != nil {
+		return settings, err
+	}
+	defer read.Close()
+
+	if err := json.NewDecoder(read).Decode(&settings); err != nil {
+		return DefaultSettings(), fmt.Errorf("设置文件已损坏，使用默认设置: %v", err)
+	}
+	settings.Normalize(DefaultSettings())
+	return settings, nil
+}
+
+// SaveSettings 保存用户设置
+func SaveSettings(settings models.Settings) error {
+	settings.Normalize(DefaultSettings())
+
+	if err := writeDataFile(getSettingsFilePath(), settings); err != nil {
+		return fmt.Errorf("保存设置失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/itsHenry35/tal_downloader/models"
+)
+
+const SubscriptionsFileName = "subscriptions.json"
+
+// subscriptionsMutex 下载完成时会更新同步状态，读写文件需要串行
+var subscriptionsMutex sync.Mutex
+
+func getSubscriptionsFilePath() string {
+	return dataFilePath(SubscriptionsFileName)
+}
+
+// LoadSubscriptions 加载课程订阅
+func LoadSubscriptions() (*models.SubscriptionsData, error) {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	return loadSubscriptions()
+}
+
+func loadSubscriptions() (*models.SubscriptionsData, error) {
+	filePath := getSubscriptionsFilePath()
+
+	exists, err := dataFileExists(filePath)
+	if err != nil {
+		return &models.SubscriptionsData{}, err
+	}
+	if !exists {
+		return &models.SubscriptionsData{}, nil
+	}
+
+	read, err := os.Open(filePath)
+	if err != nil {
+		return &models.SubscriptionsData{}, err
+	}
+	defer read.Close()
+
+	var data models.SubscriptionsData
+	if err := json.NewDecoder(read).Decode(&data); err != nil {
+		return &models.SubscriptionsData{}, fmt.Errorf("订阅文件已损坏: %v", err)
+	}
+	return &data, nil
+}
+
+func saveSubscriptions(data *models.SubscriptionsData) error {
+	if err := writeDataFile(getSubscriptionsFilePath(), data); err != nil {
+		return fmt.Errorf("保存订阅失败: %v", err)
+	}
+	return nil
+}
+
+// Subscribe 添加订阅。学员已有订阅时合并课程，并使用新的延伸内容、清晰度和下载目录设置
+func Subscribe(sub models.Subscription) (*models.Subscription, error) {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+
+	data, err := loadSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := data.Find(sub.Platform, sub.StudentID)
+	if existing == nil {
+		existing = &models.Subscription{Platform: sub.Platform, StudentID: sub.StudentID}
+		data.Subscriptions = append(data.Subscriptions, existing)
+	}
+	existing.Username = sub.Username
+	existing.StudentName = sub.StudentName
+	existing.AllCourses = existing.AllCourses || sub.AllCourses
+	for _, id := range sub.CourseIDs {
+		if !existing.Covers(id) {
+			existing.CourseIDs = append(existing.CourseIDs, id)
+		}
+	}
+	existing.Extensive = sub.Extensive
+	existing.Definition = sub.Definition
+	existing.Path = sub.Path
+	return existing, saveSubscriptions(data)
+}
+
+// Unsubscribe 取消订阅的课程，courseIDs 为空时取消学员的整个订阅
+func Unsubscribe(platform, studentID string, courseIDs []string) error {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+
+	data, err := loadSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	sub := data.Find(platform, studentID)
+	if sub == nil {
+		return fmt.Errorf("学员 %s 没有订阅", studentID)
+	}
+	if len(courseIDs) == 0 {
+		for i, s := range data.Subscriptions {
+			if s == sub {
+				data.Subscriptions = append(data.Subscriptions[:i], data.Subscriptions[i+1:]...)
+				break
+			}
+		}
+		return saveSubscriptions(data)
+	}
+
+	if sub.AllCourses {
+		return fmt.Errorf("学员 %s 订阅了全部课程，只能取消整个订阅", studentID)
+	}
+	remove := make(map[string]bool, len(courseIDs))
+	for _, id := range courseIDs {
+		remove[id] = true
+	}
+	var kept []string
+	for _, id := range sub.CourseIDs {
+		if remove[id] {
+			delete(sub.Courses, id)
+		} else {
+			kept = append(kept, id)
+		}
+	}
+	sub.CourseIDs = kept
+	return saveSubscriptions(data)
+}
+
+// UpdateSubscription 保存同步后的订阅状态（发现的延伸内容和同步时间），不改变下载中记录的已同步讲次
+func UpdateSubscription(sub *models.Subscription) error {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+
+	data, err := loadSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	existing := data.Find(sub.Platform, sub.StudentID)
+	if existing == nil {
+		return fmt.Errorf("学员 %s 没有订阅", sub.StudentID)
+	}
+	for courseID, state := range sub.Courses {
+		saved := existing.State(courseID)
+		for _, liveID := range state.Extensive {
+			if !saved.HasExtensive(liveID) {
+				saved.Extensive = append(saved.Extensive, liveID)
+			}
+		}
+	}
+	existing.LastSync = sub.LastSync
+	return saveSubscriptions(data)
+}
+
+// MarkSynced 下载完成（或本地已有）时记录订阅课程中的一讲已同步，课程不在订阅中时忽略
+func MarkSynced(platform, studentID, courseID string, liveID int, extensive bool) error {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+
+	data, err := loadSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	sub := data.Find(platform, studentID)
+	if sub == nil || !sub.Covers(courseID) {
+		return nil
+	}
+	state := sub.State(courseID)
+	if state.IsSynced(liveID, extensive) {
+		return nil
+	}
+	state.MarkSynced(liveID, extensive)
+	return saveSubscriptions(data)
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/itsHenry35/tal_downloader/models"
+)
+
+// knownDefinitions 已知的清晰度名称，从低到高
+var knownDefinitions = []string{"流畅", "标清", "高清", "超清", "蓝光", "原画"}
+
+// definitionRank 清晰度的高低顺序，未知的名称返回 -1
+func definitionRank(definition string) int {
+	for i, name := range knownDefinitions {
+		if name == definition {
+			return i
+		}
+	}
+	return -1
+}
+
+// SortVideoSources 按清晰度从高到低排序，未知的清晰度按名称排在所有已知清晰度之后
+func SortVideoSources(sources []models.VideoSource) {
+	sort.SliceStable(sources, func(i, j int) bool {
+		ri, rj := definitionRank(sources[i].Definition), definitionRank(sources[j].Definition)
+		if (ri < 0) != (rj < 0) {
+			return ri >= 0
+		}
+		if ri != rj {
+			return ri > rj
+		}
+		return sources[i].Definition < sources[j].Definition
+	})
+}
+
+// SelectVideoSource 按清晰度偏好从已排序（见 SortVideoSources）的清晰度中选择一个。
+// 有已知的清晰度时最高和最低都在已知的清晰度中选择，无法判断高低的只在没有已知清晰度时使用。
+// 偏好为具体名称但该讲没有时，选择不高于该清晰度中最高的，仍没有则选择最高的
+func SelectVideoSource(sources []models.VideoSource, preference string) (models.VideoSource, error) {
+	if len(sources) == 0 {
+		return models.VideoSource{}, fmt.Errorf("没有可用的清晰度")
+	}
+
+	switch preference {
+	case models.DefinitionHighest, "":
+		return sources[0], nil
+	case models.DefinitionLowest:
+		for i := len(sources) - 1; i >= 0; i-- {
+			if definitionRank(sources[i].Definition) >= 0 {
+				return sources[i], nil
+			}
+		}
+		return sources[len(sources)-1], nil
+	}
+
+	for _, source := range sources {
+		if source.Definition == preference {
+			return source, nil
+		}
+	}
+	if rank := definitionRank(preference); rank >= 0 {
+		for _, source := range sources {
+			if r := definitionRank(source.Definition); r >= 0 && r <= rank {
+				return source, nil
+			}
+		}
+	}
+	return sources[0], nil
+}
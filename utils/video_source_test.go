@@ -0,0 +1,53 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/itsHenry35/tal_downloader/models"
+	"github.com/itsHenry35/tal_downloader/utils"
+)
+
+func TestSelectVideoSourceWithUnknownDefinitions(t *testing.T) {
+	sources := []models.VideoSource{
+		{Definition: "4K60帧", URL: "u1"},
+		{Definition: "标清", URL: "sd"},
+		{Definition: "超清", URL: "uhd"},
+		{Definition: "", URL: "u2"},
+	}
+	utils.SortVideoSources(sources)
+	var order []string
+	for _, s := range sources {
+		order = append(order, s.Definition)
+	}
+	want := []string{"超清", "标清", "", "4K60帧"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("sorted = %q, want %q", order, want)
+		}
+	}
+
+	tests := []struct {
+		preference string
+		want       string
+	}{
+		{models.DefinitionHighest, "超清"},
+		{models.DefinitionLowest, "标清"},
+		{"高清", "标清"},
+		{"4K60帧", "4K60帧"},
+		{"原画", "超清"},
+		{"不存在", "超清"},
+	}
+	for _, tt := range tests {
+		got, err := utils.SelectVideoSource(sources, tt.preference)
+		if err != nil || got.Definition != tt.want {
+			t.Errorf("SelectVideoSource(%q) = %q, %v, want %q", tt.preference, got.Definition, err, tt.want)
+		}
+	}
+
+	// 全部无法判断高低时按排序选择
+	unknown := []models.VideoSource{{Definition: "b"}, {Definition: "a"}}
+	utils.SortVideoSources(unknown)
+	if got, _ := utils.SelectVideoSource(unknown, models.DefinitionLowest); got.Definition != "b" {
+		t.Errorf("lowest of unknown = %q, want b", got.Definition)
+	}
+}